@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentConfirmTimeout is how long to wait for users to confirm that their
+// forwarded agent's keys should be listed
+const agentConfirmTimeout = 30 * time.Second
+
+// confirmAgentAudit asks the user to confirm that the keys held by their
+// forwarded agent should be listed, writing the prompt to w. Anything but
+// "yes" within agentConfirmTimeout is taken as a refusal.
+func confirmAgentAudit(logger *log.Entry, w io.Writer, input *lineReader) bool {
+	w.Write([]byte(agentConfirmPrompt))
+
+	line, answered, _ := input.readLine("", clk.After(agentConfirmTimeout))
+	if !answered {
+		w.Write([]byte("\r\n"))
+	}
+	confirmed := strings.ToLower(strings.TrimSpace(line)) == "yes"
+
+	logger.WithField("confirmed", confirmed).Infoln("Asked to list the keys in the forwarded agent")
+	return confirmed
+}
+
+// agentKeys lists the keys held by the client's forwarded SSH agent. Keys of
+// types the ssh package can't parse are logged and skipped.
+func agentKeys(logger *log.Entry, conn ssh.Conn) ([]*publicKey, error) {
+	channel, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(reqs)
+
+	list, err := agent.NewClient(channel).List()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*publicKey
+	for _, k := range list {
+		key, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil && malformedKeyError(err) {
+			logger.Warnf("Forwarded agent listed %s key with MALFORMED KEY DATA: %s", k.Format, err)
+			continue
+		} else if err != nil {
+			logger.Warnf("Failed to parse %s key from forwarded agent: %s", k.Format, err)
+			continue
+		}
+		declared := mismatchedType(k.Format, k.Blob, key)
+		keys = append(keys, &publicKey{
+			key:          key,
+			declaredType: declared,
+			nonCanonical: declared == "" && !bytes.Equal(key.Marshal(), k.Blob),
+		})
+	}
+
+	return keys, nil
+}
+
+// mismatchedType returns the type an agent declared a key as, either in
+// its list of keys or at the start of the key's blob, if that isn't the
+// key's actual type. The ssh package takes ECDSA keys' curve from their
+// parameters rather than their type, so a key on one curve can be passed
+// off as another.
+func mismatchedType(format string, blob []byte, key ssh.PublicKey) string {
+	var declared struct {
+		Type string
+		Rest []byte `ssh:"rest"`
+	}
+	ssh.Unmarshal(blob, &declared)
+
+	for _, t := range []string{format, declared.Type} {
+		if t != key.Type() {
+			return t
+		}
+	}
+
+	return ""
+}
+
+// agentSources describes, for each key offered by the client or held by its
+// forwarded agent, whether it was offered and whether the agent holds it, in
+// the order the keys were offered followed by the agent's order. Clients
+// offer keys from their agent and from files such as IdentityFile, and may
+// not offer every key their agent holds.
+func agentSources(offered, held []*publicKey) []string {
+	inAgent := make(map[string]bool)
+	for _, k := range held {
+		inAgent[string(k.key.Marshal())] = true
+	}
+
+	var sources []string
+	wasOffered := make(map[string]bool)
+	for _, k := range offered {
+		blob := string(k.key.Marshal())
+		if wasOffered[blob] {
+			continue
+		}
+		wasOffered[blob] = true
+
+		source := "offered, but not held by your agent"
+		if inAgent[blob] {
+			source = "offered, held by your agent"
+		}
+		sources = append(sources, k.Fingerprint()+" ("+k.key.Type()+"): "+source)
+	}
+	for _, k := range held {
+		if !wasOffered[string(k.key.Marshal())] {
+			sources = append(sources, k.Fingerprint()+" ("+k.key.Type()+"): held by your agent, but not offered")
+		}
+	}
+
+	return sources
+}
+
+// mergeKeys returns the keys in a followed by any keys in b that aren't
+// also in a
+func mergeKeys(a, b []*publicKey) []*publicKey {
+	merged := a
+	for _, kb := range b {
+		found := false
+		for _, ka := range a {
+			if bytes.Equal(ka.key.Marshal(), kb.key.Marshal()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, kb)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testChannel is an ssh.Channel whose input is written by the test
+type testChannel struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *testChannel) Write(p []byte) (int, error) { return c.Buffer.Write(p) }
+func (c *testChannel) Read(p []byte) (int, error)  { return c.Reader.Read(p) }
+func (c *testChannel) Close() error                { return nil }
+func (c *testChannel) CloseWrite() error           { return nil }
+func (c *testChannel) Stderr() io.ReadWriter       { return new(bytes.Buffer) }
+func (c *testChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return false, nil
+}
+
+func TestConfirmAgentAudit(t *testing.T) {
+	for _, test := range []struct {
+		input     string
+		pty       bool
+		confirmed bool
+	}{
+		{"yes\n", false, true},
+		{" YES \n", false, true},
+		{"no\n", false, false},
+		{"\n", false, false},
+		{"yes\r", true, true},
+		{"y\r", true, false},
+	} {
+		channel := &testChannel{Reader: bytes.NewBufferString(test.input)}
+		if confirmed := confirmAgentAudit(testLogger, channel, newLineReader(channel, test.pty)); confirmed != test.confirmed {
+			t.Errorf("%q with pty %t: got %t, expected %t", test.input, test.pty, confirmed, test.confirmed)
+		}
+	}
+}
+
+// Users who don't answer within agentConfirmTimeout are taken to refuse,
+// and what they type afterwards is left for the next reader
+func TestConfirmAgentAuditTimeout(t *testing.T) {
+	c := useFakeClock(t)
+	input, answer := io.Pipe()
+	defer answer.Close()
+	channel := &testChannel{Reader: input}
+	lines := newLineReader(channel, false)
+
+	confirmed := make(chan bool, 1)
+	go func() {
+		confirmed <- confirmAgentAudit(testLogger, channel, lines)
+	}()
+
+	c.waitFor(t, agentConfirmTimeout)
+	c.Advance(agentConfirmTimeout - time.Second)
+	select {
+	case <-confirmed:
+		t.Fatal("gave up waiting before agentConfirmTimeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	select {
+	case ok := <-confirmed:
+		if ok {
+			t.Error("confirmed without an answer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after agentConfirmTimeout")
+	}
+
+	go answer.Write([]byte("keys\n"))
+	line, ok, err := lines.readLine("", time.After(5*time.Second))
+	if !ok || err != nil || line != "keys\n" {
+		t.Errorf("got %q (read %t, %v), expected the line typed after the timeout", line, ok, err)
+	}
+}
+
+func TestMismatchedType(t *testing.T) {
+	p256 := generateKey(t, "ecdsa-256")
+	p384 := generateKey(t, "ecdsa-384")
+
+	// A P-384 key whose blob claims to be on P-256, which the ssh package
+	// parses as P-384 all the same
+	relabelled := ssh.Marshal(struct {
+		Type string
+		Rest []byte `ssh:"rest"`
+	}{ssh.KeyAlgoECDSA256, p384.Marshal()[4+len(ssh.KeyAlgoECDSA384):]})
+	parsed, err := ssh.ParsePublicKey(relabelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		format   string
+		blob     []byte
+		key      ssh.PublicKey
+		declared string
+	}{
+		{"matching", ssh.KeyAlgoECDSA256, p256.Marshal(), p256, ""},
+		{"listed as another type", ssh.KeyAlgoECDSA256, p384.Marshal(), p384, ssh.KeyAlgoECDSA256},
+		{"blob of another type", ssh.KeyAlgoECDSA384, relabelled, parsed, ssh.KeyAlgoECDSA256},
+	} {
+		if declared := mismatchedType(test.format, test.blob, test.key); declared != test.declared {
+			t.Errorf("%s: got %q, expected %q", test.name, declared, test.declared)
+		}
+	}
+}
+
+func TestAnalyzeTypeMismatch(t *testing.T) {
+	k := &publicKey{key: generateKey(t, "ecdsa-384"), declaredType: ssh.KeyAlgoECDSA256}
+	a := analyze(testLogger, []*publicKey{k}, nil, nil)
+	if !a.typeMismatch || a.results[0].issue != issueTypeMismatch {
+		t.Errorf("got %q, expected %q", a.results[0].issue, issueTypeMismatch)
+	}
+}
+
+// agentConn is an ssh.Conn whose forwarded agent lists the given keys
+type agentConn struct {
+	ssh.Conn
+	keys [][]byte
+}
+
+func (c agentConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	// The agent's answer to the request for its identities, as described
+	// in PROTOCOL.agent in OpenSSH
+	answer := ssh.Marshal(struct {
+		Type  byte
+		Count uint32
+	}{12, uint32(len(c.keys))})
+	for _, blob := range c.keys {
+		answer = append(answer, ssh.Marshal(struct{ Blob, Comment string }{string(blob), "test"})...)
+	}
+	reqs := make(chan *ssh.Request)
+	close(reqs)
+
+	return &testChannel{Reader: bytes.NewReader(ssh.Marshal(struct{ Message string }{string(answer)}))}, reqs, nil
+}
+
+// padExponent returns the RSA key's blob with a zero byte before its
+// exponent, which it doesn't need
+func padExponent(t *testing.T, key ssh.PublicKey) []byte {
+	var wire struct {
+		Type string
+		E, N []byte
+	}
+	if err := ssh.Unmarshal(key.Marshal(), &wire); err != nil {
+		t.Fatal(err)
+	}
+	wire.E = append([]byte{0}, wire.E...)
+
+	return ssh.Marshal(wire)
+}
+
+func TestAgentKeysNonCanonical(t *testing.T) {
+	rsaKey := generateKey(t, "rsa-2048")
+	p256 := generateKey(t, "ecdsa-256")
+	p384 := generateKey(t, "ecdsa-384")
+	relabelled := ssh.Marshal(struct {
+		Type string
+		Rest []byte `ssh:"rest"`
+	}{ssh.KeyAlgoECDSA256, p384.Marshal()[4+len(ssh.KeyAlgoECDSA384):]})
+
+	// Malformed keys are skipped
+	truncated := p256.Marshal()[:len(p256.Marshal())-5]
+	keys, err := agentKeys(testLogger, agentConn{keys: [][]byte{rsaKey.Marshal(), padExponent(t, rsaKey), truncated, p256.Marshal(), relabelled}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range []struct {
+		name         string
+		nonCanonical bool
+		declared     string
+	}{
+		{"RSA key", false, ""},
+		{"RSA key with a padded exponent", true, ""},
+		{"ECDSA key", false, ""},
+		{"P-384 key whose blob claims P-256", false, ssh.KeyAlgoECDSA256},
+	} {
+		if i >= len(keys) {
+			t.Fatalf("got %d keys, expected 4", len(keys))
+		}
+		if keys[i].nonCanonical != test.nonCanonical || keys[i].declaredType != test.declared {
+			t.Errorf("%s: got non-canonical %t, declared %q, expected %t, %q", test.name, keys[i].nonCanonical, keys[i].declaredType, test.nonCanonical, test.declared)
+		}
+	}
+
+	a := analyze(testLogger, keys[:2], nil, nil)
+	if !a.nonCanonical || len(a.nonCanonicalKeys) != 1 || a.results[1].issue != issueNonCanonical || a.results[0].issue == issueNonCanonical {
+		t.Errorf("got %q and %q, expected only the second key to be %q", a.results[0].issue, a.results[1].issue, issueNonCanonical)
+	}
+}
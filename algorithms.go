@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The transport algorithms supported by the ssh package, from which the
+// server's offer can be chosen using CIPHERS, KEY_EXCHANGES and MACS
+var (
+	supportedCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	supportedKeyExchanges = []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	}
+	supportedMACs = []string{"hmac-sha1", "hmac-sha1-96"}
+)
+
+// The algorithms offered when RESTRICT_TRANSPORT is set. RC4 has practical
+// biases, the 1024-bit Oakley group is within reach of precomputation, and
+// diffie-hellman-group14-sha1 was dropped from OpenSSH's defaults in 8.2.
+// The ssh package only offers SHA-1 HMACs, which are still sound; the
+// truncated hmac-sha1-96 is left out.
+var (
+	restrictedCiphers      = []string{"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"}
+	restrictedKeyExchanges = []string{"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521"}
+	restrictedMACs         = []string{"hmac-sha1"}
+)
+
+// parseAlgorithms parses a comma-separated list of algorithms, in the order
+// they should be preferred, each of which must be one of those supported
+func parseAlgorithms(s string, supported []string) ([]string, error) {
+	var algos []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if !offers(supported, a) {
+			return nil, fmt.Errorf("unsupported algorithm %q, expected one of %s", a, strings.Join(supported, ", "))
+		}
+		algos = append(algos, a)
+	}
+
+	return algos, nil
+}
+
+// noCommonAlgorithms reports whether the handshake failed because the client
+// didn't offer any of the algorithms the server supports for some purpose
+func noCommonAlgorithms(err error) bool {
+	return err.Error() == "ssh: no common algorithms"
+}
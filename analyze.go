@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyResult is the outcome of checking a single key
+type keyResult struct {
+	// index is the key's position in the order presented, counting from 1
+	index int
+
+	key      *publicKey
+	length   int
+	issue    string
+	accepted string
+
+	// all lists every issue found with the key, in the order found; issue
+	// is the last of them unless the key is exempt
+	all []string
+
+	// fips is whether the key complies with the FIPS policy, if one is in
+	// use
+	fips string
+}
+
+// bits returns the key's length for display, or "?" if it is unknown
+func (r keyResult) bits() string {
+	if r.key.parseErr != nil {
+		return "?"
+	}
+
+	return strconv.Itoa(r.length)
+}
+
+// strength returns the key's security strength for display, or "?" if it is
+// unknown
+func (r keyResult) strength() string {
+	strength, err := r.key.SecurityStrength()
+	switch {
+	case err != nil:
+		return "?"
+	case strength == 0:
+		return "<80"
+	}
+
+	return strconv.Itoa(strength)
+}
+
+// rank orders results by the severity of their issue, placing keys with no
+// known issues last
+func (r keyResult) rank() int {
+	name, ok := issueSeverities[r.issue]
+	if !ok {
+		return -1
+	}
+
+	return int(severities[name])
+}
+
+type bySeverity []keyResult
+
+func (s bySeverity) Len() int           { return len(s) }
+func (s bySeverity) Less(i, j int) bool { return s[i].rank() > s[j].rank() }
+func (s bySeverity) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// bySeverityAndType orders results by the severity of their issue, and
+// those of the same severity by key type
+type bySeverityAndType []keyResult
+
+func (s bySeverityAndType) Len() int      { return len(s) }
+func (s bySeverityAndType) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySeverityAndType) Less(i, j int) bool {
+	if s[i].rank() != s[j].rank() {
+		return s[i].rank() > s[j].rank()
+	}
+
+	return s[i].key.key.Type() < s[j].key.key.Type()
+}
+
+// severityOrder returns the results with the most severe issues first, then
+// by key type, otherwise in the order the keys were presented
+func severityOrder(results []keyResult) []keyResult {
+	sorted := append([]keyResult(nil), results...)
+	sort.Stable(bySeverityAndType(sorted))
+
+	return sorted
+}
+
+// mostSevere returns the n results with the most severe issues, otherwise
+// in the order the keys were presented
+func mostSevere(results []keyResult, n int) []keyResult {
+	sorted := append([]keyResult(nil), results...)
+	sort.Stable(bySeverity(sorted))
+
+	return sorted[:n]
+}
+
+// analysis is the outcome of checking all of the keys presented by a client
+type analysis struct {
+	results []keyResult
+
+	blacklisted, weak, dsa, strong, modern, collision, mismatch bool
+	weakerThanHost, wellKnown, unparseable, sharedModulus       bool
+	revoked, weakModulus, containerImage, trivialModulus        bool
+	knownFactor, lowEntropy, weakCurve, typeMismatch            bool
+	nonCanonical                                                bool
+
+	// minimumCurve is set if any ECDSA key is on P-256, the weakest of
+	// the curves accepted
+	minimumCurve bool
+
+	// weakSHA1 is set if weak RSA keys were presented by a client that can
+	// only sign with them using ssh-rsa (SHA-1), so need more than a longer
+	// key to keep working
+	weakSHA1 bool
+
+	// exemplary is set if every key is modern, or RSA of at least 3072
+	// bits, and has no known issues
+	exemplary bool
+
+	// strongRSA is set if any RSA key is long enough, as users often
+	// mistake the deprecation of ssh-rsa signatures for a weakness in
+	// their key
+	strongRSA bool
+
+	// legacy lists the fingerprints of keys with issues, and
+	// blacklistSources and wellKnownSources where each blacklisted or
+	// well-known key was found
+	legacy, blacklistSources, wellKnownSources []string
+
+	// containerImages lists the fingerprints of keys found in public
+	// container images, and which image
+	containerImages []string
+
+	// exemptions lists the fingerprints of exempt keys that had issues,
+	// along with the issue and why they are exempt
+	exempt     bool
+	exemptions []string
+
+	// revocations lists the fingerprints of revoked keys, and why
+	revocations []string
+
+	// typeMismatches lists the fingerprints of keys the forwarded agent
+	// listed as a different type, and which
+	typeMismatches []string
+
+	// nonCanonicalKeys lists the fingerprints of keys the forwarded agent
+	// didn't encode the way the ssh package does
+	nonCanonicalKeys []string
+
+	// weakModuli lists the fingerprints of RSA keys whose moduli were
+	// factored by the experimental modulus checks, and how
+	weakModuli []string
+
+	// trivialModuli lists the fingerprints of RSA keys whose moduli are
+	// trivially factorable, and why
+	trivialModuli []string
+
+	// factoredModuli lists the fingerprints of RSA keys whose moduli are
+	// divisible by a known factor, and which
+	factoredModuli []string
+
+	// lowEntropyKeys lists the fingerprints of keys that entropyCheck found
+	// were generated with too little entropy, and why
+	lowEntropyKeys []string
+
+	// sharedModuli lists the fingerprints of each pair of RSA keys that
+	// share a modulus
+	sharedModuli []string
+
+	// weakCurves lists the fingerprints of ECDSA keys on curves that
+	// aren't accepted, and which curve
+	weakCurves []string
+
+	// unparseableErrs lists the fingerprints of keys that couldn't be
+	// parsed, and why
+	unparseableErrs []string
+
+	// notEvaluated lists the checks that took longer than checkTimeout, so
+	// were left out
+	notEvaluated []string
+
+	// fipsFailures counts the keys that don't comply with the FIPS policy
+	fipsFailures int
+
+	// hostBits is the RSA equivalent length of the host key
+	hostBits int
+
+	issueCounts map[string]int
+}
+
+// analyze checks each of the given keys for known issues, given the client's
+// key exchange offer, which may be nil if it couldn't be recorded. If
+// checked isn't nil, it's called with each key's result as soon as the key
+// has been checked.
+func analyze(logger *log.Entry, keys []*publicKey, client *kexInitMsg, checked func(keyResult)) *analysis {
+	markBlacklistedKeys(keys)
+
+	a := &analysis{issueCounts: make(map[string]int), exemplary: len(keys) > 0}
+	a.hostBits, _ = (&publicKey{key: hostKey.PublicKey()}).RSAEquivalentBits()
+	fingerprintTypes := make(map[string]string)
+	moduli := make(map[string]string)
+
+	// Keys that share a prime can all be factored, whichever order they
+	// were presented in
+	var prime map[*publicKey]string
+	if modulusChecks {
+		var shared map[*publicKey]string
+		if withinTimeout(logger, "shared primes", func(stop <-chan struct{}) { shared = sharedPrimes(keys, stop) }) {
+			prime = shared
+		} else {
+			a.notEvaluated = append(a.notEvaluated, "Shared primes between keys")
+		}
+	}
+
+	for _, k := range keys {
+		// Each issue found overrides those found before it in the table,
+		// but all of them are kept
+		issues := issueNone
+		var all []string
+		found := func(issue string) {
+			issues = issue
+			all = append(all, issue)
+		}
+
+		// The issues of exempt keys are noted, but don't otherwise count
+		// towards the report
+		target := a
+		note, isExempt := exempt(k)
+		if isExempt {
+			target = &analysis{hostBits: a.hostBits, issueCounts: make(map[string]int)}
+		}
+
+		// Public keys are all that clients ever send, so there is no
+		// private material to leak here
+		if logOfferedKeys {
+			logger.Debugf("Offered key: %s", bytes.TrimSpace(ssh.MarshalAuthorizedKey(k.key)))
+		}
+
+		length, err := k.BitLen()
+		k.parseErr = err
+
+		if k.Modern() && err == nil {
+			a.modern = true
+		}
+
+		// The claimed length is only worth flagging if it differs from
+		// the actual length; any weaknesses found below take priority
+		if claimed, err := k.ClaimedBitLen(); err == nil && claimed != length {
+			found(issueMismatch)
+			target.mismatch = true
+			logger.Warnf("%s key %s claims to be %d bits but is %d bits", k.key.Type(), k.LogFingerprint(), claimed, length)
+		}
+
+		// The key is otherwise sound, but strict servers may reject it
+		if k.nonCanonical {
+			found(issueNonCanonical)
+			target.nonCanonical = true
+			target.nonCanonicalKeys = append(target.nonCanonicalKeys, k.Fingerprint())
+			logger.Warnf("Forwarded agent listed %s key %s with a non-canonical encoding", k.key.Type(), k.LogFingerprint())
+		}
+
+		// Certificates are judged by the key they certify
+		keyType := certifiedType(k)
+
+		if keyType == ssh.KeyAlgoDSA {
+			found(issueDSA)
+			target.dsa = true
+		}
+
+		// The ssh package only parses keys on the accepted curves, so
+		// keys on any other curve are also unparseable, but their curve
+		// is the more useful thing to report
+		curve, ecdsa := k.ECDSACurve()
+		weakCurve := ecdsa && !keycheck.AcceptedCurve(curve)
+
+		// Nothing more can be said about the key's strength if its
+		// parameters can't be parsed
+		if err != nil && !weakCurve {
+			found(issueUnparseable)
+			target.unparseable = true
+			target.unparseableErrs = append(target.unparseableErrs, k.Fingerprint()+" ("+err.Error()+")")
+			logger.Errorf("Failed to parse %s key %s: %s", k.key.Type(), k.LogFingerprint(), err)
+		}
+
+		if weakCurve {
+			found(issueWeakCurve)
+			target.weakCurve = true
+			target.weakCurves = append(target.weakCurves, k.Fingerprint()+" ("+curve+")")
+			logger.Warnf("ECDSA key %s is on curve %q", k.LogFingerprint(), curve)
+		} else if curve == "nistp256" {
+			target.minimumCurve = true
+		}
+
+		if err == nil && length < keycheck.MinRSABits && keyType == ssh.KeyAlgoRSA {
+			found(issueWeak)
+			target.weak = true
+			if sha1Only(client) {
+				found(issueWeakSHA1)
+				target.weakSHA1 = true
+			}
+		}
+
+		if length >= keycheck.MinRSABits && length < target.hostBits && keyType == ssh.KeyAlgoRSA {
+			target.weakerThanHost = true
+		}
+
+		if length >= keycheck.MinRSABits && keyType == ssh.KeyAlgoRSA {
+			target.strongRSA = true
+		}
+
+		if k.blacklisted {
+			// being blacklisted takes priority of any key length weaknesses
+			switch {
+			case k.blacklistDebian:
+				found(issueBlacklistedDebian)
+			case k.blacklistLocal:
+				found(issueBlacklistedLocal)
+			default:
+				found(issueBlacklisted)
+			}
+			target.blacklisted = true
+			target.blacklistSources = append(target.blacklistSources, k.Fingerprint()+" ("+k.blacklistSource+")")
+			logger.Warnf("Blacklisted %s key %s found in %s", k.key.Type(), k.LogFingerprint(), k.blacklistSource)
+		}
+
+		if reason, ok := revoked(k); ok {
+			found(issueRevoked)
+			target.revoked = true
+			target.revocations = append(target.revocations, k.Fingerprint()+" ("+reason+")")
+			logger.Warnf("Revoked %s key %s presented (%s)", k.key.Type(), k.LogFingerprint(), reason)
+		} else if reason, ok := serialRevoked(k); ok {
+			found(issueRevokedSerial)
+			target.revoked = true
+			target.revocations = append(target.revocations, k.Fingerprint()+" ("+reason+")")
+			logger.Warnf("Revoked %s certificate %s presented (%s)", k.key.Type(), k.LogFingerprint(), reason)
+		}
+
+		// Keys sharing a modulus can only differ in their exponent, and
+		// the private key for one reveals the factors of the modulus. A
+		// certificate shares its modulus with the key it certifies, so
+		// they're compared by the certified key.
+		if keyType == ssh.KeyAlgoRSA && err == nil {
+			if n, err := keycheck.RSAModulus(k.key); err == nil {
+				certified := k.Fingerprint()
+				if cert, ok := k.key.(*ssh.Certificate); ok {
+					certified = (&publicKey{key: cert.Key}).Fingerprint()
+				}
+				if other, ok := moduli[n.String()]; ok && other != certified {
+					found(issueSharedModulus)
+					target.sharedModulus = true
+					target.sharedModuli = append(target.sharedModuli, k.Fingerprint()+" and "+other)
+					logger.Warnf("RSA key %s shares its modulus with another key presented", k.LogFingerprint())
+				}
+				moduli[n.String()] = certified
+
+				if modulusChecks {
+					var reason string
+					var weak bool
+					if !withinTimeout(logger, "modulus weaknesses of RSA key "+k.LogFingerprint(), func(stop <-chan struct{}) { reason, weak = modulusWeakness(n, stop) }) {
+						a.notEvaluated = append(a.notEvaluated, "Experimental modulus checks for "+k.Fingerprint())
+					} else {
+						if other, ok := prime[k]; ok && !weak {
+							reason, weak = "shares a prime with "+other, true
+						}
+
+						if weak {
+							found(issueWeakModulus)
+							target.weakModulus = true
+							target.weakModuli = append(target.weakModuli, k.Fingerprint()+" ("+reason+")")
+							logger.Warnf("RSA key %s %s", k.LogFingerprint(), reason)
+						}
+					}
+				}
+
+				var reason string
+				var trivial, factored bool
+				evaluated := withinTimeout(logger, "modulus structure of RSA key "+k.LogFingerprint(), func(stop <-chan struct{}) {
+					if reason, trivial = keycheck.TriviallyFactorable(n); !trivial {
+						reason, factored = knownFactorOf(n, stop)
+					}
+				})
+
+				switch {
+				case !evaluated:
+					a.notEvaluated = append(a.notEvaluated, "Modulus structure and known factors for "+k.Fingerprint())
+				case trivial:
+					found(issueTrivialModulus)
+					target.trivialModulus = true
+					target.trivialModuli = append(target.trivialModuli, k.Fingerprint()+" (modulus "+reason+")")
+					logger.Warnf("RSA key %s has a modulus that %s", k.LogFingerprint(), reason)
+				case factored:
+					found(issueKnownFactor)
+					target.knownFactor = true
+					target.factoredModuli = append(target.factoredModuli, k.Fingerprint()+" (modulus "+reason+")")
+					logger.Warnf("RSA key %s has a modulus %s", k.LogFingerprint(), reason)
+				}
+			}
+		}
+
+		var reason string
+		var lowEntropy bool
+		if !withinTimeout(logger, "entropy of "+k.key.Type()+" key "+k.LogFingerprint(), func(stop <-chan struct{}) { reason, lowEntropy = entropyCheck.checkEntropy(k, stop) }) {
+			a.notEvaluated = append(a.notEvaluated, "Entropy heuristics for "+k.Fingerprint())
+		} else if lowEntropy {
+			found(issueLowEntropy)
+			target.lowEntropy = true
+			target.lowEntropyKeys = append(target.lowEntropyKeys, k.Fingerprint()+" ("+reason+")")
+			logger.Warnf("%s key %s appears to have been generated with too little entropy (%s)", k.key.Type(), k.LogFingerprint(), reason)
+		}
+
+		// Anyone who pulls the image can extract its keys
+		if image, ok := containerImageKeys[k.FingerprintSHA256()]; ok {
+			found(issueContainerImage)
+			target.containerImage = true
+			target.containerImages = append(target.containerImages, k.Fingerprint()+" ("+image+")")
+			logger.Warnf("Container image %s key %s presented (%s)", k.key.Type(), k.LogFingerprint(), image)
+		}
+
+		// Anyone can use a well-known key, which is worse still
+		if source, ok := wellKnownKeys[k.FingerprintSHA256()]; ok {
+			found(issueWellKnown)
+			target.wellKnown = true
+			target.wellKnownSources = append(target.wellKnownSources, k.Fingerprint()+" ("+source+")")
+			logger.Warnf("Well-known %s key %s presented (%s)", k.key.Type(), k.LogFingerprint(), source)
+		}
+
+		// Agents should never list a key as a different type, so this too
+		// indicates a bug or tampering
+		if k.declaredType != "" {
+			found(issueTypeMismatch)
+			target.typeMismatch = true
+			target.typeMismatches = append(target.typeMismatches, k.Fingerprint()+" (listed as "+k.declaredType+", but is "+k.key.Type()+")")
+			logger.Errorf("Forwarded agent listed %s key %s as %s", k.key.Type(), k.LogFingerprint(), k.declaredType)
+		}
+
+		// Keys of different types should never share a fingerprint,
+		// so this indicates a bug in the client or tampering
+		if t, ok := fingerprintTypes[k.Fingerprint()]; ok && t != k.key.Type() {
+			found(issueCollision)
+			target.collision = true
+			logger.Errorf("Fingerprint %s presented for both %s and %s keys", k.LogFingerprint(), t, k.key.Type())
+		}
+		fingerprintTypes[k.Fingerprint()] = k.key.Type()
+
+		if isExempt && issues != issueNone {
+			a.exempt = true
+			a.exemptions = append(a.exemptions, k.Fingerprint()+" ("+issues+"; "+note+")")
+			logger.Infof("Exempt %s key %s has issue %s (%s)", k.key.Type(), k.LogFingerprint(), issues, note)
+			issues = issueExempt
+		}
+
+		target.issueCounts[issues]++
+
+		if issues != issueNone || !(k.Modern() || keyType == ssh.KeyAlgoRSA && length >= 3072) {
+			a.exemplary = false
+		}
+
+		if issues == issueNone {
+			a.strong = true
+		} else {
+			target.legacy = append(target.legacy, k.Fingerprint())
+		}
+
+		compliance, compliant := fipsCompliance(k)
+		if !compliant {
+			a.fipsFailures++
+		}
+
+		r := keyResult{
+			index:    len(a.results) + 1,
+			key:      k,
+			length:   length,
+			issue:    issues,
+			all:      all,
+			accepted: acceptance(k),
+			fips:     compliance,
+		}
+		a.results = append(a.results, r)
+		if checked != nil {
+			checked(r)
+		}
+	}
+
+	return a
+}
+
+// inScope reports whether the named issue is reported, given REPORT_ONLY
+func inScope(name string) bool {
+	return reportOnly == nil || reportOnly[name]
+}
+
+// scoped returns the issue to show for a key, which is issueOutOfScope if
+// the key's issue isn't reported
+func scoped(issue string) string {
+	if name, ok := issueSeverities[issue]; ok && !inScope(name) {
+		return issueOutOfScope
+	}
+	return issue
+}
+
+// leaveOutOfScope removes the issues that aren't reported from the keys'
+// results, the counts of each issue and the keys advised against, once the
+// totals have been recorded
+func leaveOutOfScope(a *analysis) {
+	if reportOnly == nil {
+		return
+	}
+
+	outOfScope := make(map[string]bool)
+	for i, r := range a.results {
+		if a.results[i].issue = scoped(r.issue); a.results[i].issue == issueOutOfScope {
+			outOfScope[r.key.Fingerprint()] = true
+		}
+	}
+	var legacy []string
+	for _, fp := range a.legacy {
+		if !outOfScope[fp] {
+			legacy = append(legacy, fp)
+		}
+	}
+	a.legacy = legacy
+	for issue := range a.issueCounts {
+		if scoped(issue) == issueOutOfScope {
+			delete(a.issueCounts, issue)
+		}
+	}
+}
+
+// acceptance describes whether OpenSSH 9 accepts the key, for the table
+func acceptance(k *publicKey) string {
+	if ok, reason := openssh9.accepts(k); !ok {
+		return "No: " + reason
+	}
+	return "Yes"
+}
+
+// fipsCompliance describes whether the key complies with the FIPS policy,
+// for the table, and reports whether it does. The description is empty if
+// no FIPS policy is in use.
+func fipsCompliance(k *publicKey) (string, bool) {
+	if fips == nil {
+		return "", true
+	}
+	if ok, reason := fips.accepts(k); !ok {
+		return "Non-compliant: " + reason, false
+	}
+	return "Compliant", true
+}
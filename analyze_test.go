@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// generatedKeys caches the keys generated by generateKey, by name, as large
+// RSA keys are slow to generate
+var generatedKeys = struct {
+	sync.Mutex
+	keys map[string]ssh.PublicKey
+}{keys: make(map[string]ssh.PublicKey)}
+
+// generateKey returns a freshly generated public key of the named type and
+// size, e.g. "rsa-2048", the same key each time it is asked for
+func generateKey(tb testing.TB, name string) ssh.PublicKey {
+	generatedKeys.Lock()
+	defer generatedKeys.Unlock()
+	if k, ok := generatedKeys.keys[name]; ok {
+		return k
+	}
+
+	var private interface{}
+	var err error
+	switch name {
+	case "rsa-1024", "rsa-2048", "rsa-3072", "rsa-4096":
+		bits, _ := strconv.Atoi(name[len("rsa-"):])
+		private, err = rsa.GenerateKey(rand.Reader, bits)
+	case "dsa-1024":
+		k := new(dsa.PrivateKey)
+		if err = dsa.GenerateParameters(&k.Parameters, rand.Reader, dsa.L1024N160); err == nil {
+			err = dsa.GenerateKey(k, rand.Reader)
+		}
+		private = k
+	case "ecdsa-256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-384":
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		tb.Fatalf("no key named %q", name)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	generatedKeys.keys[name] = signer.PublicKey()
+
+	return signer.PublicKey()
+}
+
+// testLogger is the logger passed to the checks in tests
+var testLogger = log.NewEntry(log.StandardLogger())
+
+// analyzeKeys returns the analysis of the given keys, as the server would
+// make it
+func analyzeKeys(keys ...ssh.PublicKey) *analysis {
+	var offered []*publicKey
+	for _, k := range keys {
+		offered = append(offered, &publicKey{key: k})
+	}
+
+	return analyze(testLogger, offered, nil, nil)
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	for _, name := range []string{"rsa-2048", "rsa-4096", "dsa-1024", "ecdsa-256", "ecdsa-384"} {
+		b.Run(name, func(b *testing.B) {
+			key := generateKey(b, name)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				analyzeKeys(key)
+			}
+		})
+	}
+}
+
+// The experimental modulus checks add the most work to each RSA key
+func BenchmarkAnalyzeModulusChecks(b *testing.B) {
+	defer func(enabled bool) { modulusChecks = enabled }(modulusChecks)
+	modulusChecks = true
+
+	for _, name := range []string{"rsa-2048", "rsa-4096"} {
+		b.Run(name, func(b *testing.B) {
+			key := generateKey(b, name)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				analyzeKeys(key)
+			}
+		})
+	}
+}
+
+// Keys a bit short of the length their type implies are flagged, and logged
+// for operators to investigate
+func TestAnalyzeSizeMismatch(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		mismatch bool
+	}{
+		{"rsa-2048", generateKey(t, "rsa-2048"), false},
+		{"rsa-2047", shortRSAKey(t, 2047), true},
+		{"rsa-3071", shortRSAKey(t, 3071), true},
+		{"rsa-2046", shortRSAKey(t, 2046), false},
+		{"ecdsa-256", generateKey(t, "ecdsa-256"), false},
+	} {
+		logs := captureLogs(t)
+		a := analyzeKeys(test.key)
+		if a.mismatch != test.mismatch {
+			t.Errorf("%s: got mismatch %t, expected %t", test.name, a.mismatch, test.mismatch)
+		}
+		if logged := logs.contains("claims to be"); logged != test.mismatch {
+			t.Errorf("%s: got mismatch logged %t, expected %t", test.name, logged, test.mismatch)
+		}
+	}
+}
+
+// Keys whose parameters can't be parsed are reported as unparseable, with
+// the error, rather than being given a length
+func TestAnalyzeUnparseable(t *testing.T) {
+	rsaKey, ecdsaKey := generateKey(t, "rsa-2048"), generateKey(t, "ecdsa-256")
+	offCurve := ssh.Marshal(struct {
+		Name, Curve string
+		KeyBytes    []byte
+	}{ssh.KeyAlgoECDSA256, "nistp256", append([]byte{4}, make([]byte, 64)...)})
+
+	for _, test := range []struct {
+		name string
+		key  ssh.PublicKey
+		err  string
+	}{
+		{"truncated RSA modulus", malformedKey{rsaKey, rsaKey.Marshal()[:len(rsaKey.Marshal())-10]}, "ssh: short read"},
+		{"ECDSA point not on its curve", malformedKey{ecdsaKey, offCurve}, "ECDSA X or Y points were nil"},
+		{"sound key", rsaKey, ""},
+	} {
+		a := analyzeKeys(test.key)
+		r := a.results[0]
+		if test.err == "" {
+			if a.unparseable || r.key.parseErr != nil || r.bits() != "2048" {
+				t.Errorf("%s: got %q, %v, %s bits, expected it to be parsed", test.name, r.issue, r.key.parseErr, r.bits())
+			}
+			continue
+		}
+
+		if !a.unparseable || r.issue != issueUnparseable || r.bits() != "?" {
+			t.Errorf("%s: got %q, %s bits, expected %q, ? bits", test.name, r.issue, r.bits(), issueUnparseable)
+		}
+		if len(a.unparseableErrs) != 1 || !strings.HasPrefix(a.unparseableErrs[0], r.key.Fingerprint()+" ("+test.err) {
+			t.Errorf("%s: got errors %q, expected %q", test.name, a.unparseableErrs, test.err)
+		}
+	}
+}
+
+// Truncated tables keep the keys with the most severe issues, however late
+// they were presented, then the first of the others
+func TestMostSevere(t *testing.T) {
+	var keys []ssh.PublicKey
+	for i := 0; i < 50; i++ {
+		keys = append(keys, testSigner(t).PublicKey())
+	}
+	// The DSA and weak keys are warnings, and the short RSA key's modulus
+	// has small factors, which is critical
+	keys[20], keys[40], keys[45] = generateKey(t, "dsa-1024"), generateKey(t, "rsa-1024"), shortRSAKey(t, 2047)
+	a := analyzeKeys(keys...)
+
+	for _, test := range []struct {
+		n        int
+		expected []int
+	}{
+		{1, []int{46}},
+		{3, []int{46, 21, 41}},
+		{5, []int{46, 21, 41, 1, 2}},
+		{50, nil},
+	} {
+		shown := mostSevere(a.results, test.n)
+		if len(shown) != test.n {
+			t.Fatalf("%d rows: got %d", test.n, len(shown))
+		}
+
+		var got []int
+		for _, r := range shown[:len(test.expected)] {
+			got = append(got, r.index)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%d rows: got keys %v, expected %v", test.n, got, test.expected)
+		}
+	}
+}
+
+// RSA keys sharing a modulus are flagged, whatever their exponents, but a
+// key presented twice isn't
+func TestAnalyzeSharedModulus(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withExponent := func(e int) ssh.PublicKey {
+		key, err := ssh.NewPublicKey(&rsa.PublicKey{N: private.N, E: e})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	f4, e3, e17 := withExponent(65537), withExponent(3), withExponent(17)
+	fingerprint := func(k ssh.PublicKey) string { return (&publicKey{key: k}).Fingerprint() }
+
+	for _, test := range []struct {
+		name     string
+		keys     []ssh.PublicKey
+		expected []string
+	}{
+		{"same modulus", []ssh.PublicKey{f4, generateKey(t, "ecdsa-256"), e3}, []string{fingerprint(e3) + " and " + fingerprint(f4)}},
+		{"three keys", []ssh.PublicKey{f4, e3, e17}, []string{fingerprint(e3) + " and " + fingerprint(f4), fingerprint(e17) + " and " + fingerprint(e3)}},
+		{"same key twice", []ssh.PublicKey{f4, f4}, nil},
+		{"key and its certificate", []ssh.PublicKey{f4, testCertificate(t, testSigner(t), f4, 1, "")}, nil},
+		{"different moduli", []ssh.PublicKey{f4, generateKey(t, "rsa-2048")}, nil},
+	} {
+		a := analyzeKeys(test.keys...)
+		if a.sharedModulus != (test.expected != nil) || !reflect.DeepEqual(a.sharedModuli, test.expected) {
+			t.Errorf("%s: got %t, %q, expected %q", test.name, a.sharedModulus, a.sharedModuli, test.expected)
+		}
+		if last := a.results[len(a.results)-1]; test.expected != nil && last.issue != issueSharedModulus {
+			t.Errorf("%s: got %q for the last key, expected %q", test.name, last.issue, issueSharedModulus)
+		}
+	}
+}
+
+// Certificates are judged by the key they certify, as if it were presented
+// itself
+func TestAnalyzeCertificates(t *testing.T) {
+	ca := testSigner(t)
+	certify := func(name string) ssh.PublicKey { return testCertificate(t, ca, generateKey(t, name), 1, "") }
+
+	for _, test := range []struct {
+		name                         string
+		key                          ssh.PublicKey
+		issue                        string
+		weak, dsa, strongRSA, modern bool
+	}{
+		{"weak RSA", certify("rsa-1024"), issueWeak, true, false, false, false},
+		{"RSA", certify("rsa-2048"), issueNone, false, false, true, false},
+		{"DSA", certify("dsa-1024"), issueDSA, false, true, false, false},
+		{"ECDSA", certify("ecdsa-256"), issueNone, false, false, false, true},
+	} {
+		a := analyzeKeys(test.key)
+		if r := a.results[0]; r.issue != test.issue {
+			t.Errorf("%s: got %q, expected %q", test.name, r.issue, test.issue)
+		}
+		if a.weak != test.weak || a.dsa != test.dsa || a.strongRSA != test.strongRSA || a.modern != test.modern {
+			t.Errorf("%s: got weak=%t dsa=%t strongRSA=%t modern=%t, expected %t, %t, %t, %t",
+				test.name, a.weak, a.dsa, a.strongRSA, a.modern, test.weak, test.dsa, test.strongRSA, test.modern)
+		}
+		if a.strong == (test.issue != issueNone) {
+			t.Errorf("%s: got strong=%t", test.name, a.strong)
+		}
+	}
+}
+
+// KEY_ORDER=severity lists keys with the most severe issues first, then by
+// type, otherwise in the order presented
+func TestSeverityOrder(t *testing.T) {
+	defer func(s severity) { severities["dsa"] = s }(severities["dsa"])
+
+	a := analyzeKeys(
+		generateKey(t, "ecdsa-256"),
+		generateKey(t, "rsa-2048"),
+		generateKey(t, "dsa-1024"),
+		generateKey(t, "rsa-1024"),
+		generateKey(t, "ecdsa-384"),
+		shortRSAKey(t, 2047),
+		testSigner(t).PublicKey(),
+	)
+
+	for _, test := range []struct {
+		name     string
+		dsa      severity
+		expected []int
+	}{
+		{"default severities", severityWarning, []int{6, 3, 4, 1, 7, 5, 2}},
+		{"DSA keys critical", severityCritical, []int{3, 6, 4, 1, 7, 5, 2}},
+		{"DSA keys a notice", severityNotice, []int{6, 4, 3, 1, 7, 5, 2}},
+	} {
+		severities["dsa"] = test.dsa
+
+		var got []int
+		for _, r := range severityOrder(a.results) {
+			got = append(got, r.index)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got keys %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+// Issues left out by REPORT_ONLY are shown as out of scope, and aren't
+// counted or advised against
+func TestLeaveOutOfScope(t *testing.T) {
+	defer func(scope map[string]bool) { reportOnly = scope }(reportOnly)
+	loadTestBlacklist()
+	_, debian := debianKey(t)
+	keys := []ssh.PublicKey{debian, generateKey(t, "rsa-1024"), generateKey(t, "dsa-1024"), generateKey(t, "ecdsa-256")}
+
+	for _, test := range []struct {
+		name   string
+		scope  map[string]bool
+		issues []string
+		legacy int
+	}{
+		{"everything in scope", nil, []string{issueBlacklistedDebian, issueWeak, issueDSA, issueNone}, 3},
+		{"compromised keys only", map[string]bool{"blacklisted": true}, []string{issueBlacklistedDebian, issueOutOfScope, issueOutOfScope, issueNone}, 1},
+		{"weak keys only", map[string]bool{"weak": true, "dsa": true}, []string{issueOutOfScope, issueWeak, issueDSA, issueNone}, 2},
+	} {
+		reportOnly = test.scope
+		a := analyzeKeys(keys...)
+		leaveOutOfScope(a)
+
+		for i, r := range a.results {
+			if r.issue != test.issues[i] {
+				t.Errorf("%s: key %d has issue %q, expected %q", test.name, r.index, r.issue, test.issues[i])
+			}
+		}
+		for issue := range a.issueCounts {
+			if scoped(issue) == issueOutOfScope {
+				t.Errorf("%s: %q still counted", test.name, issue)
+			}
+		}
+		if len(a.legacy) != test.legacy {
+			t.Errorf("%s: got %d keys advised against, expected %d", test.name, len(a.legacy), test.legacy)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// auditLog is where a JSON object describing each report is appended, one
+// per line, if AUDIT_LOG is set, for ingestion by a SIEM. Unlike the logs,
+// its format is stable and each report is described by a single entry.
+var auditLog = struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}{}
+
+// auditRecord describes a report in the audit log
+type auditRecord struct {
+	Time            time.Time  `json:"time"`
+	RemoteAddr      string     `json:"remote_addr"`
+	ForwardedFor    string     `json:"forwarded_for,omitempty"`
+	Conn            string     `json:"conn"`
+	Ref             string     `json:"ref"`
+	User            string     `json:"user"`
+	Keys            []auditKey `json:"keys"`
+	Issues          []string   `json:"issues"`
+	AgentForwarding bool       `json:"agent_forwarding_requested"`
+	X11Forwarding   bool       `json:"x11_requested"`
+	Verdict         string     `json:"verdict"`
+}
+
+// auditKey describes a key presented by the client. Fingerprints are
+// written as set by LOG_FINGERPRINTS.
+type auditKey struct {
+	Type        string `json:"type"`
+	Bits        string `json:"bits"`
+	Fingerprint string `json:"fingerprint"`
+	Issue       string `json:"issue"`
+}
+
+// openAuditLog opens the audit log named by target, which is either a
+// path to append to or "fd:" followed by an open file descriptor, e.g.
+// "fd:3"
+func openAuditLog(target string) error {
+	if strings.HasPrefix(target, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 0 {
+			return fmt.Errorf("invalid file descriptor: %q", target)
+		}
+
+		auditLog.mu.Lock()
+		auditLog.file = os.NewFile(uintptr(fd), target)
+		auditLog.mu.Unlock()
+		return nil
+	}
+
+	auditLog.mu.Lock()
+	auditLog.path = target
+	auditLog.mu.Unlock()
+
+	return reopenAuditLog()
+}
+
+// reopenAuditLog reopens the audit log file, so that it can be rotated by
+// moving it aside before sending SIGHUP. The existing file is kept open if
+// the file can't be opened. File descriptors are left as they are.
+func reopenAuditLog() error {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	if auditLog.path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(auditLog.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	if auditLog.file != nil {
+		auditLog.file.Close()
+	}
+	auditLog.file = file
+
+	return nil
+}
+
+// audit appends the record to the audit log, if there is one. Each record
+// is written in a single write, so that records aren't interleaved.
+func audit(logger *log.Entry, record auditRecord) {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	if auditLog.file == nil {
+		return
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		logger.Errorln("Failed to encode audit record:", err)
+		return
+	}
+
+	if _, err := auditLog.file.Write(append(b, '\n')); err != nil {
+		logger.Errorln("Failed to write audit record:", err)
+	}
+}
+
+// auditKeys describes each key in the results for the audit log
+func auditKeys(results []keyResult) []auditKey {
+	keys := []auditKey{}
+	for _, r := range results {
+		keys = append(keys, auditKey{
+			Type:        r.key.key.Type(),
+			Bits:        r.bits(),
+			Fingerprint: r.key.LogFingerprint(),
+			Issue:       r.issue,
+		})
+	}
+
+	return keys
+}
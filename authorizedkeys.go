@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+)
+
+// restrictedBy lists the options that each stop a key being used for
+// something other than running its forced command, all of which restrict
+// implies
+var restrictedBy = []string{"no-port-forwarding", "no-agent-forwarding", "no-x11-forwarding"}
+
+// authorizedKeyOption splits an option of an authorized_keys entry, as
+// returned by ssh.ParseAuthorizedKey, into its name, in lower case as
+// options are case insensitive, and its value, if any, without the quotes
+// around it
+func authorizedKeyOption(option string) (name, value string) {
+	parts := strings.SplitN(option, "=", 2)
+	name = strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return name, ""
+	}
+
+	value = parts[1]
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.Replace(value[1:len(value)-1], `\"`, `"`, -1)
+	}
+
+	return name, value
+}
+
+// riskyOptions returns why the options of an authorized_keys entry are
+// risky, if they are: those that let the key be used more widely than its
+// other options suggest was intended. They are only advice, as some of
+// them can be intended.
+func riskyOptions(options []string) []string {
+	values := make(map[string][]string)
+	for _, option := range options {
+		name, value := authorizedKeyOption(option)
+		values[name] = append(values[name], value)
+	}
+	_, restricted := values["restrict"]
+	_, command := values["command"]
+	_, from := values["from"]
+
+	var risks []string
+	for _, hosts := range values["from"] {
+		for _, pattern := range strings.Split(hosts, ",") {
+			if pattern == "*" || pattern == "*.*.*.*" || pattern == "0.0.0.0/0" || pattern == "::/0" {
+				risks = append(risks, "from="+pattern+" matches every host")
+			}
+		}
+	}
+
+	if command && !restricted {
+		var allowed []string
+		for _, option := range restrictedBy {
+			if _, ok := values[option]; !ok {
+				allowed = append(allowed, option)
+			}
+		}
+		if len(allowed) > 0 {
+			risks = append(risks, "command= doesn't stop the key being used for forwarding without "+strings.Join(allowed, ", ")+" or restrict")
+		}
+	}
+	if command && !from {
+		risks = append(risks, "command= can be run from any host without from=")
+	}
+	if _, noPTY := values["no-pty"]; noPTY && !command {
+		risks = append(risks, "no-pty suggests the key is used by a script, but without command= it can run any command")
+	}
+
+	if _, ok := values["environment"]; ok {
+		risks = append(risks, "environment= can change how commands are run if the server sets PermitUserEnvironment")
+	}
+	if _, ok := values["cert-authority"]; ok {
+		if _, ok := values["principals"]; !ok {
+			risks = append(risks, "cert-authority without principals= accepts certificates for any user the CA names")
+		}
+	}
+	for _, dest := range values["permitopen"] {
+		if dest == "any" || dest == "*:*" {
+			risks = append(risks, "permitopen="+dest+" allows forwarding to every host and port")
+		}
+	}
+	if _, ok := values["no-touch-required"]; ok {
+		risks = append(risks, "no-touch-required lets a security key be used without being touched")
+	}
+
+	return risks
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRiskyOptions(t *testing.T) {
+	for _, test := range []struct {
+		options  string
+		expected []string
+	}{
+		{"", nil},
+		{`restrict,command="/usr/bin/backup",from="192.0.2.0/24"`, nil},
+		{`command="/usr/bin/backup",from="192.0.2.1",no-port-forwarding,no-agent-forwarding,No-X11-Forwarding`, nil},
+		{
+			`command="/usr/bin/backup",no-agent-forwarding`,
+			[]string{
+				"command= doesn't stop the key being used for forwarding without no-port-forwarding, no-x11-forwarding or restrict",
+				"command= can be run from any host without from=",
+			},
+		},
+		{`from="192.0.2.1,*"`, []string{"from=* matches every host"}},
+		{`from="!192.0.2.1,0.0.0.0/0"`, []string{"from=0.0.0.0/0 matches every host"}},
+		{`no-pty`, []string{"no-pty suggests the key is used by a script, but without command= it can run any command"}},
+		{`environment="LD_PRELOAD=/tmp/x.so"`, []string{"environment= can change how commands are run if the server sets PermitUserEnvironment"}},
+		{`cert-authority`, []string{"cert-authority without principals= accepts certificates for any user the CA names"}},
+		{`cert-authority,principals="alice"`, nil},
+		{`permitopen="any"`, []string{"permitopen=any allows forwarding to every host and port"}},
+		{`permitopen="localhost:8080"`, nil},
+		{`no-touch-required`, []string{"no-touch-required lets a security key be used without being touched"}},
+		// Quoted values may contain commas and escaped quotes
+		{`command="echo \"a,b\"",from="192.0.2.1",restrict`, nil},
+	} {
+		entry := string(ssh.MarshalAuthorizedKey(generateKey(t, "ecdsa-256")))
+		if test.options != "" {
+			entry = test.options + " " + entry
+		}
+		_, _, options, _, err := ssh.ParseAuthorizedKey([]byte(entry))
+		if err != nil {
+			t.Fatalf("%s: %s", test.options, err)
+		}
+
+		if risks := riskyOptions(options); strings.Join(risks, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: got %q, expected %q", test.options, risks, test.expected)
+		}
+	}
+}
+
+func TestAuthorizedKeyOption(t *testing.T) {
+	for _, test := range []struct {
+		option, name, value string
+	}{
+		{"no-pty", "no-pty", ""},
+		{`From="192.0.2.1,192.0.2.2"`, "from", "192.0.2.1,192.0.2.2"},
+		{`command="echo \"hello\""`, "command", `echo "hello"`},
+		{`tunnel=0`, "tunnel", "0"},
+	} {
+		if name, value := authorizedKeyOption(test.option); name != test.name || value != test.value {
+			t.Errorf("%s: got %q, %q, expected %q, %q", test.option, name, value, test.name, test.value)
+		}
+	}
+}
+
+// Risky options are logged with the line they're on, and the key is still
+// checked
+func TestReadSignersRiskyOptions(t *testing.T) {
+	key := string(ssh.MarshalAuthorizedKey(generateKey(t, "ecdsa-256")))
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := ioutil.WriteFile(path, []byte(key+"no-pty "+key), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := captureLogs(t)
+	signers, err := readSigners(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 || !logs.contains("line 2 of "+path+" has RISKY OPTIONS: no-pty") || logs.contains("line 1 of "+path+" has RISKY OPTIONS") {
+		t.Errorf("got %d keys, expected the risky options on line 2 alone to be logged", len(signers))
+	}
+}
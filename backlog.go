@@ -0,0 +1,51 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setBacklog changes the length of the queue of connections the kernel holds
+// for the listener until they're accepted. Calling listen(2) again on a
+// listening socket changes its backlog without affecting connections that
+// are already queued.
+func setBacklog(l net.Listener, backlog int) error {
+	tcpListener, ok := l.(*net.TCPListener)
+	if !ok {
+		return errors.New("not a TCP listener")
+	}
+
+	raw, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+
+	return listenErr
+}
+
+// maxBacklog returns the limit the kernel silently caps each listener's
+// backlog at, if it can be found
+func maxBacklog() (int, bool) {
+	b, err := ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0, false
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	return max, err == nil
+}
@@ -0,0 +1,19 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog reports that the backlog can't be changed on this platform
+func setBacklog(l net.Listener, backlog int) error {
+	return errors.New("not supported on this platform")
+}
+
+// maxBacklog reports that the kernel's limit on the backlog isn't known
+func maxBacklog() (int, bool) {
+	return 0, false
+}
@@ -2,58 +2,270 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/mattbostock/sshkeycheck/keycheck"
 	"golang.org/x/crypto/ssh"
 )
 
 const blacklistPath = "blacklist"
 
-var blacklist = make(map[string]bool)
+// debianSet matches the names of the files holding the keys generated by
+// Debian's broken OpenSSL package
+var debianSet = regexp.MustCompile(`^(dsa|rsa)-[0-9]+$`)
+
+// debianSource starts the source of each key in a Debian set
+const debianSource = "Debian 2008 blacklist"
+
+// The formats in which blacklisted keys can be listed. Each is kept in its
+// own set, as partial digests can't be converted to SHA-256 fingerprints.
+const (
+	// formatSHA256 is a SHA-256 fingerprint, or a public key from which
+	// one is computed
+	formatSHA256 = "sha256"
+
+	// formatSHA1 is a SHA-1 fingerprint, as shown by `ssh-keygen -E sha1`
+	formatSHA1 = "sha1"
+
+	// formatMD5 is a colon-separated MD5 fingerprint, as shown by older
+	// versions of ssh-keygen
+	formatMD5 = "md5"
+
+	// formatVulnkey is the last 20 hex digits of a key's MD5 fingerprint,
+	// as used by ssh-vulnkey's blacklists
+	formatVulnkey = "ssh-vulnkey"
+
+	// formatOpenSSL is the last 20 hex digits of the SHA-1 digest of an RSA
+	// key's modulus as printed by `openssl rsa -modulus`, as used by
+	// openssl-vulnkey's blacklists
+	formatOpenSSL = "openssl-vulnkey"
+)
+
+// blacklists maps each format to the blacklisted keys in that format, each
+// mapped to a description of where it was found
+var blacklists = map[string]map[string]string{
+	formatSHA256:  make(map[string]string),
+	formatSHA1:    make(map[string]string),
+	formatMD5:     make(map[string]string),
+	formatVulnkey: make(map[string]string),
+	formatOpenSSL: make(map[string]string),
+}
+
+var partialDigest = regexp.MustCompile(`^[0-9a-f]{20}$`)
 
 func loadBlacklistedKeys() {
-	files, err := ioutil.ReadDir(blacklistPath)
+	lists, err := readBlacklists(blacklistPath, false)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	for format, digests := range lists {
+		for digest, source := range digests {
+			blacklists[format][digest] = source
+		}
+	}
+}
+
+// readBlacklists reads the blacklists in the named directory, returning the
+// digests listed in each format. If validate is set, the blacklists are
+// only checked for errors, and no digests are returned.
+func readBlacklists(dir string, validate bool) (map[string]map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var lists map[string]map[string]string
+	if !validate {
+		lists = make(map[string]map[string]string)
+		for format := range blacklists {
+			lists[format] = make(map[string]string)
+		}
+	}
+
 	for _, f := range files {
 		if f.IsDir() {
-			log.Fatalf("Subdirectories not supported in %q directory\n", blacklistPath)
+			return nil, fmt.Errorf("subdirectories not supported in %q directory", dir)
+		}
+
+		if err := readBlacklist(filepath.Join(dir, f.Name()), lists); err != nil {
+			return nil, err
+		}
+	}
+
+	return lists, nil
+}
+
+// readBlacklist adds the digests listed in the named file to lists, unless
+// lists is nil
+func readBlacklist(path string, lists map[string]map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(path)
+	source := name + " blacklist"
+	if debianSet.MatchString(name) {
+		source = debianSource + ", " + name + " set"
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
 		}
 
-		file, err := os.Open(filepath.Join(blacklistPath, f.Name()))
+		format, digest, err := blacklistEntry(entry, strings.Contains(name, "openssl"))
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("invalid entry in %s on line %d: %s", path, line, err)
+		}
+		if lists != nil {
+			lists[format][digest] = source
 		}
-		defer file.Close()
+	}
+
+	return scanner.Err()
+}
 
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			key := strings.TrimSpace(scanner.Text())
-			blacklist[key] = true
+// blacklistEntry detects the format of a blacklist entry and returns the
+// digest it lists. 20 digit hex entries are taken to be from ssh-vulnkey's
+// blacklists, unless openssl is set. Public keys of the types the ssh
+// package understands are encoded again if they weren't encoded the way it
+// encodes them, so that they match however they were encoded in the
+// blacklist, as fingerprints of presented keys are computed from their
+// encoding by the ssh package. Other keys are used as they are, so that
+// keys of any type can be blacklisted.
+func blacklistEntry(entry string, openssl bool) (format, digest string, err error) {
+	switch {
+	case strings.HasPrefix(entry, "SHA256:"):
+		return formatSHA256, strings.TrimRight(entry, "="), nil
+	case strings.HasPrefix(entry, "SHA1:"):
+		return formatSHA1, strings.TrimRight(entry, "="), nil
+	case len(entry) <= len("md5:")+47 && md5Fingerprint.MatchString(entry):
+		return formatMD5, strings.TrimPrefix(strings.ToLower(entry), "md5:"), nil
+	case len(entry) == 20 && partialDigest.MatchString(strings.ToLower(entry)):
+		if openssl {
+			return formatOpenSSL, strings.ToLower(entry), nil
 		}
+		return formatVulnkey, strings.ToLower(entry), nil
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
+	// Only the encoded key is wanted, so the rest of the line isn't split
+	// into fields, which would take a while across the whole blacklist
+	i := strings.IndexAny(entry, " \t")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected a fingerprint or public key: %q", entry)
+	}
+	encoded := strings.TrimLeft(entry[i:], " \t")
+	if j := strings.IndexAny(encoded, " \t"); j >= 0 {
+		encoded = encoded[:j]
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", err
+	}
+	// Parsing every key in the blacklist would take seconds, and almost all
+	// of them are already encoded the way the ssh package encodes them
+	if !minimallyEncoded(key) {
+		if parsed, err := ssh.ParsePublicKey(key); err == nil {
+			key = parsed.Marshal()
 		}
 	}
 
-	return
+	sum := sha256.Sum256(key)
+	return formatSHA256, "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
 }
 
-func markBlacklistedKeys(keys []*publicKey) {
+// minimallyEncoded reports whether the key's blob is a series of strings,
+// none with a second byte that could be dropped were it an mpint, i.e.
+// whether the ssh package would encode the key it holds, if it understands
+// it, the same way. Keys that aren't may well be encoded that way anyway.
+func minimallyEncoded(blob []byte) bool {
+	for len(blob) > 0 {
+		if len(blob) < 4 {
+			return false
+		}
+		n := uint64(blob[0])<<24 | uint64(blob[1])<<16 | uint64(blob[2])<<8 | uint64(blob[3])
+		blob = blob[4:]
+		if n > uint64(len(blob)) {
+			return false
+		}
+
+		// An mpint's leading zero byte is only needed if the byte after it
+		// has its top bit set, and a leading 0xff only if it doesn't
+		if n == 1 && blob[0] == 0 || n >= 2 && (blob[0] == 0 && blob[1]&0x80 == 0 || blob[0] == 0xff && blob[1]&0x80 != 0) {
+			return false
+		}
+		blob = blob[n:]
+	}
+
+	return true
+}
+
+// blacklistDigests returns the key's digest in each of the blacklist formats
+// that apply to it. Certificates are checked using the key they certify, as
+// that is what the blacklists list.
+func blacklistDigests(k *publicKey) map[string]string {
+	if cert, ok := k.key.(*ssh.Certificate); ok {
+		k = &publicKey{key: cert.Key}
+	}
+
+	md5 := k.Fingerprint()
+	sha1Sum := sha1.Sum(k.key.Marshal())
+	digests := map[string]string{
+		formatSHA256:  k.FingerprintSHA256(),
+		formatSHA1:    "SHA1:" + base64.RawStdEncoding.EncodeToString(sha1Sum[:]),
+		formatMD5:     md5,
+		formatVulnkey: strings.Replace(md5, ":", "", -1)[12:],
+	}
+
+	if k.key.Type() == ssh.KeyAlgoRSA {
+		if n, err := keycheck.RSAModulus(k.key); err == nil {
+			sum := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", n)))
+			digests[formatOpenSSL] = hex.EncodeToString(sum[:])[20:]
+		}
+	}
 
+	return digests
+}
+
+// markBlacklistedKeys marks the keys listed in the blacklist directory, in
+// BLACKLIST_FILE or in BLACKLIST_INDEX_FILE, noting where each was found.
+// Keys in more than one are taken to be from the first of those, whose
+// sources are more specific.
+func markBlacklistedKeys(keys []*publicKey) {
 	for _, k := range keys {
-		key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.key)))
-		if blacklist[key] {
+		digests := blacklistDigests(k)
+		for format, digest := range digests {
+			if source, ok := blacklists[format][digest]; ok {
+				k.blacklisted = true
+				k.blacklistSource = source
+				k.blacklistDebian = strings.HasPrefix(source, debianSource)
+			}
+		}
+
+		if source, ok := localBlacklistSource(k); ok && !k.blacklisted {
 			k.blacklisted = true
+			k.blacklistSource = source
+			k.blacklistLocal = true
 		}
-	}
 
-	return
+		if !k.blacklisted {
+			k.blacklistSource, k.blacklisted = blacklistIndex.lookup(digests[formatSHA256])
+		}
+	}
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadBlacklistOnce loads the full blacklist for the tests that need it, as
+// it takes a while
+var loadBlacklistOnce sync.Once
+
+func loadTestBlacklist() {
+	loadBlacklistOnce.Do(loadBlacklistedKeys)
+}
+
+func BenchmarkMarkBlacklistedKeys(b *testing.B) {
+	loadTestBlacklist()
+	keys := []*publicKey{
+		{key: generateKey(b, "rsa-2048")},
+		{key: generateKey(b, "ecdsa-256")},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		markBlacklistedKeys(keys)
+	}
+}
+
+func BenchmarkLoadBlacklistedKeys(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := readBlacklists(blacklistPath, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// debianKey returns the first key of the Debian set of 2048 bit RSA keys,
+// whose fingerprints below were worked out using ssh-keygen and openssl
+func debianKey(t testing.TB) (string, ssh.PublicKey) {
+	file, err := os.Open(filepath.Join(blacklistPath, "rsa-2048"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	key, _, _, _, err := ssh.ParseAuthorizedKey(scanner.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scanner.Text(), key
+}
+
+func TestBlacklistFormats(t *testing.T) {
+	entry, key := debianKey(t)
+
+	// The exponent is encoded with a leading zero it doesn't need, which
+	// the ssh package drops
+	var wire struct {
+		Type string
+		E    []byte
+		N    []byte
+	}
+	if err := ssh.Unmarshal(key.Marshal(), &wire); err != nil {
+		t.Fatal(err)
+	}
+	wire.E = append([]byte{0}, wire.E...)
+	padded := "ssh-rsa " + base64.StdEncoding.EncodeToString(ssh.Marshal(wire)) + " padded"
+
+	for _, test := range []struct {
+		file   string
+		entry  string
+		format string
+	}{
+		{"custom", entry, formatSHA256},
+		{"custom", padded, formatSHA256},
+		{"custom", "SHA256:yqDW7ZhouG1jafUTgPJ8aL2mBZwNkc9pnnehRbBI810", formatSHA256},
+		{"custom", "SHA1:WPPhhyn5DwrymX/zFIuIr6ICXT4", formatSHA1},
+		{"custom", "SHA1:WPPhhyn5DwrymX/zFIuIr6ICXT4=", formatSHA1},
+		{"custom", "MD5:00:02:d5:af:29:27:6c:95:a4:9d:c2:ab:3b:50:67:07", formatMD5},
+		{"custom", "00:02:D5:AF:29:27:6C:95:A4:9D:C2:AB:3B:50:67:07", formatMD5},
+		{"blacklist.RSA-2048", "6c95a49dc2ab3b506707", formatVulnkey},
+		{"openssl-blacklist.RSA-2048", "217a790a9fe6abddb4d4", formatOpenSSL},
+		{"openssl-blacklist.RSA-2048", "217A790A9FE6ABDDB4D4", formatOpenSSL},
+	} {
+		dir, err := ioutil.TempDir("", "blacklist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, test.file), []byte("# A comment\n\n"+test.entry+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		lists, err := readBlacklists(dir, false)
+		if err != nil {
+			t.Errorf("%s in %s: %s", test.entry, test.file, err)
+			continue
+		}
+		for format, digests := range lists {
+			if expected := format == test.format; (len(digests) == 1) != expected {
+				t.Errorf("%s in %s: %d %s digests, expected it to be read as %s", test.entry, test.file, len(digests), format, test.format)
+			}
+		}
+
+		previous := blacklists
+		blacklists = lists
+		k := &publicKey{key: key}
+		markBlacklistedKeys([]*publicKey{k})
+		blacklists = previous
+		if !k.blacklisted || k.blacklistSource != test.file+" blacklist" {
+			t.Errorf("%s in %s: key not blacklisted, source %q", test.entry, test.file, k.blacklistSource)
+		}
+	}
+}
+
+// ed25519Key is an Ed25519 public key, which the ssh package can't parse
+type ed25519Key []byte
+
+func (k ed25519Key) Type() string { return "ssh-ed25519" }
+func (k ed25519Key) Marshal() []byte {
+	return ssh.Marshal(struct {
+		Name string
+		Key  []byte
+	}{k.Type(), k})
+}
+func (k ed25519Key) Verify([]byte, *ssh.Signature) error {
+	return errors.New("ssh-ed25519 signatures are not supported")
+}
+
+// Keys of any type can be blacklisted by fingerprint or as public keys,
+// and are reported as blacklisted by the file that lists them
+func TestBlacklistAnyKeyType(t *testing.T) {
+	ed25519Pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, ecKey := ed25519Key(ed25519Pub), generateKey(t, "ecdsa-256")
+	unlisted := ed25519Key(make([]byte, 32))
+
+	// The Debian sets are still checked alongside the other lists
+	loadTestBlacklist()
+	_, debian := debianKey(t)
+
+	for _, test := range []struct {
+		name  string
+		entry string
+		key   ssh.PublicKey
+	}{
+		{"Ed25519 key", "ssh-ed25519 " + base64.StdEncoding.EncodeToString(edKey.Marshal()) + " compromised", edKey},
+		{"Ed25519 fingerprint", (&publicKey{key: edKey}).FingerprintSHA256(), edKey},
+		{"ECDSA key", string(ssh.MarshalAuthorizedKey(ecKey)), ecKey},
+		{"ECDSA fingerprint", (&publicKey{key: ecKey}).FingerprintSHA256(), ecKey},
+	} {
+		dir, err := ioutil.TempDir("", "blacklist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "incident-42"), []byte(test.entry+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lists, err := readBlacklists(dir, false)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		for format, digests := range lists {
+			for digest, source := range digests {
+				blacklists[format][digest] = source
+			}
+		}
+		a := analyzeKeys(test.key, unlisted, debian)
+		for format, digests := range lists {
+			for digest := range digests {
+				delete(blacklists[format], digest)
+			}
+		}
+
+		expected := []string{
+			(&publicKey{key: test.key}).Fingerprint() + " (incident-42 blacklist)",
+			(&publicKey{key: debian}).Fingerprint() + " (" + debianSource + ", rsa-2048 set)",
+		}
+		if !reflect.DeepEqual(a.blacklistSources, expected) {
+			t.Errorf("%s: got blacklisted %q, expected %q", test.name, a.blacklistSources, expected)
+		}
+		if a.results[0].issue != issueBlacklisted || a.results[1].issue == issueBlacklisted || a.results[2].issue != issueBlacklistedDebian {
+			t.Errorf("%s: got issues %q, %q, %q", test.name, a.results[0].issue, a.results[1].issue, a.results[2].issue)
+		}
+	}
+}
+
+// Each entry's format is detected from the entry itself, other than for
+// the 20 digit entries of ssh-vulnkey and openssl-vulnkey, and its digest
+// normalised to match those computed for presented keys
+func TestBlacklistEntry(t *testing.T) {
+	entry, _ := debianKey(t)
+	sha256 := "SHA256:yqDW7ZhouG1jafUTgPJ8aL2mBZwNkc9pnnehRbBI810"
+
+	for _, test := range []struct {
+		entry   string
+		openssl bool
+		format  string
+		digest  string
+	}{
+		{entry, false, formatSHA256, sha256},
+		{strings.Replace(entry, " ", "\t", -1), false, formatSHA256, sha256},
+		{sha256 + "=", false, formatSHA256, sha256},
+		{"SHA1:WPPhhyn5DwrymX/zFIuIr6ICXT4=", false, formatSHA1, "SHA1:WPPhhyn5DwrymX/zFIuIr6ICXT4"},
+		{"MD5:00:02:D5:AF:29:27:6C:95:A4:9D:C2:AB:3B:50:67:07", false, formatMD5, "00:02:d5:af:29:27:6c:95:a4:9d:c2:ab:3b:50:67:07"},
+		{"00:02:d5:af:29:27:6c:95:a4:9d:c2:ab:3b:50:67:07", true, formatMD5, "00:02:d5:af:29:27:6c:95:a4:9d:c2:ab:3b:50:67:07"},
+		{"6C95A49DC2AB3B506707", false, formatVulnkey, "6c95a49dc2ab3b506707"},
+		{"217a790a9fe6abddb4d4", true, formatOpenSSL, "217a790a9fe6abddb4d4"},
+	} {
+		format, digest, err := blacklistEntry(test.entry, test.openssl)
+		if err != nil || format != test.format || digest != test.digest {
+			t.Errorf("%q: got %s %q, %v, expected %s %q", test.entry, format, digest, err, test.format, test.digest)
+		}
+	}
+}
+
+func TestBlacklistEntryErrors(t *testing.T) {
+	for _, entry := range []string{
+		"ssh-rsa",
+		"ssh-rsa not-base64!",
+		"SHA384:abc",
+	} {
+		if _, _, err := blacklistEntry(entry, false); err == nil {
+			t.Errorf("%q: expected an error", entry)
+		}
+	}
+}
+
+func TestMinimallyEncoded(t *testing.T) {
+	for _, test := range []struct {
+		blob     []byte
+		expected bool
+	}{
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\x23"}), true},
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\x00\x80"}), true},
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\x00\x23"}), false},
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\x00"}), false},
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\xff\x80"}), false},
+		{ssh.Marshal(struct{ A, B string }{"ssh-rsa", "\xff\x7f"}), true},
+		{[]byte{0, 0, 0, 9, 'x'}, false},
+		{[]byte{0, 0}, false},
+		{nil, true},
+	} {
+		if got := minimallyEncoded(test.blob); got != test.expected {
+			t.Errorf("%x: got %t, expected %t", test.blob, got, test.expected)
+		}
+	}
+}
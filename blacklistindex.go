@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// blacklistIndexHeader begins each blacklist index, which is followed by
+// the SHA-256 digests of the keys it lists, sorted, each of
+// blacklistIndexRecord bytes
+const (
+	blacklistIndexHeader = "SSHKEYCHECK BLACKLIST INDEX 1\n"
+	blacklistIndexRecord = 32
+)
+
+// blacklistStore looks up where a key was blacklisted, by its SHA-256
+// fingerprint
+type blacklistStore interface {
+	lookup(fingerprint string) (source string, ok bool)
+}
+
+// memoryBlacklist is a blacklistStore held in memory
+type memoryBlacklist map[string]string
+
+func (b memoryBlacklist) lookup(fingerprint string) (string, bool) {
+	source, ok := b[fingerprint]
+	return source, ok
+}
+
+// blacklistIndex is consulted for keys not in the blacklist directory or
+// BLACKLIST_FILE. It is empty unless BLACKLIST_INDEX_FILE is set.
+var blacklistIndex blacklistStore = memoryBlacklist{}
+
+// indexedBlacklist is a blacklistStore read from a blacklist index as keys
+// are looked up, so that blacklists too large to hold in memory can be
+// used. Each lookup is a binary search, reading one record per step; reads
+// at an offset don't move the file's position, so lookups can be made
+// concurrently.
+type indexedBlacklist struct {
+	file    *os.File
+	records int64
+	source  string
+}
+
+// openBlacklistIndex opens the named blacklist index, checking its header
+// and length
+func openBlacklistIndex(path string) (*indexedBlacklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(blacklistIndexHeader))
+	if _, err := io.ReadFull(file, header); err != nil || string(header) != blacklistIndexHeader {
+		file.Close()
+		return nil, fmt.Errorf("%s isn't a blacklist index; create one using -index-blacklist", path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := info.Size() - int64(len(blacklistIndexHeader))
+	if size%blacklistIndexRecord != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+
+	return &indexedBlacklist{
+		file:    file,
+		records: size / blacklistIndexRecord,
+		source:  filepath.Base(path) + " blacklist index",
+	}, nil
+}
+
+func (b *indexedBlacklist) lookup(fingerprint string) (string, bool) {
+	digest, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:"))
+	if err != nil || len(digest) != blacklistIndexRecord {
+		return "", false
+	}
+
+	// The search stops at the first record read that matches
+	var found bool
+	var readErr error
+	record := make([]byte, blacklistIndexRecord)
+	sort.Search(int(b.records), func(i int) bool {
+		if found || readErr != nil {
+			return true
+		}
+		if _, readErr = b.file.ReadAt(record, int64(len(blacklistIndexHeader))+int64(i)*blacklistIndexRecord); readErr != nil {
+			return true
+		}
+
+		c := bytes.Compare(record, digest)
+		found = c == 0
+		return c >= 0
+	})
+	if readErr != nil {
+		log.Errorln("Failed to read blacklist index, so keys may not be marked as blacklisted:", readErr)
+		return "", false
+	}
+
+	return b.source, found
+}
+
+// loadBlacklistIndex looks up keys in the named blacklist index from now
+// on. If it can't be opened, keys are only looked up in the blacklists held
+// in memory.
+func loadBlacklistIndex(path string) {
+	index, err := openBlacklistIndex(path)
+	if err != nil {
+		log.Errorln("Failed to open BLACKLIST_INDEX_FILE, so only the blacklists in memory will be used:", err)
+		return
+	}
+
+	blacklistIndex = index
+	log.Infof("Using the %d key(s) listed in the blacklist index %s", index.records, path)
+}
+
+// writeBlacklistIndex writes an index of the keys listed in r, which holds
+// SHA-256 fingerprints or public keys, one per line, as BLACKLIST_FILE
+// does, to w. Other lines, and keys listed more than once, are skipped.
+func writeBlacklistIndex(w io.Writer, r io.Reader) error {
+	var digests [][]byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		// Fingerprints are checked to be 32 bytes long when they're read
+		fingerprint, err := localBlacklistEntry(entry)
+		if err != nil {
+			log.Warnf("Skipping line %d: %s", line, err)
+			continue
+		}
+		digest, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:"))
+		digests = append(digests, digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(digests, func(i, j int) bool { return bytes.Compare(digests[i], digests[j]) < 0 })
+
+	out := bufio.NewWriter(w)
+	out.WriteString(blacklistIndexHeader)
+	for i, d := range digests {
+		if i > 0 && bytes.Equal(d, digests[i-1]) {
+			continue
+		}
+		out.Write(d)
+	}
+
+	return out.Flush()
+}
+
+// runIndexBlacklist writes an index of the keys listed in the named files,
+// or in stdin if none are named, to stdout
+func runIndexBlacklist(paths []string) int {
+	var readers []io.Reader
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Errorln("Failed to read keys to index:", err)
+			return 1
+		}
+		defer file.Close()
+		// Each file's last line may not end with a newline
+		readers = append(readers, file, strings.NewReader("\n"))
+	}
+	if len(paths) == 0 {
+		readers = append(readers, os.Stdin)
+	}
+
+	if err := writeBlacklistIndex(os.Stdout, io.MultiReader(readers...)); err != nil {
+		log.Errorln("Failed to write blacklist index:", err)
+		return 1
+	}
+
+	return 0
+}
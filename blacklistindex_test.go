@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testBlacklistIndex writes an index of the given list and opens it
+func testBlacklistIndex(t *testing.T, list string) *indexedBlacklist {
+	var b bytes.Buffer
+	if err := writeBlacklistIndex(&b, strings.NewReader(list)); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "blacklist.idx")
+	if err := ioutil.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := openBlacklistIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.file.Close() })
+
+	return index
+}
+
+func TestIndexedBlacklist(t *testing.T) {
+	listed := []ssh.PublicKey{generateKey(t, "rsa-2048"), generateKey(t, "ecdsa-256"), generateKey(t, "ecdsa-384")}
+	fingerprint := func(k ssh.PublicKey) string { return (&publicKey{key: k}).FingerprintSHA256() }
+	list := strings.Join([]string{
+		"# Compromised keys",
+		fingerprint(listed[0]),
+		`from="192.0.2.1" ` + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(listed[1]))),
+		fingerprint(listed[2]) + "=",
+		fingerprint(listed[0]),
+		"not a key",
+	}, "\n")
+
+	logs := captureLogs(t)
+	index := testBlacklistIndex(t, list)
+	if index.records != 3 {
+		t.Errorf("got %d records, expected duplicates to be left out", index.records)
+	}
+	if !logs.contains("Skipping line 6") {
+		t.Error("malformed line not logged")
+	}
+
+	for _, test := range []struct {
+		name   string
+		key    ssh.PublicKey
+		listed bool
+	}{
+		{"listed by fingerprint", listed[0], true},
+		{"listed as a public key", listed[1], true},
+		{"listed with padding", listed[2], true},
+		{"not listed", generateKey(t, "rsa-3072"), false},
+	} {
+		source, ok := index.lookup(fingerprint(test.key))
+		if ok != test.listed || ok && source != "blacklist.idx blacklist index" {
+			t.Errorf("%s: got %q, %t, expected %t", test.name, source, ok, test.listed)
+		}
+	}
+
+	// Lookups read at offsets, so can be made concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, k := range listed {
+				if _, ok := index.lookup(fingerprint(k)); !ok {
+					t.Error("listed key not found by concurrent lookup")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOpenBlacklistIndex(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"empty index", blacklistIndexHeader, true},
+		{"one key", blacklistIndexHeader + strings.Repeat("x", blacklistIndexRecord), true},
+		{"truncated", blacklistIndexHeader + strings.Repeat("x", blacklistIndexRecord-1), false},
+		{"not an index", "SHA256:sxk7OxEP4HINjLOjypyaGS8cDTP89TE2YxEygRZIJ7Q\n", false},
+		{"empty file", "", false},
+	} {
+		path := filepath.Join(dir, "index")
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		index, err := openBlacklistIndex(path)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if index != nil {
+			index.file.Close()
+		}
+	}
+}
+
+// Keys found in the index are blacklisted, unless listed elsewhere, and the
+// server starts without the index if it can't be opened
+func TestMarkBlacklistedKeysIndex(t *testing.T) {
+	defer func(index blacklistStore) { blacklistIndex = index }(blacklistIndex)
+	loadTestBlacklist()
+	_, debian := debianKey(t)
+	listed := generateKey(t, "ecdsa-256")
+	blacklistIndex = testBlacklistIndex(t, (&publicKey{key: listed}).FingerprintSHA256()+"\n"+(&publicKey{key: debian}).FingerprintSHA256())
+
+	keys := []*publicKey{{key: listed}, {key: debian}, {key: generateKey(t, "rsa-2048")}}
+	markBlacklistedKeys(keys)
+	if !keys[0].blacklisted || keys[0].blacklistSource != "blacklist.idx blacklist index" {
+		t.Errorf("got %t, %q, expected the key to be blacklisted by the index", keys[0].blacklisted, keys[0].blacklistSource)
+	}
+	if !keys[1].blacklistDebian {
+		t.Errorf("got %q, expected the Debian set to be given as the source", keys[1].blacklistSource)
+	}
+	if keys[2].blacklisted {
+		t.Error("unlisted key blacklisted")
+	}
+
+	logs := captureLogs(t)
+	loadBlacklistIndex(filepath.Join(t.TempDir(), "missing"))
+	if !logs.contains("Failed to open BLACKLIST_INDEX_FILE") {
+		t.Error("missing index not logged")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certificateDetails describes the restrictions placed on each certificate
+// presented, and how its CA signed it, so that users can check that they are
+// as intended, and lists the fingerprints of certificates with neither
+// principals nor critical options, which may be accepted by any server
+// trusting their CA, for any user, from any address
+func certificateDetails(keys []*publicKey) (details, unrestricted []string) {
+	for _, k := range keys {
+		cert, ok := k.key.(*ssh.Certificate)
+		if !ok {
+			continue
+		}
+
+		certType := "user"
+		if cert.CertType == ssh.HostCert {
+			certType = "host"
+		}
+
+		principals := "any"
+		if len(cert.ValidPrincipals) > 0 {
+			principals = strings.Join(cert.ValidPrincipals, ", ")
+		}
+
+		details = append(details, strings.Join([]string{
+			fmt.Sprintf("%s %s certificate, key ID %q, serial %d", k.Fingerprint(), certType, cert.KeyId, cert.Serial),
+			"  Principals:       " + principals,
+			"  Valid:            " + certTime(cert.ValidAfter, "always") + " to " + certTime(cert.ValidBefore, "forever"),
+			"  Critical options: " + certOptions(cert.CriticalOptions),
+			"  Extensions:       " + certOptions(cert.Extensions),
+			"  CA signature:     " + caSignature(cert),
+		}, "\n\r          "))
+
+		if len(cert.ValidPrincipals) == 0 && len(cert.CriticalOptions) == 0 {
+			unrestricted = append(unrestricted, k.Fingerprint())
+		}
+	}
+
+	return details, unrestricted
+}
+
+// sha1Certificates lists the certificates signed by their CA using ssh-rsa,
+// which signs a SHA-1 hash of the certificate and so is no longer accepted
+// by OpenSSH 8.2 and later unless it's explicitly re-enabled. This concerns
+// the CA's signing practices rather than the key certified.
+func sha1Certificates(keys []*publicKey) []string {
+	var found []string
+	for _, k := range keys {
+		cert, ok := k.key.(*ssh.Certificate)
+		if ok && cert.Signature != nil && cert.Signature.Format == ssh.KeyAlgoRSA {
+			found = append(found, fmt.Sprintf("%s, key ID %q, CA signature %s", k.Fingerprint(), cert.KeyId, caSignature(cert)))
+		}
+	}
+
+	return found
+}
+
+// caSignature describes the algorithm the certificate's CA signed it with,
+// and the CA's key
+func caSignature(cert *ssh.Certificate) string {
+	format := "unknown"
+	if cert.Signature != nil {
+		format = cert.Signature.Format
+	}
+
+	if cert.SignatureKey == nil {
+		return format
+	}
+
+	return fmt.Sprintf("%s by %s key %s", format, cert.SignatureKey.Type(), (&publicKey{key: cert.SignatureKey}).Fingerprint())
+}
+
+// suspiciousKeyIDs lists the certificates whose key ID is empty or contains
+// one of suspiciousIDs, which suggests a certificate issued while testing,
+// or by a provisioning script that was never personalised
+func suspiciousKeyIDs(keys []*publicKey) []string {
+	var found []string
+	for _, k := range keys {
+		cert, ok := k.key.(*ssh.Certificate)
+		if !ok {
+			continue
+		}
+
+		if strings.TrimSpace(cert.KeyId) == "" {
+			found = append(found, k.Fingerprint()+" (empty key ID)")
+			continue
+		}
+
+		id := strings.ToLower(cert.KeyId)
+		for _, s := range suspiciousIDs {
+			if strings.Contains(id, s) {
+				found = append(found, fmt.Sprintf("%s (key ID %q contains %q)", k.Fingerprint(), cert.KeyId, s))
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// certTime formats a certificate's validity time, using never for the
+// first or last possible time
+func certTime(t uint64, never string) string {
+	if t == 0 || t == ssh.CertTimeInfinity {
+		return never
+	}
+
+	return time.Unix(int64(t), 0).UTC().Format("2006-01-02 15:04:05 MST")
+}
+
+// certOptions formats a certificate's critical options or extensions in
+// name order. Values are themselves SSH strings, which are decoded where
+// possible.
+func certOptions(options map[string]string) string {
+	if len(options) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		value := options[name]
+		if len(value) >= 4 && int(binary.BigEndian.Uint32([]byte(value))) == len(value)-4 {
+			value = value[4:]
+		}
+		if value != "" {
+			names[i] = fmt.Sprintf("%s=%q", name, value)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
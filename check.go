@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// checkSigners returns the public keys in the named files, which may be
+// public key files, in OpenSSH's or RFC 4716 format, or authorized_keys
+// files, or in stdin if no files are named. Keys that can't be parsed are
+// skipped, as are keys whose blob is of a different type than the line
+// declares, as the server only ever sees the blob.
+func checkSigners(paths []string) []ssh.Signer {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range paths {
+		s, err := readSigners(path)
+		if err != nil {
+			log.Fatalln("Failed to read keys:", err)
+		}
+		signers = append(signers, s...)
+	}
+
+	if len(signers) == 0 {
+		log.Fatalln("No keys to check")
+	}
+
+	return signers
+}
+
+// readSigners returns the public keys in the named file, or in stdin if the
+// path is "-", skipping those that can't be parsed or are mislabelled. If
+// the file holds private keys, the public keys are derived from them, if
+// -private-keys is given.
+func readSigners(path string) ([]ssh.Signer, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Private keys are only read when asked for, and never leave this
+	// process; only their public keys are sent to the server
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		defer zeroBytes(data)
+		if !acceptPrivateKeys {
+			return nil, fmt.Errorf("%s holds a private key; give -private-keys to check it, or give its public key instead", path)
+		}
+		return readPrivateKeys(data, path), nil
+	}
+
+	if bytes.HasPrefix(data, []byte(ssh1PrivateKeyHeader)) {
+		return nil, fmt.Errorf("%s holds an SSH PROTOCOL 1 KEY (obsolete, insecure), which current SSH software doesn't accept; generate a new key using ssh-keygen -t ed25519", path)
+	}
+
+	// PuTTYgen exports public keys in RFC 4716 format, which the ssh
+	// package doesn't read
+	if bytes.Contains(data, []byte(rfc4716Begin)) {
+		return readRFC4716Keys(data, path)
+	}
+
+	var signers []ssh.Signer
+	for line, entry := range bytes.Split(data, []byte("\n")) {
+		entry = bytes.TrimSpace(entry)
+		if len(entry) == 0 || entry[0] == '#' {
+			continue
+		}
+
+		key, _, options, _, err := ssh.ParseAuthorizedKey(entry)
+		if keyErr := blobError(entry); err != nil && keyErr != nil && invalidCurvePoint(keyErr) {
+			log.Errorf("Skipping key on line %d of %s: INVALID CURVE POINT, its point isn't on the curve its type names, or is the point at infinity", line+1, path)
+			continue
+		} else if err != nil && keyErr != nil && malformedKeyError(keyErr) {
+			log.Warnf("Skipping key on line %d of %s: MALFORMED KEY DATA, it is truncated or has trailing data (%s)", line+1, path, keyErr)
+			continue
+		} else if bits, ok := ssh1Key(entry); err != nil && ok {
+			log.Errorf("Skipping key on line %d of %s: SSH PROTOCOL 1 KEY (obsolete, insecure), a %d bit RSA key for a protocol that current SSH software doesn't support; remove it and, if it's still needed, generate a new key using ssh-keygen -t ed25519", line+1, path, bits)
+			continue
+		}
+		if err != nil {
+			log.Warnf("Skipping key on line %d of %s: %s", line+1, path, err)
+			continue
+		}
+
+		// The ssh package ignores the type given before the key, and
+		// takes ECDSA keys' curve from their parameters rather than
+		// their type, so a key of one type can be passed off as
+		// another, e.g. an RSA key labelled ssh-ed25519
+		declared, blob := declaredTypes(entry)
+		if declared == key.Type() {
+			declared = blob
+		}
+		if declared != key.Type() {
+			log.Errorf("Skipping key on line %d of %s: TYPE/ALGORITHM MISMATCH, declared as %s but is %s", line+1, path, declared, key.Type())
+			continue
+		}
+
+		// Only the key as the ssh package encodes it reaches the server,
+		// so its original encoding can only be checked here
+		if !bytes.Equal(entryBlob(entry), key.Marshal()) {
+			log.Warnf("Key on line %d of %s has a NON-CANONICAL ENCODING, which some servers reject; export it again using ssh-keygen -y", line+1, path)
+		}
+
+		// Options are never sent to the server either, and only matter
+		// to the host whose authorized_keys file they're in
+		if risks := riskyOptions(options); len(risks) > 0 {
+			log.Warnf("Key on line %d of %s has RISKY OPTIONS: %s", line+1, path, strings.Join(risks, "; "))
+		}
+
+		signers = append(signers, publicOnlySigner{key})
+	}
+
+	return signers, nil
+}
+
+// declaredTypes returns the key types declared by an authorized_keys
+// entry: the one given before the base64-encoded key, and the one at the
+// start of the key itself
+func declaredTypes(entry []byte) (text, blob string) {
+	fields := strings.Fields(string(entry))
+	for i := 1; i < len(fields); i++ {
+		data, err := base64.StdEncoding.DecodeString(fields[i])
+		if err != nil {
+			continue
+		}
+
+		var declared struct {
+			Type string
+			Rest []byte `ssh:"rest"`
+		}
+		if ssh.Unmarshal(data, &declared) == nil {
+			return fields[i-1], declared.Type
+		}
+	}
+
+	return "", ""
+}
+
+// blobError returns why the key in an authorized_keys entry can't be parsed,
+// if it can't, as ssh.ParseAuthorizedKey only reports that no key was found
+func blobError(entry []byte) error {
+	if blob := entryBlob(entry); blob != nil {
+		_, err := ssh.ParsePublicKey(blob)
+		return err
+	}
+
+	return nil
+}
+
+// ssh1PrivateKeyHeader begins private key files of SSH protocol 1
+const ssh1PrivateKeyHeader = "SSH PRIVATE KEY FILE FORMAT 1.1\n"
+
+// ssh1Key reports whether an authorized_keys entry holds an RSA key of SSH
+// protocol 1, which is given as its length in bits, its exponent and its
+// modulus, in decimal, after any options, and returns the modulus's length
+func ssh1Key(entry []byte) (int, bool) {
+	fields := strings.Fields(string(entry))
+	for i := 0; i+2 < len(fields); i++ {
+		if _, err := strconv.Atoi(fields[i]); err != nil {
+			continue
+		}
+
+		e, eOK := new(big.Int).SetString(fields[i+1], 10)
+		n, nOK := new(big.Int).SetString(fields[i+2], 10)
+		if eOK && nOK && e.Sign() > 0 && n.BitLen() > 64 {
+			return n.BitLen(), true
+		}
+	}
+
+	return 0, false
+}
+
+// entryBlob returns the key in an authorized_keys entry as it was encoded,
+// or nil if there isn't one
+func entryBlob(entry []byte) []byte {
+	for _, field := range strings.Fields(string(entry)) {
+		data, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			continue
+		}
+
+		var declared struct {
+			Type string
+			Rest []byte `ssh:"rest"`
+		}
+		if ssh.Unmarshal(data, &declared) == nil {
+			return data
+		}
+	}
+
+	return nil
+}
+
+// runCheck checks the keys in the named files using the server at addr and
+// prints the report to stdout. The exit status is that given to the
+// "status" user, so it is non-zero if any issues were found.
+func runCheck(addr string, paths []string) int {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return runAudit(addr, paths)
+		}
+	}
+
+	signers := checkSigners(paths)
+
+	status := runSession(addr, "status", signers, ioutil.Discard)
+	runSession(addr, "check", signers, os.Stdout)
+
+	return status
+}
+
+// authorizedKeysFiles are the names of the files searched for when auditing
+// a directory, as read by OpenSSH's default AuthorizedKeysFile setting
+var authorizedKeysFiles = map[string]bool{"authorized_keys": true, "authorized_keys2": true}
+
+// auditFiles returns the files to audit: those named, and any authorized_keys
+// files found by walking the named directories. Directories that can't be
+// read are skipped, and returned with the reason.
+func auditFiles(paths []string) (files, unreadable []string) {
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case err != nil:
+				log.Warnf("Skipping %s: %s", path, err)
+				unreadable = append(unreadable, fmt.Sprintf("%s (%s)", path, pathError(err)))
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+			case path == root && !info.IsDir():
+				files = append(files, path)
+			case !info.IsDir() && authorizedKeysFiles[info.Name()]:
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+
+	return files, unreadable
+}
+
+// pathError returns the reason for an error accessing a file, without the
+// path, which is shown alongside it
+func pathError(err error) error {
+	if e, ok := err.(*os.PathError); ok {
+		return e.Err
+	}
+	return err
+}
+
+// keyOwner guesses whose keys are in the file from its path, assuming it's
+// in the user's ~/.ssh directory, or returns an empty string
+func keyOwner(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) != ".ssh" {
+		return ""
+	}
+
+	return filepath.Base(filepath.Dir(dir))
+}
+
+// runAudit checks the keys in each of the named files, and the
+// authorized_keys files in the named directories, and prints a report for
+// each file followed by a summary of those with issues. Files that can't be
+// read are listed in the summary. The exit status is the most severe given
+// to the "status" user for any file, and at least 1 if any file couldn't be
+// read, so that an incomplete audit isn't mistaken for a clean one.
+func runAudit(addr string, paths []string) int {
+	files, unreadable := auditFiles(paths)
+	if len(files) == 0 && len(unreadable) == 0 {
+		log.Fatalln("No authorized_keys files to check")
+	}
+
+	worst, checked := 0, 0
+	var issues []string
+	for _, path := range files {
+		label := path
+		if owner := keyOwner(path); owner != "" {
+			label = owner + ": " + path
+		}
+
+		signers, err := readSigners(path)
+		if err != nil {
+			log.Warnf("Skipping %s: %s", path, err)
+			unreadable = append(unreadable, fmt.Sprintf("%s (%s)", label, pathError(err)))
+			continue
+		}
+		checked++
+		if len(signers) == 0 {
+			continue
+		}
+
+		fmt.Printf("==> %s <==\n", label)
+		var result bytes.Buffer
+		status := runSession(addr, "status", signers, &result)
+		runSession(addr, "check", signers, os.Stdout)
+
+		if status > 0 {
+			issues = append(issues, fmt.Sprintf("%-8s %s", strings.TrimSpace(result.String()), label))
+		}
+		if status > worst {
+			worst = status
+		}
+	}
+
+	fmt.Printf("Summary: %d of %d file(s) checked have keys with issues\n", len(issues), checked)
+	for _, i := range issues {
+		fmt.Println("  " + i)
+	}
+	if len(unreadable) > 0 {
+		fmt.Printf("%d file(s) or directories couldn't be read:\n", len(unreadable))
+		for _, u := range unreadable {
+			fmt.Println("  " + u)
+		}
+		if worst == 0 {
+			worst = 1
+		}
+	}
+
+	return worst
+}
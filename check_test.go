@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// Keys that aren't of the type they are declared as, or are malformed, are
+// skipped
+func TestReadSignersTypeMismatch(t *testing.T) {
+	rsaKey := generateKey(t, "rsa-2048")
+	p384 := generateKey(t, "ecdsa-384")
+	relabelled := ssh.Marshal(struct {
+		Type string
+		Rest []byte `ssh:"rest"`
+	}{ssh.KeyAlgoECDSA256, p384.Marshal()[4+len(ssh.KeyAlgoECDSA384):]})
+
+	dir, err := ioutil.TempDir("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name  string
+		entry string
+		keys  int
+	}{
+		{"matching", "ssh-rsa " + base64.StdEncoding.EncodeToString(rsaKey.Marshal()), 1},
+		{"RSA key labelled ssh-ed25519", "ssh-ed25519 " + base64.StdEncoding.EncodeToString(rsaKey.Marshal()), 0},
+		{"P-384 key whose blob claims P-256", "ecdsa-sha2-nistp256 " + base64.StdEncoding.EncodeToString(relabelled), 0},
+		{"RSA key with trailing data", "ssh-rsa " + base64.StdEncoding.EncodeToString(append(rsaKey.Marshal(), "garbage"...)), 0},
+		{"truncated RSA key", "ssh-rsa " + base64.StdEncoding.EncodeToString(rsaKey.Marshal()[:100]), 0},
+		// Keys that aren't encoded canonically are still checked
+		{"RSA key with a padded exponent", "ssh-rsa " + base64.StdEncoding.EncodeToString(padExponent(t, rsaKey)), 1},
+	} {
+		path := filepath.Join(dir, "authorized_keys")
+		if err := ioutil.WriteFile(path, []byte(test.entry+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		signers, err := readSigners(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signers) != test.keys {
+			t.Errorf("%s: got %d key(s), expected %d", test.name, len(signers), test.keys)
+		}
+	}
+}
+
+// ECDSA keys whose point isn't on the curve, or is the point at infinity,
+// are skipped and logged as errors
+func TestReadSignersInvalidCurvePoint(t *testing.T) {
+	valid := generateKey(t, "ecdsa-256")
+	outOfRange := append([]byte{4}, elliptic.P256().Params().P.FillBytes(make([]byte, 32))...)
+	outOfRange = append(outOfRange, valid.Marshal()[len(valid.Marshal())-32:]...)
+
+	for _, test := range []struct {
+		name    string
+		blob    []byte
+		invalid bool
+	}{
+		{"valid point", valid.Marshal(), false},
+		{"point not on the curve", ecdsaPoint(append([]byte{4}, make([]byte, 64)...)), true},
+		{"point at infinity", ecdsaPoint([]byte{0}), true},
+		{"coordinate outside the field", ecdsaPoint(outOfRange), true},
+	} {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		entry := ssh.KeyAlgoECDSA256 + " " + base64.StdEncoding.EncodeToString(test.blob) + "\n"
+		if err := ioutil.WriteFile(path, []byte(entry), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		logs := captureLogs(t)
+		signers, err := readSigners(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		level, logged := logs.level("INVALID CURVE POINT")
+		if (len(signers) == 0) != test.invalid || logged != test.invalid {
+			t.Errorf("%s: got %d key(s), logged %t, expected invalid %t", test.name, len(signers), logged, test.invalid)
+		}
+		if logged && level != log.ErrorLevel {
+			t.Errorf("%s: logged at level %s, expected %s", test.name, level, log.ErrorLevel)
+		}
+	}
+}
+
+// RSA keys of SSH protocol 1 are skipped and logged as such, rather than as
+// keys that can't be parsed
+func TestReadSignersSSH1(t *testing.T) {
+	n, err := keycheck.RSAModulus(generateKey(t, "rsa-1024"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssh1 := "1024 35 " + n.String() + " alice@example.com"
+
+	for _, test := range []struct {
+		name  string
+		data  string
+		keys  int
+		ssh1  bool
+		fails bool
+	}{
+		{"public key", ssh1 + "\n", 0, true, false},
+		{"authorized_keys entry with options", `from="192.0.2.1",no-pty ` + ssh1 + "\n", 0, true, false},
+		{"alongside a protocol 2 key", ssh1 + "\n" + string(ssh.MarshalAuthorizedKey(generateKey(t, "ecdsa-256"))), 1, true, false},
+		{"numbers too short to be a modulus", "1024 35 1234567890\n", 0, false, false},
+		{"private key", ssh1PrivateKeyHeader + "\x00\x00\x00\x00", 0, false, true},
+	} {
+		path := filepath.Join(t.TempDir(), "identity.pub")
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		logs := captureLogs(t)
+		signers, err := readSigners(path)
+		if test.fails {
+			if err == nil || !strings.Contains(err.Error(), "SSH PROTOCOL 1 KEY") {
+				t.Errorf("%s: got error %v, expected a protocol 1 key", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signers) != test.keys || logs.contains("SSH PROTOCOL 1 KEY (obsolete, insecure), a 1024 bit RSA key") != test.ssh1 {
+			t.Errorf("%s: got %d key(s), expected %d, protocol 1 key logged %t", test.name, len(signers), test.keys, test.ssh1)
+		}
+	}
+}
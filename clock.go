@@ -0,0 +1,55 @@
+package main
+
+import "time"
+
+// clk is the clock used for all timeouts and timestamps. The tests replace
+// it with a fake clock (see clock_test.go), so that timeouts can be
+// triggered deterministically rather than by waiting for them.
+var clk clock = realClock{}
+
+// clock provides the subset of the time package used by the server
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) timer
+	NewTicker(d time.Duration) ticker
+}
+
+// timer is a timer created by clock.AfterFunc
+type timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// ticker is a ticker created by clock.NewTicker
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is a clock backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
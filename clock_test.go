@@ -0,0 +1,204 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves when advanced, firing the
+// timers and tickers that fall due as it does
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a timer or ticker created by a fakeClock. Timers created by
+// After send on c, those created by AfterFunc call f, and tickers send on c
+// every period.
+type fakeTimer struct {
+	clock    *fakeClock
+	duration time.Duration
+	at       time.Time
+	period   time.Duration
+	c        chan time.Time
+	f        func()
+}
+
+// useFakeClock replaces clk with a fake clock until the test ends
+func useFakeClock(t testing.TB) *fakeClock {
+	c := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	previous := clk
+	clk = c
+	t.Cleanup(func() { clk = previous })
+
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(&fakeTimer{duration: d, c: make(chan time.Time, 1)}).c
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	return c.add(&fakeTimer{duration: d, f: f})
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	return fakeTicker{c.add(&fakeTimer{duration: d, period: d, c: make(chan time.Time, 1)})}
+}
+
+// add schedules the timer to fire once its duration has passed
+func (c *fakeClock) add(t *fakeTimer) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.clock = c
+	t.at = c.now.Add(t.duration)
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Advance moves the clock on by d, firing the timers that fall due in the
+// order they do
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		due = append(due, t)
+		if t.period > 0 {
+			// Tickers drop the ticks that they can't keep up with
+			for !t.at.After(c.now) {
+				t.at = t.at.Add(t.period)
+			}
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	now := c.now
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		if t.f != nil {
+			go t.f()
+			continue
+		}
+		select {
+		case t.c <- now:
+		default:
+		}
+	}
+}
+
+// waitFor waits until a timer or ticker of the given duration is pending,
+// so that the clock isn't advanced before the code under test has started
+// waiting for it
+func (c *fakeClock) waitFor(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		for _, timer := range c.timers {
+			if timer.duration == d {
+				c.mu.Unlock()
+				return
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	t.Fatalf("timed out waiting for a %s timer", d)
+}
+
+// fakeTicker is a ticker created by a fakeClock
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+// Stop removes the timer, returning whether it was pending
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reset reschedules the timer to fire once d has passed from now,
+// returning whether it was pending
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	pending := t.Stop()
+	t.duration = d
+	t.clock.add(t)
+
+	return pending
+}
+
+func TestFakeClock(t *testing.T) {
+	c := &fakeClock{now: time.Unix(0, 0)}
+
+	after := c.After(time.Minute)
+	fired := make(chan bool, 1)
+	stopped := c.AfterFunc(time.Second, func() { fired <- true })
+	stopped.Stop()
+	ticker := c.NewTicker(10 * time.Second)
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	select {
+	case <-ticker.C():
+	default:
+		t.Error("ticker didn't tick")
+	}
+
+	c.Advance(time.Second)
+	select {
+	case <-after:
+	default:
+		t.Error("After didn't fire")
+	}
+	select {
+	case <-fired:
+		t.Error("stopped timer fired")
+	default:
+	}
+	if !c.Now().Equal(time.Unix(60, 0)) {
+		t.Errorf("got %s, expected the clock to have moved on a minute", c.Now())
+	}
+}
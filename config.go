@@ -0,0 +1,542 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// bannerMsg is shown above the report to users with a terminal, if set
+	bannerMsg string
+
+	// goodbyeMsg is shown at the very end of the report, if set, and
+	// disconnectReason is sent to the client when disconnecting
+	goodbyeMsg       string
+	disconnectReason = "Report complete"
+
+	// showBabble adds a column showing each key's bubblebabble fingerprint
+	showBabble bool
+
+	// showStrength adds a column showing each key's security strength
+	showStrength bool
+
+	// numberKeys adds a column numbering the keys in the order they were
+	// presented
+	numberKeys bool
+
+	// keyOrder is the order in which keys are listed in the table: either
+	// "presented" or "severity", most severe first
+	keyOrder = "presented"
+
+	// requireModern fails clients that don't present at least one modern key
+	requireModern bool
+
+	// fips checks each key against FIPS 140 key size guidance, if set to
+	// the matching policy
+	fips *policy
+
+	// minRSABits and allowedKeyTypes are clauses of this server's own key
+	// policy, with fips and sunsetSchedule; see localPolicy. minRSABits is
+	// zero and allowedKeyTypes empty if not set.
+	minRSABits      int
+	allowedKeyTypes []string
+
+	// strict rejects clients that don't present at least one key without
+	// known issues, once they've been shown the report
+	strict bool
+
+	// channelTimeout is how long to wait for a client to open a channel
+	// after authenticating before closing the connection
+	channelTimeout = 30 * time.Second
+
+	// handshakeTimeout is how long a client may take to complete the TLS
+	// and SSH handshakes, including authentication, before the connection
+	// is closed, so that idle connections can't hold every worker, or zero
+	// to wait indefinitely
+	handshakeTimeout = 30 * time.Second
+
+	// sessionTimeout is how long a connection may stay open once the
+	// client has authenticated, however active it is, or zero for no limit
+	sessionTimeout = 10 * time.Minute
+
+	// writeTimeout is how long each write to a client may take before the
+	// connection is closed, e.g. if it stops reading, or zero to wait
+	// indefinitely
+	writeTimeout = 30 * time.Second
+
+	// tcpKeepalive is how often to probe idle TCP connections, or zero to
+	// leave the setting unchanged. tcpReadBuffer and tcpWriteBuffer set the
+	// size of each socket's buffers, or zero to use the system's defaults.
+	tcpKeepalive   = 30 * time.Second
+	tcpReadBuffer  int
+	tcpWriteBuffer int
+
+	// listenBacklog is the number of connections the kernel queues for
+	// each listener until they're accepted, or zero for the system's
+	// default
+	listenBacklog int
+
+	// sessionTTL is how long to keep the keys offered during a handshake
+	// that was never completed
+	sessionTTL = 10 * time.Minute
+
+	// keepaliveInterval is how often to send keepalives to the client while
+	// its keys are being checked, or zero to disable them
+	keepaliveInterval = 5 * time.Second
+
+	// compareHostKey notes RSA keys that are weaker than the host key
+	compareHostKey = true
+
+	// checkCompression notes clients that offer compression
+	checkCompression = true
+
+	// checkDeprecations notes keys used in ways that OpenSSH has deprecated
+	checkDeprecations = true
+
+	// modulusChecks applies experimental checks for RSA moduli made by
+	// flawed key generators
+	modulusChecks bool
+
+	// praise congratulates users whose keys all follow current best
+	// practices
+	praise = true
+
+	// pinningNote reminds users connecting without a terminal, who are
+	// likely to be running a script, to pin the server's host key
+	pinningNote = true
+
+	// postQuantumNote notes, once per report, that none of the keys
+	// presented will resist attack by a quantum computer
+	postQuantumNote = true
+
+	// detectChains enables the heuristic detection of agents forwarded
+	// through multiple hosts, by comparing key sets seen within chainWindow
+	detectChains bool
+	chainWindow  = 10 * time.Minute
+
+	// compareSessions enables comparing the keys presented with those the
+	// same client presented within compareWindow
+	compareSessions bool
+	compareWindow   = time.Hour
+
+	// briefWindow is how long after a report the same address is sent a
+	// shorter one, without the welcome or advice already given, or zero to
+	// always send the full report
+	briefWindow time.Duration
+
+	// statsInterval is how often to log the totals of connections served
+	// and keys checked, or zero to only log them when the server stops
+	statsInterval time.Duration
+
+	// selfCheckInterval is how often to check that the files the server
+	// loads its configuration from can still be loaded, or zero to never
+	// check
+	selfCheckInterval = time.Hour
+
+	// maxKeys is the number of keys a client can present before being
+	// advised to present fewer
+	maxKeys = 6
+
+	// maxAuthTries is the number of keys checked for each client, or zero
+	// to check every key. Every public key authentication attempt is
+	// failed so that the client offers its next key, and the ssh package
+	// doesn't otherwise limit the number of attempts.
+	maxAuthTries int
+
+	// jsonPublicKeys includes each key in authorized_keys format in JSON
+	// reports, which makes them larger and ties them to the keys' owners
+	jsonPublicKeys bool
+
+	// streamRows sends each row of the table as soon as its key has been
+	// checked, rather than with the rest of the report
+	streamRows bool
+
+	// maxRows is the number of keys shown in the table before it is
+	// truncated, or zero to show every key
+	maxRows = 100
+
+	// workers is the number of connections served concurrently, and
+	// queueDepth the number of accepted connections allowed to wait for a
+	// free worker before new connections are turned away
+	workers    = 100
+	queueDepth = 100
+
+	// greetingDelay is how long to wait before sending each report, to slow
+	// down automated scanners
+	greetingDelay time.Duration
+
+	// proxyProtocol requires each SSH connection to start with a PROXY
+	// protocol header giving the client's address, as sent by TCP load
+	// balancers
+	proxyProtocol bool
+
+	// logFingerprints is how key fingerprints are written to the logs:
+	// "full", "truncate" or "hash"
+	logFingerprints = "full"
+
+	// logOfferedKeys logs each key offered in authorized_keys format, at
+	// debug level, to help diagnose keys that clients send malformed
+	logOfferedKeys bool
+
+	// interactive offers users with a terminal a menu of further details
+	// once the report has been shown, until they are idle for menuTimeout
+	interactive bool
+	menuTimeout = time.Minute
+
+	// hiddenMsgs lists the issues whose advice is left out of the report;
+	// they are still shown in the table
+	hiddenMsgs = make(map[string]bool)
+
+	// metricsToken is the token required by the HTTP endpoints served on
+	// METRICS_ADDR, if set
+	metricsToken string
+
+	// metricsInsecure allows METRICS_ADDR to be served without a token on
+	// an address other than loopback, for networks only Prometheus can reach
+	metricsInsecure bool
+
+	// metricsExemplars attaches the hashed fingerprint of a key found with
+	// each issue to its count, when metrics are scraped as OpenMetrics
+	metricsExemplars bool
+
+	// dashboard serves a page of the metrics and recent reports at
+	// /dashboard on METRICS_ADDR, showing the last dashboardRows reports
+	dashboard     bool
+	dashboardRows = 50
+
+	// reportOnly lists the issues in scope, if set; other issues are left
+	// out of the report, as if they hadn't been found
+	reportOnly map[string]bool
+
+	// wrapMessages re-wraps the report's messages to the width of the
+	// client's terminal, or to messageWidth without one
+	wrapMessages bool
+	messageWidth = 80
+
+	// suspiciousIDs are substrings of certificate key IDs, in lower case,
+	// suggesting that the certificate was never personalised
+	suspiciousIDs = []string{"test", "default", "changeme", "example", "placeholder"}
+
+	// banThreshold is the number of connections from an address that can
+	// end without a report, e.g. by failing the handshake or opening no
+	// channel, within banWindow before it's banned for banDuration, or
+	// zero to never ban. Repeat offenders are banned for twice as long
+	// each time, up to banMaxDuration.
+	banThreshold   int
+	banWindow      = 10 * time.Minute
+	banDuration    = 10 * time.Minute
+	banMaxDuration = 24 * time.Hour
+
+	// remediationQR is a QR code linking to remediationURL, shown to users
+	// with a terminal below the recommended actions, if set
+	remediationURL string
+	remediationQR  qrCode
+
+	// teachingMode explains each check made of each key in the report, as
+	// connecting as the "explain" user does
+	teachingMode bool
+
+	// ciphers, keyExchanges and macs are the transport algorithms offered to
+	// clients, or the ssh package's defaults if nil. Clients that don't
+	// support any of them fail to connect.
+	ciphers, keyExchanges, macs []string
+)
+
+// maxBannerSize is the largest banner that may be loaded, so that a mistaken
+// path can't fill users' terminals
+const maxBannerSize = 4096
+
+// loadConfig overrides the default settings with any given in the environment
+func loadConfig() {
+	if footer := os.Getenv("FOOTER"); footer != "" {
+		footerMsg = strings.Replace(footer+"\n\n", "\n", "\n\r", -1)
+	}
+
+	if path := os.Getenv("BANNER_FILE"); path != "" {
+		banner, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalln("Failed to read banner:", err)
+		}
+		if len(banner) > maxBannerSize {
+			log.Fatalf("Banner in %s is larger than %d bytes", path, maxBannerSize)
+		}
+		bannerMsg = strings.Replace(strings.TrimRight(string(banner), "\r\n")+"\n\n", "\n", "\n\r", -1)
+	}
+
+	if goodbye := os.Getenv("GOODBYE"); goodbye != "" {
+		goodbyeMsg = strings.Replace(goodbye+"\n", "\n", "\n\r", -1)
+	}
+	if reason := os.Getenv("DISCONNECT_REASON"); reason != "" {
+		disconnectReason = reason
+	}
+
+	showBabble = envBool("BUBBLEBABBLE", false)
+	showStrength = envBool("SECURITY_STRENGTH", false)
+	numberKeys = envBool("NUMBER_KEYS", false)
+	if order := os.Getenv("KEY_ORDER"); order != "" {
+		if order != "presented" && order != "severity" {
+			log.Fatalln("Invalid value for KEY_ORDER, expected presented or severity:", order)
+		}
+		keyOrder = order
+	}
+	requireModern = envBool("REQUIRE_MODERN_KEY", false)
+	strict = envBool("STRICT", false)
+	channelTimeout = envDuration("CHANNEL_TIMEOUT", channelTimeout)
+	handshakeTimeout = envDuration("HANDSHAKE_TIMEOUT", handshakeTimeout)
+	sessionTimeout = envDuration("SESSION_TIMEOUT", sessionTimeout)
+	writeTimeout = envDuration("WRITE_TIMEOUT", writeTimeout)
+	keepaliveInterval = envDuration("KEEPALIVE_INTERVAL", keepaliveInterval)
+	sessionTTL = envDuration("SESSION_TTL", sessionTTL)
+	tcpKeepalive = envDuration("TCP_KEEPALIVE", tcpKeepalive)
+	tcpReadBuffer = envInt("TCP_READ_BUFFER", tcpReadBuffer)
+	tcpWriteBuffer = envInt("TCP_WRITE_BUFFER", tcpWriteBuffer)
+	listenBacklog = envInt("LISTEN_BACKLOG", listenBacklog)
+	if sessionTTL <= 0 {
+		log.Fatalln("SESSION_TTL must be greater than zero")
+	}
+	compareHostKey = envBool("COMPARE_HOST_KEY", compareHostKey)
+	checkCompression = envBool("CHECK_COMPRESSION", checkCompression)
+	checkDeprecations = envBool("CHECK_DEPRECATIONS", checkDeprecations)
+	pinningNote = envBool("HOST_KEY_PINNING_NOTE", pinningNote)
+	praise = envBool("PRAISE_STRONG_KEYS", praise)
+	postQuantumNote = envBool("POST_QUANTUM_NOTE", postQuantumNote)
+	if note := os.Getenv("POST_QUANTUM_MESSAGE"); note != "" {
+		postQuantumMsg = strings.Replace("NOTE:     "+note+"\n\n", "\n", "\n\r", -1)
+	}
+	modulusChecks = envBool("EXPERIMENTAL_MODULUS_CHECKS", false)
+	detectChains = envBool("DETECT_FORWARDING_CHAINS", false)
+	chainWindow = envDuration("FORWARDING_CHAIN_WINDOW", chainWindow)
+	compareSessions = envBool("COMPARE_SESSIONS", false)
+	compareWindow = envDuration("COMPARE_SESSIONS_WINDOW", compareWindow)
+	briefWindow = envDuration("BRIEF_RETURNING_WINDOW", briefWindow)
+	if briefWindow < 0 {
+		log.Fatalln("BRIEF_RETURNING_WINDOW must not be negative")
+	}
+	maxKeys = envInt("MAX_KEYS", maxKeys)
+	checkTimeout = envDuration("CHECK_TIMEOUT", checkTimeout)
+	if checkTimeout <= 0 {
+		log.Fatalln("CHECK_TIMEOUT must be greater than zero")
+	}
+	maxAuthTries = envInt("MAX_AUTH_TRIES", maxAuthTries)
+	selfCheckInterval = envDuration("SELF_CHECK_INTERVAL", selfCheckInterval)
+	statsInterval = envDuration("STATS_INTERVAL", statsInterval)
+	maxRows = envInt("MAX_REPORT_ROWS", maxRows)
+	streamRows = envBool("STREAM_ROWS", false)
+	jsonPublicKeys = envBool("JSON_PUBLIC_KEYS", false)
+	workers = envInt("WORKERS", workers)
+	queueDepth = envInt("QUEUE_DEPTH", queueDepth)
+	greetingDelay = envDuration("GREETING_DELAY", greetingDelay)
+	if v := os.Getenv("SEVERITY"); v != "" {
+		if err := parseSeverities(v); err != nil {
+			log.Fatalln("Invalid value for SEVERITY:", err)
+		}
+	}
+
+	if v := os.Getenv("DOC_URLS"); v != "" {
+		if err := parseDocURLs(v); err != nil {
+			log.Fatalln("Invalid value for DOC_URLS:", err)
+		}
+	}
+
+	if v := os.Getenv("HIDE_MESSAGES"); v != "" {
+		for _, issue := range strings.Split(v, ",") {
+			issue = strings.TrimSpace(issue)
+			if _, ok := severities[issue]; !ok {
+				log.Fatalf("Invalid value for HIDE_MESSAGES, unknown issue: %q", issue)
+			}
+			hiddenMsgs[issue] = true
+		}
+	}
+
+	// The advice for issues out of scope is hidden like any other
+	if v := os.Getenv("REPORT_ONLY"); v != "" {
+		reportOnly = make(map[string]bool)
+		for _, issue := range strings.Split(v, ",") {
+			issue = strings.TrimSpace(issue)
+			if _, ok := severities[issue]; !ok {
+				log.Fatalf("Invalid value for REPORT_ONLY, unknown issue: %q", issue)
+			}
+			reportOnly[issue] = true
+		}
+		for issue := range severities {
+			if !reportOnly[issue] {
+				hiddenMsgs[issue] = true
+			}
+		}
+	}
+
+	metricsToken = os.Getenv("METRICS_TOKEN")
+	metricsInsecure = envBool("METRICS_INSECURE", false)
+	metricsExemplars = envBool("METRICS_EXEMPLARS", false)
+	dashboard = envBool("DASHBOARD", false)
+	dashboardRows = envInt("DASHBOARD_ROWS", dashboardRows)
+
+	interactive = envBool("INTERACTIVE", false)
+	menuTimeout = envDuration("INTERACTIVE_TIMEOUT", menuTimeout)
+	wrapMessages = envBool("WRAP_MESSAGES", false)
+	messageWidth = envInt("MESSAGE_WIDTH", messageWidth)
+	if messageWidth < minWrapWidth {
+		log.Fatalf("MESSAGE_WIDTH must be at least %d", minWrapWidth)
+	}
+
+	if v := os.Getenv("LOG_FINGERPRINTS"); v != "" {
+		logFingerprints = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, err := log.ParseLevel(v)
+		if err != nil {
+			log.Fatalln("Invalid value for LOG_LEVEL:", err)
+		}
+		log.SetLevel(level)
+	}
+
+	logOfferedKeys = envBool("LOG_KEYS", false)
+	if logOfferedKeys && log.GetLevel() < log.DebugLevel {
+		log.Warnln("LOG_KEYS has no effect unless LOG_LEVEL is set to debug")
+	}
+
+	switch logFingerprints {
+	case "full", "truncate", "hash":
+	default:
+		log.Fatalf("Invalid value for LOG_FINGERPRINTS, expected full, truncate or hash: %q", logFingerprints)
+	}
+	// Whole keys identify their owners as well as full fingerprints do
+	if logOfferedKeys && logFingerprints != "full" {
+		log.Fatalln("LOG_KEYS can't be used unless LOG_FINGERPRINTS is full")
+	}
+	banThreshold = envInt("BAN_THRESHOLD", banThreshold)
+	banWindow = envDuration("BAN_WINDOW", banWindow)
+	banDuration = envDuration("BAN_DURATION", banDuration)
+	banMaxDuration = envDuration("BAN_MAX_DURATION", banMaxDuration)
+	if banThreshold > 0 && (banWindow <= 0 || banDuration <= 0 || banMaxDuration < banDuration) {
+		log.Fatalln("BAN_WINDOW and BAN_DURATION must be greater than zero, and BAN_MAX_DURATION at least BAN_DURATION")
+	}
+	if remediationURL = os.Getenv("QR_CODE_URL"); remediationURL != "" {
+		var err error
+		if remediationQR, err = encodeQR(remediationURL); err != nil {
+			log.Fatalf("Invalid value for QR_CODE_URL, expected a URL of at most %d bytes: %s", maxQRLength, err)
+		}
+	}
+	teachingMode = envBool("TEACHING_MODE", false)
+	if v, ok := os.LookupEnv("SUSPICIOUS_KEY_IDS"); ok {
+		suspiciousIDs = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+				suspiciousIDs = append(suspiciousIDs, s)
+			}
+		}
+	}
+	if err := loadLessons(os.Getenv("TEACHING_TEMPLATES_FILE")); err != nil {
+		log.Fatalln("Invalid value for TEACHING_TEMPLATES_FILE:", err)
+	}
+	if envBool("RESTRICT_TRANSPORT", false) {
+		ciphers, keyExchanges, macs = restrictedCiphers, restrictedKeyExchanges, restrictedMACs
+	}
+	for _, list := range []struct {
+		name      string
+		algos     *[]string
+		supported []string
+	}{
+		{"CIPHERS", &ciphers, supportedCiphers},
+		{"KEY_EXCHANGES", &keyExchanges, supportedKeyExchanges},
+		{"MACS", &macs, supportedMACs},
+	} {
+		if v := os.Getenv(list.name); v != "" {
+			var err error
+			if *list.algos, err = parseAlgorithms(v, list.supported); err != nil {
+				log.Fatalf("Invalid value for %s: %s", list.name, err)
+			}
+		}
+	}
+	proxyProtocol = envBool("PROXY_PROTOCOL", false)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		var err error
+		if trustedProxies, err = parseTrustedProxies(v); err != nil {
+			log.Fatalln("Invalid value for TRUSTED_PROXIES:", err)
+		}
+	}
+	if v := os.Getenv("SUNSET_SCHEDULE"); v != "" {
+		var err error
+		if sunsetSchedule, err = parseSunsetSchedule(v); err != nil {
+			log.Fatalln("Invalid value for SUNSET_SCHEDULE:", err)
+		}
+	}
+	minRSABits = envInt("MIN_RSA_BITS", minRSABits)
+	if minRSABits < 0 {
+		log.Fatalln("MIN_RSA_BITS must not be negative")
+	}
+	if v := os.Getenv("ALLOWED_KEY_TYPES"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := keyAlgorithms[name]; !ok {
+				log.Fatalf("Invalid value for ALLOWED_KEY_TYPES, expected rsa, dsa, ecdsa or ed25519: %q", name)
+			}
+			allowedKeyTypes = append(allowedKeyTypes, name)
+		}
+	}
+	switch v := os.Getenv("FIPS"); v {
+	case "", "false":
+	case "true":
+		fips = &fips140
+	case "strict":
+		fips = &fips140Strict
+	default:
+		log.Fatalf("Invalid value for FIPS, expected true, strict or false: %q", v)
+	}
+	if workers < 1 {
+		log.Fatalln("WORKERS must be at least 1")
+	}
+}
+
+// envBool returns the boolean value of the named environment variable, such
+// as "1" or "true", or def if it is not set
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected true or false: %q", name, v)
+	}
+
+	return b
+}
+
+// envInt returns the integer value of the named environment variable, or def
+// if it is not set
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Fatalf("Invalid value for %s, expected a positive integer: %q", name, v)
+	}
+
+	return i
+}
+
+// envDuration returns the duration given by the named environment variable,
+// e.g. "30s", or def if it is not set
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid value for %s, expected a duration such as 30s: %q", name, v)
+	}
+
+	return d
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// offenders records the addresses whose connections ended without a report,
+// as scanners' do, so that those that persist can be turned away for a
+// while. Each ban lasts twice as long as the one before, up to banMaxDuration.
+var offenders = struct {
+	mu    sync.Mutex
+	hosts map[string]*offender
+}{
+	hosts: make(map[string]*offender),
+}
+
+// offender describes the recent offences from an address
+type offender struct {
+	// offences counts the offences since first, within banWindow
+	offences int
+	first    time.Time
+	last     time.Time
+
+	// bans counts how many times the address has been banned, which
+	// is remembered until it has behaved for banMaxDuration
+	bans        int
+	bannedUntil time.Time
+}
+
+// connectionHost returns the host part of the address, or false if it
+// belongs to a trusted proxy, which can't be held responsible for the
+// clients it forwards
+func connectionHost(addr net.Addr) (string, bool) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || trustedProxy(net.ParseIP(host)) {
+		return "", false
+	}
+
+	return host, true
+}
+
+// recordOffence notes that a connection from addr ended without a report,
+// banning the address if it has done so banThreshold times within banWindow
+func recordOffence(logger *log.Entry, addr net.Addr, reason string) {
+	host, ok := connectionHost(addr)
+	if banThreshold == 0 || !ok {
+		return
+	}
+
+	now := clk.Now()
+
+	offenders.mu.Lock()
+	defer offenders.mu.Unlock()
+
+	o, ok := offenders.hosts[host]
+	if !ok {
+		o = &offender{}
+		offenders.hosts[host] = o
+	}
+	if o.offences == 0 || now.Sub(o.first) > banWindow {
+		o.offences, o.first = 0, now
+	}
+	o.offences++
+	o.last = now
+
+	if o.offences < banThreshold {
+		return
+	}
+
+	duration := banDuration
+	for i := 0; i < o.bans && duration < banMaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > banMaxDuration {
+		duration = banMaxDuration
+	}
+
+	o.bans++
+	o.offences = 0
+	o.bannedUntil = now.Add(duration)
+	logger.WithFields(log.Fields{
+		"bans":     o.bans,
+		"duration": duration.String(),
+	}).Warnf("Banned %s after %d connections without a report within %s, most recently by %s", host, banThreshold, banWindow, reason)
+}
+
+// banned reports whether connections from addr should be turned away
+func banned(addr net.Addr) bool {
+	host, ok := connectionHost(addr)
+	if banThreshold == 0 || !ok {
+		return false
+	}
+
+	offenders.mu.Lock()
+	defer offenders.mu.Unlock()
+
+	o, ok := offenders.hosts[host]
+	return ok && clk.Now().Before(o.bannedUntil)
+}
+
+// expireBans periodically logs the bans that have expired, and forgets the
+// addresses that have behaved for long enough, so that the map doesn't grow
+// without bound
+func expireBans() {
+	ticker := clk.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		now := clk.Now()
+
+		offenders.mu.Lock()
+		for host, o := range offenders.hosts {
+			if !o.bannedUntil.IsZero() && !now.Before(o.bannedUntil) {
+				log.WithField("bans", o.bans).Infoln("Ban on", host, "expired")
+				o.bannedUntil = time.Time{}
+			}
+
+			if o.bannedUntil.IsZero() && now.Sub(o.last) > banWindow && (o.bans == 0 || now.Sub(o.last) > banMaxDuration) {
+				delete(offenders.hosts, host)
+			}
+		}
+		offenders.mu.Unlock()
+	}
+}
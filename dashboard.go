@@ -0,0 +1,124 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// recentReports holds the most recent reports, up to dashboardRows, for the
+// dashboard. It is a ring buffer: next is where the next report is written,
+// over the oldest once it is full.
+var recentReports = struct {
+	sync.Mutex
+	records []auditRecord
+	next    int
+}{}
+
+// recordRecent adds the report to those shown on the dashboard, if it's
+// enabled
+func recordRecent(record auditRecord) {
+	if !dashboard || dashboardRows <= 0 {
+		return
+	}
+
+	recentReports.Lock()
+	defer recentReports.Unlock()
+
+	if len(recentReports.records) < dashboardRows {
+		recentReports.records = append(recentReports.records, record)
+		return
+	}
+	recentReports.records[recentReports.next] = record
+	recentReports.next = (recentReports.next + 1) % dashboardRows
+}
+
+// recent returns the recent reports, newest first
+func recent() []auditRecord {
+	recentReports.Lock()
+	defer recentReports.Unlock()
+
+	n := len(recentReports.records)
+	records := make([]auditRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		records = append(records, recentReports.records[(recentReports.next-i+n)%n])
+	}
+
+	return records
+}
+
+// dashboardTemplate is the dashboard, which refreshes itself. Users choose
+// their user names and keys, so they are escaped by html/template, and
+// nothing is loaded from elsewhere.
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>sshkeycheck</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.critical { background: #fdd; }
+.warn { background: #ffd; }
+.ok { background: #dfd; }
+</style>
+</head>
+<body>
+<h1>sshkeycheck</h1>
+<table>
+<tr><th>Handshakes</th><td>{{.Handshakes}}</td></tr>
+<tr><th>Failed handshakes</th><td>{{.HandshakeFailures}}</td></tr>
+<tr><th>Sessions</th><td>{{.Sessions}}</td></tr>
+{{- range .Findings}}
+<tr><th>Reports warning about {{.Category}}</th><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+<h2>Recent reports</h2>
+<table>
+<tr><th>Time</th><th>Reference</th><th>Client</th><th>User</th><th>Keys</th><th>Issues</th><th>Verdict</th></tr>
+{{- range .Recent}}
+<tr class="{{lower .Verdict}}"><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Ref}}</td><td>{{.RemoteAddr}}{{if .ForwardedFor}} ({{.ForwardedFor}}){{end}}</td><td>{{.User}}</td><td>{{len .Keys}}</td><td>{{join .Issues ", "}}</td><td>{{.Verdict}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// writeDashboard writes the dashboard: the totals counted by the metrics,
+// leaving out issues not yet found, and the recent reports
+func writeDashboard(w http.ResponseWriter, r *http.Request) {
+	type finding struct {
+		Category string
+		Count    uint64
+	}
+	var page struct {
+		Handshakes, HandshakeFailures, Sessions uint64
+		Findings                                []finding
+		Recent                                  []auditRecord
+	}
+
+	metrics.Lock()
+	page.Handshakes, page.HandshakeFailures, page.Sessions = metrics.handshakes, metrics.handshakeFailures, metrics.sessionsCount
+	for name, count := range metrics.findings {
+		if count > 0 {
+			page.Findings = append(page.Findings, finding{findingCategory(name), count})
+		}
+	}
+	metrics.Unlock()
+	sort.Slice(page.Findings, func(i, j int) bool { return page.Findings[i].Category < page.Findings[j].Category })
+	page.Recent = recent()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		log.Errorln("Failed to render dashboard:", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// resetRecent empties the recent reports, restoring them when the test ends
+func resetRecent(t *testing.T) {
+	recentReports.Lock()
+	records, next := recentReports.records, recentReports.next
+	recentReports.records, recentReports.next = nil, 0
+	recentReports.Unlock()
+
+	t.Cleanup(func() {
+		recentReports.Lock()
+		recentReports.records, recentReports.next = records, next
+		recentReports.Unlock()
+	})
+}
+
+// Once full, the oldest reports are replaced, and reports are only kept if
+// the dashboard is enabled
+func TestRecordRecent(t *testing.T) {
+	defer func(enabled bool, rows int) { dashboard, dashboardRows = enabled, rows }(dashboard, dashboardRows)
+
+	for _, test := range []struct {
+		name     string
+		enabled  bool
+		rows     int
+		records  int
+		expected string
+	}{
+		{"disabled", false, 3, 2, ""},
+		{"no rows", true, 0, 2, ""},
+		{"not full", true, 3, 2, "ref-1,ref-0"},
+		{"full", true, 3, 3, "ref-2,ref-1,ref-0"},
+		{"wrapped", true, 3, 5, "ref-4,ref-3,ref-2"},
+		{"wrapped twice", true, 3, 7, "ref-6,ref-5,ref-4"},
+	} {
+		resetRecent(t)
+		dashboard, dashboardRows = test.enabled, test.rows
+		for i := 0; i < test.records; i++ {
+			recordRecent(auditRecord{Ref: fmt.Sprintf("ref-%d", i)})
+		}
+
+		var refs []string
+		for _, r := range recent() {
+			refs = append(refs, r.Ref)
+		}
+		if got := strings.Join(refs, ","); got != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+// The dashboard shows the totals and the recent reports, escaping what
+// clients chose
+func TestWriteDashboard(t *testing.T) {
+	defer func(enabled bool, rows int) { dashboard, dashboardRows = enabled, rows }(dashboard, dashboardRows)
+	dashboard, dashboardRows = true, 10
+	resetRecent(t)
+	metrics.Lock()
+	findings := metrics.findings
+	metrics.findings = map[string]uint64{"weak": 4, "dsa": 0}
+	metrics.Unlock()
+	defer func() {
+		metrics.Lock()
+		metrics.findings = findings
+		metrics.Unlock()
+	}()
+
+	hostile := `<script>alert("pwned")</script>`
+	recordRecent(auditRecord{
+		Time:       useFakeClock(t).Now(),
+		RemoteAddr: "192.0.2.1:50000",
+		Ref:        "abc123",
+		User:       hostile,
+		Keys:       make([]auditKey, 2),
+		Issues:     []string{"agent", "weak"},
+		Verdict:    "CRITICAL",
+	})
+
+	w := httptest.NewRecorder()
+	writeDashboard(w, httptest.NewRequest("GET", "/dashboard", nil))
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("got status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		`<meta http-equiv="refresh"`,
+		"<tr><th>Reports warning about weak_rsa</th><td>4</td></tr>",
+		`<tr class="critical"><td>2026-01-01 00:00:00</td><td>abc123</td><td>192.0.2.1:50000</td>`,
+		"&lt;script&gt;",
+		"<td>2</td><td>agent, weak</td><td>CRITICAL</td>",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in dashboard:\n%s", expected, body)
+		}
+	}
+	for _, unexpected := range []string{hostile, "about dsa", "http://", "https://"} {
+		if strings.Contains(body, unexpected) {
+			t.Errorf("unexpected %q in dashboard:\n%s", unexpected, body)
+		}
+	}
+}
+
+// Reports made by the server are recorded for the dashboard
+func TestDashboardRecordsReports(t *testing.T) {
+	defer func(enabled bool, rows int) { dashboard, dashboardRows = enabled, rows }(dashboard, dashboardRows)
+	dashboard, dashboardRows = true, 10
+	resetRecent(t)
+
+	testReport(t, "dashboard-user", testWeakSigner(t))
+
+	records := recent()
+	if len(records) != 1 {
+		t.Fatalf("got %d recent reports, expected 1", len(records))
+	}
+	if r := records[0]; r.User != "dashboard-user" || len(r.Keys) != 1 || strings.Join(r.Issues, ",") != "weak" {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// demoBlacklistedKey is a key from the Debian blacklist's rsa-2048 set. Only
+// its public key is known, which is all that's needed to offer it.
+const demoBlacklistedKey = `ssh-rsa AAAAB3NzaC1yc2EAAAABIwAAAQEA1TnqEb1xLloPGAIKmdxJXHuNZAicwj/IDjSWshsgqnfjYQCH8HyQT9+l4RYmCBDK/usbqgpw4+zh5Pg0Zx8dm/ho+B1r5z4Vv9pk5SLieeM+nxM1bfXa5oz8TJuztIHz8LmQp3ByAal9kc4HTXDCMoaWAmFju+mfmnpG+p+t+vc707uzJJLqIwCoZo/JCDdL3+K9fHoeClP+1MeW1SnWyN8IrQmXqMYqDiIzMK5ckMhTV8ga95G77DW/xYGdLp+Ic5OaPfo1bE+VNSe82oSbwaTcmOS2gXL9R9rs70gNGuJRbsDvVM13LjKhL4mvj1ABOHqyaR6ZybrdNu9LVkNeyQ==`
+
+// publicOnlySigner offers a public key whose private key isn't known. The
+// server never accepts a key, so it's never asked to sign anything.
+type publicOnlySigner struct {
+	key ssh.PublicKey
+}
+
+func (s publicOnlySigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s publicOnlySigner) Sign(io.Reader, []byte) (*ssh.Signature, error) {
+	return nil, errors.New("private key not known")
+}
+
+// demoSigners returns a set of sample keys with a variety of issues, freshly
+// generated other than the blacklisted key. The ssh package doesn't support
+// Ed25519, so no Ed25519 key is included.
+func demoSigners() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	dsaKey := new(dsa.PrivateKey)
+	if err := dsa.GenerateParameters(&dsaKey.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		return nil, err
+	}
+	if err := dsa.GenerateKey(dsaKey, rand.Reader); err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range []interface{}{rsaKey, dsaKey, ecdsaKey} {
+		signer, err := ssh.NewSignerFromKey(k)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	blacklisted, _, _, _, err := ssh.ParseAuthorizedKey([]byte(demoBlacklistedKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signers, publicOnlySigner{blacklisted}), nil
+}
+
+// demoHostKey returns a freshly generated host key, for use when no host key
+// has been configured
+func demoHostKey() (ssh.Signer, error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(k)
+}
+
+// runDemo connects to the server at addr using the sample keys and prints
+// the report to stdout, returning the session's exit status
+func runDemo(addr string) int {
+	signers, err := demoSigners()
+	if err != nil {
+		log.Fatalln("Failed to generate sample keys:", err)
+	}
+
+	return runSession(addr, "demo", signers, os.Stdout)
+}
+
+// runSession connects to the server at addr as the given user, offering
+// the given keys, and copies the output to stdout with local line endings,
+// returning the session's exit status
+func runSession(addr, user string, signers []ssh.Signer, stdout io.Writer) int {
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signers...),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return nil, nil
+			}),
+		},
+	})
+	if err != nil {
+		log.Fatalln("Failed to connect to the server:", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatalln("Failed to open a session:", err)
+	}
+	defer session.Close()
+
+	session.Stdout = withLineEnding(stdout, localLineEnding)
+	if err := session.Shell(); err != nil {
+		log.Fatalln("Failed to start a shell:", err)
+	}
+
+	// The full report is sent without an exit status, which the ssh
+	// package reports as an error
+	if exitErr, ok := session.Wait().(*ssh.ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// docURLs maps each issue to a page explaining it in more detail, which is
+// linked to from the issue's advice in the report. The defaults can be
+// overridden using the DOC_URLS environment variable.
+var docURLs = map[string]string{
+	"blacklisted": "https://www.debian.org/security/2008/dsa-1576",
+	"revoked":     "https://man.openbsd.org/ssh-keygen#KEY_REVOCATION_LISTS",
+	"modulus":     "https://fermatattack.secvuln.info/",
+	"dsa":         "https://www.openssh.com/txt/release-7.0",
+	"weak":        "https://www.keylength.com/en/4/",
+	"agent":       "https://man.openbsd.org/ssh_config#ForwardAgent",
+	"x11":         "https://man.openbsd.org/ssh_config#ForwardX11",
+}
+
+// parseDocURLs overrides the documentation URLs for the issues listed in s,
+// which takes the form "dsa=https://example.com/dsa,weak=". An empty URL
+// removes the link for that issue.
+func parseDocURLs(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected issue=URL: %q", pair)
+		}
+
+		if _, ok := severities[parts[0]]; !ok {
+			return fmt.Errorf("unknown issue: %q", parts[0])
+		}
+
+		if parts[1] == "" {
+			delete(docURLs, parts[0])
+			continue
+		}
+		docURLs[parts[0]] = parts[1]
+	}
+
+	return nil
+}
+
+// documented adds a link to the issue's documentation, if it has any, to
+// the end of msg, ahead of the blank line that separates it from the next
+func documented(issue, msg string) string {
+	url, ok := docURLs[issue]
+	if !ok {
+		return msg
+	}
+
+	return strings.TrimSuffix(msg, "\n\r") + "          See: " + url + "\n\r\n\r"
+}
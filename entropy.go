@@ -0,0 +1,27 @@
+package main
+
+// entropyChecker is implemented by heuristics that spot keys generated with
+// too little entropy, such as those generated by embedded devices at first
+// boot, which tend to cluster. Such keys can rarely be recognised from the
+// public key alone, so no heuristics are built in; operators with knowledge
+// of their own fleet can set entropyCheck to their own implementation from
+// a file of their own, e.g. in an init function.
+type entropyChecker interface {
+	// checkEntropy returns why the key appears to have been generated
+	// with too little entropy, if it does. It is called for every key
+	// presented, so may be called concurrently, and is skipped if it
+	// takes longer than checkTimeout, when stop is closed so that it can
+	// give up.
+	checkEntropy(k *publicKey, stop <-chan struct{}) (reason string, weak bool)
+}
+
+// entropyCheck is the heuristic used to spot keys generated with too little
+// entropy
+var entropyCheck entropyChecker = noEntropyCheck{}
+
+// noEntropyCheck finds no keys generated with too little entropy
+type noEntropyCheck struct{}
+
+func (noEntropyCheck) checkEntropy(*publicKey, <-chan struct{}) (string, bool) {
+	return "", false
+}
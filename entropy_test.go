@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+)
+
+// fleetEntropyCheck is the example from the README, flagging keys whose
+// modulus is in a set collected from a fleet of devices known to have
+// generated keys before gathering enough entropy
+type fleetEntropyCheck struct {
+	// moduli maps the SHA-256 hashes of the flawed moduli to the firmware
+	// that generated them
+	moduli map[string]string
+}
+
+func (c fleetEntropyCheck) checkEntropy(k *publicKey, stop <-chan struct{}) (string, bool) {
+	n, err := keycheck.RSAModulus(k.key)
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(n.Bytes())
+	firmware, ok := c.moduli[hex.EncodeToString(sum[:])]
+	return "modulus generated by " + firmware, ok
+}
+
+// slowEntropyCheck runs until it's told to stop, then closes gaveUp
+type slowEntropyCheck struct {
+	gaveUp chan struct{}
+}
+
+func (c slowEntropyCheck) checkEntropy(k *publicKey, stop <-chan struct{}) (string, bool) {
+	defer close(c.gaveUp)
+	for !stopped(stop) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return "too slow", true
+}
+
+func TestEntropyCheck(t *testing.T) {
+	defer func(c entropyChecker) { entropyCheck = c }(entropyCheck)
+
+	flawed, sound := generateKey(t, "rsa-2048"), generateKey(t, "rsa-3072")
+	n, err := keycheck.RSAModulus(flawed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(n.Bytes())
+	fleet := fleetEntropyCheck{map[string]string{hex.EncodeToString(sum[:]): "firmware 1.2"}}
+
+	for _, test := range []struct {
+		name     string
+		check    entropyChecker
+		expected []string
+	}{
+		{"no heuristics", noEntropyCheck{}, nil},
+		{"fleet moduli", fleet, []string{(&publicKey{key: flawed}).Fingerprint() + " (modulus generated by firmware 1.2)"}},
+	} {
+		entropyCheck = test.check
+		a := analyzeKeys(flawed, sound, generateKey(t, "ecdsa-256"))
+
+		if a.lowEntropy != (test.expected != nil) || strings.Join(a.lowEntropyKeys, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: got %t, %q, expected %q", test.name, a.lowEntropy, a.lowEntropyKeys, test.expected)
+		}
+		if flagged := a.results[0].issue == issueLowEntropy; flagged != (test.expected != nil) || a.results[1].issue == issueLowEntropy {
+			t.Errorf("%s: got %q, %q", test.name, a.results[0].issue, a.results[1].issue)
+		}
+	}
+}
+
+// Heuristics that take too long are skipped, and the report says so
+func TestEntropyCheckTimeout(t *testing.T) {
+	defer func(c entropyChecker, d time.Duration) { entropyCheck, checkTimeout = c, d }(entropyCheck, checkTimeout)
+	slow := slowEntropyCheck{make(chan struct{})}
+	entropyCheck, checkTimeout = slow, 100*time.Millisecond
+
+	a := analyzeKeys(generateKey(t, "ecdsa-256"))
+
+	// The check must have given up before it's replaced by the original
+	select {
+	case <-slow.gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("entropy check still running after timing out")
+	}
+
+	if a.lowEntropy || !strings.Contains(strings.Join(a.notEvaluated, ","), "Entropy heuristics for ") {
+		t.Errorf("got %t, %q, expected the heuristics to be noted as not evaluated", a.lowEntropy, a.notEvaluated)
+	}
+}
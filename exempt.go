@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// exemptions maps the SHA-256 fingerprints of keys whose issues are known
+// about, e.g. because they are due to be replaced, to a note explaining
+// why. These keys are reported as known exceptions rather than with their
+// issues.
+var exemptions = struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}{
+	keys: make(map[string]string),
+}
+
+// loadExemptions replaces the exemptions with those listed in the named
+// file. Each line gives a SHA-256 fingerprint, optionally followed by a
+// note. The existing exemptions are kept if the file can't be read.
+func loadExemptions(path string) error {
+	keys, err := readExemptions(path)
+	if err != nil {
+		return err
+	}
+
+	exemptions.mu.Lock()
+	exemptions.keys = keys
+	exemptions.mu.Unlock()
+
+	return nil
+}
+
+// readExemptions reads the exemptions listed in the named file
+func readExemptions(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(entry, " ", 2)
+		if !strings.HasPrefix(fields[0], "SHA256:") {
+			return nil, fmt.Errorf("expected a SHA256 fingerprint on line %d: %q", line, entry)
+		}
+
+		note := "rotation scheduled"
+		if len(fields) == 2 && strings.TrimSpace(fields[1]) != "" {
+			note = strings.TrimSpace(fields[1])
+		}
+		keys[strings.TrimRight(fields[0], "=")] = note
+	}
+
+	return keys, scanner.Err()
+}
+
+// exempt returns the note explaining why the key is exempt, if it is
+func exempt(k *publicKey) (string, bool) {
+	exemptions.mu.RLock()
+	defer exemptions.mu.RUnlock()
+
+	note, ok := exemptions.keys[k.FingerprintSHA256()]
+	return note, ok
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/list"
+	"time"
+)
+
+// expiringMap holds values for as long as the window it reads, and at most
+// max of them, forgetting the one stored least recently to make room for
+// another. Entries are kept in the order they were stored, so those that
+// have expired are forgotten as others are stored, without looking at the
+// rest. It isn't safe for concurrent use.
+type expiringMap struct {
+	// window points at the configured window, so that it's read as each
+	// value is looked up or stored
+	window *time.Duration
+	max    int
+
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// expiringEntry is a value in an expiringMap, and when it was stored
+type expiringEntry struct {
+	key   string
+	value interface{}
+	at    time.Time
+}
+
+func newExpiringMap(window *time.Duration, max int) *expiringMap {
+	return &expiringMap{
+		window:  window,
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the value stored under key, if it was stored within the
+// window
+func (m *expiringMap) get(key string, now time.Time) (interface{}, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*expiringEntry)
+	if now.Sub(entry.at) > *m.window {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// put stores the value under key, forgetting those that have expired, and
+// the one stored least recently if there are then too many
+func (m *expiringMap) put(key string, value interface{}, now time.Time) {
+	for e := m.order.Front(); e != nil; e = m.order.Front() {
+		entry := e.Value.(*expiringEntry)
+		if now.Sub(entry.at) <= *m.window {
+			break
+		}
+		m.remove(e)
+	}
+
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+	m.entries[key] = m.order.PushBack(&expiringEntry{key: key, value: value, at: now})
+
+	for m.order.Len() > m.max {
+		m.remove(m.order.Front())
+	}
+}
+
+func (m *expiringMap) remove(e *list.Element) {
+	delete(m.entries, e.Value.(*expiringEntry).key)
+	m.order.Remove(e)
+}
+
+// len returns the number of values held, including any that have expired
+// but are yet to be forgotten
+func (m *expiringMap) len() int {
+	return m.order.Len()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Values are forgotten once the window has passed since they were last
+// stored, or to make room for others, least recently stored first
+func TestExpiringMap(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name     string
+		window   time.Duration
+		puts     []string
+		after    time.Duration
+		expected string
+	}{
+		{"within window", time.Minute, []string{"a", "b"}, time.Minute, "a,b"},
+		{"expired", time.Minute, []string{"a", "b"}, time.Minute + time.Second, ""},
+		{"over capacity", time.Hour, []string{"a", "b", "c", "d"}, 0, "b,c,d"},
+		{"stored again", time.Hour, []string{"a", "b", "c", "a", "d"}, 0, "a,c,d"},
+		{"window changed", 0, []string{"a"}, time.Second, ""},
+	} {
+		window := time.Hour
+		m := newExpiringMap(&window, 3)
+		now := start
+		for _, key := range test.puts {
+			m.put(key, key, now)
+		}
+
+		// The window is read as values are looked up
+		window = test.window
+		now = now.Add(test.after)
+		var held []string
+		for _, key := range []string{"a", "b", "c", "d"} {
+			if v, ok := m.get(key, now); ok {
+				held = append(held, v.(string))
+			}
+		}
+		if got := strings.Join(held, ","); got != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+// Values that have expired are forgotten as others are stored
+func TestExpiringMapForgets(t *testing.T) {
+	window := time.Minute
+	m := newExpiringMap(&window, 10)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.put("a", nil, now)
+	m.put("b", nil, now.Add(30*time.Second))
+	m.put("c", nil, now.Add(time.Minute+time.Second))
+	if m.len() != 2 {
+		t.Errorf("got %d values held, expected 2", m.len())
+	}
+}
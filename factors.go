@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// knownFactor is a prime known to divide the modulus of keys generated by
+// flawed software, and where it came from
+type knownFactor struct {
+	prime  *big.Int
+	source string
+}
+
+// knownFactors lists the primes loaded from KNOWN_FACTORS_FILE, and
+// knownFactorsProduct is their product, so that a modulus can be checked
+// against all of them with a single GCD
+var (
+	knownFactors        []knownFactor
+	knownFactorsProduct = big.NewInt(1)
+)
+
+// loadKnownFactors adds the primes listed in the named file to
+// knownFactors. Each line gives a prime in decimal, or in hexadecimal with
+// a 0x prefix, optionally followed by where it came from.
+func loadKnownFactors(path string) error {
+	factors, err := readKnownFactors(path)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range factors {
+		knownFactors = append(knownFactors, f)
+		knownFactorsProduct.Mul(knownFactorsProduct, f.prime)
+	}
+
+	return nil
+}
+
+// readKnownFactors reads the primes listed in the named file
+func readKnownFactors(path string) ([]knownFactor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var factors []knownFactor
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(entry, " ", 2)
+		p, ok := new(big.Int).SetString(fields[0], 0)
+		if !ok || p.Cmp(big.NewInt(1)) <= 0 {
+			return nil, fmt.Errorf("expected a prime on line %d: %q", line, entry)
+		}
+
+		source := path
+		if len(fields) == 2 {
+			source = strings.TrimSpace(fields[1])
+		}
+		factors = append(factors, knownFactor{p, source})
+	}
+
+	return factors, scanner.Err()
+}
+
+// knownFactorOf returns which known factor, if any, divides the modulus n.
+// Moduli are first reduced by the primes below smallPrimeLimit, which no
+// properly generated modulus is divisible by, then by those listed in
+// KNOWN_FACTORS_FILE, giving up once stop is closed.
+func knownFactorOf(n *big.Int, stop <-chan struct{}) (string, bool) {
+	one := big.NewInt(1)
+	if g := new(big.Int).GCD(nil, nil, n, smallPrimes); g.Cmp(one) != 0 {
+		// The smallest prime factor is the one users can check most easily
+		for p := int64(2); ; p++ {
+			if new(big.Int).Mod(n, big.NewInt(p)).Sign() == 0 {
+				return fmt.Sprintf("divisible by %d", p), true
+			}
+		}
+	}
+
+	if len(knownFactors) == 0 {
+		return "", false
+	}
+	if g := new(big.Int).GCD(nil, nil, n, knownFactorsProduct); g.Cmp(one) == 0 {
+		return "", false
+	}
+
+	r := new(big.Int)
+	for _, f := range knownFactors {
+		if stopped(stop) {
+			break
+		}
+		if r.Mod(n, f.prime).Sign() == 0 {
+			return "divisible by a prime from " + f.source, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadKnownFactors(t *testing.T) {
+	for _, test := range []struct {
+		list    string
+		primes  []int64
+		sources []string
+		ok      bool
+	}{
+		{"# Factors\n\n10007 Debian 2008\n0x2713\n", []int64{10007, 10003}, []string{"Debian 2008", ""}, true},
+		{"not-a-prime\n", nil, nil, false},
+		{"1\n", nil, nil, false},
+	} {
+		path := filepath.Join(t.TempDir(), "factors")
+		if err := ioutil.WriteFile(path, []byte(test.list), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		factors, err := readKnownFactors(path)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.list, err)
+			continue
+		}
+		if len(factors) != len(test.primes) {
+			t.Errorf("%q: got %d factors, expected %d", test.list, len(factors), len(test.primes))
+			continue
+		}
+		// Primes listed without a source are from the file
+		for i, f := range factors {
+			source := test.sources[i]
+			if source == "" {
+				source = path
+			}
+			if f.prime.Int64() != test.primes[i] || f.source != source {
+				t.Errorf("%q: got %s from %q, expected %d from %q", test.list, f.prime, f.source, test.primes[i], source)
+			}
+		}
+	}
+}
+
+// Moduli divisible by a small prime, or by a prime listed in
+// KNOWN_FACTORS_FILE, are flagged with the factor's source
+func TestKnownFactorOf(t *testing.T) {
+	defer func(factors []knownFactor, product *big.Int) {
+		knownFactors, knownFactorsProduct = factors, product
+	}(knownFactors, knownFactorsProduct)
+
+	listed, q, r := testPrime(t), testPrime(t), testPrime(t)
+	path := filepath.Join(t.TempDir(), "factors")
+	if err := ioutil.WriteFile(path, []byte(listed.String()+" a flawed token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	knownFactors, knownFactorsProduct = nil, big.NewInt(1)
+	if err := loadKnownFactors(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		n        *big.Int
+		expected string
+	}{
+		{"listed factor", new(big.Int).Mul(listed, q), "divisible by a prime from a flawed token"},
+		{"small factor", new(big.Int).Mul(big.NewInt(9973), q), "divisible by 9973"},
+		{"smallest of several small factors", new(big.Int).Mul(big.NewInt(7*13), q), "divisible by 7"},
+		{"no known factor", new(big.Int).Mul(q, r), ""},
+	} {
+		reason, ok := knownFactorOf(test.n, nil)
+		if reason != test.expected || ok != (test.expected != "") {
+			t.Errorf("%s: got %q, %t, expected %q", test.name, reason, ok, test.expected)
+		}
+	}
+
+	a := analyzeKeys(modulusKey(t, new(big.Int).Mul(listed, r)), modulusKey(t, new(big.Int).Mul(q, r)))
+	if a.results[0].issue != issueKnownFactor || a.results[1].issue == issueKnownFactor {
+		t.Errorf("got %q, %q, expected the first key alone to be %q", a.results[0].issue, a.results[1].issue, issueKnownFactor)
+	}
+}
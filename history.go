@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// maxHistory is the number of previous sessions remembered for comparison;
+// the oldest is forgotten to make room for another
+const maxHistory = 10000
+
+// history records the keys presented during each client's most recent
+// session, so that users fixing their configuration can see what changed.
+// Clients are identified by a keyed hash of their token or address.
+var history = struct {
+	mu   sync.Mutex
+	seen *expiringMap
+}{
+	seen: newExpiringMap(&compareWindow, maxHistory),
+}
+
+// keyHistory describes each key presented during a session, by fingerprint
+type keyHistory struct {
+	keys map[string]string
+}
+
+// historyID returns the identifier under which a client's keys are
+// remembered: the token it sent in KEYCHECK_TOKEN if there is one,
+// otherwise its address. Neither is stored as is.
+func historyID(token, host string) string {
+	mac := hmac.New(sha256.New, logKey)
+	if token != "" {
+		fmt.Fprintf(mac, "token:%s", token)
+	} else {
+		fmt.Fprintf(mac, "host:%s", host)
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// compareWithPrevious records the keys presented by the client, and
+// returns how they differ from those it presented last time, if it has
+// connected within compareWindow
+func compareWithPrevious(id string, results []keyResult) (added, removed []string, ok bool) {
+	now := clk.Now()
+	current := keyHistory{keys: make(map[string]string)}
+	for _, r := range results {
+		current.keys[r.key.Fingerprint()] = fmt.Sprintf("%s %s %s (%s)", r.key.key.Type(), r.bits(), r.key.Fingerprint(), r.issue)
+	}
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	v, ok := history.seen.get(id, now)
+	history.seen.put(id, current, now)
+	if !ok {
+		return nil, nil, false
+	}
+	previous := v.(keyHistory)
+
+	// Keys that were added are listed once each, in the order presented
+	listed := make(map[string]bool)
+	for _, r := range results {
+		fingerprint := r.key.Fingerprint()
+		if _, seen := previous.keys[fingerprint]; !seen && !listed[fingerprint] {
+			added = append(added, current.keys[fingerprint])
+			listed[fingerprint] = true
+		}
+	}
+	for fingerprint, description := range previous.keys {
+		if _, seen := current.keys[fingerprint]; !seen {
+			removed = append(removed, description)
+		}
+	}
+	sort.Strings(removed)
+
+	return added, removed, true
+}
+
+// describeChanges returns a line for each key added or removed. The keys
+// removed are only described to clients identified by their token, as the
+// previous session from an address may have been someone else's behind the
+// same NAT; otherwise only their number is given.
+func describeChanges(added, removed []string, byToken bool) []string {
+	var changes []string
+	switch {
+	case byToken:
+		for _, d := range removed {
+			changes = append(changes, "removed "+d)
+		}
+	case len(removed) == 1:
+		changes = append(changes, "removed a key")
+	case len(removed) > 1:
+		changes = append(changes, fmt.Sprintf("removed %d keys", len(removed)))
+	}
+	for _, d := range added {
+		changes = append(changes, "added "+d)
+	}
+
+	return changes
+}
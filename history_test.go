@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareWithPrevious(t *testing.T) {
+	c := useFakeClock(t)
+	defer func(window time.Duration) { compareWindow = window }(compareWindow)
+	compareWindow = time.Hour
+
+	first := analyzeKeys(generateKey(t, "ecdsa-256"), generateKey(t, "dsa-1024")).results
+	second := analyzeKeys(generateKey(t, "ecdsa-256"), generateKey(t, "ecdsa-384")).results
+
+	if _, _, ok := compareWithPrevious("client", first); ok {
+		t.Fatal("compared with a session that never happened")
+	}
+
+	c.Advance(time.Hour)
+	added, removed, ok := compareWithPrevious("client", second)
+	switch {
+	case !ok:
+		t.Fatal("not compared with the session compareWindow ago")
+	case len(added) != 1 || len(removed) != 1:
+		t.Fatalf("got added %q, removed %q, expected one of each", added, removed)
+	case added[0] != second[1].key.key.Type()+" 384 "+second[1].key.Fingerprint()+" ("+second[1].issue+")":
+		t.Errorf("got added %q, expected the ECDSA P-384 key", added[0])
+	case removed[0] != first[1].key.key.Type()+" 1024 "+first[1].key.Fingerprint()+" ("+first[1].issue+")":
+		t.Errorf("got removed %q, expected the DSA key", removed[0])
+	}
+
+	c.Advance(time.Hour + time.Second)
+	if _, _, ok := compareWithPrevious("client", second); ok {
+		t.Error("compared with a session longer than compareWindow ago")
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	for _, test := range []struct {
+		added, removed []string
+		byToken        bool
+		expected       []string
+	}{
+		{nil, nil, true, nil},
+		{[]string{"new"}, []string{"old"}, true, []string{"removed old", "added new"}},
+		{[]string{"new"}, []string{"old"}, false, []string{"removed a key", "added new"}},
+		{nil, []string{"old", "older"}, false, []string{"removed 2 keys"}},
+		{[]string{"new", "newer"}, nil, false, []string{"added new", "added newer"}},
+	} {
+		if changes := describeChanges(test.added, test.removed, test.byToken); !reflect.DeepEqual(changes, test.expected) {
+			t.Errorf("added %q, removed %q, by token %t: got %q, expected %q", test.added, test.removed, test.byToken, changes, test.expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// remediations tells users what to do about each issue, by its name in
+// severities, in the HTML report
+var remediations = map[string]string{
+	"wellknown":     "Anyone can log in with this key. Remove it from every authorized_keys file and generate a new key.",
+	"container":     "This key ships in a public container image, so anyone can use it. Remove it and generate a new key.",
+	"blacklisted":   "This key can be derived by anyone. Remove it from every authorized_keys file and generate a new key.",
+	"revoked":       "This key has been revoked by the server's operator. Stop using it and generate a new key.",
+	"collision":     "Keys of different types share a fingerprint, which suggests tampering. Check where these keys came from.",
+	"typemismatch":  "Your SSH agent declared a key as a different type than it is, which suggests tampering. Check your agent.",
+	"trivial":       "This key's modulus can be factored trivially, so it offers no security. Generate a new key.",
+	"factor":        "This key's modulus has a known factor, so its private key can be recovered. Generate a new key.",
+	"entropy":       "This key was generated with too little randomness. Generate a new key on a different machine.",
+	"sharedmodulus": "This key shares its modulus with another key, so either can be used to break the other. Generate new keys.",
+	"modulus":       "This key's modulus has been factored. Generate a new key.",
+	"dsa":           "DSA keys are no longer accepted by OpenSSH by default. Generate a new key, e.g. using ssh-keygen -t ed25519.",
+	"weak":          "This key is too short to resist attack. Generate a new key, e.g. using ssh-keygen -t ed25519.",
+	"curve":         "This key uses a curve that SSH software doesn't widely support. Generate a new key, e.g. using ssh-keygen -t ed25519.",
+	"mismatch":      "This key is shorter than its type suggests. Generate a new key.",
+	"encoding":      "This key isn't encoded the way SSH software usually encodes it, and some servers reject it. Export it again using ssh-keygen -y.",
+	"unparseable":   "This key couldn't be parsed, so couldn't be checked. Generate a new key.",
+	"agent":         "Anyone with access to this server, or any server you connect to, can use your keys. Set ForwardAgent no, and only forward your agent to hosts you trust.",
+	"x11":           "Any server you connect to can watch what you type on your display. Set ForwardX11 no.",
+}
+
+// htmlIssue describes an issue found, for the HTML report
+type htmlIssue struct {
+	Description, Remediation, URL, Severity string
+}
+
+// htmlKey describes a key presented, for the HTML report
+type htmlKey struct {
+	Index                                              int
+	Type, Bits, Fingerprint, Accepted, Issue, Severity string
+}
+
+// htmlTemplate is the HTML report. Every key-derived field is chosen by
+// the client, so is escaped by html/template, and nothing is loaded from
+// elsewhere, so the report can be saved and viewed offline.
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>SSH key check: {{.Verdict}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+code { font-size: 0.9em; }
+.critical { background: #fdd; }
+.warn { background: #ffd; }
+.notice { background: #eef; }
+.ok { background: #dfd; }
+.verdict { font-size: 1.5em; padding: 0.3em 0.6em; display: inline-block; }
+</style>
+</head>
+<body>
+<h1>SSH key check</h1>
+<p class="verdict {{.Class}}">{{.Verdict}}</p>
+<table>
+<tr><th>#</th><th>Type</th><th>Bits</th><th>SHA-256 fingerprint</th><th>Accepted by OpenSSH 9</th><th>Issue</th></tr>
+{{- range .Keys}}
+<tr class="{{.Severity}}"><td>{{.Index}}</td><td>{{.Type}}</td><td>{{.Bits}}</td><td><code>{{.Fingerprint}}</code></td><td>{{.Accepted}}</td><td>{{.Issue}}</td></tr>
+{{- end}}
+</table>
+{{- if .Issues}}
+<h2>What to do</h2>
+<ul>
+{{- range .Issues}}
+<li class="{{.Severity}}"><strong>{{.Description}}</strong>: {{.Remediation}}{{if .URL}} <a href="{{.URL}}">More information</a>{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeHTML writes the report as an HTML page, with a row for each key,
+// coloured by the severity of its issue, followed by what to do about each
+// issue found, named as in severities, most severe first
+func writeHTML(w io.Writer, a *analysis, found map[string]bool, verdict string) error {
+	page := struct {
+		Verdict, Class string
+		Keys           []htmlKey
+		Issues         []htmlIssue
+	}{Verdict: verdict, Class: strings.ToLower(verdict)}
+
+	for _, r := range a.results {
+		k := htmlKey{
+			Index:       r.index,
+			Type:        r.key.key.Type(),
+			Bits:        r.bits(),
+			Fingerprint: r.key.FingerprintSHA256(),
+			Accepted:    r.accepted,
+			Issue:       r.issue,
+		}
+		if name, ok := issueSeverities[r.issue]; ok {
+			k.Severity = severities[name].className()
+		}
+		page.Keys = append(page.Keys, k)
+	}
+
+	names := make([]string, 0, len(found))
+	for name, ok := range found {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if severities[names[i]] != severities[names[j]] {
+			return severities[names[i]] > severities[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		page.Issues = append(page.Issues, htmlIssue{
+			Description: sarifRules[name],
+			Remediation: remediations[name],
+			URL:         docURLs[name],
+			Severity:    severities[name].className(),
+		})
+	}
+
+	return htmlTemplate.Execute(w, page)
+}
+
+// className returns the class used to colour the severity in HTML
+func (s severity) className() string {
+	switch s {
+	case severityCritical:
+		return "critical"
+	case severityWarning:
+		return "warn"
+	}
+
+	return "notice"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Every issue that can be found needs advice on what to do about it
+func TestRemediationsComplete(t *testing.T) {
+	for name := range severities {
+		if remediations[name] == "" {
+			t.Errorf("no remediation for %s", name)
+		}
+	}
+}
+
+// hostileKey is a key whose type is chosen to inject markup into the report
+type hostileKey struct {
+	ssh.PublicKey
+}
+
+func (k hostileKey) Type() string { return `<script>alert("key")</script>` }
+
+func TestWriteHTML(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		keys     []ssh.PublicKey
+		found    map[string]bool
+		verdict  string
+		expected []string
+	}{
+		{
+			name:     "no issues",
+			keys:     []ssh.PublicKey{generateKey(t, "ecdsa-256")},
+			verdict:  "OK",
+			expected: []string{`<p class="verdict ok">OK</p>`, "ecdsa-sha2-nistp256"},
+		},
+		{
+			name:    "issues listed by severity",
+			keys:    []ssh.PublicKey{generateKey(t, "dsa-1024"), generateKey(t, "ecdsa-256")},
+			found:   map[string]bool{"dsa": true, "agent": true, "weak": false},
+			verdict: "CRITICAL",
+			expected: []string{
+				`<p class="verdict critical">CRITICAL</p>`,
+				`<tr class="warn"><td>1</td><td>ssh-dss</td><td>1024</td>`,
+				"<tr class=\"\"><td>2</td>",
+				`<li class="critical"><strong>SSH agent forwarding enabled</strong>`,
+				`<li class="warn"><strong>DSA key</strong>`,
+				`<a href="https://www.openssh.com/txt/release-7.0">`,
+			},
+		},
+		{
+			name:     "key-derived fields are escaped",
+			keys:     []ssh.PublicKey{hostileKey{generateKey(t, "ecdsa-256")}},
+			verdict:  "OK",
+			expected: []string{"&lt;script&gt;alert(&#34;key&#34;)&lt;/script&gt;"},
+		},
+	} {
+		var keys []*publicKey
+		for _, k := range test.keys {
+			keys = append(keys, &publicKey{key: k})
+		}
+		var b bytes.Buffer
+		if err := writeHTML(&b, analyze(testLogger, keys, nil, nil), test.found, test.verdict); err != nil {
+			t.Fatal(err)
+		}
+
+		page := b.String()
+		for _, s := range test.expected {
+			if !strings.Contains(page, s) {
+				t.Errorf("%s: expected %s in:\n%s", test.name, s, page)
+			}
+		}
+		if strings.Contains(page, "<script>") || strings.Contains(page, "\r") {
+			t.Errorf("%s: got unescaped markup or carriage returns:\n%s", test.name, page)
+		}
+		if strings.Index(page, "agent forwarding") > strings.Index(page, "DSA key") {
+			t.Errorf("%s: issues not listed most severe first", test.name)
+		}
+	}
+}
+
+// The report given to the html user is a web page
+func TestHTMLReport(t *testing.T) {
+	page := testReport(t, "html", testWeakSigner(t))
+	for _, s := range []string{"<!DOCTYPE html>", `<p class="verdict warn">WARN</p>`, "RSA key shorter than 2048 bits"} {
+		if !strings.Contains(page, s) {
+			t.Errorf("expected %s in:\n%s", s, page)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import "strings"
+
+// translations maps each language the report can be shown in, other than
+// English, to its translations of the report's messages, keyed by the
+// English message. Messages that haven't been translated are shown in
+// English. Severity labels are kept in English, so that they line up and
+// stay recognisable however the report is shown.
+var translations = map[string]map[string]string{
+	"de": {
+		actionsMsg: strings.Replace(`Empfohlene Maßnahmen:
+%s
+
+`, "\n", "\n\r", -1),
+		agentMsg: strings.Replace(`CRITICAL: SSH-Agent-Weiterleitung ist aktiviert; es ist gefährlich, sie für
+          Server zu aktivieren, denen Sie nicht vertrauen, da diese sich damit
+          in Ihrem Namen bei anderen Servern anmelden können.
+
+`, "\n", "\n\r", -1),
+		dsaMsg: strings.Replace(`WARNING:  Sie verwenden DSA-Schlüssel (ssh-dss), die ab OpenSSH 7.0
+          standardmäßig nicht mehr unterstützt werden.
+          Ersetzen Sie sie am besten durch einen neuen RSA- oder ECDSA-Schlüssel.
+
+`, "\n", "\n\r", -1),
+		footerMsg: strings.Replace(`Fragen? Siehe https://github.com/mattbostock/sshkeycheck/issues
+
+`, "\n", "\n\r", -1),
+		praiseMsg: strings.Replace(`NOTE:     Ihre SSH-Schlüssel entsprechen den aktuellen Empfehlungen. Gut gemacht!
+
+`, "\n", "\n\r", -1),
+		progressMsg: "Ihre Schlüssel werden geprüft...",
+		weakMsg: strings.Replace(`WARNING:  Sie verwenden RSA-Schlüssel mit einer Länge von weniger als 2048 Bit.
+          Ersetzen Sie sie am besten durch einen neuen Schlüssel mit mindestens
+          2048 Bit.
+
+`, "\n", "\n\r", -1),
+		welcomeMsg: strings.Replace(`Dieser Server prüft Ihre öffentlichen SSH-Schlüssel auf bekannte oder
+mögliche Sicherheitsschwächen.
+
+Weitere Informationen finden Sie unter:
+https://github.com/mattbostock/sshkeycheck
+
+Ihr SSH-Client hat folgende öffentliche Schlüssel vorgelegt:
+
+`, "\n", "\n\r", -1),
+		x11Msg: strings.Replace(`CRITICAL: X11-Weiterleitung ist aktiviert; es ist gefährlich, sie für Server
+          zu erlauben, denen Sie nicht vertrauen, da diese damit auf Ihren
+          Desktop zugreifen können.
+
+`, "\n", "\n\r", -1),
+	},
+	"fr": {
+		actionsMsg: strings.Replace(`Actions recommandées :
+%s
+
+`, "\n", "\n\r", -1),
+		agentMsg: strings.Replace(`CRITICAL: Le transfert d'agent SSH est activé ; il est dangereux de l'activer
+          pour des serveurs auxquels vous ne faites pas confiance, car cela leur
+          permet de se connecter à d'autres serveurs en votre nom.
+
+`, "\n", "\n\r", -1),
+		dsaMsg: strings.Replace(`WARNING:  Vous utilisez des clés DSA (ssh-dss), qui ne sont plus prises en
+          charge par défaut depuis la version 7.0 d'OpenSSH.
+          Envisagez de les remplacer par une nouvelle clé RSA ou ECDSA.
+
+`, "\n", "\n\r", -1),
+		footerMsg: strings.Replace(`Des questions ? Consultez https://github.com/mattbostock/sshkeycheck/issues
+
+`, "\n", "\n\r", -1),
+		praiseMsg: strings.Replace(`NOTE:     Vos clés SSH suivent les bonnes pratiques actuelles. Bravo !
+
+`, "\n", "\n\r", -1),
+		progressMsg: "Vérification de vos clés...",
+		weakMsg: strings.Replace(`WARNING:  Vous utilisez des clés RSA d'une longueur inférieure à 2048 bits.
+          Envisagez de les remplacer par une nouvelle clé d'au moins 2048 bits.
+
+`, "\n", "\n\r", -1),
+		welcomeMsg: strings.Replace(`Ce serveur vérifie vos clés publiques SSH à la recherche de faiblesses de
+sécurité connues ou potentielles.
+
+Pour plus d'informations, consultez :
+https://github.com/mattbostock/sshkeycheck
+
+Les clés publiques présentées par votre client SSH sont :
+
+`, "\n", "\n\r", -1),
+		x11Msg: strings.Replace(`CRITICAL: Le transfert X11 est activé ; il est dangereux de l'autoriser pour
+          des serveurs auxquels vous ne faites pas confiance, car cela leur
+          permet d'accéder à votre bureau.
+
+`, "\n", "\n\r", -1),
+	},
+}
+
+// language returns the supported language named by a locale such as
+// "fr_FR.UTF-8", as sent by clients in LANG, or the empty string for
+// English or any language that isn't supported
+func language(locale string) string {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+
+	if _, ok := translations[lang]; !ok {
+		return ""
+	}
+
+	return lang
+}
+
+// splitLanguage separates the language suffix, if any, from a username such
+// as "verbose+fr", returning the username without it and the language
+func splitLanguage(user string) (string, string) {
+	i := strings.LastIndex(user, "+")
+	if i < 0 {
+		return user, ""
+	}
+
+	lang := language(user[i+1:])
+	if lang == "" {
+		return user, ""
+	}
+
+	return user[:i], lang
+}
+
+// translator returns a function that translates messages into the given
+// language, falling back to English
+func translator(lang string) func(msg string) string {
+	return func(msg string) string {
+		if t, ok := translations[lang][msg]; ok {
+			return t
+		}
+
+		return msg
+	}
+}
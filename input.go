@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// lineReader reads the lines typed by the user during a session. Reads
+// from a channel can't be cancelled, so a read whose caller stopped waiting
+// is left for the next caller to finish, rather than taking the line typed
+// for it.
+type lineReader struct {
+	term    *terminal.Terminal // nil unless the client asked for a terminal
+	buf     *bufio.Reader
+	pending chan lineRead
+}
+
+// lineRead is the result of reading a line
+type lineRead struct {
+	line string
+	err  error
+}
+
+// newLineReader returns a reader for the lines typed on the channel, which
+// are edited and echoed by a terminal if the client asked for one
+func newLineReader(channel ssh.Channel, pty bool) *lineReader {
+	r := &lineReader{}
+	if pty {
+		r.term = terminal.NewTerminal(channel, "")
+	} else {
+		r.buf = bufio.NewReader(channel)
+	}
+
+	return r
+}
+
+// readLine returns the next line, showing the prompt first if a terminal
+// is in use and no read is already pending. It returns false if timeout
+// fires first, leaving the read pending; a nil timeout never fires.
+func (r *lineReader) readLine(prompt string, timeout <-chan time.Time) (string, bool, error) {
+	if r.pending == nil {
+		r.pending = make(chan lineRead, 1)
+		go func(result chan<- lineRead) {
+			var line string
+			var err error
+			if r.term != nil {
+				r.term.SetPrompt(prompt)
+				line, err = r.term.ReadLine()
+			} else {
+				line, err = r.buf.ReadString('\n')
+			}
+			result <- lineRead{line, err}
+		}(r.pending)
+	}
+
+	select {
+	case read := <-r.pending:
+		r.pending = nil
+		return read.line, true, read.err
+	case <-timeout:
+		return "", false, nil
+	}
+}
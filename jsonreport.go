@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// jsonCommand is the command clients run to be sent the report as JSON,
+// e.g. using `ssh keycheck.mattbostock.com report --json`
+const jsonCommand = "report --json"
+
+// jsonReport is the report sent in reply to jsonCommand
+type jsonReport struct {
+	Keys            []jsonKey `json:"keys"`
+	AgentForwarding bool      `json:"agent_forwarding_requested"`
+	X11Forwarding   bool      `json:"x11_requested"`
+	Verdict         string    `json:"verdict"`
+}
+
+// jsonKey describes a key presented by the client. Bits is null if the
+// key's length is unknown, and issues lists every issue found with the key
+// by its name in jsonIssues. PublicKey is only set if JSON_PUBLIC_KEYS is.
+type jsonKey struct {
+	Type              string   `json:"type"`
+	Bits              *int     `json:"bits"`
+	Fingerprint       string   `json:"fingerprint"`
+	FingerprintSHA256 string   `json:"fingerprint_sha256"`
+	PublicKey         string   `json:"public_key,omitempty"`
+	Issues            []string `json:"issues"`
+}
+
+// jsonIssues maps the names of issues in severities to the identifiers
+// used in JSON reports, which match keycheck's names where it has the same
+// issue
+var jsonIssues = map[string]string{
+	"wellknown":     "well_known",
+	"container":     "container_image",
+	"blacklisted":   "blacklisted",
+	"revoked":       "revoked",
+	"collision":     "fingerprint_collision",
+	"typemismatch":  "type_mismatch",
+	"trivial":       string(keycheck.TrivialModulus),
+	"factor":        "known_factor",
+	"entropy":       "low_entropy",
+	"sharedmodulus": "shared_modulus",
+	"modulus":       "weak_modulus",
+	"dsa":           string(keycheck.DSA),
+	"weak":          string(keycheck.WeakLength),
+	"curve":         string(keycheck.WeakCurve),
+	"mismatch":      string(keycheck.SizeMismatch),
+	"encoding":      "non_canonical_encoding",
+	"unparseable":   string(keycheck.Unparseable),
+}
+
+// jsonIssueNames returns the JSON identifiers of the issues found with the
+// key, in the order found, listing each once
+func jsonIssueNames(r keyResult) []string {
+	names := []string{}
+	if r.issue == issueExempt {
+		return names
+	}
+
+	listed := make(map[string]bool)
+	for _, issue := range r.all {
+		name, ok := jsonIssues[issueSeverities[issue]]
+		if ok && !listed[name] {
+			names = append(names, name)
+			listed[name] = true
+		}
+	}
+
+	return names
+}
+
+// writeJSON writes the report as a JSON document, with the keys in the
+// order presented. Exempt keys are listed without their issues.
+func writeJSON(w io.Writer, a *analysis, agentFwd, x11 bool, verdict string) error {
+	report := jsonReport{
+		Keys:            []jsonKey{},
+		AgentForwarding: agentFwd,
+		X11Forwarding:   x11,
+		Verdict:         verdict,
+	}
+	for _, r := range a.results {
+		k := jsonKey{
+			Type:              r.key.key.Type(),
+			Fingerprint:       r.key.Fingerprint(),
+			FingerprintSHA256: r.key.FingerprintSHA256(),
+			Issues:            jsonIssueNames(r),
+		}
+		if r.key.parseErr == nil {
+			length := r.length
+			k.Bits = &length
+		}
+		if jsonPublicKeys {
+			k.PublicKey = strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(r.key.key)), "\n")
+		}
+		report.Keys = append(report.Keys, k)
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Every issue that can be found with a key needs an identifier in JSON
+func TestJSONIssuesComplete(t *testing.T) {
+	for issue, name := range issueSeverities {
+		if _, ok := jsonIssues[name]; !ok {
+			t.Errorf("no JSON identifier for %s (%s)", issue, name)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		result   keyResult
+		expected []string
+	}{
+		{"no issues", keyResult{issue: issueNone}, []string{}},
+		{"one issue", keyResult{issue: issueDSA, all: []string{issueDSA}}, []string{"dsa"}},
+		{
+			"every issue listed once",
+			keyResult{issue: issueBlacklisted, all: []string{issueMismatch, issueWeak, issueWeakSHA1, issueBlacklisted}},
+			[]string{"size_mismatch", "weak_length", "blacklisted"},
+		},
+		{"exempt", keyResult{issue: issueExempt, all: []string{issueDSA}}, []string{}},
+	} {
+		test.result.key = &publicKey{key: generateKey(t, "ecdsa-256")}
+
+		var b bytes.Buffer
+		if err := writeJSON(&b, &analysis{results: []keyResult{test.result}}, false, false, "OK"); err != nil {
+			t.Fatal(err)
+		}
+		var report jsonReport
+		if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if issues := report.Keys[0].Issues; !reflect.DeepEqual(issues, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, issues, test.expected)
+		}
+	}
+}
+
+// Keys with several issues have them all listed, not just the one shown in
+// the table
+func TestAnalyzeAllIssues(t *testing.T) {
+	k := &publicKey{key: generateKey(t, "rsa-1024"), blacklisted: true, blacklistSource: "test"}
+	r := analyze(testLogger, []*publicKey{k}, nil, nil).results[0]
+	if expected := []string{issueWeak, issueBlacklisted}; r.issue != issueBlacklisted || !reflect.DeepEqual(r.all, expected) {
+		t.Errorf("got %q, %q, expected %q, %q", r.issue, r.all, issueBlacklisted, expected)
+	}
+}
+
+// Keys are only given in authorized_keys format if JSON_PUBLIC_KEYS is set
+func TestWriteJSONPublicKeys(t *testing.T) {
+	defer func(include bool) { jsonPublicKeys = include }(jsonPublicKeys)
+	key := generateKey(t, "ecdsa-256")
+	expected := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(key)), "\n")
+
+	for _, include := range []bool{false, true} {
+		jsonPublicKeys = include
+		var b bytes.Buffer
+		if err := writeJSON(&b, analyzeKeys(key), false, false, "OK"); err != nil {
+			t.Fatal(err)
+		}
+		var report jsonReport
+		if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+
+		got := report.Keys[0].PublicKey
+		if include && got != expected || !include && (got != "" || strings.Contains(b.String(), "public_key")) {
+			t.Errorf("JSON_PUBLIC_KEYS=%t: got %q", include, got)
+			continue
+		}
+		if include {
+			if parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(got)); err != nil || !bytes.Equal(parsed.Marshal(), key.Marshal()) {
+				t.Errorf("public key doesn't parse back to the key presented: %v", err)
+			}
+		}
+	}
+}
+
+// Forwarding requested before the command is run is included in the report
+func TestJSONForwardingRequested(t *testing.T) {
+	addr := startTestServer(t)
+	x11Req := ssh.Marshal(struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{false, "MIT-MAGIC-COOKIE-1", "00", 0})
+
+	for _, test := range []struct {
+		agent, x11 bool
+	}{
+		{false, false},
+		{true, false},
+		{false, true},
+		{true, true},
+	} {
+		channel, reqs, err := testClient(t, addr, "json", testSigner(t)).OpenChannel("session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(reqs)
+
+		// Waiting for each reply ensures the server has seen the request
+		if test.agent {
+			channel.SendRequest("auth-agent-req@openssh.com", true, nil)
+		}
+		if test.x11 {
+			channel.SendRequest("x11-req", true, x11Req)
+		}
+		channel.SendRequest("exec", true, ssh.Marshal(struct{ Command string }{jsonCommand}))
+
+		var report jsonReport
+		if err := json.Unmarshal([]byte(<-readReport(channel)), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.AgentForwarding != test.agent || report.X11Forwarding != test.x11 {
+			t.Errorf("requested agent %t, X11 %t: got %t, %t", test.agent, test.x11, report.AgentForwarding, report.X11Forwarding)
+		}
+	}
+}
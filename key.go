@@ -1,107 +1,213 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"bytes"
+	"crypto/hmac"
 	"crypto/md5"
-	"errors"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"math/big"
+	"regexp"
 	"strings"
 
+	"github.com/mattbostock/sshkeycheck/keycheck"
 	"golang.org/x/crypto/ssh"
 )
 
+// keyAlgoED25519 isn't defined by the ssh package, which doesn't yet support
+// Ed25519 keys
+const keyAlgoED25519 = "ssh-ed25519"
+
 type publicKey struct {
-	key         ssh.PublicKey
-	blacklisted bool
+	key             ssh.PublicKey
+	blacklisted     bool
+	blacklistSource string
+
+	// blacklistDebian is set if the key is one generated by Debian's
+	// broken OpenSSL package, and blacklistLocal if it's only listed in
+	// BLACKLIST_FILE
+	blacklistDebian, blacklistLocal bool
+
+	// parseErr is set if the key's parameters couldn't be parsed, in which
+	// case its length is unknown
+	parseErr error
+
+	// declaredType is the type the key was declared as, if that isn't its
+	// actual type. Only keys listed by a forwarded agent are declared
+	// apart from their parameters, as the ssh package only passes the
+	// parsed key to PublicKeyCallback.
+	declaredType string
+
+	// nonCanonical is set if the key wasn't encoded the way the ssh
+	// package encodes it, e.g. with a number padded with a zero byte it
+	// doesn't need. As with declaredType, this is only known for keys
+	// listed by a forwarded agent.
+	nonCanonical bool
 }
 
+// BitLen returns the length of the key, or of the key a certificate
+// certifies
 func (p *publicKey) BitLen() (int, error) {
-	var (
-		length int
-		err    error
-	)
+	return keycheck.BitLen(p.key)
+}
 
-	switch p.key.Type() {
-	case ssh.KeyAlgoRSA:
-		length, err = rsaKeyLength(p.key)
-	case ssh.KeyAlgoDSA:
-		length, err = dsaKeyLength(p.key)
+// Modern reports whether the key, or the key a certificate certifies, uses
+// a modern algorithm, i.e. ECDSA on a NIST curve of at least 256 bits.
+// Ed25519 keys aren't counted, as the ssh package can't parse them, so
+// they're refused before reaching the checks.
+func (p *publicKey) Modern() bool {
+	switch certifiedType(p) {
 	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
-		length, err = ecdsaKeyLength(p.key)
-	default:
-		err = errors.New("Key type not supported: " + p.key.Type())
+		return true
 	}
 
-	return length, err
+	return false
 }
 
-func (p *publicKey) Fingerprint() string {
-	return md5HexString(md5.Sum(p.key.Marshal()))
+// ECDSACurve returns the name of the curve the key is on, as given by the key
+// itself, or false if it isn't an ECDSA key
+func (p *publicKey) ECDSACurve() (string, bool) {
+	return keycheck.Curve(p.key)
 }
 
-func rsaKeyLength(key ssh.PublicKey) (int, error) {
-	var w struct {
-		Name string
-		E    *big.Int
-		N    *big.Int
-		Rest []byte `ssh:"rest"`
-	}
-
-	err := ssh.Unmarshal(key.Marshal(), &w)
+// RSAEquivalentBits estimates the length of an RSA key that would offer
+// comparable security to this key, per NIST SP 800-57 Part 1
+func (p *publicKey) RSAEquivalentBits() (int, error) {
+	length, err := p.BitLen()
 	if err != nil {
 		return 0, err
 	}
 
-	return w.N.BitLen(), nil
+	switch certifiedType(p) {
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		switch {
+		case length >= 512:
+			return 15360, nil
+		case length >= 384:
+			return 7680, nil
+		default:
+			return 3072, nil
+		}
+	}
+
+	return length, nil
 }
 
-func dsaKeyLength(key ssh.PublicKey) (int, error) {
-	var w struct {
-		Name       string
-		P, Q, G, Y *big.Int
-		Rest       []byte `ssh:"rest"`
+// finiteFieldStrengths maps the length of RSA and DSA keys to the security
+// strength they offer in bits, per NIST SP 800-57 Part 1 table 2, longest
+// first; shorter keys offer less than 80 bits
+var finiteFieldStrengths = []struct{ length, strength int }{
+	{15360, 256},
+	{7680, 192},
+	{3072, 128},
+	{2048, 112},
+	{1024, 80},
+}
+
+// curveStrengths maps each key type using an elliptic curve to the security
+// strength it offers in bits, per the same table
+var curveStrengths = map[string]int{
+	ssh.KeyAlgoECDSA256: 128,
+	ssh.KeyAlgoECDSA384: 192,
+	ssh.KeyAlgoECDSA521: 256,
+	keyAlgoED25519:      128,
+}
+
+// SecurityStrength estimates the security strength of the key in bits, i.e.
+// the length of a symmetric key that would take as much work to break, or
+// zero if it offers less than 80 bits
+func (p *publicKey) SecurityStrength() (int, error) {
+	if cert, ok := p.key.(*ssh.Certificate); ok {
+		return (&publicKey{key: cert.Key}).SecurityStrength()
+	}
+
+	if strength, ok := curveStrengths[p.key.Type()]; ok {
+		return strength, nil
 	}
-	err := ssh.Unmarshal(key.Marshal(), &w)
+
+	length, err := p.BitLen()
 	if err != nil {
 		return 0, err
 	}
 
-	return w.P.BitLen(), nil
+	for _, s := range finiteFieldStrengths {
+		if length >= s.length {
+			return s.strength, nil
+		}
+	}
+
+	return 0, nil
 }
 
-func ecdsaKeyLength(key ssh.PublicKey) (int, error) {
-	var w struct {
-		Name     string
-		Curve    string
-		KeyBytes []byte
-		Rest     []byte `ssh:"rest"`
-	}
+// ClaimedBitLen returns the key length implied by the key's type; see
+// keycheck.ClaimedBitLen
+func (p *publicKey) ClaimedBitLen() (int, error) {
+	return keycheck.ClaimedBitLen(p.key)
+}
 
-	err := ssh.Unmarshal(key.Marshal(), &w)
-	if err != nil {
-		return 0, err
+func (p *publicKey) Fingerprint() string {
+	return md5HexString(md5.Sum(p.key.Marshal()))
+}
+
+// FingerprintSHA256 returns the key's SHA-256 fingerprint in the format used
+// by OpenSSH 6.8 and above
+func (p *publicKey) FingerprintSHA256() string {
+	sum := sha256.Sum256(p.key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// MatchesFingerprint reports whether the key has the given fingerprint,
+// which may be either an MD5 or SHA-256 fingerprint, optionally prefixed
+// with its hash algorithm as shown by `ssh-keygen -l -E md5`
+func (p *publicKey) MatchesFingerprint(fingerprint string) bool {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return strings.TrimRight(fingerprint, "=") == p.FingerprintSHA256()
 	}
 
-	k := new(ecdsa.PublicKey)
-	switch w.Curve {
-	case "nistp256":
-		k.Curve = elliptic.P256()
-	case "nistp384":
-		k.Curve = elliptic.P384()
-	case "nistp521":
-		k.Curve = elliptic.P521()
-	default:
-		return 0, fmt.Errorf("ECSDA curve not supported: %q", w.Curve)
+	fingerprint = strings.ToLower(fingerprint)
+	return strings.TrimPrefix(fingerprint, "md5:") == p.Fingerprint()
+}
+
+// logKey is used to hash fingerprints before logging them. It is chosen at
+// random on startup, as anyone holding a public key could otherwise find its
+// entries in the logs.
+var logKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
+	return b
+}()
 
-	k.X, k.Y = elliptic.Unmarshal(k.Curve, w.KeyBytes)
-	if k.X == nil || k.Y == nil {
-		return 0, fmt.Errorf("ECDSA X or Y points were nil: %q, %q", k.X, k.Y)
+// LogFingerprint returns the key's fingerprint in the form configured for
+// logging, which may be truncated or hashed to protect users' privacy
+func (p *publicKey) LogFingerprint() string {
+	switch logFingerprints {
+	case "truncate":
+		return p.Fingerprint()[:11] + ":..."
+	case "hash":
+		return p.HashedFingerprint()
 	}
 
-	return k.Params().BitSize, nil
+	return p.Fingerprint()
+}
+
+// HashedFingerprint returns an HMAC of the key using logKey, which
+// identifies it in the logs and metrics of this process alone
+func (p *publicKey) HashedFingerprint() string {
+	mac := hmac.New(sha256.New, logKey)
+	mac.Write(p.key.Marshal())
+	return "HMAC:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// FingerprintBabble returns the bubblebabble encoding of the key's SHA-1
+// digest, as shown by `ssh-keygen -B`
+func (p *publicKey) FingerprintBabble() string {
+	digest := sha1.Sum(p.key.Marshal())
+	return bubblebabble(digest[:])
 }
 
 // md5HexString returns a formatted string representing the given md5 sum in hex
@@ -110,3 +216,141 @@ func md5HexString(md5 [16]byte) (s string) {
 	s = strings.Replace(s, " ", ":", -1)
 	return s
 }
+
+// bubblebabble encodes the given digest using Antti Huima's Bubble Babble
+// encoding, as implemented by OpenSSH
+func bubblebabble(digest []byte) string {
+	const (
+		vowels     = "aeiouy"
+		consonants = "bcdfghklmnprstvzx"
+	)
+
+	seed := 1
+	rounds := len(digest)/2 + 1
+	s := []byte{'x'}
+
+	for i := 0; i < rounds; i++ {
+		if i+1 < rounds || len(digest)%2 != 0 {
+			b1 := int(digest[2*i])
+			s = append(s,
+				vowels[(((b1>>6)&3)+seed)%6],
+				consonants[(b1>>2)&15],
+				vowels[((b1&3)+seed/6)%6])
+
+			if i+1 < rounds {
+				b2 := int(digest[2*i+1])
+				s = append(s,
+					consonants[(b2>>4)&15],
+					'-',
+					consonants[b2&15])
+				seed = (seed*5 + b1*7 + b2) % 36
+			}
+		} else {
+			s = append(s,
+				vowels[seed%6],
+				consonants[16],
+				vowels[seed/6])
+		}
+	}
+
+	return string(append(s, 'x'))
+}
+
+// Randomart returns the key's "drunken bishop" visualisation, as shown by
+// `ssh-keygen -lv`
+func (p *publicKey) Randomart() string {
+	const (
+		width   = 17
+		height  = 9
+		symbols = " .o+=*BOX@%&#/^SE"
+	)
+	end := len(symbols) - 1
+
+	var field [width][height]int
+	x, y := width/2, height/2
+
+	digest := sha256.Sum256(p.key.Marshal())
+	for _, b := range digest {
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+
+			x = clamp(x, 0, width-1)
+			y = clamp(y, 0, height-1)
+			if field[x][y] < end-2 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[width/2][height/2] = end - 1
+	field[x][y] = end
+
+	var title string
+	switch p.key.Type() {
+	case ssh.KeyAlgoRSA:
+		title = "RSA"
+	case ssh.KeyAlgoDSA:
+		title = "DSA"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		title = "ECDSA"
+	case keyAlgoED25519:
+		title = "ED25519"
+	default:
+		title = p.key.Type()
+	}
+	if length, err := p.BitLen(); err == nil {
+		title = fmt.Sprintf("%s %d", title, length)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(randomartBorder("["+title+"]", width) + "\n")
+	for y := 0; y < height; y++ {
+		b.WriteByte('|')
+		for x := 0; x < width; x++ {
+			b.WriteByte(symbols[field[x][y]])
+		}
+		b.WriteString("|\n")
+	}
+	b.WriteString(randomartBorder("[SHA256]", width) + "\n")
+
+	return b.String()
+}
+
+func clamp(n, lower, upper int) int {
+	switch {
+	case n < lower:
+		return lower
+	case n > upper:
+		return upper
+	}
+
+	return n
+}
+
+// randomartBorder returns the top or bottom border of a randomart picture,
+// with the given label centred in it
+func randomartBorder(label string, width int) string {
+	if len(label) > width {
+		label = ""
+	}
+	left := (width - len(label)) / 2
+	right := width - left - len(label)
+
+	return "+" + strings.Repeat("-", left) + label + strings.Repeat("-", right) + "+"
+}
+
+var md5Fingerprint = regexp.MustCompile(`^(?i:md5:)?[[:xdigit:]]{2}(:[[:xdigit:]]{2}){15}$`)
+
+// isFingerprint reports whether s looks like an MD5 or SHA-256 fingerprint
+func isFingerprint(s string) bool {
+	return strings.HasPrefix(s, "SHA256:") || md5Fingerprint.MatchString(s)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestBubblebabble(t *testing.T) {
+	// The examples given in the Bubble Babble specification
+	for _, test := range []struct {
+		digest, expected string
+	}{
+		{"", "xexax"},
+		{"1234567890", "xesef-disof-gytuf-katof-movif-baxux"},
+		{"Pineapple", "xigak-nyryk-humil-bosek-sonax"},
+	} {
+		if got := bubblebabble([]byte(test.digest)); got != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.digest, got, test.expected)
+		}
+	}
+}
+
+// Fingerprints match those shown by `ssh-keygen -B`
+func TestFingerprintBabble(t *testing.T) {
+	_, key := debianKey(t)
+	expected := "xikaz-fomem-lapuz-nifyb-pisin-nezoz-fuhim-redyp-zimob-dolof-voxux"
+	if got := (&publicKey{key: key}).FingerprintBabble(); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestSecurityStrength(t *testing.T) {
+	ecdsaKey := generateKey(t, "ecdsa-384")
+	rsaKey := generateKey(t, "rsa-2048")
+
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		expected string
+	}{
+		{"rsa-768", shortRSAKey(t, 768), "<80"},
+		{"rsa-1024", generateKey(t, "rsa-1024"), "80"},
+		{"rsa-2047", shortRSAKey(t, 2047), "80"},
+		{"rsa-2048", rsaKey, "112"},
+		{"rsa-3072", generateKey(t, "rsa-3072"), "128"},
+		{"rsa-4096", generateKey(t, "rsa-4096"), "128"},
+		{"rsa-7680", shortRSAKey(t, 7680), "192"},
+		{"rsa-15360", shortRSAKey(t, 15360), "256"},
+		{"dsa-1024", generateKey(t, "dsa-1024"), "80"},
+		{"ecdsa-256", generateKey(t, "ecdsa-256"), "128"},
+		{"ecdsa-384", ecdsaKey, "192"},
+		{"ed25519", ed25519Key(make([]byte, 32)), "128"},
+		// Certificates are as strong as the key they certify, not their CA
+		{"certificate", testCertificate(t, testSigner(t), ecdsaKey, 1, ""), "192"},
+		{"unparseable", malformedKey{rsaKey, rsaKey.Marshal()[:20]}, "?"},
+	} {
+		if got := (keyResult{key: &publicKey{key: test.key}}).strength(); got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package keycheck_test
+
+import (
+	"fmt"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+)
+
+func ExampleAnalyzeAuthorizedKeys() {
+	authorizedKeys := []byte(`# Added in 2012
+ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDG4lc+OXMzZQA+0c3HHd1rHqIx3fQ/G3AA4gkFjbIIcuFQzBuD5CiPCXuojIIsugZXX+Z0EXbuXMCW2UES7vO0Pn0Bp9wQ0SHoiBvAZCLRz3lSjxeIDJrRzeEFa+pGWeaTfzbd/BKshxdUSpzRs+CiCTo1579gc0nCGdhk/zX28Q== old laptop
+`)
+
+	results, err := keycheck.AnalyzeAuthorizedKeys(authorizedKeys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, r := range results {
+		fmt.Println(r.Key.Type(), r.Bits, r.Has(keycheck.WeakLength))
+	}
+	// Output: ssh-rsa 1024 true
+}
@@ -0,0 +1,332 @@
+// Package keycheck checks SSH public keys for weaknesses that can be found
+// from the key alone:
+//
+//   - RSA keys shorter than MinRSABits
+//   - DSA (ssh-dss) keys, which OpenSSH no longer supports by default
+//   - ECDSA keys on curves other than the NIST curves of at least 256 bits
+//   - keys that are shorter than their type suggests, e.g. 2047-bit RSA keys
+//   - RSA moduli that are trivially factorable, e.g. because they are even
+//
+// These are the checks the sshkeycheck server makes of every key. The
+// server also checks keys against lists it loads from its configuration,
+// such as the blacklist of keys generated by Debian's broken OpenSSL
+// package, key revocation lists and well-known keys, which aren't covered
+// here.
+package keycheck
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MinRSABits is the length of the shortest RSA key that isn't weak
+const MinRSABits = 2048
+
+// Issue identifies a weakness found in a key
+type Issue string
+
+// The issues found by Analyze
+const (
+	// Unparseable keys' parameters couldn't be parsed, so nothing more
+	// is known about their strength
+	Unparseable Issue = "unparseable"
+
+	// DSA keys are limited to 1024 bits by FIPS 186-2, and OpenSSH no
+	// longer supports them by default
+	DSA Issue = "dsa"
+
+	// WeakLength RSA keys are shorter than MinRSABits
+	WeakLength Issue = "weak_length"
+
+	// WeakCurve ECDSA keys are on a curve that isn't accepted
+	WeakCurve Issue = "weak_curve"
+
+	// SizeMismatch keys are shorter than their type suggests
+	SizeMismatch Issue = "size_mismatch"
+
+	// TrivialModulus RSA keys have a modulus that can be factored trivially
+	TrivialModulus Issue = "trivial_modulus"
+)
+
+// Finding is an issue found in a key, with its details if there are any,
+// such as which curve an ECDSA key is on
+type Finding struct {
+	Issue  Issue
+	Detail string
+}
+
+// Result is the outcome of checking a key
+type Result struct {
+	Key ssh.PublicKey
+
+	// Bits is the key's length, or zero if it couldn't be parsed
+	Bits int
+
+	// Findings lists the issues found, if any, in the order checked
+	Findings []Finding
+}
+
+// Has reports whether the issue was found in the key
+func (r Result) Has(issue Issue) bool {
+	for _, f := range r.Findings {
+		if f.Issue == issue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Analyze checks each of the keys, returning their results in the same order
+func Analyze(keys []ssh.PublicKey) []Result {
+	results := make([]Result, len(keys))
+	for i, k := range keys {
+		results[i] = Check(k)
+	}
+
+	return results
+}
+
+// AnalyzeAuthorizedKeys checks each of the keys in data, which is in the
+// format of an OpenSSH authorized_keys file. Blank lines and comments are
+// skipped. It fails if any other line doesn't hold a key.
+func AnalyzeAuthorizedKeys(data []byte) ([]Result, error) {
+	var keys []ssh.PublicKey
+	for line, entry := range bytes.Split(data, []byte("\n")) {
+		entry = bytes.TrimSpace(entry)
+		if len(entry) == 0 || entry[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line+1, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return Analyze(keys), nil
+}
+
+// Check checks a single key
+func Check(key ssh.PublicKey) Result {
+	var r Result
+	r.Key = key
+
+	// A certificate is as strong as the key it certifies
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	length, err := BitLen(key)
+	if err == nil {
+		r.Bits = length
+	}
+
+	if claimed, err := ClaimedBitLen(key); err == nil && claimed != length {
+		r.Findings = append(r.Findings, Finding{SizeMismatch, fmt.Sprintf("claims to be %d bits", claimed)})
+	}
+
+	if key.Type() == ssh.KeyAlgoDSA {
+		r.Findings = append(r.Findings, Finding{Issue: DSA})
+	}
+
+	curve, isECDSA := Curve(key)
+	switch {
+	case isECDSA && !AcceptedCurve(curve):
+		r.Findings = append(r.Findings, Finding{WeakCurve, curve})
+	case err != nil:
+		r.Findings = append(r.Findings, Finding{Unparseable, err.Error()})
+	}
+
+	if err == nil && length < MinRSABits && key.Type() == ssh.KeyAlgoRSA {
+		r.Findings = append(r.Findings, Finding{Issue: WeakLength})
+	}
+
+	if n, err := RSAModulus(key); err == nil && key.Type() == ssh.KeyAlgoRSA {
+		if reason, trivial := TriviallyFactorable(n); trivial {
+			r.Findings = append(r.Findings, Finding{TrivialModulus, "modulus " + reason})
+		}
+	}
+
+	return r
+}
+
+// acceptedCurves are the curves on which ECDSA keys are strong enough, all of
+// them NIST curves of at least 256 bits
+var acceptedCurves = map[string]bool{
+	"nistp256": true,
+	"nistp384": true,
+	"nistp521": true,
+}
+
+// AcceptedCurve reports whether ECDSA keys on the named curve, e.g.
+// "nistp256", are strong enough
+func AcceptedCurve(name string) bool {
+	return acceptedCurves[name]
+}
+
+// BitLen returns the length of the key, or of the key a certificate
+// certifies
+func BitLen(key ssh.PublicKey) (int, error) {
+	var (
+		length int
+		err    error
+	)
+
+	// A certificate is as strong as the key it certifies
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return BitLen(cert.Key)
+	}
+
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		length, err = rsaKeyLength(key)
+	case ssh.KeyAlgoDSA:
+		length, err = dsaKeyLength(key)
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		length, err = ecdsaKeyLength(key)
+	default:
+		err = errors.New("Key type not supported: " + key.Type())
+	}
+
+	return length, err
+}
+
+// ClaimedBitLen returns the key length implied by the key's type for ECDSA
+// keys, whose type names the curve used. SSH doesn't record the intended
+// length of RSA keys, so for these the nearest multiple of 1024 bits is
+// assumed if the modulus is a single bit short of it, which indicates the
+// key was generated by software that didn't set the modulus' top bit.
+// Otherwise, the key's actual length is returned.
+func ClaimedBitLen(key ssh.PublicKey) (int, error) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return ClaimedBitLen(cert.Key)
+	}
+
+	length, err := BitLen(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch key.Type() {
+	case ssh.KeyAlgoECDSA256:
+		return 256, nil
+	case ssh.KeyAlgoECDSA384:
+		return 384, nil
+	case ssh.KeyAlgoECDSA521:
+		return 521, nil
+	case ssh.KeyAlgoRSA:
+		if (length+1)%1024 == 0 {
+			return length + 1, nil
+		}
+	}
+
+	return length, nil
+}
+
+// Curve returns the name of the curve the key is on, as given by the key
+// itself, or false if it isn't an ECDSA key
+func Curve(key ssh.PublicKey) (string, bool) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return Curve(cert.Key)
+	}
+	if !strings.HasPrefix(key.Type(), "ecdsa-sha2-") {
+		return "", false
+	}
+
+	var w struct {
+		Name  string
+		Curve string
+		Rest  []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(key.Marshal(), &w); err != nil {
+		return "", false
+	}
+
+	return w.Curve, true
+}
+
+func rsaKeyLength(key ssh.PublicKey) (int, error) {
+	n, err := RSAModulus(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.BitLen(), nil
+}
+
+// RSAModulus returns the modulus of the RSA key, or of the RSA key a
+// certificate certifies
+func RSAModulus(key ssh.PublicKey) (*big.Int, error) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return RSAModulus(cert.Key)
+	}
+
+	var w struct {
+		Name string
+		E    *big.Int
+		N    *big.Int
+		Rest []byte `ssh:"rest"`
+	}
+
+	err := ssh.Unmarshal(key.Marshal(), &w)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.N, nil
+}
+
+func dsaKeyLength(key ssh.PublicKey) (int, error) {
+	var w struct {
+		Name       string
+		P, Q, G, Y *big.Int
+		Rest       []byte `ssh:"rest"`
+	}
+	err := ssh.Unmarshal(key.Marshal(), &w)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.P.BitLen(), nil
+}
+
+func ecdsaKeyLength(key ssh.PublicKey) (int, error) {
+	var w struct {
+		Name     string
+		Curve    string
+		KeyBytes []byte
+		Rest     []byte `ssh:"rest"`
+	}
+
+	err := ssh.Unmarshal(key.Marshal(), &w)
+	if err != nil {
+		return 0, err
+	}
+
+	k := new(ecdsa.PublicKey)
+	switch w.Curve {
+	case "nistp256":
+		k.Curve = elliptic.P256()
+	case "nistp384":
+		k.Curve = elliptic.P384()
+	case "nistp521":
+		k.Curve = elliptic.P521()
+	default:
+		return 0, fmt.Errorf("ECSDA curve not supported: %q", w.Curve)
+	}
+
+	k.X, k.Y = elliptic.Unmarshal(k.Curve, w.KeyBytes)
+	if k.X == nil || k.Y == nil {
+		return 0, fmt.Errorf("ECDSA X or Y points were nil: %q, %q", k.X, k.Y)
+	}
+
+	return k.Params().BitSize, nil
+}
@@ -0,0 +1,340 @@
+package keycheck
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// unsupportedKey is a key of a type the ssh package can't parse, such as an
+// ECDSA key on a curve other than the NIST curves it supports
+type unsupportedKey struct {
+	typ  string
+	blob []byte
+}
+
+func (k unsupportedKey) Type() string    { return k.typ }
+func (k unsupportedKey) Marshal() []byte { return k.blob }
+func (k unsupportedKey) Verify([]byte, *ssh.Signature) error {
+	return nil
+}
+
+// rsaKey returns an RSA public key with the given modulus
+func rsaKey(t testing.TB, n *big.Int) ssh.PublicKey {
+	key, err := ssh.NewPublicKey(&rsa.PublicKey{N: n, E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestCheck(t *testing.T) {
+	generate := func(private interface{}, err error) ssh.PublicKey {
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer.PublicKey()
+	}
+
+	rsa2048 := generate(rsa.GenerateKey(rand.Reader, 2048))
+	rsa1024 := generate(rsa.GenerateKey(rand.Reader, 1024))
+	p256 := generate(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	p521 := generate(ecdsa.GenerateKey(elliptic.P521(), rand.Reader))
+
+	dsaKey := new(dsa.PrivateKey)
+	err := dsa.GenerateParameters(&dsaKey.Parameters, rand.Reader, dsa.L1024N160)
+	if err == nil {
+		err = dsa.GenerateKey(dsaKey, rand.Reader)
+	}
+	dsa1024 := generate(dsaKey, err)
+
+	// Certificates are checked as the key they certify
+	ca, err := ssh.NewSignerFromKey(mustECDSA(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certify := func(key ssh.PublicKey) ssh.PublicKey {
+		cert := &ssh.Certificate{Key: key, CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	// Generate a 1023-bit modulus, as if by software that didn't set
+	// the top bits of its primes
+	short := new(big.Int)
+	for short.BitLen() != 1023 {
+		p, err := rand.Prime(rand.Reader, 512)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := rand.Prime(rand.Reader, 511)
+		if err != nil {
+			t.Fatal(err)
+		}
+		short.Mul(p, q)
+	}
+
+	p, err := rand.Prime(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		bits     int
+		findings []Finding
+	}{
+		{
+			name: "RSA 2048",
+			key:  rsa2048,
+			bits: 2048,
+		},
+		{
+			name:     "RSA 1024",
+			key:      rsa1024,
+			bits:     1024,
+			findings: []Finding{{Issue: WeakLength}},
+		},
+		{
+			name:     "RSA 1023",
+			key:      rsaKey(t, short),
+			bits:     1023,
+			findings: []Finding{{SizeMismatch, "claims to be 1024 bits"}, {Issue: WeakLength}},
+		},
+		{
+			name:     "DSA",
+			key:      dsa1024,
+			bits:     1024,
+			findings: []Finding{{Issue: DSA}},
+		},
+		{
+			name:     "RSA 1024 certificate",
+			key:      certify(rsa1024),
+			bits:     1024,
+			findings: []Finding{{Issue: WeakLength}},
+		},
+		{
+			name:     "DSA certificate",
+			key:      certify(dsa1024),
+			bits:     1024,
+			findings: []Finding{{Issue: DSA}},
+		},
+		{
+			name: "ECDSA P-256",
+			key:  p256,
+			bits: 256,
+		},
+		{
+			name: "ECDSA P-256 certificate",
+			key:  certify(p256),
+			bits: 256,
+		},
+		{
+			name: "ECDSA P-521",
+			key:  p521,
+			bits: 521,
+		},
+		{
+			name: "ECDSA P-192",
+			key: unsupportedKey{"ecdsa-sha2-nistp192", ssh.Marshal(struct {
+				Name, Curve string
+				KeyBytes    []byte
+			}{"ecdsa-sha2-nistp192", "nistp192", []byte{4, 1, 2}})},
+			findings: []Finding{{WeakCurve, "nistp192"}},
+		},
+		{
+			name:     "unsupported type",
+			key:      unsupportedKey{"ssh-foo", ssh.Marshal(struct{ Name string }{"ssh-foo"})},
+			findings: []Finding{{Unparseable, "Key type not supported: ssh-foo"}},
+		},
+		{
+			name:     "even modulus",
+			key:      rsaKey(t, new(big.Int).Lsh(p, 1)),
+			bits:     1025,
+			findings: []Finding{{Issue: WeakLength}, {TrivialModulus, "modulus is even"}},
+		},
+		{
+			name:     "prime modulus",
+			key:      rsaKey(t, nextPrime(new(big.Int).Lsh(p, 1024))),
+			bits:     2048,
+			findings: []Finding{{TrivialModulus, "modulus is prime"}},
+		},
+		{
+			name:     "square modulus",
+			key:      rsaKey(t, new(big.Int).Mul(p, p)),
+			bits:     2048,
+			findings: []Finding{{TrivialModulus, "modulus is a perfect power, an integer raised to the power 2"}},
+		},
+	} {
+		r := Check(test.key)
+		if r.Bits != test.bits {
+			t.Errorf("%s: got %d bits, expected %d", test.name, r.Bits, test.bits)
+		}
+		if !reflect.DeepEqual(r.Findings, test.findings) {
+			t.Errorf("%s: got %v, expected %v", test.name, r.Findings, test.findings)
+		}
+	}
+}
+
+// mustECDSA returns a freshly generated P-256 key
+func mustECDSA(t testing.TB) *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// nextPrime returns the smallest prime greater than n
+func nextPrime(n *big.Int) *big.Int {
+	p := new(big.Int).Add(n, big.NewInt(1))
+	for !p.ProbablyPrime(20) {
+		p.Add(p, big.NewInt(1))
+	}
+
+	return p
+}
+
+func TestAnalyzeAuthorizedKeys(t *testing.T) {
+	if _, err := AnalyzeAuthorizedKeys([]byte("# comment\n\nnot a key\n")); err == nil || err.Error() != "line 3: ssh: no key found" {
+		t.Errorf("got %v, expected an error for line 3", err)
+	}
+
+	results, err := AnalyzeAuthorizedKeys(nil)
+	if err != nil || len(results) != 0 {
+		t.Errorf("got %v, %v, expected no results", results, err)
+	}
+}
+
+// mislabelledECDSAKey returns a P-384 key whose type claims it's on the
+// P-256 curve, which the ssh package would refuse to parse
+func mislabelledECDSAKey(t testing.TB) ssh.PublicKey {
+	k, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return unsupportedKey{ssh.KeyAlgoECDSA256, ssh.Marshal(struct {
+		Name, Curve string
+		KeyBytes    []byte
+	}{ssh.KeyAlgoECDSA256, "nistp384", elliptic.Marshal(k.Curve, k.X, k.Y)})}
+}
+
+func TestClaimedBitLen(t *testing.T) {
+	one := big.NewInt(1)
+	modulus := func(bits uint) *big.Int {
+		n := new(big.Int).Lsh(one, bits-1)
+		return n.Add(n, one)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(p256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name            string
+		key             ssh.PublicKey
+		length, claimed int
+	}{
+		{"rsa-2048", rsaKey(t, modulus(2048)), 2048, 2048},
+		{"rsa-2047", rsaKey(t, modulus(2047)), 2047, 2048},
+		{"rsa-4095", rsaKey(t, modulus(4095)), 4095, 4096},
+		{"rsa-2046", rsaKey(t, modulus(2046)), 2046, 2046},
+		{"ecdsa-256", signer.PublicKey(), 256, 256},
+		{"P-384 key claiming P-256", mislabelledECDSAKey(t), 384, 256},
+	} {
+		length, err := BitLen(test.key)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		claimed, err := ClaimedBitLen(test.key)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if length != test.length || claimed != test.claimed {
+			t.Errorf("%s: got %d bits claiming %d, expected %d claiming %d", test.name, length, claimed, test.length, test.claimed)
+		}
+	}
+}
+
+func TestPerfectPower(t *testing.T) {
+	pow := func(x, k int64) *big.Int {
+		return new(big.Int).Exp(big.NewInt(x), big.NewInt(k), nil)
+	}
+	p := nextPrime(new(big.Int).Lsh(big.NewInt(1), 700))
+
+	for _, test := range []struct {
+		name string
+		n    *big.Int
+		k    int
+		ok   bool
+	}{
+		{"cube", pow(3, 3), 3, true},
+		{"sixth power", pow(7, 6), 2, true},
+		{"large root", new(big.Int).Exp(p, big.NewInt(5), nil), 5, true},
+		{"smallest root", pow(3, 2579), 2579, true},
+		{"large prime power", pow(5, 1499), 1499, true},
+		{"one more than a power", new(big.Int).Add(pow(3, 2579), big.NewInt(2)), 0, false},
+		{"product of primes", new(big.Int).Mul(p, nextPrime(p)), 0, false},
+	} {
+		if k, ok := perfectPower(test.n); k != test.k || ok != test.ok {
+			t.Errorf("%s: got %d, %t, expected %d, %t", test.name, k, ok, test.k, test.ok)
+		}
+	}
+}
+
+func BenchmarkTriviallyFactorable(b *testing.B) {
+	for _, bits := range []int{2048, 4096} {
+		k, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strconv.Itoa(bits), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TriviallyFactorable(k.N)
+			}
+		})
+	}
+
+	// Generating the largest keys takes too long, but a modulus with no
+	// small factors that isn't a perfect power takes as long to check as
+	// an RSA modulus
+	smallPrimes := big.NewInt(3 * 5 * 7 * 11 * 13 * 17 * 19 * 23 * 29 * 31 * 37)
+	for _, bits := range []int{8192, 16384} {
+		var n *big.Int
+		for n == nil || new(big.Int).GCD(nil, nil, n, smallPrimes).Cmp(big.NewInt(1)) != 0 {
+			var err error
+			if n, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(bits))); err != nil {
+				b.Fatal(err)
+			}
+			n.SetBit(n, bits-1, 1)
+			n.SetBit(n, 0, 1)
+		}
+		b.Run(strconv.Itoa(bits), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				TriviallyFactorable(n)
+			}
+		})
+	}
+}
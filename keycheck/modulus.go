@@ -0,0 +1,104 @@
+package keycheck
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// maxPrimalityBits is the length of the longest modulus tested for being
+// prime. Testing takes about a quarter of a second for an 8192 bit modulus
+// and a second for a 16384 bit one, the longest OpenSSH accepts, and
+// software that produces a prime modulus is unlikely to produce keys that
+// long.
+const maxPrimalityBits = 4096
+
+// TriviallyFactorable returns how the RSA modulus n can be factored trivially,
+// if it can. These checks are cheap enough to always apply, and any modulus
+// that fails them was generated by badly broken software.
+func TriviallyFactorable(n *big.Int) (string, bool) {
+	switch {
+	case n.Bit(0) == 0:
+		return "is even", true
+	case n.BitLen() <= maxPrimalityBits && n.ProbablyPrime(20):
+		return "is prime", true
+	}
+
+	if k, ok := perfectPower(n); ok {
+		return fmt.Sprintf("is a perfect power, an integer raised to the power %d", k), true
+	}
+
+	return "", false
+}
+
+// perfectPower returns the smallest k for which n is an integer raised to
+// the power k, if there is one. n must be odd. Only prime powers need to be
+// tried, as a kth power is also a pth power for each prime p dividing k,
+// and only up to about the k at which the root would be less than 3, the
+// smallest odd root other than 1.
+func perfectPower(n *big.Int) (int, bool) {
+	// Candidate roots are first compared in the low 64 bits, which rules
+	// out nearly all of them without raising them to the full power
+	mod := new(big.Int).Lsh(big.NewInt(1), 64)
+	low := new(big.Int).Mod(n, mod)
+	log2n := log2(n)
+
+	// The bound is raised by one, so that no k is missed to rounding
+	for k := 2; float64(k) <= log2n/math.Log2(3)+1; k++ {
+		if !big.NewInt(int64(k)).ProbablyPrime(20) {
+			continue
+		}
+
+		root := integerRoot(n, k)
+		bigK := big.NewInt(int64(k))
+		if new(big.Int).Exp(root, bigK, mod).Cmp(low) != 0 {
+			continue
+		}
+		if new(big.Int).Exp(root, bigK, nil).Cmp(n) == 0 {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+// log2 returns the base 2 logarithm of n, which must be positive
+func log2(n *big.Int) float64 {
+	shift := n.BitLen() - 64
+	if shift < 0 {
+		shift = 0
+	}
+
+	return float64(shift) + math.Log2(float64(new(big.Int).Rsh(n, uint(shift)).Uint64()))
+}
+
+// integerRoot returns the integer kth root of n, rounded down, using
+// Newton's method
+func integerRoot(n *big.Int, k int) *big.Int {
+	if k == 2 {
+		return new(big.Int).Sqrt(n)
+	}
+
+	// Start from a floating point estimate, raised enough to be no smaller
+	// than the root, from which each step moves closer until the root is
+	// reached. Being close to start with, few steps are needed.
+	e := log2(n) / float64(k)
+	shift := 0
+	if e > 52 {
+		shift = int(e) - 52
+	}
+	x := new(big.Int).SetUint64(uint64(math.Exp2(e-float64(shift))*(1+1e-9)) + 1)
+	x.Lsh(x, uint(shift))
+
+	bigK, bigK1 := big.NewInt(int64(k)), big.NewInt(int64(k-1))
+	for {
+		y := new(big.Int).Exp(x, bigK1, nil)
+		y.Quo(n, y)
+		y.Add(y, new(big.Int).Mul(bigK1, x))
+		y.Quo(y, bigK)
+		if y.Cmp(x) >= 0 {
+			return x
+		}
+		x = y
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The sections of an OpenSSH key revocation list, as described in
+// PROTOCOL.krl in the OpenSSH source
+const (
+	krlMagic   = "SSHKRL\n\x00"
+	krlVersion = 1
+
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlCertSerialList   = 0x20
+	krlCertSerialRange  = 0x21
+	krlCertSerialBitmap = 0x22
+	krlCertKeyID        = 0x23
+)
+
+// krl is a parsed key revocation list
+type krl struct {
+	// explicit, sha1 and sha256 hold the revoked keys, as key blobs or the
+	// SHA-1 or SHA-256 digests of them
+	explicit, sha1, sha256 map[string]bool
+
+	certs []krlCerts
+}
+
+// krlCerts lists the revoked certificates issued by a CA
+type krlCerts struct {
+	// caKey is the CA's public key blob, or empty if the revocations
+	// apply to certificates issued by any CA
+	caKey []byte
+
+	serials []krlSerialRange
+	bitmaps []krlSerialBitmap
+	keyIDs  map[string]bool
+}
+
+type krlSerialRange struct{ min, max uint64 }
+
+// krlSerialBitmap revokes serial offset+n for each bit n set in bits
+type krlSerialBitmap struct {
+	offset uint64
+	bits   *big.Int
+}
+
+// revocations holds the KRL loaded from KRL_FILE, if any
+var revocations = struct {
+	mu  sync.RWMutex
+	krl *krl
+}{}
+
+// loadKRL replaces the revocation list with the one in the named file. The
+// existing list is kept if the file can't be read or parsed.
+func loadKRL(path string) error {
+	k, err := readKRL(path)
+	if err != nil {
+		return err
+	}
+
+	revocations.mu.Lock()
+	revocations.krl = k
+	revocations.mu.Unlock()
+
+	return nil
+}
+
+// readKRL reads and parses the KRL in the named file
+func readKRL(path string) (*krl, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKRL(data)
+}
+
+// revoked returns why the key is revoked by the KRL, if it is. As with
+// OpenSSH, a certificate is revoked if its own key or its CA's key is
+// revoked, as well as by its serial number or key ID.
+func revoked(k *publicKey) (string, bool) {
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+
+	if revocations.krl == nil {
+		return "", false
+	}
+
+	cert, isCert := k.key.(*ssh.Certificate)
+	if !isCert {
+		return revocations.krl.keyRevoked(k.key.Marshal())
+	}
+
+	if reason, ok := revocations.krl.keyRevoked(cert.Key.Marshal()); ok {
+		return reason, true
+	}
+	if reason, ok := revocations.krl.keyRevoked(cert.SignatureKey.Marshal()); ok {
+		return "CA " + reason, true
+	}
+
+	return revocations.krl.certRevoked(cert)
+}
+
+func (k *krl) keyRevoked(blob []byte) (string, bool) {
+	sha1Sum := sha1.Sum(blob)
+	sha256Sum := sha256.Sum256(blob)
+
+	switch {
+	case k.explicit[string(blob)]:
+		return "key revoked", true
+	case k.sha1[string(sha1Sum[:])]:
+		return "key revoked by SHA-1 fingerprint", true
+	case k.sha256[string(sha256Sum[:])]:
+		return "key revoked by SHA-256 fingerprint", true
+	}
+
+	return "", false
+}
+
+func (k *krl) certRevoked(cert *ssh.Certificate) (string, bool) {
+	ca := cert.SignatureKey.Marshal()
+
+	for _, c := range k.certs {
+		if len(c.caKey) > 0 && !bytes.Equal(c.caKey, ca) {
+			continue
+		}
+
+		for _, r := range c.serials {
+			if cert.Serial >= r.min && cert.Serial <= r.max {
+				return fmt.Sprintf("certificate serial %d revoked", cert.Serial), true
+			}
+		}
+
+		for _, b := range c.bitmaps {
+			if cert.Serial >= b.offset && cert.Serial-b.offset < uint64(b.bits.BitLen()) && b.bits.Bit(int(cert.Serial-b.offset)) == 1 {
+				return fmt.Sprintf("certificate serial %d revoked", cert.Serial), true
+			}
+		}
+
+		if c.keyIDs[cert.KeyId] {
+			return fmt.Sprintf("certificate key ID %q revoked", cert.KeyId), true
+		}
+	}
+
+	return "", false
+}
+
+// parseKRL parses a KRL in the binary format generated by `ssh-keygen -k`.
+// Its signature, if any, isn't checked, as OpenSSH doesn't support signing
+// KRLs either.
+func parseKRL(data []byte) (*krl, error) {
+	r := &krlReader{data: data}
+
+	magic := r.next(len(krlMagic))
+	if string(magic) != krlMagic {
+		return nil, errors.New("not a KRL")
+	}
+	if version := r.uint32(); r.err == nil && version != krlVersion {
+		return nil, fmt.Errorf("unsupported KRL format version %d", version)
+	}
+	r.uint64() // KRL version
+	r.uint64() // generated date
+	r.uint64() // flags
+	r.string() // reserved
+	r.string() // comment
+
+	k := &krl{
+		explicit: make(map[string]bool),
+		sha1:     make(map[string]bool),
+		sha256:   make(map[string]bool),
+	}
+
+	for r.err == nil && len(r.data) > 0 {
+		sectionType := r.byte()
+		section := &krlReader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+
+		switch sectionType {
+		case krlSectionCertificates:
+			c, err := parseKRLCerts(section)
+			if err != nil {
+				return nil, err
+			}
+			k.certs = append(k.certs, c)
+		case krlSectionExplicitKey:
+			for section.err == nil && len(section.data) > 0 {
+				k.explicit[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA1:
+			for section.err == nil && len(section.data) > 0 {
+				k.sha1[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA256:
+			for section.err == nil && len(section.data) > 0 {
+				k.sha256[string(section.string())] = true
+			}
+		case krlSectionSignature:
+			// Signatures come last
+			return k, nil
+		default:
+			return nil, fmt.Errorf("unsupported KRL section type %d", sectionType)
+		}
+
+		if section.err != nil {
+			return nil, section.err
+		}
+	}
+
+	return k, r.err
+}
+
+func parseKRLCerts(r *krlReader) (krlCerts, error) {
+	c := krlCerts{
+		caKey:  r.string(),
+		keyIDs: make(map[string]bool),
+	}
+	r.string() // reserved
+
+	for r.err == nil && len(r.data) > 0 {
+		subsectionType := r.byte()
+		sub := &krlReader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+
+		switch subsectionType {
+		case krlCertSerialList:
+			for sub.err == nil && len(sub.data) > 0 {
+				serial := sub.uint64()
+				c.serials = append(c.serials, krlSerialRange{serial, serial})
+			}
+		case krlCertSerialRange:
+			c.serials = append(c.serials, krlSerialRange{sub.uint64(), sub.uint64()})
+		case krlCertSerialBitmap:
+			offset := sub.uint64()
+			c.bitmaps = append(c.bitmaps, krlSerialBitmap{offset, new(big.Int).SetBytes(sub.string())})
+		case krlCertKeyID:
+			for sub.err == nil && len(sub.data) > 0 {
+				c.keyIDs[string(sub.string())] = true
+			}
+		default:
+			return c, fmt.Errorf("unsupported KRL certificate section type %d", subsectionType)
+		}
+
+		if sub.err != nil {
+			return c, sub.err
+		}
+	}
+
+	return c, r.err
+}
+
+// krlReader reads the SSH wire encoding used by KRLs. After the first
+// error, reads return zero values and err is set.
+type krlReader struct {
+	data []byte
+	err  error
+}
+
+func (r *krlReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.err = errors.New("truncated KRL")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *krlReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *krlReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *krlReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *krlReader) string() []byte {
+	n := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint64(n) > uint64(len(r.data)) {
+		r.err = errors.New("truncated KRL")
+		return nil
+	}
+
+	return r.next(int(n))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// generateKRL returns the path of a KRL generated by `ssh-keygen -k` from
+// the given specification, revoking certificates issued by ca
+func generateKRL(t *testing.T, dir string, ca ssh.PublicKey, spec string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+
+	files := map[string]string{
+		"ca.pub": string(ssh.MarshalAuthorizedKey(ca)),
+		"spec":   spec,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "krl")
+	cmd := exec.Command("ssh-keygen", "-k", "-f", path, "-s", filepath.Join(dir, "ca.pub"), filepath.Join(dir, "spec"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen -k failed: %s\n%s", err, out)
+	}
+
+	return path
+}
+
+func TestKRL(t *testing.T) {
+	defer func(k *krl) { revocations.krl = k }(revocations.krl)
+
+	dir, err := ioutil.TempDir("", "krl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, otherCA, revokedCA := testSigner(t), testSigner(t), testSigner(t)
+	explicit, bySHA1, bySHA256, unrevoked := testSigner(t).PublicKey(), testSigner(t).PublicKey(), testSigner(t).PublicKey(), testSigner(t).PublicKey()
+	line := func(k ssh.PublicKey) string { return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k))) }
+
+	// ssh-keygen encodes the serials as whichever of lists, ranges and
+	// bitmaps is smallest, so these are chosen to give one of each
+	spec := []string{
+		"serial: 1-3",
+		"serial: 1000-5000",
+		"serial: 1099511627776",
+	}
+	for serial := 100; serial <= 160; serial += 2 {
+		spec = append(spec, "serial: "+strconv.Itoa(serial))
+	}
+	spec = append(spec,
+		"id: INC-311",
+		"key: "+line(explicit),
+		"key: "+line(revokedCA.PublicKey()),
+		"sha1: "+line(bySHA1),
+		"sha256: "+line(bySHA256),
+	)
+
+	if err := loadKRL(generateKRL(t, dir, ca.PublicKey(), strings.Join(spec, "\n")+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if c := revocations.krl.certs; len(c) != 1 || len(c[0].serials) < 2 || len(c[0].bitmaps) < 2 || len(c[0].keyIDs) != 1 {
+		t.Fatalf("expected serial lists, ranges, bitmaps and key IDs, got %+v", c)
+	}
+
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		expected string
+	}{
+		{"explicit key", explicit, "key revoked"},
+		{"key by SHA-1", bySHA1, "key revoked by SHA-1 fingerprint"},
+		{"key by SHA-256", bySHA256, "key revoked by SHA-256 fingerprint"},
+		{"unrevoked key", unrevoked, ""},
+
+		{"serial in list", testCertificate(t, ca, unrevoked, 1099511627776, ""), "certificate serial 1099511627776 revoked"},
+		{"first serial in range", testCertificate(t, ca, unrevoked, 1000, ""), "certificate serial 1000 revoked"},
+		{"last serial in range", testCertificate(t, ca, unrevoked, 5000, ""), "certificate serial 5000 revoked"},
+		{"serial after range", testCertificate(t, ca, unrevoked, 5001, ""), ""},
+		{"serial in small bitmap", testCertificate(t, ca, unrevoked, 2, ""), "certificate serial 2 revoked"},
+		{"serial after small bitmap", testCertificate(t, ca, unrevoked, 4, ""), ""},
+		{"serial in bitmap", testCertificate(t, ca, unrevoked, 158, ""), "certificate serial 158 revoked"},
+		{"serial missing from bitmap", testCertificate(t, ca, unrevoked, 101, ""), ""},
+		{"serial after bitmap", testCertificate(t, ca, unrevoked, 162, ""), ""},
+		{"key ID", testCertificate(t, ca, unrevoked, 7, "INC-311"), `certificate key ID "INC-311" revoked`},
+		{"other key ID", testCertificate(t, ca, unrevoked, 7, "INC-312"), ""},
+
+		{"serial for another CA", testCertificate(t, otherCA, unrevoked, 2, ""), ""},
+		{"key ID for another CA", testCertificate(t, otherCA, unrevoked, 7, "INC-311"), ""},
+		{"certificate for revoked key", testCertificate(t, otherCA, explicit, 7, ""), "key revoked"},
+		{"certificate from revoked CA", testCertificate(t, revokedCA, unrevoked, 7, ""), "CA key revoked"},
+	} {
+		reason, ok := revoked(&publicKey{key: test.key})
+		if reason != test.expected || ok != (test.expected != "") {
+			t.Errorf("%s: got %q, %t, expected %q", test.name, reason, ok, test.expected)
+		}
+	}
+}
+
+func TestParseKRLErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(generateKRL(t, dir, testSigner(t).PublicKey(), "serial: 1-3\nid: INC-311\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseKRL(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("SSHKRL\n\x01"), data[len(krlMagic):]...)},
+		{"unsupported version", append(append([]byte(krlMagic), 0, 0, 0, 2), data[len(krlMagic)+4:]...)},
+		{"truncated header", data[:len(krlMagic)+10]},
+		{"truncated section", data[:len(data)-1]},
+		{"unknown section", append(append([]byte{}, data...), 9, 0, 0, 0, 0)},
+	} {
+		if _, err := parseKRL(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// lessonTemplates explain what each check looks for and why, for teaching
+// mode. Each is a text/template executed with a lesson. The defaults can be
+// overridden using TEACHING_TEMPLATES_FILE.
+var lessonTemplates = map[string]string{
+	"rsa":         "Checking RSA modulus size: yours is {{.Bits}} bits. Moduli shorter than 2048 bits are within reach of well-funded attackers, and 3072 bits matches the strength of a 128-bit symmetric key.",
+	"dsa":         "Checking key type: DSA keys are limited to 1024 bits and SHA-1 by the SSH protocol, so OpenSSH 7.0 stopped accepting them by default.",
+	"ecdsa":       "Checking key type: yours is an ECDSA key on a {{.Bits}}-bit NIST curve, which is as strong as a much longer RSA key, but relies on a good random number generator each time it signs.",
+	"ed25519":     "Checking key type: Ed25519 keys are short, fast and don't depend on a random number generator when signing, which makes them the best choice for most users.",
+	"certificate": "Checking certificate: yours is signed by a certificate authority, which servers trust instead of each key. The key it certifies is checked as if it had been presented on its own.",
+	"blacklist":   "Checking the Debian blacklist: between 2006 and 2008, Debian's OpenSSL package could only generate 32,768 keys of each type and size, all of which have been published.",
+	"result":      "Result: {{.Issue}}.",
+}
+
+// lessonOrder is the order in which the lessons that apply to a key are given
+var lessonOrder = []string{"certificate", "rsa", "dsa", "ecdsa", "ed25519", "blacklist", "result"}
+
+// parsedLessons holds lessonTemplates once parsed, by name
+var parsedLessons map[string]*template.Template
+
+// lesson is the data each lesson template is executed with
+type lesson struct {
+	Index       int
+	Type        string
+	Bits        string
+	Fingerprint string
+	Issue       string
+}
+
+// loadLessons parses lessonTemplates, after overriding them with those in
+// the named file, if given. Each line of the file gives the name of a
+// template, a colon and the template. Lines starting with "#" are ignored.
+func loadLessons(path string) error {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for line := 1; scanner.Scan(); line++ {
+			entry := strings.TrimSpace(scanner.Text())
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected name: template on line %d: %q", line, entry)
+			}
+			if _, ok := lessonTemplates[parts[0]]; !ok {
+				return fmt.Errorf("unknown template on line %d: %q", line, parts[0])
+			}
+			lessonTemplates[parts[0]] = strings.TrimSpace(parts[1])
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	parsed := make(map[string]*template.Template)
+	for name, text := range lessonTemplates {
+		t, err := template.New(name).Parse(text)
+		if err != nil {
+			return err
+		}
+
+		// Catch references to fields that don't exist before a client
+		// has to see them
+		if err := t.Execute(ioutil.Discard, lesson{}); err != nil {
+			return err
+		}
+		parsed[name] = t
+	}
+	parsedLessons = parsed
+
+	return nil
+}
+
+// lessonsFor returns the names of the lessons that apply to the key, in
+// the order they're given
+func lessonsFor(k *publicKey) []string {
+	key := k.key
+	applies := map[string]bool{"result": true}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		applies["certificate"] = true
+		key = cert.Key
+	}
+
+	// The Debian blacklist predates OpenSSH's support for other types
+	switch t := key.Type(); {
+	case t == ssh.KeyAlgoRSA:
+		applies["rsa"], applies["blacklist"] = true, true
+	case t == ssh.KeyAlgoDSA:
+		applies["dsa"], applies["blacklist"] = true, true
+	case strings.HasPrefix(t, "ecdsa-"):
+		applies["ecdsa"] = true
+	case strings.Contains(t, "ed25519"):
+		applies["ed25519"] = true
+	}
+
+	var names []string
+	for _, name := range lessonOrder {
+		if applies[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// explain describes each check made of the key and what it found, wrapped
+// to the given width
+func explain(r keyResult, width int) string {
+	data := lesson{
+		Index:       r.index,
+		Type:        r.key.key.Type(),
+		Bits:        r.bits(),
+		Fingerprint: r.key.Fingerprint(),
+		Issue:       r.issue,
+	}
+
+	lines := []string{fmt.Sprintf("LESSON:   Key %d, %s %s:", data.Index, data.Type, data.Fingerprint)}
+	for _, name := range lessonsFor(r.key) {
+		var b bytes.Buffer
+		// Templates were checked when loaded, so can't fail here
+		parsedLessons[name].Execute(&b, data)
+		lines = append(lines, wrapMessage("          "+b.String(), width))
+	}
+
+	return strings.Join(lines, "\n\r") + "\n\r\n\r"
+}
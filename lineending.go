@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"runtime"
+)
+
+// The line endings of each kind of output. Reports are rendered for SSH
+// clients, ending each line with "\n\r" so that terminals return to the
+// start of the line whether or not the client asked for a pty. Output meant
+// for anything else rewrites them as it's written, using withLineEnding.
+const (
+	sshLineEnding  = "\n\r"
+	lfLineEnding   = "\n"
+	crlfLineEnding = "\r\n"
+)
+
+// localLineEnding ends the lines of output printed locally, such as that of
+// -check and -demo
+var localLineEnding = func() string {
+	if runtime.GOOS == "windows" {
+		return crlfLineEnding
+	}
+	return lfLineEnding
+}()
+
+// withLineEnding returns a writer that writes reports rendered for SSH
+// clients to w, ending each line with the given line ending instead
+func withLineEnding(w io.Writer, ending string) io.Writer {
+	if ending == sshLineEnding {
+		return w
+	}
+
+	return &lineEndingWriter{w: w, ending: []byte(ending)}
+}
+
+// lineEndingWriter rewrites the line endings of a report as it's written.
+// Lines ending with "\n" alone, such as the status user's verdict, are
+// rewritten too.
+type lineEndingWriter struct {
+	w      io.Writer
+	ending []byte
+
+	// newline is set if the last byte written was "\n", in which case a
+	// "\r" at the start of the next write completes the line ending
+	newline bool
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch {
+		case l.newline && b == '\r':
+			l.newline = false
+		case b == '\n':
+			l.newline = true
+			out = append(out, l.ending...)
+		default:
+			l.newline = false
+			out = append(out, b)
+		}
+	}
+
+	if _, err := l.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWithLineEnding(t *testing.T) {
+	for _, test := range []struct {
+		ending   string
+		writes   []string
+		expected string
+	}{
+		{lfLineEnding, []string{"one\n\rtwo\n\r"}, "one\ntwo\n"},
+		{lfLineEnding, []string{"one\n", "\rtwo\n", "\r"}, "one\ntwo\n"},
+		{lfLineEnding, []string{"\n\r\n\r"}, "\n\n"},
+		{lfLineEnding, []string{"status\n"}, "status\n"},
+		{lfLineEnding, []string{"\r\x1b[K"}, "\r\x1b[K"},
+		{crlfLineEnding, []string{"one\n\rtwo\n", "\r"}, "one\r\ntwo\r\n"},
+		{crlfLineEnding, []string{"status\n"}, "status\r\n"},
+		{sshLineEnding, []string{"one\n\rtwo\n\r"}, "one\n\rtwo\n\r"},
+	} {
+		var out bytes.Buffer
+		w := withLineEnding(&out, test.ending)
+		for _, s := range test.writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Fatalf("%q: wrote %d bytes, error %v", s, n, err)
+			}
+		}
+		if out.String() != test.expected {
+			t.Errorf("%q with %q: got %q, expected %q", test.writes, test.ending, out.String(), test.expected)
+		}
+	}
+}
+
+// Reports are sent to SSH clients with "\n\r" line endings, and printed by
+// -check and -demo with local ones
+func TestReportLineEndings(t *testing.T) {
+	sent := testReport(t, "check", testSigner(t))
+	if strings.Count(sent, "\n") != strings.Count(sent, "\n\r") {
+		t.Errorf("report sent over SSH has lines not ending in \\n\\r:\n%q", sent)
+	}
+
+	var printed bytes.Buffer
+	runSession(startTestServer(t), "check", []ssh.Signer{testSigner(t)}, &printed)
+	switch {
+	case !strings.Contains(printed.String(), "Fingerprint"):
+		t.Fatalf("no report:\n%s", printed.String())
+	case localLineEnding == lfLineEnding && strings.Contains(printed.String(), "\r"):
+		t.Errorf("report printed locally has carriage returns:\n%q", printed.String())
+	}
+}
+
+// Machine-readable output has no carriage returns, either as line endings
+// or escaped within the messages it carries, other than CSV, whose lines
+// end in "\r\n" as RFC 4180 asks
+func TestMachineOutputLineEndings(t *testing.T) {
+	addr := startTestServer(t)
+	signer := testSigner(t)
+
+	for _, test := range []struct {
+		user, command string
+		crlf          bool
+	}{
+		{"sarif", "", false},
+		{"csv", "", true},
+		{"html", "", false},
+		{"check", jsonCommand, false},
+		{"status", "", false},
+	} {
+		session, err := testClient(t, addr, test.user, signer).NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		session.Stdout = &out
+		// The exit status is non-zero if issues were found, which doesn't
+		// matter here
+		if test.command != "" {
+			session.Run(test.command)
+		} else if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		} else {
+			session.Wait()
+		}
+		session.Close()
+
+		if out.Len() == 0 {
+			t.Errorf("%s %s: no output", test.user, test.command)
+		}
+		output := out.String()
+		if test.crlf {
+			output = strings.Replace(output, "\r\n", "\n", -1)
+		}
+		if strings.Contains(output, "\r") || strings.Contains(output, `\r`) {
+			t.Errorf("%s %s: output has stray carriage returns:\n%q", test.user, test.command, out.String())
+		}
+		if test.crlf && strings.Count(out.String(), "\n") != strings.Count(out.String(), "\r\n") {
+			t.Errorf("%s %s: output has lines not ending in \\r\\n:\n%q", test.user, test.command, out.String())
+		}
+	}
+}
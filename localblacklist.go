@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// localBlacklist maps the SHA-256 fingerprints of the keys listed in
+// BLACKLIST_FILE to the line each is listed on, for keys known to be
+// compromised that aren't in any public blacklist, e.g. after an incident.
+// Unlike the blacklist directory, it can be reloaded without restarting.
+var localBlacklist = struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}{
+	keys: make(map[string]string),
+}
+
+// loadLocalBlacklist replaces the locally blacklisted keys with those listed
+// in the named file. Malformed lines are logged and skipped. The existing
+// keys are kept if the file can't be read.
+func loadLocalBlacklist(path string) error {
+	keys, malformed, err := readLocalBlacklist(path)
+	if err != nil {
+		return err
+	}
+	for _, m := range malformed {
+		log.Warnln("Skipping malformed line in BLACKLIST_FILE:", m)
+	}
+
+	localBlacklist.mu.Lock()
+	localBlacklist.keys = keys
+	localBlacklist.mu.Unlock()
+
+	log.Infof("Loaded %d locally blacklisted key(s)", len(keys))
+	return nil
+}
+
+// readLocalBlacklist reads the keys listed in the named file, one per line,
+// each either a SHA-256 fingerprint or a public key in authorized_keys
+// format, along with a description of each line that couldn't be read
+func readLocalBlacklist(path string) (keys map[string]string, malformed []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	keys = make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		fingerprint, err := localBlacklistEntry(entry)
+		if err != nil {
+			malformed = append(malformed, fmt.Sprintf("line %d: %s", line, err))
+			continue
+		}
+		keys[fingerprint] = fmt.Sprintf("local blacklist, line %d", line)
+	}
+
+	return keys, malformed, scanner.Err()
+}
+
+// localBlacklistEntry returns the SHA-256 fingerprint listed by the entry.
+// Public keys may be preceded by authorized_keys options, and are
+// fingerprinted as blacklistEntry does, so that keys of any type can be
+// listed.
+func localBlacklistEntry(entry string) (string, error) {
+	if strings.HasPrefix(entry, "SHA256:") {
+		fingerprint := strings.TrimRight(entry, "=")
+		if _, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:")); err != nil || len(fingerprint) != 50 {
+			return "", fmt.Errorf("malformed SHA256 fingerprint: %q", entry)
+		}
+		return fingerprint, nil
+	}
+
+	// The key is the first field that decodes to a key of the type given
+	// by the field before it
+	fields := strings.Fields(entry)
+	for i := 1; i < len(fields); i++ {
+		data, err := base64.StdEncoding.DecodeString(fields[i])
+		if err != nil {
+			continue
+		}
+
+		var key struct {
+			Type string
+			Rest []byte `ssh:"rest"`
+		}
+		if ssh.Unmarshal(data, &key) == nil && key.Type == fields[i-1] {
+			_, fingerprint, err := blacklistEntry(fields[i-1]+" "+fields[i], false)
+			return fingerprint, err
+		}
+	}
+
+	return "", fmt.Errorf("expected a SHA256 fingerprint or public key: %q", entry)
+}
+
+// localBlacklistSource returns where the key is listed in the local
+// blacklist, if it is
+func localBlacklistSource(k *publicKey) (string, bool) {
+	fingerprint := blacklistDigests(k)[formatSHA256]
+
+	localBlacklist.mu.RLock()
+	defer localBlacklist.mu.RUnlock()
+
+	source, ok := localBlacklist.keys[fingerprint]
+	return source, ok
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// policyClause is one rule of this server's own key policy, as set by its
+// operator, so that each key's verdict can cite the settings it breaks
+type policyClause struct {
+	// setting is the configuration the clause comes from, as the operator
+	// gave it
+	setting string
+
+	// violation returns why the key breaks the clause, or an empty string
+	// if it doesn't
+	violation func(r keyResult) string
+}
+
+// localPolicy returns the clauses of this server's policy, from MIN_RSA_BITS,
+// ALLOWED_KEY_TYPES, FIPS and SUNSET_SCHEDULE, in that order. It is empty if
+// none of them are set.
+func localPolicy() []policyClause {
+	var clauses []policyClause
+
+	if minRSABits > 0 {
+		clauses = append(clauses, policyClause{
+			setting: fmt.Sprintf("MIN_RSA_BITS=%d", minRSABits),
+			violation: func(r keyResult) string {
+				switch {
+				case !keyAlgorithms["rsa"](certifiedType(r.key)):
+					return ""
+				case r.key.parseErr != nil:
+					return "unknown key length"
+				case r.length < minRSABits:
+					return fmt.Sprintf("shorter than %d bits", minRSABits)
+				}
+				return ""
+			},
+		})
+	}
+
+	if len(allowedKeyTypes) > 0 {
+		clauses = append(clauses, policyClause{
+			setting: "ALLOWED_KEY_TYPES=" + strings.Join(allowedKeyTypes, ","),
+			violation: func(r keyResult) string {
+				for _, name := range allowedKeyTypes {
+					if keyAlgorithms[name](certifiedType(r.key)) {
+						return ""
+					}
+				}
+				return certifiedType(r.key) + " keys not allowed"
+			},
+		})
+	}
+
+	if fips != nil {
+		setting := "FIPS=true"
+		if fips == &fips140Strict {
+			setting = "FIPS=strict"
+		}
+		clauses = append(clauses, policyClause{
+			setting: setting,
+			violation: func(r keyResult) string {
+				if ok, reason := fips.accepts(r.key); !ok {
+					return reason
+				}
+				return ""
+			},
+		})
+	}
+
+	// Rules whose date is still to come are listed by sunsetDetails, but
+	// aren't yet broken
+	for _, rule := range sunsetSchedule {
+		rule := rule
+		clauses = append(clauses, policyClause{
+			setting: "SUNSET_SCHEDULE " + rule.String(),
+			violation: func(r keyResult) string {
+				if !rule.appliesTo(r) || clk.Now().Before(rule.date) {
+					return ""
+				}
+				return "retired on " + rule.date.Format("2006-01-02")
+			},
+		})
+	}
+
+	return clauses
+}
+
+// policyVerdict evaluates each key against the clauses, and the client
+// against REQUIRE_MODERN_KEY, if set. It returns a line for each key and
+// each clause it breaks, and whether the client passes: it fails if any key
+// breaks a clause, or if it presented no modern key when one is required.
+func policyVerdict(clauses []policyClause, a *analysis) (details []string, pass bool) {
+	pass = true
+	for _, r := range a.results {
+		var broken []string
+		for _, c := range clauses {
+			if v := c.violation(r); v != "" {
+				broken = append(broken, "  "+c.setting+": "+v)
+			}
+		}
+
+		verdict := "PASS"
+		if len(broken) > 0 {
+			verdict = "FAIL"
+			pass = false
+		}
+		details = append(details, fmt.Sprintf("Key %d, %s (%s %s): %s", r.index, r.key.Fingerprint(), r.key.key.Type(), r.bits(), verdict))
+		details = append(details, broken...)
+	}
+
+	if requireModern && !a.modern {
+		details = append(details, "REQUIRE_MODERN_KEY=true: no Ed25519 or ECDSA key presented")
+		pass = false
+	}
+
+	return details, pass
+}
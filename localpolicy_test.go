@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Each key's verdict cites every setting it breaks, and the client passes
+// only if no key breaks any
+func TestPolicyVerdict(t *testing.T) {
+	defer func(bits int, types []string, p *policy, rules []sunsetRule, modern bool) {
+		minRSABits, allowedKeyTypes, fips, sunsetSchedule, requireModern = bits, types, p, rules, modern
+	}(minRSABits, allowedKeyTypes, fips, sunsetSchedule, requireModern)
+	useFakeClock(t)
+
+	rules, err := parseSunsetSchedule("rsa<3072=2025-12-31,ecdsa=2027-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name          string
+		minRSABits    int
+		allowed       []string
+		fips          *policy
+		sunset        []sunsetRule
+		requireModern bool
+		keys          []string
+		expected      []string
+		pass          bool
+	}{
+		{
+			name: "no policy",
+			keys: []string{"rsa-1024"},
+		},
+		{
+			name:       "minimum RSA length",
+			minRSABits: 3072,
+			keys:       []string{"rsa-2048", "rsa-3072", "ecdsa-256"},
+			expected:   []string{"FAIL", "  MIN_RSA_BITS=3072: shorter than 3072 bits", "PASS", "PASS"},
+		},
+		{
+			name:     "allowed key types",
+			allowed:  []string{"rsa", "ed25519"},
+			keys:     []string{"rsa-2048", "dsa-1024", "ecdsa-256"},
+			expected: []string{"PASS", "FAIL", "  ALLOWED_KEY_TYPES=rsa,ed25519: ssh-dss keys not allowed", "FAIL", "  ALLOWED_KEY_TYPES=rsa,ed25519: ecdsa-sha2-nistp256 keys not allowed"},
+		},
+		{
+			name:     "FIPS",
+			fips:     &fips140,
+			keys:     []string{"rsa-1024", "ecdsa-384"},
+			expected: []string{"FAIL", "  FIPS=true: less than 2048 bits", "PASS"},
+		},
+		{
+			name:     "sunset schedule, before and after each date",
+			sunset:   rules,
+			keys:     []string{"rsa-2048", "rsa-3072", "ecdsa-256"},
+			expected: []string{"FAIL", "  SUNSET_SCHEDULE rsa<3072=2025-12-31: retired on 2025-12-31", "PASS", "PASS"},
+		},
+		{
+			name:       "several settings broken by one key",
+			minRSABits: 2048,
+			allowed:    []string{"ecdsa"},
+			fips:       &fips140Strict,
+			sunset:     rules,
+			keys:       []string{"rsa-1024"},
+			expected: []string{
+				"FAIL",
+				"  MIN_RSA_BITS=2048: shorter than 2048 bits",
+				"  ALLOWED_KEY_TYPES=ecdsa: ssh-rsa keys not allowed",
+				"  FIPS=strict: less than 2048 bits",
+				"  SUNSET_SCHEDULE rsa<3072=2025-12-31: retired on 2025-12-31",
+			},
+		},
+		{
+			name:          "modern key required",
+			minRSABits:    2048,
+			requireModern: true,
+			keys:          []string{"rsa-4096"},
+			expected:      []string{"PASS", "REQUIRE_MODERN_KEY=true: no Ed25519 or ECDSA key presented"},
+		},
+		{
+			name:          "modern key required and presented",
+			minRSABits:    2048,
+			requireModern: true,
+			keys:          []string{"rsa-4096", "ecdsa-256"},
+			expected:      []string{"PASS", "PASS"},
+			pass:          true,
+		},
+	} {
+		minRSABits, allowedKeyTypes, fips, sunsetSchedule, requireModern = test.minRSABits, test.allowed, test.fips, test.sunset, test.requireModern
+
+		clauses := localPolicy()
+		if (len(clauses) == 0) != (test.expected == nil) {
+			t.Errorf("%s: got %d clauses", test.name, len(clauses))
+			continue
+		}
+		if test.expected == nil {
+			continue
+		}
+
+		var keys []*publicKey
+		for _, name := range test.keys {
+			keys = append(keys, &publicKey{key: generateKey(t, name)})
+		}
+		details, pass := policyVerdict(clauses, analyze(testLogger, keys, nil, nil))
+
+		// Keep only the verdict of each key's line, which also names the key
+		var got []string
+		for _, d := range details {
+			if strings.HasPrefix(d, "Key ") {
+				d = d[strings.LastIndex(d, ": ")+2:]
+			}
+			got = append(got, d)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.expected, "\n") || pass != test.pass {
+			t.Errorf("%s: got %t:\n%s\nexpected %t:\n%s", test.name, pass, strings.Join(details, "\n"), test.pass, strings.Join(test.expected, "\n"))
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
 	"net"
 	"os"
 
@@ -8,41 +10,288 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// hostKey is the server's private host key
+var hostKey ssh.Signer
+
 func main() {
+	demo := flag.Bool("demo", false, "connect to the server using sample keys, print the report and exit")
+	check := flag.Bool("check", false, "check the public keys in the files given as arguments, or stdin, print the report and exit")
+	privateKeys := flag.Bool("private-keys", false, "with -check, also accept unencrypted private keys, checking that RSA private keys are consistent; only their public keys are checked by the server")
+	indexBlacklist := flag.Bool("index-blacklist", false, "write an index of the keys listed in the files given as arguments, or stdin, for use as BLACKLIST_INDEX_FILE, to stdout and exit")
+	testAuth := flag.Bool("insecure-test-auth", false, "accept any public key offered, skipping keyboard-interactive authentication; for automated tests only")
+	flag.Parse()
+	acceptPrivateKeys = *privateKeys
+
 	log.SetOutput(os.Stderr)
+	if *indexBlacklist {
+		os.Exit(runIndexBlacklist(flag.Args()))
+	}
+	loadConfig()
+	setupSyslog()
 
 	config := &ssh.ServerConfig{
 		KeyboardInteractiveCallback: keyboardInteractiveCallback,
 		PublicKeyCallback:           publicKeyCallback,
 	}
+	config.Ciphers = ciphers
+	config.KeyExchanges = keyExchanges
+	config.MACs = macs
+
+	if *testAuth {
+		log.Warnln("INSECURE: -insecure-test-auth is set, so clients may stop offering keys once one is accepted and their remaining keys won't be checked. Never use this flag in production.")
+		config.PublicKeyCallback = testPublicKeyCallback
+	}
 
 	loadBlacklistedKeys()
+	checks := []selfCheck{{"the blacklist", func() error {
+		_, err := readBlacklists(blacklistPath, true)
+		return err
+	}}}
+
+	if path := os.Getenv("WELL_KNOWN_KEYS_FILE"); path != "" {
+		loadWellKnownKeys(path)
+		checks = append(checks, selfCheck{"WELL_KNOWN_KEYS_FILE", func() error {
+			_, err := readPublishedKeys(path)
+			return err
+		}})
+	}
+	if path := os.Getenv("CONTAINER_IMAGE_KEYS_FILE"); path != "" {
+		loadContainerImageKeys(path)
+		checks = append(checks, selfCheck{"CONTAINER_IMAGE_KEYS_FILE", func() error {
+			_, err := readPublishedKeys(path)
+			return err
+		}})
+	}
+	if path := os.Getenv("KNOWN_FACTORS_FILE"); path != "" {
+		if err := loadKnownFactors(path); err != nil {
+			log.Fatalln("Failed to load known factors:", err)
+		}
 
-	private, err := ssh.ParsePrivateKey([]byte(os.Getenv("HOST_PRIVATE_KEY")))
+		checks = append(checks, selfCheck{"KNOWN_FACTORS_FILE", func() error {
+			_, err := readKnownFactors(path)
+			return err
+		}})
+	}
+
+	var reloads []func()
+	if path := os.Getenv("EXEMPT_KEYS_FILE"); path != "" {
+		if err := loadExemptions(path); err != nil {
+			log.Fatalln("Failed to load exempt keys:", err)
+		}
+
+		reloads = append(reloads, func() {
+			if err := loadExemptions(path); err != nil {
+				log.Errorln("Failed to reload exempt keys, keeping the existing list:", err)
+			}
+		})
+		checks = append(checks, selfCheck{"EXEMPT_KEYS_FILE", func() error {
+			_, err := readExemptions(path)
+			return err
+		}})
+	}
+	if path := os.Getenv("BLACKLIST_FILE"); path != "" {
+		if err := loadLocalBlacklist(path); err != nil {
+			log.Fatalln("Failed to load BLACKLIST_FILE:", err)
+		}
+
+		reloads = append(reloads, func() {
+			if err := loadLocalBlacklist(path); err != nil {
+				log.Errorln("Failed to reload BLACKLIST_FILE, keeping the existing list:", err)
+			}
+		})
+		checks = append(checks, selfCheck{"BLACKLIST_FILE", func() error {
+			_, _, err := readLocalBlacklist(path)
+			return err
+		}})
+	}
+	if path := os.Getenv("BLACKLIST_INDEX_FILE"); path != "" {
+		loadBlacklistIndex(path)
+	}
+	if path := os.Getenv("KRL_FILE"); path != "" {
+		if err := loadKRL(path); err != nil {
+			log.Fatalln("Failed to load KRL:", err)
+		}
+
+		reloads = append(reloads, func() {
+			if err := loadKRL(path); err != nil {
+				log.Errorln("Failed to reload KRL, keeping the existing list:", err)
+			}
+		})
+		checks = append(checks, selfCheck{"KRL_FILE", func() error {
+			_, err := readKRL(path)
+			return err
+		}})
+	}
+	if path := os.Getenv("REVOKED_SERIALS_FILE"); path != "" {
+		if err := loadRevokedSerials(path); err != nil {
+			log.Fatalln("Failed to load revoked serials:", err)
+		}
+
+		reloads = append(reloads, func() {
+			if err := loadRevokedSerials(path); err != nil {
+				log.Errorln("Failed to reload revoked serials, keeping the existing list:", err)
+			}
+		})
+		checks = append(checks, selfCheck{"REVOKED_SERIALS_FILE", func() error {
+			_, err := readRevokedSerials(path)
+			return err
+		}})
+	}
+	if target := os.Getenv("AUDIT_LOG"); target != "" {
+		if err := openAuditLog(target); err != nil {
+			log.Fatalln("Failed to open audit log:", err)
+		}
+
+		reloads = append(reloads, func() {
+			if err := reopenAuditLog(); err != nil {
+				log.Errorln("Failed to reopen audit log, writing to the existing file:", err)
+			}
+		})
+	}
+	go reloadOnHangup(reloads...)
+	go runSelfChecks(checks)
+
+	var err error
+	if (*demo || *check) && os.Getenv("HOST_PRIVATE_KEY") == "" {
+		hostKey, err = demoHostKey()
+	} else {
+		hostKey, err = ssh.ParsePrivateKey([]byte(os.Getenv("HOST_PRIVATE_KEY")))
+	}
 	if err != nil {
 		log.Fatalln("Failed to parse host private key")
 	}
-	config.AddHostKey(private)
+	config.AddHostKey(hostKey)
+
+	if err := setupReportSigner(); err != nil {
+		log.Fatalln("Failed to set up report signing:", err)
+	}
+
+	// The demo and checks listen on any free port, and exit once the
+	// report has been printed
+	if *demo || *check {
+		listener, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			log.Fatalln("Failed to listen for connection:", err)
+		}
+
+		startWorkers(config)
+		go accept(listener)
+		if *check {
+			os.Exit(runCheck(listener.Addr().String(), flag.Args()))
+		}
+		os.Exit(runDemo(listener.Addr().String()))
+	}
 
 	addr := os.Getenv("ADDR")
 	if addr == "" {
 		addr = "localhost:2022"
 	}
 
-	listener, err := net.Listen("tcp", addr)
+	listener, err := activatedListener()
 	if err != nil {
-		log.Fatalf("Failed to listen for connection on %s, perhaps that port is already in use", addr)
+		log.Fatalln("Failed to use socket passed by systemd:", err)
+	}
+
+	if listener != nil {
+		log.Infoln("Listening on", listener.Addr(), "using systemd socket activation")
+	} else {
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Failed to listen for connection on %s, perhaps that port is already in use", addr)
+		}
+
+		log.Infoln("Listening on", addr)
+
+		// systemd sets the backlog of the sockets it passes, using
+		// Backlog= in the socket unit
+		tuneListener(listener)
+	}
+
+	listeners := []net.Listener{tunedListener{listener}}
+
+	startWorkers(config)
+	go evictSessions()
+	if banThreshold > 0 {
+		go expireBans()
+	}
+	if statsInterval > 0 {
+		go logStatsEvery(statsInterval)
+	}
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		serveMetrics(addr, os.Getenv("METRICS_TLS_CERT_FILE"), os.Getenv("METRICS_TLS_KEY_FILE"))
+	}
+
+	// Optionally accept SSH wrapped in TLS, for clients behind firewalls
+	// that only allow outbound connections to port 443
+	if tlsAddr := os.Getenv("TLS_ADDR"); tlsAddr != "" {
+		cert, err := tls.LoadX509KeyPair(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+		if err != nil {
+			log.Fatalln("Failed to load TLS certificate and key:", err)
+		}
+
+		tlsListener, err := net.Listen("tcp", tlsAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for connection on %s, perhaps that port is already in use", tlsAddr)
+		}
+
+		log.Infoln("Listening for SSH over TLS on", tlsAddr)
+		tuneListener(tlsListener)
+
+		listeners = append(listeners, tls.NewListener(tunedListener{tlsListener}, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}))
 	}
 
-	log.Infoln("Listening on", addr)
+	// Optionally accept SSH over WebSockets, for browser-based clients on
+	// machines where only a browser can be used
+	if wsAddr := os.Getenv("WEBSOCKET_ADDR"); wsAddr != "" {
+		wsListener, err := net.Listen("tcp", wsAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for connection on %s, perhaps that port is already in use", wsAddr)
+		}
+		tuneListener(wsListener)
+
+		var l net.Listener = tunedListener{wsListener}
+		if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" || keyFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				log.Fatalln("Failed to load TLS certificate and key:", err)
+			}
+			l = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+			log.Infoln("Listening for SSH over secure WebSockets on", wsAddr)
+		} else {
+			log.Infoln("Listening for SSH over WebSockets on", wsAddr)
+		}
 
+		listeners = append(listeners, newWebsocketListener(l))
+	}
+
+	serveUntilSignalled(listeners...)
+}
+
+func accept(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-stopping:
+				return
+			default:
+			}
+
 			log.Warnln("Accept failed:", err)
 			continue
 		}
 
-		go serve(config, conn)
+		// Banned clients are turned away without a word, as they're
+		// unlikely to be listening
+		traced := trace(conn)
+		if banned(conn.RemoteAddr()) {
+			traced.logger().Debugln("Rejected connection from banned address", conn.RemoteAddr())
+			traced.Close()
+			continue
+		}
+
+		enqueue(traced)
 	}
 }
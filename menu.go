@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// menu lets interactive users ask for more details about their keys once
+// the report has been shown, until they quit or are idle for menuTimeout
+func menu(logger *log.Entry, channel ssh.Channel, input *lineReader, keys []*publicKey, transport func() string) {
+	write := func(s string) {
+		channel.Write([]byte(strings.Replace(s, "\n", "\n\r", -1)))
+	}
+
+	// Closing the channel makes ReadLine return, ending the menu
+	idle := clk.AfterFunc(menuTimeout, func() {
+		write("\nClosing idle session.\n")
+		atomic.AddUint64(&reaped.interactive, 1)
+		channel.Close()
+	})
+	defer idle.Stop()
+
+	write(menuHelp)
+	for {
+		line, _, err := input.readLine("> ", nil)
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnln("Failed to read from menu:", err)
+			}
+			return
+		}
+
+		if !idle.Stop() {
+			return
+		}
+
+		switch strings.TrimSpace(line) {
+		case "":
+		case "keys":
+			for _, k := range keys {
+				write(string(ssh.MarshalAuthorizedKey(k.key)))
+			}
+		case "randomart":
+			for _, k := range keys {
+				write(k.FingerprintSHA256() + "\n" + k.Randomart())
+			}
+		case "transport":
+			channel.Write([]byte(transport()))
+		case "help":
+			write(menuHelp)
+		case "quit", "exit":
+			return
+		default:
+			write("Unknown command; type help for a list of commands.\n")
+		}
+
+		idle.Reset(menuTimeout)
+	}
+}
+
+const menuHelp = `Type a command for more details, or quit to disconnect:
+  keys       show your public keys in authorized_keys format
+  randomart  show your keys' fingerprints as randomart
+  transport  show your SSH client's version and negotiated algorithms
+  quit       disconnect
+
+`
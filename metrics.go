@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sessionBuckets are the upper bounds, in seconds, of the buckets of the
+// session duration histogram
+var sessionBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metrics counts what is exposed to Prometheus in METRICS_ADDR. Each
+// report counts each issue it warns about once, by its name in severities,
+// however many keys have that issue.
+var metrics = struct {
+	sync.Mutex
+	handshakes, handshakeFailures uint64
+	findings                      map[string]uint64
+
+	// exemplars holds, for each issue, the most recent key found with it,
+	// if METRICS_EXEMPLARS is set
+	exemplars map[string]exemplar
+
+	// sessions counts the sessions in each of sessionBuckets, and those
+	// longer than the last bucket
+	sessions        []uint64
+	sessionsSeconds float64
+	sessionsCount   uint64
+}{
+	findings:  make(map[string]uint64),
+	exemplars: make(map[string]exemplar),
+	sessions:  make([]uint64, len(sessionBuckets)+1),
+}
+
+// exemplar links a count of findings to a key it was found with, by its
+// hashed fingerprint, as logged with LOG_FINGERPRINTS=hash, so that a rise
+// in an issue can be traced to the connections that caused it
+type exemplar struct {
+	fingerprint string
+	time        time.Time
+}
+
+// findingCategories maps the issues whose names in severities are too terse
+// to stand alone in a dashboard to the category they are counted under.
+// Other issues are counted under their names in severities.
+var findingCategories = map[string]string{
+	"weak":  "weak_rsa",
+	"agent": "agent_forwarding",
+}
+
+// findingCategory returns the category the issue is counted under
+func findingCategory(issue string) string {
+	if category, ok := findingCategories[issue]; ok {
+		return category
+	}
+
+	return issue
+}
+
+// recordHandshake counts a handshake, and whether it failed
+func recordHandshake(failed bool) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	metrics.handshakes++
+	if failed {
+		metrics.handshakeFailures++
+	}
+}
+
+// recordFindings counts the issues found in a report, named as in
+// severities, and records the first key found with each as its exemplar,
+// if METRICS_EXEMPLARS is set. Forwarding has no key, so no exemplar.
+func recordFindings(found map[string]bool, results []keyResult) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	for name, ok := range found {
+		if ok {
+			metrics.findings[name]++
+		}
+	}
+
+	if !metricsExemplars {
+		return
+	}
+	recorded := make(map[string]bool)
+	for _, r := range results {
+		if r.issue == issueExempt || r.issue == issueOutOfScope {
+			continue
+		}
+		for _, issue := range r.all {
+			name := issueSeverities[issue]
+			if found[name] && !recorded[name] {
+				metrics.exemplars[name] = exemplar{r.key.HashedFingerprint(), clk.Now()}
+				recorded[name] = true
+			}
+		}
+	}
+}
+
+// recordSession adds a session's duration to the histogram
+func recordSession(d time.Duration) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	i := sort.SearchFloat64s(sessionBuckets, d.Seconds())
+	metrics.sessions[i]++
+	metrics.sessionsSeconds += d.Seconds()
+	metrics.sessionsCount++
+}
+
+// openMetricsType is the content type of the OpenMetrics text format, which
+// Prometheus asks for when it supports exemplars
+const openMetricsType = "application/openmetrics-text"
+
+// writeMetrics writes the metrics in Prometheus' text format or, if the
+// client asks for it and METRICS_EXEMPLARS is set, in the OpenMetrics text
+// format, with the exemplars of the findings
+func writeMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	openMetrics := metricsExemplars && strings.Contains(r.Header.Get("Accept"), openMetricsType)
+	var b bytes.Buffer
+
+	// OpenMetrics names counters without their _total suffix
+	family := func(name, typ, help string) {
+		if openMetrics && typ == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	family("sshkeycheck_handshakes_total", "counter", "SSH handshakes attempted.")
+	fmt.Fprintln(&b, "sshkeycheck_handshakes_total", metrics.handshakes)
+	family("sshkeycheck_handshake_failures_total", "counter", "SSH handshakes that failed.")
+	fmt.Fprintln(&b, "sshkeycheck_handshake_failures_total", metrics.handshakeFailures)
+
+	family("sshkeycheck_reaped_total", "counter", "Connections closed for taking too long, by the timeout that closed them.")
+	for _, r := range reapedCounts() {
+		fmt.Fprintf(&b, "sshkeycheck_reaped_total{timeout=%q} %d\n", r.timeout, r.count)
+	}
+
+	family("sshkeycheck_workers", "gauge", "Workers serving connections.")
+	fmt.Fprintln(&b, "sshkeycheck_workers", workers)
+	family("sshkeycheck_workers_busy", "gauge", "Workers currently serving a connection.")
+	fmt.Fprintln(&b, "sshkeycheck_workers_busy", atomic.LoadInt32(&busyWorkers))
+	family("sshkeycheck_queue_capacity", "gauge", "Connections allowed to wait for a free worker.")
+	fmt.Fprintln(&b, "sshkeycheck_queue_capacity", queueDepth)
+	family("sshkeycheck_queue_depth", "gauge", "Connections currently waiting for a free worker.")
+	fmt.Fprintln(&b, "sshkeycheck_queue_depth", len(queue))
+
+	// Every issue is listed, so that rates can be taken of those not yet
+	// found
+	names := make([]string, 0, len(severities))
+	for name := range severities {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return findingCategory(names[i]) < findingCategory(names[j]) })
+	family("sshkeycheck_findings_total", "counter", "Reports warning about each category of issue.")
+	for _, name := range names {
+		fmt.Fprintf(&b, "sshkeycheck_findings_total{category=%q} %d", findingCategory(name), metrics.findings[name])
+		if e, ok := metrics.exemplars[name]; ok && openMetrics {
+			fmt.Fprintf(&b, " # {fingerprint=%q} 1 %s", e.fingerprint, strconv.FormatFloat(float64(e.time.UnixNano())/1e9, 'f', 3, 64))
+		}
+		fmt.Fprintln(&b)
+	}
+
+	family("sshkeycheck_session_duration_seconds", "histogram", "Duration of SSH sessions, from the end of the handshake.")
+	var cumulative uint64
+	for i, le := range sessionBuckets {
+		cumulative += metrics.sessions[i]
+		fmt.Fprintf(&b, "sshkeycheck_session_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(&b, "sshkeycheck_session_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.sessionsCount)
+	fmt.Fprintln(&b, "sshkeycheck_session_duration_seconds_sum", strconv.FormatFloat(metrics.sessionsSeconds, 'g', -1, 64))
+	fmt.Fprintln(&b, "sshkeycheck_session_duration_seconds_count", metrics.sessionsCount)
+
+	if openMetrics {
+		fmt.Fprintln(&b, "# EOF")
+		w.Header().Set("Content-Type", openMetricsType+"; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	}
+	w.Write(b.Bytes())
+}
+
+// serveMetrics exposes the metrics to Prometheus at /metrics on the given
+// address, which should be kept apart from the SSH listener and not be
+// reachable by the public. The listener uses TLS if certFile and keyFile
+// are given, and every endpoint requires metricsToken, if set.
+func serveMetrics(addr, certFile, keyFile string) {
+	if err := checkMetricsAddr(addr); err != nil {
+		log.Fatalln("Refusing to serve metrics:", err)
+	}
+
+	var config *tls.Config
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalln("Failed to load TLS certificate and key for metrics:", err)
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen for metrics on %s, perhaps that port is already in use", addr)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	log.Infoln("Serving metrics on", addr)
+
+	switch {
+	case loopbackAddr(addr):
+	case metricsToken == "":
+		log.Warnf("Metrics are served on %s WITHOUT AUTHENTICATION, as METRICS_INSECURE is set; make sure only Prometheus can reach it", addr)
+	case config == nil:
+		log.Warnf("Metrics are served on %s without TLS, so METRICS_TOKEN is sent in the clear; set METRICS_TLS_CERT_FILE and METRICS_TLS_KEY_FILE", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", writeMetrics)
+	if dashboard {
+		mux.HandleFunc("/dashboard", writeDashboard)
+		log.Infoln("Serving the dashboard on", addr)
+	}
+	server := &http.Server{
+		Handler:           requireToken(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Errorln("Stopped serving metrics:", err)
+		}
+	}()
+}
+
+// checkMetricsAddr returns an error if metrics would be served on the
+// address to anyone who can reach it, i.e. it isn't a loopback address and
+// no METRICS_TOKEN is set, unless METRICS_INSECURE says that's intended
+func checkMetricsAddr(addr string) error {
+	if loopbackAddr(addr) || metricsToken != "" || metricsInsecure {
+		return nil
+	}
+
+	return fmt.Errorf("%s isn't a loopback address, so requires METRICS_TOKEN; set METRICS_INSECURE to true if only Prometheus can reach it", addr)
+}
+
+// requireToken rejects requests that don't give metricsToken as a bearer
+// token, or as the password of basic authentication, for clients that only
+// support that. Every request is allowed if no token is set.
+func requireToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if metricsToken == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+			// Browsers only ask for a password if offered basic
+			// authentication, as they would for the dashboard
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sshkeycheck"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="sshkeycheck"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loopbackAddr reports whether the address only listens on a loopback
+// interface. An address with no host listens on every interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics.Lock()
+	previous := metrics.findings
+	metrics.findings = make(map[string]uint64)
+	metrics.Unlock()
+	defer func() {
+		metrics.Lock()
+		metrics.findings = previous
+		metrics.Unlock()
+	}()
+
+	recordFindings(map[string]bool{"blacklisted": true, "weak": true, "agent": true, "dsa": false}, nil)
+	recordFindings(map[string]bool{"weak": true, "x11": true}, nil)
+
+	w := httptest.NewRecorder()
+	writeMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`sshkeycheck_findings_total{category="blacklisted"} 1`,
+		`sshkeycheck_findings_total{category="dsa"} 0`,
+		`sshkeycheck_findings_total{category="weak_rsa"} 2`,
+		`sshkeycheck_findings_total{category="agent_forwarding"} 1`,
+		`sshkeycheck_findings_total{category="x11"} 1`,
+		`sshkeycheck_workers_busy 0`,
+		`sshkeycheck_queue_depth 0`,
+		fmt.Sprintf("sshkeycheck_workers %d", workers),
+		fmt.Sprintf("sshkeycheck_queue_capacity %d", queueDepth),
+		fmt.Sprintf(`sshkeycheck_reaped_total{timeout="handshake"} %d`, atomic.LoadUint64(&reaped.handshake)),
+		fmt.Sprintf(`sshkeycheck_reaped_total{timeout="channel"} %d`, atomic.LoadUint64(&reaped.channel)),
+		fmt.Sprintf(`sshkeycheck_reaped_total{timeout="interactive"} %d`, atomic.LoadUint64(&reaped.interactive)),
+		fmt.Sprintf(`sshkeycheck_reaped_total{timeout="session"} %d`, atomic.LoadUint64(&reaped.session)),
+		fmt.Sprintf(`sshkeycheck_reaped_total{timeout="write"} %d`, atomic.LoadUint64(&reaped.write)),
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %s in:\n%s", line, body)
+		}
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr     string
+		loopback bool
+	}{
+		{"127.0.0.1:9122", true},
+		{"[::1]:9122", true},
+		{"localhost:9122", true},
+		{":9122", false},
+		{"0.0.0.0:9122", false},
+		{"192.0.2.1:9122", false},
+		{"metrics.example.com:9122", false},
+	} {
+		if loopback := loopbackAddr(test.addr); loopback != test.loopback {
+			t.Errorf("%s: got %t, expected %t", test.addr, loopback, test.loopback)
+		}
+	}
+}
+
+// Metrics can only be served without a token on addresses other than
+// loopback if that's explicitly allowed
+func TestCheckMetricsAddr(t *testing.T) {
+	defer func(token string, insecure bool) { metricsToken, metricsInsecure = token, insecure }(metricsToken, metricsInsecure)
+
+	for _, test := range []struct {
+		addr     string
+		token    string
+		insecure bool
+		allowed  bool
+	}{
+		{"127.0.0.1:9122", "", false, true},
+		{":9122", "", false, false},
+		{"192.0.2.1:9122", "", false, false},
+		{"192.0.2.1:9122", "secret", false, true},
+		{"192.0.2.1:9122", "", true, true},
+	} {
+		metricsToken, metricsInsecure = test.token, test.insecure
+		if err := checkMetricsAddr(test.addr); (err == nil) != test.allowed {
+			t.Errorf("%s, token %q, insecure %t: got %v, expected allowed %t", test.addr, test.token, test.insecure, err, test.allowed)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	defer func(token string) { metricsToken = token }(metricsToken)
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"no token required", "", func(r *http.Request) {}, http.StatusOK},
+		{"no token given", "s3cret", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer token", "s3cret", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"wrong bearer token", "s3cret", func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, http.StatusUnauthorized},
+		{"token without scheme", "s3cret", func(r *http.Request) { r.Header.Set("Authorization", "s3cret") }, http.StatusOK},
+		{"basic authentication", "s3cret", func(r *http.Request) { r.SetBasicAuth("prometheus", "s3cret") }, http.StatusOK},
+		{"wrong basic password", "s3cret", func(r *http.Request) { r.SetBasicAuth("s3cret", "guess") }, http.StatusUnauthorized},
+	} {
+		metricsToken = test.token
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		test.auth(r)
+		w := httptest.NewRecorder()
+		requireToken(http.HandlerFunc(writeMetrics)).ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d", test.name, w.Code, test.status)
+		}
+		if authorized := strings.Contains(w.Body.String(), "sshkeycheck_handshakes_total"); authorized != (test.status == http.StatusOK) {
+			t.Errorf("%s: got metrics %t", test.name, authorized)
+		}
+	}
+}
+
+// Exemplars are only given when scraped as OpenMetrics, with
+// METRICS_EXEMPLARS set, and link each finding to a key found with it
+func TestWriteMetricsExemplars(t *testing.T) {
+	defer func(enabled bool) { metricsExemplars = enabled }(metricsExemplars)
+	metrics.Lock()
+	findings, exemplars := metrics.findings, metrics.exemplars
+	metrics.findings, metrics.exemplars = make(map[string]uint64), make(map[string]exemplar)
+	metrics.Unlock()
+	defer func() {
+		metrics.Lock()
+		metrics.findings, metrics.exemplars = findings, exemplars
+		metrics.Unlock()
+	}()
+	useFakeClock(t)
+
+	metricsExemplars = true
+	a := analyzeKeys(generateKey(t, "rsa-1024"), generateKey(t, "dsa-1024"))
+	recordFindings(map[string]bool{"weak": true, "dsa": true, "agent": true}, a.results)
+	weak := `sshkeycheck_findings_total{category="weak_rsa"} 1 # {fingerprint="` + a.results[0].key.HashedFingerprint() + `"} 1 1767225600.000`
+
+	for _, test := range []struct {
+		name      string
+		exemplars bool
+		accept    string
+		expected  []string
+		absent    []string
+	}{
+		{
+			name:      "OpenMetrics",
+			exemplars: true,
+			accept:    "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			expected: []string{
+				"# TYPE sshkeycheck_findings counter",
+				weak,
+				`sshkeycheck_findings_total{category="dsa"} 1 # {fingerprint="` + a.results[1].key.HashedFingerprint() + `"} 1 1767225600.000`,
+				`sshkeycheck_findings_total{category="agent_forwarding"} 1` + "\n",
+				"# TYPE sshkeycheck_session_duration_seconds histogram",
+			},
+		},
+		{
+			name:      "Prometheus text format",
+			exemplars: true,
+			accept:    "text/plain",
+			expected:  []string{"# TYPE sshkeycheck_findings_total counter", `sshkeycheck_findings_total{category="weak_rsa"} 1` + "\n"},
+			absent:    []string{"# {", "# EOF"},
+		},
+		{
+			name:     "exemplars disabled",
+			accept:   "application/openmetrics-text",
+			expected: []string{`sshkeycheck_findings_total{category="weak_rsa"} 1` + "\n"},
+			absent:   []string{"# {", "# EOF"},
+		},
+	} {
+		metricsExemplars = test.exemplars
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		writeMetrics(w, r)
+
+		body := w.Body.String()
+		for _, s := range test.expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: expected %s in:\n%s", test.name, s, body)
+			}
+		}
+		for _, s := range test.absent {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: unexpected %s in:\n%s", test.name, s, body)
+			}
+		}
+		if openMetrics := strings.HasPrefix(w.Header().Get("Content-Type"), openMetricsType); openMetrics != (test.absent == nil) || openMetrics && !strings.HasSuffix(body, "\n# EOF\n") {
+			t.Errorf("%s: got content type %q", test.name, w.Header().Get("Content-Type"))
+		}
+	}
+}
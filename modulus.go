@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math/big"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// fermatRounds is how many steps of Fermat's factorisation method to try.
+// Keys whose primes are close enough to be found this quickly were made by
+// a generator that chose the second prime by searching upwards from the
+// first, as in CVE-2022-26320.
+const fermatRounds = 100
+
+// smallPrimeLimit bounds the primes in smallPrimes
+const smallPrimeLimit = 10000
+
+// smallPrimes is the product of the primes below smallPrimeLimit. No
+// properly generated modulus has such a factor.
+var smallPrimes = func() *big.Int {
+	product := big.NewInt(1)
+	composite := make([]bool, smallPrimeLimit)
+	for p := 2; p < smallPrimeLimit; p++ {
+		if composite[p] {
+			continue
+		}
+		product.Mul(product, big.NewInt(int64(p)))
+		for m := p * p; m < smallPrimeLimit; m += p {
+			composite[m] = true
+		}
+	}
+
+	return product
+}()
+
+// modulusWeakness applies heuristic checks for signs of a flawed RSA key
+// generator to the modulus n, returning which check failed, if any. Each
+// check that fails means the modulus has been factored, so false positives
+// aren't possible, but passing the checks doesn't mean the key was
+// generated properly. Small factors are found by knownFactorOf, which
+// always applies. The checks give up once stop is closed.
+func modulusWeakness(n *big.Int, stop <-chan struct{}) (string, bool) {
+	// Fermat's method: n = a^2 - b^2 = (a+b)(a-b), starting from the
+	// square root of n
+	a := new(big.Int).Sqrt(n)
+	if new(big.Int).Mul(a, a).Cmp(n) < 0 {
+		a.Add(a, big.NewInt(1))
+	}
+	b2, b := new(big.Int), new(big.Int)
+	for i := 0; i < fermatRounds && !stopped(stop); i++ {
+		b2.Mul(a, a)
+		b2.Sub(b2, n)
+		b.Sqrt(b2)
+		if new(big.Int).Mul(b, b).Cmp(b2) == 0 {
+			return "has primes close enough together to be found using Fermat's method", true
+		}
+		a.Add(a, big.NewInt(1))
+	}
+
+	return "", false
+}
+
+// sharedPrimes finds the RSA keys whose moduli share a prime factor with
+// another key's, as happens when keys are generated with too little
+// entropy, and maps each to the fingerprint of a key it shares a prime
+// with. Both moduli can then be factored. It gives up once stop is closed.
+func sharedPrimes(keys []*publicKey, stop <-chan struct{}) map[*publicKey]string {
+	var rsaKeys []*publicKey
+	var moduli []*big.Int
+	for _, k := range keys {
+		if certifiedType(k) != ssh.KeyAlgoRSA {
+			continue
+		}
+		if n, err := keycheck.RSAModulus(k.key); err == nil {
+			rsaKeys = append(rsaKeys, k)
+			moduli = append(moduli, n)
+		}
+	}
+
+	shared := make(map[*publicKey]string)
+	g := new(big.Int)
+	for i, n := range moduli {
+		if stopped(stop) {
+			break
+		}
+		for j, m := range moduli {
+			if i == j || n.Cmp(m) == 0 {
+				continue
+			}
+			if g.GCD(nil, nil, n, m); g.Cmp(big.NewInt(1)) != 0 {
+				shared[rsaKeys[i]] = rsaKeys[j].Fingerprint()
+				break
+			}
+		}
+	}
+
+	return shared
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// testPrime returns a random 1024 bit prime
+func testPrime(t testing.TB) *big.Int {
+	p, err := rand.Prime(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// primeAfter returns the smallest prime greater than n
+func primeAfter(n *big.Int) *big.Int {
+	p := new(big.Int).Add(n, big.NewInt(1))
+	for !p.ProbablyPrime(20) {
+		p.Add(p, big.NewInt(1))
+	}
+
+	return p
+}
+
+// modulusKey returns an RSA public key with the given modulus
+func modulusKey(t testing.TB, n *big.Int) ssh.PublicKey {
+	key, err := ssh.NewPublicKey(&rsa.PublicKey{N: n, E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestModulusWeakness(t *testing.T) {
+	p, q := testPrime(t), testPrime(t)
+	generated, err := keycheck.RSAModulus(generateKey(t, "rsa-2048"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	close(stop)
+
+	for _, test := range []struct {
+		name string
+		n    *big.Int
+		stop <-chan struct{}
+		weak bool
+	}{
+		// As generated by searching upwards from the first prime for the
+		// next, as in CVE-2022-26320
+		{"consecutive primes", new(big.Int).Mul(p, primeAfter(p)), nil, true},
+		{"primes a little apart", new(big.Int).Mul(p, primeAfter(new(big.Int).Add(p, big.NewInt(1<<20)))), nil, true},
+		{"unrelated primes", new(big.Int).Mul(p, q), nil, false},
+		{"generated key", generated, nil, false},
+		{"checks stopped", new(big.Int).Mul(p, primeAfter(p)), stop, false},
+	} {
+		if reason, weak := modulusWeakness(test.n, test.stop); weak != test.weak || (reason != "") != test.weak {
+			t.Errorf("%s: got %q, %t, expected %t", test.name, reason, weak, test.weak)
+		}
+	}
+}
+
+func TestSharedPrimes(t *testing.T) {
+	p, q, r, s := testPrime(t), testPrime(t), testPrime(t), testPrime(t)
+	pq := &publicKey{key: modulusKey(t, new(big.Int).Mul(p, q))}
+	pr := &publicKey{key: modulusKey(t, new(big.Int).Mul(p, r))}
+	rs := &publicKey{key: modulusKey(t, new(big.Int).Mul(r, s))}
+	unrelated := &publicKey{key: generateKey(t, "rsa-2048")}
+	pqAgain := &publicKey{key: modulusKey(t, new(big.Int).Mul(p, q))}
+
+	shared := sharedPrimes([]*publicKey{pq, unrelated, pr, rs, pqAgain, {key: generateKey(t, "ecdsa-256")}}, nil)
+	for _, test := range []struct {
+		name     string
+		key      *publicKey
+		expected string
+	}{
+		{"p*q", pq, pr.Fingerprint()},
+		{"p*r", pr, pq.Fingerprint()},
+		{"r*s", rs, pr.Fingerprint()},
+		{"unrelated", unrelated, ""},
+	} {
+		if other := shared[test.key]; other != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, other, test.expected)
+		}
+	}
+}
+
+// The experimental checks only apply if EXPERIMENTAL_MODULUS_CHECKS is set
+func TestAnalyzeModulusChecks(t *testing.T) {
+	defer func(enabled bool) { modulusChecks = enabled }(modulusChecks)
+
+	p, q := testPrime(t), testPrime(t)
+	near := modulusKey(t, new(big.Int).Mul(p, primeAfter(p)))
+	pq, pr := modulusKey(t, new(big.Int).Mul(p, q)), modulusKey(t, new(big.Int).Mul(p, testPrime(t)))
+
+	for _, enabled := range []bool{false, true} {
+		modulusChecks = enabled
+		a := analyzeKeys(near, pq, pr, generateKey(t, "rsa-2048"))
+
+		var flagged []bool
+		for _, r := range a.results {
+			flagged = append(flagged, r.issue == issueWeakModulus)
+		}
+		expected := []bool{enabled, enabled, enabled, false}
+		for i := range expected {
+			if flagged[i] != expected[i] {
+				t.Errorf("checks enabled %t: key %d flagged %t, expected %t (%q)", enabled, i+1, flagged[i], expected[i], a.weakModuli)
+			}
+		}
+	}
+}
+
+func BenchmarkModulusWeakness(b *testing.B) {
+	for _, name := range []string{"rsa-2048", "rsa-4096"} {
+		b.Run(name, func(b *testing.B) {
+			n, err := keycheck.RSAModulus(generateKey(b, name))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				modulusWeakness(n, nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattbostock/sshkeycheck/keycheck"
+	"golang.org/x/crypto/ssh"
+)
+
+// policy describes the keys accepted by a particular SSH implementation,
+// version or standard
+type policy struct {
+	name string
+
+	// rejected maps the key types that aren't accepted to the reason why
+	rejected map[string]string
+
+	// minBits maps key types to the minimum length accepted for that type
+	minBits map[string]int
+}
+
+// openssh9 describes the keys accepted by OpenSSH 9.x with its default
+// PubkeyAcceptedAlgorithms and RequiredRSASize settings. RSA keys are still
+// accepted, but only using rsa-sha2-256 or rsa-sha2-512 signatures since
+// the ssh-rsa (SHA-1) signature algorithm was disabled in OpenSSH 8.8.
+var openssh9 = policy{
+	name: "OpenSSH 9",
+	rejected: map[string]string{
+		ssh.KeyAlgoDSA: "DSA disabled since OpenSSH 7.0",
+	},
+	minBits: map[string]int{
+		ssh.KeyAlgoRSA: 1024,
+	},
+}
+
+// fips140 describes the keys permitted by FIPS 140 key size guidance (NIST
+// SP 800-131A): RSA keys of at least 2048 bits and ECDSA keys using the P-256
+// curve or larger, which are all the curves SSH supports. DSA is not
+// permitted for signing.
+var fips140 = policy{
+	name: "FIPS 140",
+	rejected: map[string]string{
+		ssh.KeyAlgoDSA: "DSA not permitted",
+	},
+	minBits: map[string]int{
+		ssh.KeyAlgoRSA:      2048,
+		ssh.KeyAlgoECDSA256: 256,
+		ssh.KeyAlgoECDSA384: 256,
+		ssh.KeyAlgoECDSA521: 256,
+	},
+}
+
+// fips140Strict also rejects Ed25519, which FIPS 140-2 validated modules
+// don't implement
+var fips140Strict = policy{
+	name: "FIPS 140 (strict)",
+	rejected: map[string]string{
+		ssh.KeyAlgoDSA: "DSA not permitted",
+		keyAlgoED25519: "Ed25519 not permitted",
+	},
+	minBits: fips140.minBits,
+}
+
+// serverPolicies describes the keys accepted by commonly encountered
+// servers, so that users can be told where each of their keys is likely to
+// be rejected. Policies should be listed from oldest to newest.
+var serverPolicies = []policy{
+	{
+		name: "OpenSSH 7.0 and above",
+		rejected: map[string]string{
+			ssh.KeyAlgoDSA: "DSA disabled by default",
+		},
+	},
+	{
+		name: "OpenSSH 7.6 and above",
+		minBits: map[string]int{
+			ssh.KeyAlgoRSA: 1024,
+		},
+	},
+	{
+		name: "GitHub",
+		rejected: map[string]string{
+			ssh.KeyAlgoDSA: "DSA keys not accepted since 2022",
+		},
+	},
+	{
+		name: "Servers requiring 2048 bit keys, e.g. per NIST SP 800-131A",
+		rejected: map[string]string{
+			ssh.KeyAlgoDSA: "DSA not permitted",
+		},
+		minBits: map[string]int{
+			ssh.KeyAlgoRSA: 2048,
+		},
+	},
+}
+
+// rejectedBy lists the server policies that would reject the key, and why
+func rejectedBy(k *publicKey) []string {
+	var rejections []string
+	for _, p := range serverPolicies {
+		if ok, reason := p.accepts(k); !ok {
+			rejections = append(rejections, p.name+": "+reason)
+		}
+	}
+
+	return rejections
+}
+
+// accepts reports whether the policy accepts the given key and, if not, why
+func (p policy) accepts(k *publicKey) (bool, string) {
+	if reason, ok := p.rejected[k.key.Type()]; ok {
+		return false, reason
+	}
+
+	if min, ok := p.minBits[k.key.Type()]; ok {
+		length, err := k.BitLen()
+		if err != nil {
+			return false, "unknown key length"
+		}
+		if length < min {
+			return false, fmt.Sprintf("less than %d bits", min)
+		}
+	}
+
+	return true, ""
+}
+
+// deprecation describes a way of using a key that OpenSSH still accepts in
+// some versions, but has deprecated, so that users can stop relying on it
+// before it stops working
+type deprecation struct {
+	// applies reports whether the deprecation applies to the key, given
+	// the client's key exchange offer, which may be nil if it couldn't be
+	// recorded
+	applies func(k *publicKey, client *kexInitMsg) bool
+
+	description string
+}
+
+// deprecations lists the deprecated behaviours that can be detected
+var deprecations = []deprecation{
+	{
+		// Weak RSA keys are flagged as such, with advice covering both
+		applies: func(k *publicKey, client *kexInitMsg) bool {
+			length, err := k.BitLen()
+			return k.key.Type() == ssh.KeyAlgoRSA && sha1Only(client) && (err != nil || length >= keycheck.MinRSABits)
+		},
+		description: "signed using ssh-rsa (SHA-1) as your client can't negotiate rsa-sha2 (deprecated in OpenSSH 8.2, disabled in 8.8)",
+	},
+}
+
+// deprecated lists the deprecations that apply to each of the keys
+func deprecated(keys []*publicKey, client *kexInitMsg) []string {
+	var found []string
+	for _, k := range keys {
+		for _, d := range deprecations {
+			if d.applies(k, client) {
+				found = append(found, k.Fingerprint()+": "+d.description)
+			}
+		}
+	}
+
+	return found
+}
+
+// sha1Only reports whether the client can only sign using ssh-rsa (SHA-1)
+// with RSA keys. Clients learn that the server supports rsa-sha2 signatures
+// through the server-sig-algs extension (RFC 8308), which they must ask for
+// by offering ext-info-c.
+func sha1Only(client *kexInitMsg) bool {
+	return client != nil && !offers(client.KexAlgos, "ext-info-c")
+}
+
+func offers(algos []string, algo string) bool {
+	for _, a := range algos {
+		if a == algo {
+			return true
+		}
+	}
+
+	return false
+}
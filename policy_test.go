@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// shortRSAKey returns an RSA public key of the given length, which needn't
+// have a private key, as Go won't generate keys shorter than 1024 bits
+func shortRSAKey(t *testing.T, bits int) ssh.PublicKey {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	key, err := ssh.NewPublicKey(&rsa.PublicKey{N: n.Add(n, big.NewInt(1)), E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestAcceptance(t *testing.T) {
+	defer func(p *policy) { fips = p }(fips)
+
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		openssh9 string
+		fips     string
+		strict   string
+	}{
+		{"rsa-768", shortRSAKey(t, 768), "No: less than 1024 bits", "Non-compliant: less than 2048 bits", "Non-compliant: less than 2048 bits"},
+		{"rsa-1024", generateKey(t, "rsa-1024"), "Yes", "Non-compliant: less than 2048 bits", "Non-compliant: less than 2048 bits"},
+		{"rsa-2048", generateKey(t, "rsa-2048"), "Yes", "Compliant", "Compliant"},
+		{"dsa-1024", generateKey(t, "dsa-1024"), "No: DSA disabled since OpenSSH 7.0", "Non-compliant: DSA not permitted", "Non-compliant: DSA not permitted"},
+		{"ecdsa-256", generateKey(t, "ecdsa-256"), "Yes", "Compliant", "Compliant"},
+		{"ecdsa-384", generateKey(t, "ecdsa-384"), "Yes", "Compliant", "Compliant"},
+	} {
+		k := &publicKey{key: test.key}
+		if got := acceptance(k); got != test.openssh9 {
+			t.Errorf("%s: accepted by OpenSSH 9 %q, expected %q", test.name, got, test.openssh9)
+		}
+
+		for _, p := range []struct {
+			policy   *policy
+			expected string
+		}{
+			{&fips140, test.fips},
+			{&fips140Strict, test.strict},
+		} {
+			fips = p.policy
+			if got, _ := fipsCompliance(k); got != p.expected {
+				t.Errorf("%s: %s %q, expected %q", test.name, p.policy.name, got, p.expected)
+			}
+		}
+	}
+}
+
+func TestRejectedBy(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		expected []string
+	}{
+		{"rsa-768", shortRSAKey(t, 768), []string{
+			"OpenSSH 7.6 and above: less than 1024 bits",
+			"Servers requiring 2048 bit keys, e.g. per NIST SP 800-131A: less than 2048 bits",
+		}},
+		{"rsa-2048", generateKey(t, "rsa-2048"), nil},
+		{"dsa-1024", generateKey(t, "dsa-1024"), []string{
+			"OpenSSH 7.0 and above: DSA disabled by default",
+			"GitHub: DSA keys not accepted since 2022",
+			"Servers requiring 2048 bit keys, e.g. per NIST SP 800-131A: DSA not permitted",
+		}},
+	} {
+		got := rejectedBy(&publicKey{key: test.key})
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+				break
+			}
+		}
+	}
+}
+
+// RSA keys are only deprecated for clients that can't negotiate rsa-sha2
+// signatures
+func TestDeprecated(t *testing.T) {
+	rsaKey := &publicKey{key: generateKey(t, "rsa-2048")}
+	weakKey := &publicKey{key: generateKey(t, "rsa-1024")}
+	ecdsaKey := &publicKey{key: generateKey(t, "ecdsa-256")}
+	sha1Only := &kexInitMsg{KexAlgos: []string{"ecdh-sha2-nistp256"}}
+	sha2 := &kexInitMsg{KexAlgos: []string{"ecdh-sha2-nistp256", "ext-info-c"}}
+
+	for _, test := range []struct {
+		name     string
+		key      *publicKey
+		client   *kexInitMsg
+		expected int
+	}{
+		{"RSA key, SHA-1 only", rsaKey, sha1Only, 1},
+		{"RSA key, rsa-sha2", rsaKey, sha2, 0},
+		{"RSA key, unknown client", rsaKey, nil, 0},
+		// Weak keys are flagged as such instead
+		{"weak RSA key, SHA-1 only", weakKey, sha1Only, 0},
+		{"ECDSA key, SHA-1 only", ecdsaKey, sha1Only, 0},
+	} {
+		if got := deprecated([]*publicKey{test.key}, test.client); len(got) != test.expected {
+			t.Errorf("%s: got %q, expected %d deprecation(s)", test.name, got, test.expected)
+		}
+	}
+}
+
+// Short RSA keys presented by clients that can only sign with them using
+// SHA-1 are flagged as a single issue, covering both
+func TestWeakSHA1Only(t *testing.T) {
+	sha1Client := &kexInitMsg{KexAlgos: []string{"diffie-hellman-group14-sha1"}}
+	sha2Client := &kexInitMsg{KexAlgos: []string{"curve25519-sha256", "ext-info-c"}}
+
+	for _, test := range []struct {
+		name     string
+		key      ssh.PublicKey
+		client   *kexInitMsg
+		issue    string
+		weakSHA1 bool
+	}{
+		{"short key, SHA-1 only", generateKey(t, "rsa-1024"), sha1Client, issueWeakSHA1, true},
+		{"short key, SHA-2", generateKey(t, "rsa-1024"), sha2Client, issueWeak, false},
+		{"short key, unknown client", generateKey(t, "rsa-1024"), nil, issueWeak, false},
+		{"long key, SHA-1 only", generateKey(t, "rsa-2048"), sha1Client, issueNone, false},
+		{"ECDSA key, SHA-1 only", generateKey(t, "ecdsa-256"), sha1Client, issueNone, false},
+	} {
+		a := analyze(testLogger, []*publicKey{{key: test.key}}, test.client, nil)
+		if len(a.results) != 1 || a.results[0].issue != test.issue || a.weakSHA1 != test.weakSHA1 {
+			t.Errorf("%s: got %d row(s), %q, SHA-1 only %t, expected %q, %t", test.name, len(a.results), a.results[0].issue, a.weakSHA1, test.issue, test.weakSHA1)
+		}
+	}
+}
+
+// The single issue gets advice covering both the key and the client, in
+// place of the advice for short keys alone. The ssh package's client
+// doesn't offer ext-info-c, so can only sign using SHA-1.
+func TestWeakSHA1OnlyReport(t *testing.T) {
+	report := testReport(t, "sha1", testWeakSigner(t))
+	if !strings.Contains(report, issueWeakSHA1) || !strings.Contains(report, "can only sign with them using ssh-rsa") {
+		t.Errorf("expected the key and client to be flagged together:\n%s", report)
+	}
+	if strings.Contains(report, "Consider replacing them with a new key of 2048 bits or more") {
+		t.Errorf("expected the advice for short keys alone to be left out:\n%s", report)
+	}
+}
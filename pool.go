@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// busyMsg is sent in place of the SSH version string when all workers are
+// busy; clients will typically show it when the connection is closed
+const busyMsg = "Server busy, please try again later\r\n"
+
+var (
+	queue       chan *tracedConn
+	busyWorkers int32
+
+	// workersDone is used to wait for the workers to finish once the queue
+	// is closed
+	workersDone sync.WaitGroup
+)
+
+// startWorkers starts a fixed pool of workers that serve connections taken
+// from the queue, so that load spikes can't exhaust the server's resources
+func startWorkers(config *ssh.ServerConfig) {
+	queue = make(chan *tracedConn, queueDepth)
+
+	for i := 0; i < workers; i++ {
+		workersDone.Add(1)
+		go func() {
+			for conn := range queue {
+				atomic.AddInt32(&busyWorkers, 1)
+				handle(config, conn)
+				atomic.AddInt32(&busyWorkers, -1)
+			}
+			workersDone.Done()
+		}()
+	}
+
+	log.Infof("Started %d workers with a queue depth of %d", workers, queueDepth)
+}
+
+// setHandshakeDeadline limits how long the connection may take to complete
+// its handshakes to handshakeTimeout. The deadline is enforced by the
+// kernel, so is measured in real time rather than by clk.
+func setHandshakeDeadline(conn net.Conn) {
+	if handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+}
+
+// timedOut reports whether err is the result of a deadline passing
+func timedOut(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// writeTimeoutConn closes the connection if a write takes longer than
+// writeTimeout, e.g. as the client stopped reading and the socket's buffers
+// have filled, so that it doesn't hold a worker indefinitely. As with the
+// handshake deadline, it's measured in real time.
+type writeTimeoutConn struct {
+	net.Conn
+	timedOut uint32
+}
+
+func (c *writeTimeoutConn) Write(b []byte) (int, error) {
+	if writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+
+	n, err := c.Conn.Write(b)
+	if timedOut(err) && atomic.CompareAndSwapUint32(&c.timedOut, 0, 1) {
+		atomic.AddUint64(&reaped.write, 1)
+	}
+
+	return n, err
+}
+
+// enqueue passes the connection to the worker pool, or turns it away if
+// the queue is full
+func enqueue(conn *tracedConn) {
+	select {
+	case queue <- conn:
+	default:
+		conn.logger().WithFields(log.Fields{
+			"busy_workers": atomic.LoadInt32(&busyWorkers),
+			"queue_depth":  len(queue),
+		}).Warnln("Rejected connection from", conn.RemoteAddr(), "as all workers are busy")
+
+		// Skip writing to TLS connections, as the handshake would block
+		if _, ok := conn.Conn.(*tls.Conn); !ok {
+			conn.Write([]byte(busyMsg))
+		}
+		conn.Close()
+	}
+}
+
+func handle(config *ssh.ServerConfig, conn *tracedConn) {
+	setHandshakeDeadline(conn)
+
+	// Complete the TLS handshake up front so that TLS errors are
+	// reported as such, rather than as a failed SSH handshake
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			if timedOut(err) {
+				atomic.AddUint64(&reaped.handshake, 1)
+			}
+			conn.logger().Warnln("Failed TLS handshake:", err)
+			conn.Close()
+			return
+		}
+	}
+
+	// Load balancers using the PROXY protocol give the client's address
+	// before anything else. The TLS and WebSocket listeners aren't covered,
+	// as the header would precede the TLS handshake or HTTP request.
+	_, isTLS := conn.Conn.(*tls.Conn)
+	_, isWebsocket := conn.Conn.(*websocketConn)
+	if proxyProtocol && !isTLS && !isWebsocket {
+		proxied, err := readProxyHeader(conn.Conn)
+		if err == io.EOF {
+			// Load balancers' TCP health checks close the connection
+			// without sending anything
+			conn.logger().Debugln("Connection from", conn.RemoteAddr(), "closed before sending a PROXY protocol header")
+			conn.Close()
+			return
+		}
+		if err != nil {
+			conn.logger().Warnln("Rejected connection from", conn.RemoteAddr(), "with a missing or malformed PROXY protocol header:", err)
+			conn.Close()
+			return
+		}
+		conn.Conn = proxied
+
+		if banned(conn.RemoteAddr()) {
+			conn.logger().Debugln("Rejected connection from banned address", conn.RemoteAddr())
+			conn.Close()
+			return
+		}
+	}
+
+	serve(config, conn)
+}
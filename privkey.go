@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// acceptPrivateKeys lets -check read private keys, set by -private-keys.
+// Private keys are only ever read from local files, and only their public
+// keys are passed to the server.
+var acceptPrivateKeys bool
+
+// issueInconsistentKey is logged for private keys whose parameters don't
+// agree with each other, as they never reach the server to be reported
+const issueInconsistentKey = "INCONSISTENT PRIVATE KEY"
+
+// rsaPrivateParams are the parameters of an RSA private key. PKCS #1 keys
+// also give d mod (p-1) and d mod (q-1), which OpenSSH's format leaves out.
+type rsaPrivateParams struct {
+	N, E, D, P, Q, Dp, Dq, Qinv *big.Int
+}
+
+// inconsistency returns why the parameters are inconsistent with each other,
+// or an empty string if they aren't
+func (k *rsaPrivateParams) inconsistency() string {
+	one := big.NewInt(1)
+	pMinus1 := new(big.Int).Sub(k.P, one)
+	qMinus1 := new(big.Int).Sub(k.Q, one)
+	product := new(big.Int)
+	defer zero(pMinus1, qMinus1, product)
+
+	switch {
+	case k.P.Cmp(one) <= 0 || k.Q.Cmp(one) <= 0:
+		return "p and q must be greater than 1"
+	case product.Mul(k.P, k.Q).Cmp(k.N) != 0:
+		return "p*q doesn't equal the modulus n"
+	case product.Mul(k.D, k.E).Mod(product, pMinus1).Cmp(one) != 0,
+		product.Mul(k.D, k.E).Mod(product, qMinus1).Cmp(one) != 0:
+		return "the private exponent d doesn't match the public exponent e"
+	case k.Dp != nil && product.Mod(k.D, pMinus1).Cmp(k.Dp) != 0:
+		return "the CRT exponent d mod (p-1) is wrong"
+	case k.Dq != nil && product.Mod(k.D, qMinus1).Cmp(k.Dq) != 0:
+		return "the CRT exponent d mod (q-1) is wrong"
+	case product.Mul(k.Qinv, k.Q).Mod(product, k.P).Cmp(one) != 0:
+		return "the CRT coefficient isn't the inverse of q mod p"
+	}
+
+	return ""
+}
+
+// zero overwrites the values, so that private key material doesn't linger
+// in memory any longer than needed
+func zero(values ...*big.Int) {
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		words := v.Bits()
+		for i := range words {
+			words[i] = 0
+		}
+		v.SetInt64(0)
+	}
+}
+
+// zeroBytes overwrites b, for the same reason
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// readPrivateKeys returns the public keys of the PEM-encoded private keys
+// in data, after checking that the parameters of RSA private keys are
+// consistent. Inconsistent and encrypted keys are skipped with an error. The
+// private key material is zeroed once checked.
+func readPrivateKeys(data []byte, path string) []ssh.Signer {
+	var signers []ssh.Signer
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+
+		pub, reason, err := checkPrivateKey(block)
+		zeroBytes(block.Bytes)
+		switch {
+		case err != nil:
+			log.Warnf("Skipping %s in %s: %s", block.Type, path, err)
+		case reason != "":
+			log.Errorf("Skipping %s in %s: %s, %s", block.Type, path, issueInconsistentKey, reason)
+		default:
+			signers = append(signers, publicOnlySigner{pub})
+		}
+	}
+
+	return signers
+}
+
+// checkPrivateKey returns the public key of the private key, and why the
+// private key is inconsistent, if it is
+func checkPrivateKey(block *pem.Block) (ssh.PublicKey, string, error) {
+	if len(block.Headers) > 0 {
+		return nil, "", errors.New("can't check encrypted private keys")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		var k struct {
+			Version                     int
+			N, E, D, P, Q, Dp, Dq, Qinv *big.Int
+		}
+		if _, err := asn1.Unmarshal(block.Bytes, &k); err != nil {
+			return nil, "", err
+		}
+		params := rsaPrivateParams{k.N, k.E, k.D, k.P, k.Q, k.Dp, k.Dq, k.Qinv}
+		defer params.zero()
+		return rsaPublicKey(&params)
+
+	case "OPENSSH PRIVATE KEY":
+		return checkOpenSSHPrivateKey(block.Bytes)
+	}
+
+	return nil, "", fmt.Errorf("unsupported private key type, expected RSA PRIVATE KEY or OPENSSH PRIVATE KEY")
+}
+
+// checkOpenSSHPrivateKey checks an unencrypted private key in OpenSSH's
+// format (PROTOCOL.key in OpenSSH). The keys of other types are only
+// checked against the public key that the file also holds.
+func checkOpenSSHPrivateKey(data []byte) (ssh.PublicKey, string, error) {
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, "", errors.New("not in OpenSSH's private key format")
+	}
+
+	var file struct {
+		CipherName, KdfName, KdfOptions string
+		NumKeys                         uint32
+		PublicKey, PrivateKeys          []byte
+	}
+	if err := ssh.Unmarshal(data[len(magic):], &file); err != nil {
+		return nil, "", err
+	}
+	if file.CipherName != "none" {
+		return nil, "", errors.New("can't check encrypted private keys")
+	}
+	if file.NumKeys != 1 {
+		return nil, "", fmt.Errorf("expected one key, found %d", file.NumKeys)
+	}
+
+	pub, err := ssh.ParsePublicKey(file.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var private struct {
+		Check1, Check2 uint32
+		KeyType        string
+		Rest           []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(file.PrivateKeys, &private); err != nil {
+		return nil, "", err
+	}
+	defer zeroBytes(file.PrivateKeys)
+
+	// The check values are equal unless the key was decrypted using the
+	// wrong passphrase, or has been corrupted
+	switch {
+	case private.Check1 != private.Check2:
+		return nil, "the check values differ", nil
+	case private.KeyType != pub.Type():
+		return nil, fmt.Sprintf("the private key is %s, but the public key is %s", private.KeyType, pub.Type()), nil
+	case private.KeyType != ssh.KeyAlgoRSA:
+		return pub, "", nil
+	}
+
+	var k struct {
+		N, E, D, Qinv, P, Q *big.Int
+		Rest                []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(private.Rest, &k); err != nil {
+		return nil, "", err
+	}
+	params := rsaPrivateParams{N: k.N, E: k.E, D: k.D, P: k.P, Q: k.Q, Qinv: k.Qinv}
+	defer params.zero()
+
+	derived, reason, err := rsaPublicKey(&params)
+	if err == nil && reason == "" && !bytes.Equal(derived.Marshal(), pub.Marshal()) {
+		reason = "the private key doesn't match the public key"
+	}
+
+	return derived, reason, err
+}
+
+// rsaPublicKey returns the public key of the RSA private key, and why its
+// parameters are inconsistent, if they are
+func rsaPublicKey(k *rsaPrivateParams) (ssh.PublicKey, string, error) {
+	if k.N == nil || k.E == nil || k.D == nil || k.P == nil || k.Q == nil || k.Qinv == nil {
+		return nil, "", errors.New("missing RSA parameters")
+	}
+	if !k.E.IsInt64() || k.E.Int64() > 1<<31-1 {
+		return nil, "", errors.New("public exponent too large")
+	}
+	if reason := k.inconsistency(); reason != "" {
+		return nil, reason, nil
+	}
+
+	pub, err := ssh.NewPublicKey(&rsa.PublicKey{N: new(big.Int).Set(k.N), E: int(k.E.Int64())})
+	return pub, "", err
+}
+
+// zero overwrites the private parameters
+func (k *rsaPrivateParams) zero() {
+	zero(k.D, k.P, k.Q, k.Dp, k.Dq, k.Qinv)
+}
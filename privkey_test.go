@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testRSAKey returns a freshly generated RSA private key
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Precompute()
+
+	return k
+}
+
+// privateParams returns the parameters of k, each copied so that they can
+// be changed
+func privateParams(k *rsa.PrivateKey) *rsaPrivateParams {
+	c := func(n *big.Int) *big.Int { return new(big.Int).Set(n) }
+	return &rsaPrivateParams{
+		N: c(k.N), E: big.NewInt(int64(k.E)), D: c(k.D), P: c(k.Primes[0]), Q: c(k.Primes[1]),
+		Dp: c(k.Precomputed.Dp), Dq: c(k.Precomputed.Dq), Qinv: c(k.Precomputed.Qinv),
+	}
+}
+
+func TestInconsistency(t *testing.T) {
+	k := testRSAKey(t)
+	one := big.NewInt(1)
+
+	for _, test := range []struct {
+		name   string
+		change func(*rsaPrivateParams)
+		reason string
+	}{
+		{"consistent", func(*rsaPrivateParams) {}, ""},
+		{"no Dp or Dq, as in OpenSSH's format", func(p *rsaPrivateParams) { p.Dp, p.Dq = nil, nil }, ""},
+		{"p of 1", func(p *rsaPrivateParams) { p.P.SetInt64(1) }, "p and q must be greater than 1"},
+		{"wrong p*q", func(p *rsaPrivateParams) { p.N.Add(p.N, big.NewInt(2)) }, "p*q doesn't equal the modulus n"},
+		{"wrong d", func(p *rsaPrivateParams) { p.D.Add(p.D, one) }, "the private exponent d doesn't match the public exponent e"},
+		{"wrong Dp", func(p *rsaPrivateParams) { p.Dp.Add(p.Dp, one) }, "the CRT exponent d mod (p-1) is wrong"},
+		{"wrong Dq", func(p *rsaPrivateParams) { p.Dq.Add(p.Dq, one) }, "the CRT exponent d mod (q-1) is wrong"},
+		{"wrong Qinv", func(p *rsaPrivateParams) { p.Qinv.Add(p.Qinv, one) }, "the CRT coefficient isn't the inverse of q mod p"},
+	} {
+		params := privateParams(k)
+		test.change(params)
+		if reason := params.inconsistency(); reason != test.reason {
+			t.Errorf("%s: got %q, expected %q", test.name, reason, test.reason)
+		}
+	}
+}
+
+// testOpenSSHKey returns k in OpenSSH's private key format, with the given
+// check values
+func testOpenSSHKey(t *testing.T, k *rsa.PrivateKey, check1, check2 uint32) []byte {
+	pub, err := ssh.NewPublicKey(&k.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := ssh.Marshal(struct {
+		Check1, Check2      uint32
+		KeyType             string
+		N, E, D, Qinv, P, Q *big.Int
+		Comment             string
+	}{check1, check2, ssh.KeyAlgoRSA, k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1], "test"})
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	return append([]byte("openssh-key-v1\x00"), ssh.Marshal(struct {
+		CipherName, KdfName, KdfOptions string
+		NumKeys                         uint32
+		PublicKey, PrivateKeys          []byte
+	}{"none", "none", "", 1, pub.Marshal(), private})...)
+}
+
+func TestCheckPrivateKey(t *testing.T) {
+	k := testRSAKey(t)
+	expected, err := ssh.NewPublicKey(&k.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongD := *k
+	wrongD.D = new(big.Int).Add(k.D, big.NewInt(1))
+
+	for _, test := range []struct {
+		name   string
+		block  *pem.Block
+		reason string
+	}{
+		{"PKCS #1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, ""},
+		{"PKCS #1 with wrong d", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(&wrongD)}, "the private exponent d doesn't match the public exponent e"},
+		{"OpenSSH", &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: testOpenSSHKey(t, k, 42, 42)}, ""},
+		{"OpenSSH with wrong d", &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: testOpenSSHKey(t, &wrongD, 42, 42)}, "the private exponent d doesn't match the public exponent e"},
+		{"OpenSSH check values differ", &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: testOpenSSHKey(t, k, 42, 43)}, "the check values differ"},
+	} {
+		pub, reason, err := checkPrivateKey(test.block)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case reason != test.reason:
+			t.Errorf("%s: got %q, expected %q", test.name, reason, test.reason)
+		case reason == "" && !bytes.Equal(pub.Marshal(), expected.Marshal()):
+			t.Errorf("%s: got a different public key", test.name)
+		}
+	}
+
+	if _, _, err := checkPrivateKey(&pem.Block{Type: "RSA PRIVATE KEY", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED"}}); err == nil {
+		t.Error("encrypted key checked")
+	}
+}
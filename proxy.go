@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// trustedProxies lists the networks of the proxies trusted to give the
+// address of the client they are forwarding for, in X_FORWARDED_FOR
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and
+// networks in CIDR notation, e.g. "192.0.2.1,10.0.0.0/8"
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("expected an IP address or network: %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// trustedProxy reports whether the address is one of trustedProxies
+func trustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedClient returns the address of the client that the proxy at addr
+// is forwarding for, given the value of X_FORWARDED_FOR that it sent, if
+// the proxy is trusted. Only the last address listed is used, as it is the
+// one added by the proxy itself; any before it were given by the client or
+// by proxies that aren't known to be trustworthy.
+func forwardedClient(addr net.Addr, value string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", false
+	}
+
+	if !trustedProxy(net.ParseIP(host)) {
+		return "", false
+	}
+
+	listed := strings.Split(value, ",")
+	client := net.ParseIP(strings.TrimSpace(listed[len(listed)-1]))
+	if client == nil {
+		return "", false
+	}
+
+	return client.String(), true
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout is how long to wait for the PROXY protocol header
+// before giving up on the connection
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts each version 2 PROXY protocol header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxiedConn is a connection forwarded by a load balancer using the PROXY
+// protocol, whose addresses are those of the client and the address it
+// connected to, as given by the load balancer
+type proxiedConn struct {
+	net.Conn
+	r             *bufio.Reader
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *proxiedConn) RemoteAddr() net.Addr       { return c.remote }
+func (c *proxiedConn) LocalAddr() net.Addr        { return c.local }
+
+// readProxyHeader reads the PROXY protocol header that a load balancer sends
+// before the client's data, in version 1 or 2 of the protocol
+// (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt), and returns
+// the connection as seen by the client. Headers that don't give the
+// client's address, such as those of the load balancer's health checks,
+// leave the connection's addresses as they are.
+func readProxyHeader(conn net.Conn) (*proxiedConn, error) {
+	p := &proxiedConn{Conn: conn, r: bufio.NewReader(conn), remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+
+	conn.SetReadDeadline(clk.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	start, err := p.r.Peek(len(proxyV2Signature))
+	switch {
+	case err != nil:
+		return nil, err
+	case bytes.Equal(start, proxyV2Signature):
+		return p, p.readV2()
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return p, p.readV1()
+	}
+
+	return nil, errors.New("no PROXY protocol header")
+}
+
+// readV1 reads a version 1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 22\r\n"
+func (p *proxiedConn) readV1() error {
+	// The longest possible header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("PROXY protocol header too long or not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return fmt.Errorf("malformed PROXY protocol header: %q", line)
+	}
+
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	v4 := fields[1] == "TCP4"
+	if src == nil || dst == nil || (src.To4() != nil) != v4 || (dst.To4() != nil) != v4 || srcErr != nil || dstErr != nil {
+		return fmt.Errorf("malformed PROXY protocol header: %q", line)
+	}
+
+	p.remote = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	p.local = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return nil
+}
+
+// readV2 reads a version 2 header, which is binary
+func (p *proxiedConn) readV2() error {
+	var header struct {
+		Signature     [12]byte
+		VersionCmd    byte
+		FamilyProto   byte
+		AddressLength uint16
+	}
+	if err := binary.Read(p.r, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if header.VersionCmd>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", header.VersionCmd>>4)
+	}
+
+	addresses := make([]byte, header.AddressLength)
+	if _, err := io.ReadFull(p.r, addresses); err != nil {
+		return err
+	}
+
+	switch header.VersionCmd & 0xf {
+	case 0x0:
+		// LOCAL: sent by the load balancer itself, e.g. for health
+		// checks, so the connection's own addresses are right
+		return nil
+	case 0x1:
+		// PROXY
+	default:
+		return fmt.Errorf("unsupported PROXY protocol command %d", header.VersionCmd&0xf)
+	}
+
+	// Only TCP over IPv4 or IPv6 is forwarded for; other families are
+	// allowed by the protocol, but give no address to use
+	var n int
+	switch header.FamilyProto {
+	case 0x11:
+		n = net.IPv4len
+	case 0x21:
+		n = net.IPv6len
+	default:
+		return nil
+	}
+	if len(addresses) < 2*n+4 {
+		return errors.New("PROXY protocol header too short for its addresses")
+	}
+
+	p.remote = &net.TCPAddr{
+		IP:   net.IP(addresses[:n]),
+		Port: int(binary.BigEndian.Uint16(addresses[2*n:])),
+	}
+	p.local = &net.TCPAddr{
+		IP:   net.IP(addresses[n : 2*n]),
+		Port: int(binary.BigEndian.Uint16(addresses[2*n+2:])),
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// A minimal QR code encoder, enough to link users of mobile SSH clients to
+// remediation instructions: byte mode, error correction level L, versions 1
+// to 5, which need neither version information nor interleaved blocks. See
+// ISO/IEC 18004.
+
+// qrQuietZone is the width in modules of the light border scanners need
+// around the symbol
+const qrQuietZone = 4
+
+// qrVersions gives the number of data and error correction codewords in the
+// single block used by each version at level L, indexed by version number
+var qrVersions = []struct{ data, ec int }{
+	{},
+	{19, 7},
+	{34, 10},
+	{55, 15},
+	{80, 20},
+	{108, 26},
+}
+
+// maxQRLength is the longest text that can be encoded
+var maxQRLength = qrVersions[len(qrVersions)-1].data - 2
+
+// qrCode is the symbol's modules, true for dark, by row then column
+type qrCode [][]bool
+
+// encodeQR encodes text as a QR code, using the smallest version that fits
+func encodeQR(text string) (qrCode, error) {
+	// Mode and length indicators take 12 bits, plus a terminator
+	version := 1
+	for ; version < len(qrVersions) && len(text)+2 > qrVersions[version].data; version++ {
+	}
+	if version == len(qrVersions) {
+		return nil, errors.New("too long to encode as a QR code")
+	}
+
+	v := qrVersions[version]
+	data := make([]byte, 0, v.data+v.ec)
+	var acc uint
+	var n uint
+	put := func(bits uint, length uint) {
+		for i := int(length) - 1; i >= 0; i-- {
+			acc = acc<<1 | bits>>uint(i)&1
+			if n++; n == 8 {
+				data = append(data, byte(acc))
+				acc, n = 0, 0
+			}
+		}
+	}
+	put(0x4, 4) // byte mode
+	put(uint(len(text)), 8)
+	for i := 0; i < len(text); i++ {
+		put(uint(text[i]), 8)
+	}
+	put(0, 4) // terminator
+	if n > 0 {
+		put(0, 8-n)
+	}
+	for pad := byte(0xec); len(data) < v.data; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+	data = append(data, reedSolomon(data, v.ec)...)
+
+	q := newQRTemplate(version)
+	q.place(data)
+
+	best, bestPenalty := qrCode(nil), -1
+	for mask := 0; mask < 8; mask++ {
+		m := q.masked(mask)
+		if p := m.modules.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = m.modules, p
+		}
+	}
+
+	return best, nil
+}
+
+// qrTemplate is a symbol being built, recording which modules belong to
+// function patterns, which aren't masked
+type qrTemplate struct {
+	modules  qrCode
+	function [][]bool
+}
+
+func newQRTemplate(version int) *qrTemplate {
+	size := 17 + 4*version
+	q := &qrTemplate{modules: make(qrCode, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	// Finder patterns and their separators
+	for _, corner := range [][2]int{{3, 3}, {3, size - 4}, {size - 4, 3}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				y, x := corner[0]+dy, corner[1]+dx
+				if y < 0 || y >= size || x < 0 || x >= size {
+					continue
+				}
+				d := chebyshev(dx, dy)
+				q.set(y, x, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// Timing patterns
+	for i := 8; i < size-8; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	// The single alignment pattern used by versions 2 to 6
+	if version >= 2 {
+		c := size - 7
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				q.set(c+dy, c+dx, chebyshev(dx, dy) != 1)
+			}
+		}
+	}
+
+	// Reserve the format information, which depends on the mask, and set
+	// the dark module beside it
+	for i := 0; i < 9; i++ {
+		q.function[8][i] = true
+		q.function[i][8] = true
+	}
+	for i := 0; i < 8; i++ {
+		q.function[8][size-1-i] = true
+		q.function[size-1-i][8] = true
+	}
+	q.set(size-8, 8, true)
+
+	return q
+}
+
+func (q *qrTemplate) set(y, x int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// place fills the modules that aren't part of a function pattern with the
+// codewords, in two-module-wide columns zigzagging up and down from the
+// bottom right, skipping the vertical timing pattern
+func (q *qrTemplate) place(codewords []byte) {
+	size := len(q.modules)
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if upward {
+				y = size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if q.function[y][x] {
+					continue
+				}
+				// Any remainder bits are left light
+				if i < len(codewords)*8 {
+					q.modules[y][x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// qrMasks decide whether each module is inverted by each mask pattern
+var qrMasks = []func(y, x int) bool{
+	func(y, x int) bool { return (y+x)%2 == 0 },
+	func(y, x int) bool { return y%2 == 0 },
+	func(y, x int) bool { return x%3 == 0 },
+	func(y, x int) bool { return (y+x)%3 == 0 },
+	func(y, x int) bool { return (y/2+x/3)%2 == 0 },
+	func(y, x int) bool { return y*x%2+y*x%3 == 0 },
+	func(y, x int) bool { return (y*x%2+y*x%3)%2 == 0 },
+	func(y, x int) bool { return ((y+x)%2+y*x%3)%2 == 0 },
+}
+
+// masked returns a copy of the symbol with the mask applied and the format
+// information for it filled in
+func (q *qrTemplate) masked(mask int) *qrTemplate {
+	size := len(q.modules)
+	m := &qrTemplate{modules: make(qrCode, size), function: q.function}
+	for y := range q.modules {
+		m.modules[y] = append([]bool(nil), q.modules[y]...)
+		for x := range m.modules[y] {
+			if !q.function[y][x] && qrMasks[mask](y, x) {
+				m.modules[y][x] = !m.modules[y][x]
+			}
+		}
+	}
+
+	// Level L is indicated by 01, followed by the mask, protected by a
+	// BCH(15,5) code and XORed so that it's never all light
+	format := 1<<3 | mask
+	rem := format
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (format<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		m.modules[i][8] = bit(i)
+	}
+	m.modules[7][8] = bit(6)
+	m.modules[8][8] = bit(7)
+	m.modules[8][7] = bit(8)
+	for i := 9; i < 15; i++ {
+		m.modules[8][14-i] = bit(i)
+	}
+	for i := 0; i < 8; i++ {
+		m.modules[8][size-1-i] = bit(i)
+	}
+	for i := 8; i < 15; i++ {
+		m.modules[size-15+i][8] = bit(i)
+	}
+
+	return m
+}
+
+// penalty scores how likely the symbol is to confuse a scanner, so that the
+// mask with the lowest score can be chosen
+func (c qrCode) penalty() int {
+	size := len(c)
+	at := func(y, x int, transpose bool) bool {
+		if transpose {
+			y, x = x, y
+		}
+		if y < 0 || y >= size || x < 0 || x >= size {
+			return false
+		}
+		return c[y][x]
+	}
+
+	score := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more modules of the same colour
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(y, x, transpose) == at(y, x-1, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns resembling a finder, with four light modules on
+			// either side
+			for x := -4; x < size; x++ {
+				matches := true
+				for i, dark := range finderLike {
+					if at(y, x+i, transpose) != dark {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				before, after := true, true
+				for i := 1; i <= 4; i++ {
+					before = before && !at(y, x-i, transpose)
+					after = after && !at(y, x+6+i, transpose)
+				}
+				if before || after {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same colour, and an imbalance of dark and light
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c[y][x] {
+				dark++
+			}
+			if y > 0 && x > 0 && c[y][x] == c[y-1][x] && c[y][x] == c[y][x-1] && c[y][x] == c[y-1][x-1] {
+				score += 3
+			}
+		}
+	}
+	score += abs(dark*20-size*size*10) / (size * size) * 10
+
+	return score
+}
+
+// width returns the number of columns the rendered symbol takes up
+func (c qrCode) width() int {
+	return len(c) + 2*qrQuietZone
+}
+
+// render draws the symbol using Unicode half blocks, two rows of modules to
+// each line. Light modules and the quiet zone are drawn as blocks, which
+// suits the light text on a dark background used by most terminals.
+func (c qrCode) render() string {
+	size := c.width()
+	light := func(y, x int) bool {
+		y, x = y-qrQuietZone, x-qrQuietZone
+		return y < 0 || y >= len(c) || x < 0 || x >= len(c) || !c[y][x]
+	}
+
+	var lines []string
+	for y := 0; y < size; y += 2 {
+		var line bytes.Buffer
+		for x := 0; x < size; x++ {
+			top, bottom := light(y, x), y+1 < size && light(y+1, x)
+			switch {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		lines = append(lines, line.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// reedSolomon returns the n error correction codewords for data, as the
+// remainder of dividing it by the generator polynomial with roots 2^0 to
+// 2^(n-1) in GF(256), reduced by x^8 + x^4 + x^3 + x^2 + 1
+func reedSolomon(data []byte, n int) []byte {
+	generator := make([]byte, n)
+	generator[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range generator {
+			generator[j] = gfMultiply(generator[j], root)
+			if j+1 < n {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+
+	remainder := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[n-1] = 0
+		for i, coef := range generator {
+			remainder[i] ^= gfMultiply(coef, factor)
+		}
+	}
+
+	return remainder
+}
+
+func gfMultiply(x, y byte) byte {
+	var z uint
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= uint(y>>uint(i)&1) * uint(x)
+	}
+
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// chebyshev returns the distance of a module from the centre of a pattern
+func chebyshev(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+	return abs(dy)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// reloadOnHangup calls each of the given functions whenever the server
+// receives SIGHUP, so that lists loaded from files can be updated without
+// restarting the server
+func reloadOnHangup(reloads ...func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		log.Infoln("Received SIGHUP, reloading")
+		for _, reload := range reloads {
+			reload()
+		}
+	}
+}
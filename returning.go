@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// maxVisitors is the number of addresses remembered for briefWindow; the
+// one seen least recently is forgotten to make room for another
+const maxVisitors = 10000
+
+// visitors records when each address was last sent a report, and the issues
+// whose advice it has been shown since it started returning, so that users
+// re-checking their keys as they fix them aren't shown the same text again
+var visitors = struct {
+	mu   sync.Mutex
+	seen *expiringMap
+}{
+	seen: newExpiringMap(&briefWindow, maxVisitors),
+}
+
+// visitorID returns the identifier under which an address's visits are
+// remembered, which is a keyed hash of it if fingerprints are hashed in
+// the logs, as users' addresses then shouldn't be kept either
+func visitorID(host string) string {
+	if logFingerprints != "hash" {
+		return host
+	}
+
+	mac := hmac.New(sha256.New, logKey)
+	mac.Write([]byte("visitor:" + host))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// previousVisit returns the issues whose advice has been shown to the
+// visitor, and whether it was last sent a report within briefWindow
+func previousVisit(id string) (map[string]bool, bool) {
+	visitors.mu.Lock()
+	defer visitors.mu.Unlock()
+
+	v, ok := visitors.seen.get(id, clk.Now())
+	if !ok {
+		return nil, false
+	}
+
+	return v.(map[string]bool), true
+}
+
+// rememberVisit records that the visitor was sent a report explaining the
+// given issues, along with those explained during its earlier visits, if
+// it was last seen within briefWindow
+func rememberVisit(id string, explained []string) {
+	now := clk.Now()
+
+	visitors.mu.Lock()
+	defer visitors.mu.Unlock()
+
+	current := make(map[string]bool)
+	if previous, ok := visitors.seen.get(id, now); ok {
+		for issue := range previous.(map[string]bool) {
+			current[issue] = true
+		}
+	}
+	for _, issue := range explained {
+		current[issue] = true
+	}
+	visitors.seen.put(id, current, now)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBriefWindow(t *testing.T) {
+	c := useFakeClock(t)
+	defer func(window time.Duration) { briefWindow = window }(briefWindow)
+	briefWindow = time.Hour
+
+	if _, returning := previousVisit("visitor"); returning {
+		t.Fatal("returning before the first visit")
+	}
+
+	rememberVisit("visitor", []string{"dsa"})
+	c.Advance(time.Hour)
+	rememberVisit("visitor", []string{"weak"})
+
+	// The window runs from the most recent visit, and the advice shown
+	// during each visit is remembered
+	c.Advance(time.Hour)
+	explained, returning := previousVisit("visitor")
+	if !returning {
+		t.Fatal("not returning within briefWindow of the last visit")
+	}
+	if !explained["dsa"] || !explained["weak"] || len(explained) != 2 {
+		t.Errorf("got %v, expected dsa and weak to have been explained", explained)
+	}
+
+	c.Advance(time.Second)
+	if _, returning := previousVisit("visitor"); returning {
+		t.Error("still returning after briefWindow")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	rfc4716Begin = "---- BEGIN SSH2 PUBLIC KEY ----"
+	rfc4716End   = "---- END SSH2 PUBLIC KEY ----"
+)
+
+// rfc4716Key is a public key in the format described by RFC 4716, as
+// exported by PuTTYgen and the SSH2 implementations of ssh.com
+type rfc4716Key struct {
+	// line is the line of the file the key begins on, counting from 1
+	line int
+
+	blob    []byte
+	headers map[string]string
+}
+
+// parseRFC4716 returns the public keys in data, which holds one or more
+// keys in RFC 4716 format. Header values continued on the next line by a
+// trailing backslash are joined, and quotes around them are removed.
+func parseRFC4716(data []byte) ([]rfc4716Key, error) {
+	var keys []rfc4716Key
+	var key *rfc4716Key
+	var body, header string
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case key == nil && line == rfc4716Begin:
+			key = &rfc4716Key{line: i + 1, headers: make(map[string]string)}
+			body = ""
+		case key == nil && line != "":
+			return nil, fmt.Errorf("line %d: expected %q", i+1, rfc4716Begin)
+		case key == nil:
+		case header != "":
+			header += line
+			if strings.HasSuffix(header, `\`) {
+				header = strings.TrimSuffix(header, `\`)
+				continue
+			}
+			addRFC4716Header(key, header)
+			header = ""
+		case line == rfc4716End:
+			blob, err := base64.StdEncoding.DecodeString(body)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", key.line, err)
+			}
+			key.blob = blob
+			keys = append(keys, *key)
+			key = nil
+		case body == "" && strings.Contains(line, ":"):
+			if strings.HasSuffix(line, `\`) {
+				header = strings.TrimSuffix(line, `\`)
+				continue
+			}
+			addRFC4716Header(key, line)
+		default:
+			body += line
+		}
+	}
+
+	if key != nil {
+		return nil, fmt.Errorf("line %d: expected %q", key.line, rfc4716End)
+	}
+
+	return keys, nil
+}
+
+// addRFC4716Header adds the header on the line to the key. Tags are case
+// insensitive, so are stored in lower case.
+func addRFC4716Header(key *rfc4716Key, line string) {
+	parts := strings.SplitN(line, ":", 2)
+	value := strings.TrimSpace(parts[1])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	key.headers[strings.ToLower(strings.TrimSpace(parts[0]))] = value
+}
+
+// readRFC4716Keys returns the public keys in data, read from the named file,
+// which holds keys in RFC 4716 format, skipping those that can't be parsed
+func readRFC4716Keys(data []byte, path string) ([]ssh.Signer, error) {
+	keys, err := parseRFC4716(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var signers []ssh.Signer
+	for _, k := range keys {
+		key, err := ssh.ParsePublicKey(k.blob)
+		if err != nil && invalidCurvePoint(err) {
+			log.Errorf("Skipping key on line %d of %s: INVALID CURVE POINT, its point isn't on the curve its type names, or is the point at infinity", k.line, path)
+			continue
+		} else if err != nil && malformedKeyError(err) {
+			log.Warnf("Skipping key on line %d of %s: MALFORMED KEY DATA, it is truncated or has trailing data (%s)", k.line, path, err)
+			continue
+		} else if err != nil {
+			log.Warnf("Skipping key on line %d of %s: %s", k.line, path, err)
+			continue
+		}
+
+		log.Infof("Key on line %d of %s is in RFC 4716 (SSH2) format, commented %q; checking it as %s", k.line, path, k.headers["comment"], key.Type())
+		if !bytes.Equal(k.blob, key.Marshal()) {
+			log.Warnf("Key on line %d of %s has a NON-CANONICAL ENCODING, which some servers reject; export it again using ssh-keygen -y", k.line, path)
+		}
+
+		signers = append(signers, publicOnlySigner{key})
+	}
+
+	return signers, nil
+}
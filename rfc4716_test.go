@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// rfc4716 returns the blob in RFC 4716 format, with the given header lines,
+// wrapping the base64 at 70 characters as PuTTYgen does
+func rfc4716(blob []byte, headers ...string) string {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var body []string
+	for len(encoded) > 70 {
+		body = append(body, encoded[:70])
+		encoded = encoded[70:]
+	}
+	body = append(body, encoded)
+
+	lines := append(append([]string{rfc4716Begin}, headers...), body...)
+	return strings.Join(append(lines, rfc4716End), "\n") + "\n"
+}
+
+func TestParseRFC4716(t *testing.T) {
+	rsaKey, ecdsaKey := generateKey(t, "rsa-2048"), generateKey(t, "ecdsa-256")
+
+	for _, test := range []struct {
+		name    string
+		data    string
+		blobs   [][]byte
+		comment string
+		ok      bool
+	}{
+		{
+			name:    "PuTTYgen export",
+			data:    rfc4716(rsaKey.Marshal(), `Comment: "rsa-key-20260101"`),
+			blobs:   [][]byte{rsaKey.Marshal()},
+			comment: "rsa-key-20260101",
+			ok:      true,
+		},
+		{
+			name:    "continued and unrecognised headers",
+			data:    rfc4716(ecdsaKey.Marshal(), `Subject: alice`, `comment: "This is my public key for use on \`, `servers which I don't like."`, `x-private: ignored`),
+			blobs:   [][]byte{ecdsaKey.Marshal()},
+			comment: "This is my public key for use on servers which I don't like.",
+			ok:      true,
+		},
+		{
+			name:  "several keys and Windows line endings",
+			data:  strings.Replace(rfc4716(rsaKey.Marshal())+"\n"+rfc4716(ecdsaKey.Marshal()), "\n", "\r\n", -1),
+			blobs: [][]byte{rsaKey.Marshal(), ecdsaKey.Marshal()},
+			ok:    true,
+		},
+		{name: "no end line", data: strings.TrimSuffix(rfc4716(rsaKey.Marshal()), rfc4716End+"\n")},
+		{name: "text before the key", data: "not a key\n" + rfc4716(rsaKey.Marshal())},
+		{name: "bad base64", data: rfc4716Begin + "\nnot base64!\n" + rfc4716End + "\n"},
+	} {
+		keys, err := parseRFC4716([]byte(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if len(keys) != len(test.blobs) {
+			t.Errorf("%s: got %d keys, expected %d", test.name, len(keys), len(test.blobs))
+			continue
+		}
+		for i, k := range keys {
+			if !bytes.Equal(k.blob, test.blobs[i]) {
+				t.Errorf("%s: key %d doesn't match", test.name, i+1)
+			}
+		}
+		if test.ok && keys[0].headers["comment"] != test.comment {
+			t.Errorf("%s: got comment %q, expected %q", test.name, keys[0].headers["comment"], test.comment)
+		}
+	}
+}
+
+// Keys exported by ssh-keygen -e are read as the key they were exported
+// from, and reported as being in RFC 4716 format
+func TestReadSignersRFC4716(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+
+	key := generateKey(t, "ecdsa-384")
+	dir := t.TempDir()
+	pub := filepath.Join(dir, "id_ecdsa.pub")
+	if err := ioutil.WriteFile(pub, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := exec.Command("ssh-keygen", "-e", "-f", pub).Output()
+	if err != nil {
+		t.Fatal("ssh-keygen -e failed:", err)
+	}
+
+	truncated := rfc4716(key.Marshal()[:len(key.Marshal())-5])
+	path := filepath.Join(dir, "id_ecdsa.ssh2")
+	if err := ioutil.WriteFile(path, append(exported, truncated...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := captureLogs(t)
+	signers, err := readSigners(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || !bytes.Equal(signers[0].PublicKey().Marshal(), key.Marshal()) {
+		t.Fatalf("got %d keys, expected the exported key alone", len(signers))
+	}
+	for _, expected := range []string{"is in RFC 4716 (SSH2) format", "MALFORMED KEY DATA"} {
+		if !logs.contains(expected) {
+			t.Errorf("expected %q to be logged", expected)
+		}
+	}
+}
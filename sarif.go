@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// sarifRules describes each issue, by its name in severities, for use as
+// the rules in SARIF output
+var sarifRules = map[string]string{
+	"wellknown":     "Key whose private key has been published",
+	"container":     "Key shipped in a public container image",
+	"blacklisted":   "Key in a blacklist of known insecure keys",
+	"revoked":       "Key or certificate revoked by the server's operator",
+	"collision":     "Keys of different types sharing a fingerprint",
+	"typemismatch":  "Key of a different type than its SSH agent declared",
+	"trivial":       "RSA key whose modulus is trivial to factor",
+	"factor":        "RSA key whose modulus is divisible by a known factor",
+	"entropy":       "Key generated with too little entropy",
+	"sharedmodulus": "RSA key sharing its modulus with another key",
+	"modulus":       "RSA key whose modulus has been factored",
+	"dsa":           "DSA key",
+	"weak":          "RSA key shorter than 2048 bits",
+	"curve":         "ECDSA key on a curve other than NIST P-256, P-384 or P-521",
+	"mismatch":      "Key shorter than its type suggests",
+	"encoding":      "Key not encoded the way SSH software usually encodes it",
+	"unparseable":   "Key whose parameters couldn't be parsed",
+	"agent":         "SSH agent forwarding enabled",
+	"x11":           "X11 forwarding enabled",
+}
+
+// The subset of SARIF 2.1.0 used to describe issues found
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	HelpURI              string             `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifLevel returns the SARIF level corresponding to the severity
+func (s severity) sarifLevel() string {
+	switch s {
+	case severityCritical:
+		return "error"
+	case severityWarning:
+		return "warning"
+	}
+
+	return "note"
+}
+
+// writeSARIF writes the issues found as a SARIF log, with one result for
+// each key with an issue, located by its SHA-256 fingerprint, and one for
+// each forwarding request. Exempt keys are left out.
+func writeSARIF(w io.Writer, a *analysis, agentFwd, x11 bool) error {
+	names := make([]string, 0, len(sarifRules))
+	for name := range sarifRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	driver := sarifDriver{
+		Name:           "sshkeycheck",
+		InformationURI: "https://github.com/mattbostock/sshkeycheck",
+	}
+	for _, name := range names {
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   name,
+			ShortDescription:     sarifMessage{sarifRules[name]},
+			HelpURI:              docURLs[name],
+			DefaultConfiguration: sarifConfiguration{severities[name].sarifLevel()},
+		})
+	}
+
+	results := []sarifResult{}
+	for _, r := range a.results {
+		name, ok := issueSeverities[r.issue]
+		if !ok {
+			continue
+		}
+
+		results = append(results, sarifResult{
+			RuleID:  name,
+			Level:   severities[name].sarifLevel(),
+			Message: sarifMessage{r.key.key.Type() + " key " + r.key.FingerprintSHA256() + ": " + r.issue},
+			Locations: []sarifLocation{{
+				LogicalLocations: []sarifLogicalLocation{{
+					Name:               r.key.FingerprintSHA256(),
+					FullyQualifiedName: r.key.key.Type() + " " + r.key.FingerprintSHA256(),
+					Kind:               "resource",
+				}},
+			}},
+		})
+	}
+
+	forwarding := []struct {
+		name      string
+		requested bool
+	}{{"agent", agentFwd}, {"x11", x11}}
+	for _, f := range forwarding {
+		if f.requested {
+			results = append(results, sarifResult{
+				RuleID:  f.name,
+				Level:   severities[f.name].sarifLevel(),
+				Message: sarifMessage{sarifRules[f.name]},
+			})
+		}
+	}
+
+	b, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{driver}, Results: results}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
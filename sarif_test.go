@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+// Every issue that can be found needs a SARIF rule
+func TestSARIFRulesComplete(t *testing.T) {
+	for issue, name := range issueSeverities {
+		if _, ok := sarifRules[name]; !ok {
+			t.Errorf("no SARIF rule for %s (%s)", issue, name)
+		}
+	}
+	for _, name := range []string{"agent", "x11"} {
+		if _, ok := sarifRules[name]; !ok {
+			t.Errorf("no SARIF rule for %s", name)
+		}
+	}
+}
+
+// sarifLevels are the levels allowed by the SARIF 2.1.0 schema
+var sarifLevels = map[string]bool{"none": true, "note": true, "warning": true, "error": true}
+
+// validateSARIF checks the log against the constraints that the SARIF 2.1.0
+// schema places on the properties used: those that are required, the
+// allowed values of enumerations, the formats of URIs and that results
+// refer to rules that are defined
+func validateSARIF(t *testing.T, data []byte) sarifLog {
+	t.Helper()
+
+	var log sarifLog
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&log); err != nil {
+		t.Fatal("invalid SARIF:", err)
+	}
+
+	uri := func(name, s string) {
+		if u, err := url.Parse(s); err != nil || !u.IsAbs() {
+			t.Errorf("%s %q isn't an absolute URI", name, s)
+		}
+	}
+
+	if log.Version != "2.1.0" {
+		t.Errorf("got version %q, expected 2.1.0", log.Version)
+	}
+	uri("$schema", log.Schema)
+	if len(log.Runs) == 0 {
+		t.Fatal("no runs")
+	}
+
+	for _, run := range log.Runs {
+		driver := run.Tool.Driver
+		if driver.Name == "" {
+			t.Error("tool has no name")
+		}
+		uri("informationUri", driver.InformationURI)
+
+		rules := make(map[string]bool)
+		for _, r := range driver.Rules {
+			switch {
+			case r.ID == "":
+				t.Error("rule has no id")
+			case rules[r.ID]:
+				t.Errorf("rule %s defined twice", r.ID)
+			case r.ShortDescription.Text == "":
+				t.Errorf("rule %s has no description", r.ID)
+			case !sarifLevels[r.DefaultConfiguration.Level]:
+				t.Errorf("rule %s has level %q", r.ID, r.DefaultConfiguration.Level)
+			}
+			if r.HelpURI != "" {
+				uri("helpUri", r.HelpURI)
+			}
+			rules[r.ID] = true
+		}
+
+		if run.Results == nil {
+			t.Error("results missing, rather than empty")
+		}
+		for _, r := range run.Results {
+			switch {
+			case !rules[r.RuleID]:
+				t.Errorf("result refers to undefined rule %q", r.RuleID)
+			case !sarifLevels[r.Level]:
+				t.Errorf("result for %s has level %q", r.RuleID, r.Level)
+			case r.Message.Text == "":
+				t.Errorf("result for %s has no message", r.RuleID)
+			}
+			for _, l := range r.Locations {
+				if len(l.LogicalLocations) == 0 || l.LogicalLocations[0].Name == "" {
+					t.Errorf("result for %s has an empty location", r.RuleID)
+				}
+			}
+		}
+	}
+
+	return log
+}
+
+func TestWriteSARIF(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		keys            []string
+		agentFwd, x11   bool
+		expectedResults []string
+	}{
+		{"no issues", []string{"ecdsa-256"}, false, false, nil},
+		{"weak keys", []string{"rsa-1024", "ecdsa-256", "dsa-1024"}, false, false, []string{"weak", "dsa"}},
+		{"forwarding", []string{"ecdsa-256"}, true, true, []string{"agent", "x11"}},
+	} {
+		var keys []*publicKey
+		for _, name := range test.keys {
+			keys = append(keys, &publicKey{key: generateKey(t, name)})
+		}
+		a := analyze(testLogger, keys, nil, nil)
+
+		var b bytes.Buffer
+		if err := writeSARIF(&b, a, test.agentFwd, test.x11); err != nil {
+			t.Fatal(err)
+		}
+		log := validateSARIF(t, b.Bytes())
+
+		results := log.Runs[0].Results
+		if len(results) != len(test.expectedResults) {
+			t.Fatalf("%s: got %d results, expected %d:\n%s", test.name, len(results), len(test.expectedResults), b.String())
+		}
+		for i, r := range results {
+			if r.RuleID != test.expectedResults[i] {
+				t.Errorf("%s: result %d is for %s, expected %s", test.name, i, r.RuleID, test.expectedResults[i])
+			}
+		}
+	}
+}
+
+// The report given to the sarif user is a valid SARIF log
+func TestSARIFReport(t *testing.T) {
+	validateSARIF(t, []byte(testReport(t, "sarif", testSigner(t))))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+// selfCheck verifies that a file the server loads its configuration from
+// can still be loaded
+type selfCheck struct {
+	name  string
+	check func() error
+}
+
+// runSelfChecks periodically runs each of the given checks, logging an
+// error for each that fails, so that files that have gone missing or been
+// corrupted are noticed before the server is reloaded or restarted. Nothing
+// that is loaded is replaced.
+func runSelfChecks(checks []selfCheck) {
+	if selfCheckInterval <= 0 {
+		return
+	}
+
+	ticker := clk.NewTicker(selfCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		failed := 0
+		for _, c := range checks {
+			if err := c.check(); err != nil {
+				log.Errorf("Self-check failed, %s can't be loaded: %s", c.name, err)
+				failed++
+			}
+		}
+
+		if failed == 0 {
+			log.WithField("checks", len(checks)).Debugln("Self-check passed")
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// revokedSerials maps the serial numbers of revoked certificates, along
+// with the CAs that issued them, to a note explaining why they were
+// revoked. It is a lighter-weight alternative to a KRL for revoking
+// certificates quickly, e.g. during an incident.
+var revokedSerials = struct {
+	mu      sync.RWMutex
+	serials map[revokedSerial]string
+}{
+	serials: make(map[revokedSerial]string),
+}
+
+// revokedSerial identifies a revoked certificate by the fingerprint of the
+// CA that issued it, as given by caFingerprint, and its serial number. No
+// fingerprint means the serial is revoked whichever CA issued it.
+type revokedSerial struct {
+	ca     string
+	serial uint64
+}
+
+// caFingerprint returns the fingerprint in the form revoked serials are
+// keyed by: SHA-256 fingerprints without padding, and MD5 fingerprints in
+// lower case without their prefix
+func caFingerprint(fingerprint string) string {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return strings.TrimRight(fingerprint, "=")
+	}
+
+	return strings.TrimPrefix(strings.ToLower(fingerprint), "md5:")
+}
+
+// loadRevokedSerials replaces the revoked serials with those listed in the
+// named file. Each line gives the MD5 or SHA-256 fingerprint of the CA that
+// issued the certificate, if it should only be revoked for that CA, then a
+// decimal serial number, optionally followed by a note. The existing serials
+// are kept if the file can't be read.
+func loadRevokedSerials(path string) error {
+	serials, err := readRevokedSerials(path)
+	if err != nil {
+		return err
+	}
+
+	revokedSerials.mu.Lock()
+	revokedSerials.serials = serials
+	revokedSerials.mu.Unlock()
+
+	return nil
+}
+
+// readRevokedSerials reads the revoked serials listed in the named file
+func readRevokedSerials(path string) (map[revokedSerial]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	serials := make(map[revokedSerial]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		var ca string
+		if fields := strings.SplitN(entry, " ", 2); isFingerprint(fields[0]) {
+			if len(fields) == 1 {
+				return nil, fmt.Errorf("expected a serial number after the CA on line %d: %q", line, entry)
+			}
+			ca, entry = caFingerprint(fields[0]), strings.TrimSpace(fields[1])
+		}
+
+		fields := strings.SplitN(entry, " ", 2)
+		serial, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a serial number on line %d: %q", line, entry)
+		}
+
+		var note string
+		if len(fields) == 2 {
+			note = strings.TrimSpace(fields[1])
+		}
+		serials[revokedSerial{ca, serial}] = note
+	}
+
+	return serials, scanner.Err()
+}
+
+// serialRevoked returns why the key is revoked, if it is a certificate
+// whose serial number has been revoked
+func serialRevoked(k *publicKey) (string, bool) {
+	cert, ok := k.key.(*ssh.Certificate)
+	if !ok {
+		return "", false
+	}
+
+	revokedSerials.mu.RLock()
+	defer revokedSerials.mu.RUnlock()
+
+	issuers := []string{""}
+	if cert.SignatureKey != nil {
+		ca := &publicKey{key: cert.SignatureKey}
+		issuers = append([]string{ca.FingerprintSHA256(), ca.Fingerprint()}, issuers...)
+	}
+
+	var note string
+	for _, issuer := range issuers {
+		if note, ok = revokedSerials.serials[revokedSerial{issuer, cert.Serial}]; ok {
+			break
+		}
+	}
+	if !ok {
+		return "", false
+	}
+
+	reason := fmt.Sprintf("certificate serial %d revoked", cert.Serial)
+	if note != "" {
+		reason += ": " + note
+	}
+
+	return reason, true
+}
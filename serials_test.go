@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testCertificate returns a user certificate for key with the given serial
+// and key ID, signed by ca
+func testCertificate(t *testing.T, ca ssh.Signer, key ssh.PublicKey, serial uint64, keyID string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:         key,
+		Serial:      serial,
+		KeyId:       keyID,
+		CertType:    ssh.UserCert,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestSerialRevoked(t *testing.T) {
+	defer func(serials map[revokedSerial]string) { revokedSerials.serials = serials }(revokedSerials.serials)
+
+	ca, otherCA := testSigner(t), testSigner(t)
+	caKey := &publicKey{key: ca.PublicKey()}
+
+	dir, err := ioutil.TempDir("", "serials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revoked")
+	err = ioutil.WriteFile(path, []byte(`# Revoked for one CA only
+`+caKey.FingerprintSHA256()+` 1042 INC-311
+MD5:`+caKey.Fingerprint()+` 1043
+
+# Revoked for every CA
+2001
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadRevokedSerials(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		ca      ssh.Signer
+		serial  uint64
+		reason  string
+		revoked bool
+	}{
+		{"CA listed by SHA-256 fingerprint", ca, 1042, "certificate serial 1042 revoked: INC-311", true},
+		{"CA listed by MD5 fingerprint", ca, 1043, "certificate serial 1043 revoked", true},
+		{"another CA's serial", otherCA, 1042, "", false},
+		{"serial for every CA", otherCA, 2001, "certificate serial 2001 revoked", true},
+		{"serial not listed", ca, 1044, "", false},
+	} {
+		reason, revoked := serialRevoked(&publicKey{key: testCertificate(t, test.ca, testSigner(t).PublicKey(), test.serial, "")})
+		if reason != test.reason || revoked != test.revoked {
+			t.Errorf("%s: got %q, %t, expected %q, %t", test.name, reason, revoked, test.reason, test.revoked)
+		}
+	}
+
+	if reason, revoked := serialRevoked(&publicKey{key: ca.PublicKey()}); revoked {
+		t.Errorf("plain key revoked by serial: %q", reason)
+	}
+}
+
+func TestReadRevokedSerialsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, entry := range []string{"not-a-serial", "SHA256:AAAA", "SHA256:AAAA INC-311"} {
+		path := filepath.Join(dir, "revoked")
+		if err := ioutil.WriteFile(path, []byte(entry+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readRevokedSerials(path); err == nil {
+			t.Errorf("%q: expected an error", entry)
+		}
+	}
+}
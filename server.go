@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"strings"
 	"sync"
-	"text/tabwriter"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -15,24 +18,187 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// Issues shown for each key in the report
+const (
+	issueNone              = "No known issues"
+	issueBlacklisted       = "BLACKLISTED"
+	issueBlacklistedDebian = "BLACKLISTED (Debian weak key)"
+	issueBlacklistedLocal  = "BLACKLISTED (local)"
+	issueCollision         = "FINGERPRINT COLLISION"
+	issueTypeMismatch      = "TYPE/ALGORITHM MISMATCH"
+	issueSharedModulus     = "SHARED MODULUS"
+	issueTrivialModulus    = "TRIVIALLY FACTORABLE"
+	issueKnownFactor       = "FACTORABLE (known factor)"
+	issueLowEntropy        = "LOW ENTROPY"
+	issueRevoked           = "REVOKED (KRL)"
+	issueRevokedSerial     = "REVOKED (serial)"
+	issueWeakModulus       = "WEAK MODULUS (EXPERIMENTAL)"
+	issueDSA               = "DSA KEY"
+	issueMismatch          = "SIZE MISMATCH"
+	issueNonCanonical      = "NON-CANONICAL ENCODING"
+	issueWellKnown         = "WELL-KNOWN INSECURE KEY"
+	issueContainerImage    = "KEY FROM PUBLIC CONTAINER IMAGE"
+	issueUnparseable       = "UNPARSEABLE KEY"
+	issueExempt            = "KNOWN EXCEPTION"
+	issueOutOfScope        = "Not in scope"
+	issueWeak              = "WEAK KEY LENGTH"
+	issueWeakCurve         = "WEAK CURVE"
+	issueWeakSHA1          = "WEAK KEY LENGTH, SHA-1 ONLY"
+)
+
+// recommendations are the actions to recommend for each issue found, in
+// order of priority
+var recommendations = []struct {
+	issue, action string
+}{
+	{issueWellKnown, "Replace %d well-known key(s) immediately"},
+	{issueContainerImage, "Replace %d key(s) shipped in public container images immediately"},
+	{issueBlacklistedDebian, "Replace %d key(s) generated by Debian's broken OpenSSL package immediately"},
+	{issueBlacklistedLocal, "Replace %d key(s) blacklisted by this server's operator immediately"},
+	{issueBlacklisted, "Replace %d blacklisted key(s) immediately"},
+	{issueRevoked, "Stop using %d revoked key(s) or certificate(s)"},
+	{issueRevokedSerial, "Stop using %d certificate(s) with a revoked serial number"},
+	{issueCollision, "Investigate %d key(s) with colliding fingerprints"},
+	{issueTypeMismatch, "Investigate %d key(s) of a different type than declared"},
+	{issueTrivialModulus, "Replace %d RSA key(s) with a trivially factorable modulus immediately"},
+	{issueKnownFactor, "Replace %d RSA key(s) with a modulus divisible by a known factor immediately"},
+	{issueLowEntropy, "Replace %d key(s) generated with too little entropy, on a different device"},
+	{issueSharedModulus, "Replace %d RSA key(s) sharing a modulus with another key"},
+	{issueWeakModulus, "Replace %d RSA key(s) whose modulus has been factored"},
+	{issueDSA, "Remove %d DSA key(s)"},
+	{issueWeakSHA1, "Replace %d weak RSA key(s) with ECDSA keys, and upgrade your SSH client"},
+	{issueWeak, "Replace %d weak RSA key(s)"},
+	{issueWeakCurve, "Replace %d ECDSA key(s) on weak curves"},
+	{issueMismatch, "Regenerate %d key(s) with a mismatched size"},
+	{issueNonCanonical, "Export %d key(s) with a non-canonical encoding again"},
+	{issueUnparseable, "Investigate %d key(s) that couldn't be parsed"},
+}
+
+// sessions records the keys offered during each session's handshake, by
+// session ID, along with when the first key was offered and how many keys
+// were left unchecked once maxAuthTries was reached
 var sessions = struct {
-	mu   sync.RWMutex
-	keys map[string][]*publicKey
+	mu      sync.RWMutex
+	keys    map[string][]*publicKey
+	added   map[string]time.Time
+	dropped map[string]int
 }{
-	keys: make(map[string][]*publicKey),
+	keys:    make(map[string][]*publicKey),
+	added:   make(map[string]time.Time),
+	dropped: make(map[string]int),
+}
+
+// forgetSession removes everything recorded about a session. The caller
+// must hold sessions.mu.
+func forgetSession(id string) {
+	delete(sessions.keys, id)
+	delete(sessions.added, id)
+	delete(sessions.dropped, id)
 }
 
-func serve(config *ssh.ServerConfig, nConn net.Conn) {
-	// Before use, a handshake must be performed on the incoming net.Conn
-	conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+// evictSessions periodically removes sessions that were added longer ago
+// than sessionTTL. serve removes each session once it's done, but never sees
+// sessions whose handshake failed after the client offered its keys.
+func evictSessions() {
+	ticker := clk.NewTicker(sessionTTL / 2)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		var evicted int
+		now := clk.Now()
+
+		sessions.mu.Lock()
+		for id, added := range sessions.added {
+			if now.Sub(added) > sessionTTL {
+				forgetSession(id)
+				evicted++
+			}
+		}
+		sessions.mu.Unlock()
+
+		if evicted > 0 {
+			log.Infof("Evicted %d abandoned session(s)", evicted)
+		}
+	}
+}
+
+// abandonSession removes the keys offered during a session whose handshake
+// failed, logging them so that there is a record of what the client
+// presented even though the report couldn't be delivered
+func abandonSession(logger *log.Entry, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+
+	sessions.mu.Lock()
+	keys := sessions.keys[sessionID]
+	forgetSession(sessionID)
+	sessions.mu.Unlock()
+
+	var fingerprints []string
+	for _, k := range keys {
+		fingerprints = append(fingerprints, k.LogFingerprint())
+	}
+
+	logger.WithField("fingerprints", strings.Join(fingerprints, ",")).Warnf("Client presented %d key(s) but disconnected before authenticating, so the report couldn't be delivered", len(keys))
+}
+
+func serve(config *ssh.ServerConfig, nConn *tracedConn) {
+	logger := nConn.logger()
+
+	// Note the session ID when a key is offered, so that the keys can be
+	// found if the client drops the connection before authenticating
+	connConfig := *config
+	var sessionID string
+	connConfig.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		sessionID = string(c.SessionID())
+		return config.PublicKeyCallback(c, key)
+	}
+
+	// Before use, a handshake must be performed on the incoming net.Conn.
+	// Reading a PROXY protocol header clears the deadline set by handle,
+	// so it's set again; it's cleared once the client has authenticated.
+	sniffer := &kexSniffer{Conn: &writeTimeoutConn{Conn: nConn}}
+	setHandshakeDeadline(nConn)
+	conn, chans, reqs, err := ssh.NewServerConn(sniffer, &connConfig)
+	recordHandshake(err != nil)
+	if err == nil {
+		nConn.SetDeadline(time.Time{})
+	}
 	if err != nil {
-		log.Warnln("Failed to handshake:", err)
+		if timedOut(err) {
+			atomic.AddUint64(&reaped.handshake, 1)
+			logger.Infoln("Closing connection from", nConn.RemoteAddr(), "as it didn't complete the handshake within", handshakeTimeout)
+		} else if invalidCurvePoint(err) {
+			// Keys like this are crafted to attack servers that don't
+			// validate them
+			logger.Errorln("Failed to handshake, client offered an ECDSA key with an INVALID CURVE POINT:", err)
+		} else if malformedKeyError(err) {
+			// The ssh package aborts the handshake when a key can't be
+			// parsed, so we can't report it to the user
+			logger.Warnln("Failed to handshake, client offered MALFORMED KEY DATA:", err)
+		} else if kexInit := sniffer.clientKexInit(); noCommonAlgorithms(err) && kexInit != nil {
+			logger.WithFields(log.Fields{
+				"kex":     strings.Join(kexInit.KexAlgos, ","),
+				"ciphers": strings.Join(kexInit.CiphersClientServer, ","),
+				"macs":    strings.Join(kexInit.MACsClientServer, ","),
+			}).Warnln("Failed to handshake, client offered no algorithms in common with the server:", err)
+		} else {
+			logger.Warnln("Failed to handshake:", err)
+		}
+		abandonSession(logger, sessionID)
+		recordOffence(logger, nConn.RemoteAddr(), "failing the handshake")
 		return
 	}
 
+	start := clk.Now()
 	defer func() {
+		duration := clk.Now().Sub(start)
+		recordSession(duration)
+		logger.WithField("duration", duration.String()).Infoln("Session from", conn.RemoteAddr(), "ended")
+
 		sessions.mu.Lock()
-		delete(sessions.keys, string(conn.SessionID()))
+		forgetSession(string(conn.SessionID()))
 		sessions.mu.Unlock()
 		conn.Close()
 	}()
@@ -42,10 +208,32 @@ func serve(config *ssh.ServerConfig, nConn net.Conn) {
 
 	sessions.mu.RLock()
 	keys := sessions.keys[string(conn.SessionID())]
+	dropped := sessions.dropped[string(conn.SessionID())]
 	sessions.mu.RUnlock()
 
+	// Don't wait forever for clients that never open a channel, such as
+	// scanners that only wanted to see the handshake
+	noChannel := clk.AfterFunc(channelTimeout, func() {
+		logger.Infoln("Closing connection from", conn.RemoteAddr(), "as no channel was opened")
+		recordOffence(logger, conn.RemoteAddr(), "opening no channel")
+		atomic.AddUint64(&reaped.channel, 1)
+		conn.Close()
+	})
+
+	// However active it is, no connection is kept open indefinitely
+	if sessionTimeout > 0 {
+		expired := clk.AfterFunc(sessionTimeout, func() {
+			logger.Infoln("Closing connection from", conn.RemoteAddr(), "as it has been open for", sessionTimeout)
+			atomic.AddUint64(&reaped.session, 1)
+			conn.Close()
+		})
+		defer expired.Stop()
+	}
+
 	// Service the incoming Channel channel
 	for n := range chans {
+		noChannel.Stop()
+
 		// Channels have a type, depending on the application level
 		// protocol intended. In the case of a shell, the type is
 		// "session" and ServerShell may be used to present a simple
@@ -57,34 +245,149 @@ func serve(config *ssh.ServerConfig, nConn net.Conn) {
 
 		channel, requests, err := n.Accept()
 		if err != nil {
-			log.Warnln("Could not accept channel:", err)
+			logger.Warnln("Could not accept channel:", err)
 			continue
 		}
 
-		agentFwd, x11 := false, false
-		reqLock := &sync.Mutex{}
-		reqLock.Lock()
-		timeout := time.AfterFunc(30*time.Second, func() { reqLock.Unlock() })
+		agentFwd, x11, pty, agentAudit := false, false, false, false
+		var token, lang, forwardedFor, command string
+		var columns uint32
 
+		// started is closed once the client has asked for a shell, command
+		// or subsystem, or has given up or taken too long to, so that the
+		// report can go ahead. "auth-agent-req@openssh.com", "x11-req" and
+		// "pty-req" always arrive before then from well-behaved clients.
+		// agentFwd, x11, pty, columns, agentAudit, token, lang,
+		// forwardedFor and command are only written before started is
+		// closed, so are safe to read once it is; requests arriving later
+		// are too late to change the report, so are ignored.
+		started := make(chan struct{})
+		reqsDone := make(chan struct{})
 		go func(in <-chan *ssh.Request) {
-			for req := range in {
+			defer close(reqsDone)
+
+			waiting := true
+			start := func() {
+				if waiting {
+					waiting = false
+					close(started)
+				}
+			}
+			defer start()
+
+			timeout := clk.After(30 * time.Second)
+			for {
+				var req *ssh.Request
+				select {
+				case req = <-in:
+				case <-timeout:
+					start()
+					continue
+				}
+				if req == nil {
+					return
+				}
+
 				ok := false
 				switch req.Type {
-				case "shell":
-					fallthrough
 				case "pty-req":
 					ok = true
 
-					// "auth-agent-req@openssh.com" and "x11-req" always arrive
-					// before the "pty-req", so we can go ahead now
-					if timeout.Stop() {
-						reqLock.Unlock()
+					// The report's formatting can't change once it
+					// has started
+					if waiting {
+						pty = true
+
+						var ptyReq struct {
+							Term          string
+							Columns, Rows uint32
+							Width, Height uint32
+							Modes         string
+						}
+						if ssh.Unmarshal(req.Payload, &ptyReq) == nil {
+							columns = ptyReq.Columns
+						}
+					}
+
+					// The request for a shell or subsystem should follow
+					// straight away, but don't leave clients that stall
+					// waiting long for their report
+					timeout = clk.After(time.Second)
+
+				case "shell":
+					ok = true
+					start()
+
+				case "exec":
+					// Commands aren't run; the report is shown instead,
+					// in the format the command asks for, if any
+					var exec struct{ Command string }
+					if ssh.Unmarshal(req.Payload, &exec) == nil && waiting {
+						command = strings.Join(strings.Fields(exec.Command), " ")
+					}
+					ok = true
+					start()
+
+				case "subsystem":
+					// Listing the keys in the client's agent is only done
+					// when explicitly asked for, using `ssh -s host agent`
+					var subsystem struct{ Name string }
+					if ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "agent" && waiting {
+						ok = true
+						agentAudit = true
+					}
+
+					start()
+
+				case "env":
+					var env struct{ Name, Value string }
+					if ssh.Unmarshal(req.Payload, &env) != nil || !waiting {
+						break
+					}
+
+					switch env.Name {
+					case "KEYCHECK_TOKEN":
+						// Clients can identify themselves for
+						// comparison with their previous session,
+						// e.g. using `ssh -o SetEnv=KEYCHECK_TOKEN=...`
+						ok = true
+						token = env.Value
+					case "LANG":
+						// Many clients send the user's locale, which
+						// chooses the report's language if supported
+						if l := language(env.Value); l != "" {
+							ok = true
+							lang = l
+						}
+					case "X_FORWARDED_FOR":
+						// Proxies in TRUSTED_PROXIES can give the
+						// address of the client they forward for
+						if client, trusted := forwardedClient(conn.RemoteAddr(), env.Value); trusted {
+							ok = true
+							forwardedFor = client
+						}
 					}
 
 				case "auth-agent-req@openssh.com":
-					agentFwd = true
+					if waiting {
+						agentFwd = true
+					}
 				case "x11-req":
-					x11 = true
+					if waiting {
+						x11 = true
+					}
+
+				case "signal":
+					// Sent when the user interrupts the session, e.g.
+					// while waiting for the report. Nothing is running
+					// to deliver it to, so there's nothing to do.
+					ok = true
+
+				case "break":
+					// Sent by clients when the user asks for a break,
+					// e.g. using `~B`, which only means something to
+					// serial consoles (RFC 4335)
+					ok = true
 				}
 
 				if req.WantReply {
@@ -93,84 +396,854 @@ func serve(config *ssh.ServerConfig, nConn net.Conn) {
 			}
 		}(requests)
 
-		markBlacklistedKeys(keys)
+		// Wait until the client has asked for a shell, so that we know
+		// whether the session is interactive
+		<-started
+
+		// The language can also be chosen using a suffix to the username,
+		// e.g. "verbose+fr", which takes precedence over the client's locale
+		user, userLang := splitLanguage(conn.User())
+		if userLang != "" {
+			lang = userLang
+		}
+
+		// Behind a trusted proxy, clients are identified by the address it
+		// forwards for rather than its own
+		clientHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if forwardedFor != "" {
+			logger.Infof("Proxy %s is forwarding for %s", conn.RemoteAddr(), forwardedFor)
+			clientHost = forwardedFor
+		}
+		translate := translator(lang)
+
+		// Messages keep their line breaks unless asked to fit the
+		// client's terminal
+		width := messageWidth
+		if pty && columns > 0 {
+			width = int(columns)
+		}
+		wrapWidth := 0
+		if wrapMessages {
+			wrapWidth = width
+		}
+		renderf := func(msg string, args ...interface{}) string {
+			return formatMessage(translate(msg), wrapWidth, args...)
+		}
+		render := func(msg string) string {
+			return renderf(msg)
+		}
+
+		// Connecting as the "status" user gives a one word summary, for use
+		// in scripts
+		status := user == "status"
 
-		channel.Write([]byte(welcomeMsg))
+		// Output meant for scripts mustn't be mixed with progress dots
+		machine := status || user == "csv" || user == "sarif" || user == "html" || isFingerprint(user) || command == jsonCommand
 
+		// Lines are read through one reader, so that an answer the agent
+		// prompt stopped waiting for isn't lost to the menu
+		input := newLineReader(channel, pty)
+
+		// Anyone the user forwards their agent to can list its keys, but
+		// doing so here could still surprise them, so ask first. The
+		// prompt is part of the report, so is signed along with it.
+		var transcript bytes.Buffer
+		agentConsent := false
+		if agentAudit && agentFwd {
+			agentConsent = confirmAgentAudit(logger, io.MultiWriter(channel, &transcript), input)
+		}
+
+		// Streamed rows show progress themselves, and keepalives written
+		// to the terminal would land among them
+		stream := streamRows && !machine
+
+		// Addresses sent a report within briefWindow aren't welcomed
+		// again, nor given advice they have already been shown
+		var explained map[string]bool
+		var returning bool
+		if briefWindow > 0 && !machine {
+			explained, returning = previousVisit(visitorID(clientHost))
+		}
+		hidden := func(issue string) bool {
+			return hiddenMsgs[issue] || explained[issue]
+		}
+
+		// Let interactive users know we're busy in case the checks are slow
+		if pty && !machine && !stream {
+			channel.Write([]byte(render(progressMsg)))
+		}
+
+		stopKeepalive := keepalive(conn, channel, pty && !machine && !stream)
+
+		// Keepalives continue during the delay, so that proxies don't drop
+		// the connection, but there's no point waiting for clients that
+		// have already gone away
+		if greetingDelay > 0 {
+			select {
+			case <-clk.After(greetingDelay):
+			case <-reqsDone:
+			}
+		}
+
+		// Clients offering many keys risk exceeding servers' MaxAuthTries
+		offered := len(keys) + dropped
+
+		var agentAuditErr error
+		var sources []string
+		if agentAudit && agentFwd && agentConsent {
+			var listed []*publicKey
+			listed, agentAuditErr = agentKeys(logger, conn)
+			if agentAuditErr != nil {
+				logger.Warnln("Failed to list keys in forwarded agent:", agentAuditErr)
+			} else {
+				sources = agentSources(keys, listed)
+			}
+			keys = mergeKeys(keys, listed)
+		}
+
+		// Everything shown above the table is known before the keys are
+		// checked, so is sent first when streaming rows
+		intro := func(out io.Writer) {
+			if pty && !stream {
+				// Carriage return and erase the progress indicator,
+				// which isn't part of the report, so isn't signed
+				channel.Write([]byte("\r\x1b[K"))
+			}
+
+			if agentAudit {
+				switch {
+				case !agentFwd:
+					out.Write([]byte(render(agentAuditNoFwdMsg)))
+				case !agentConsent:
+					out.Write([]byte(render(agentAuditDeclinedMsg)))
+				case agentAuditErr != nil:
+					out.Write([]byte(render(agentAuditFailedMsg)))
+				default:
+					out.Write([]byte(render(agentAuditMsg)))
+					if len(sources) > 0 {
+						out.Write([]byte(renderf(agentSourcesMsg, strings.Join(sources, "\n\r          "))))
+					}
+				}
+			}
+
+			// Banners are decoration, so are kept out of scripts' output
+			if pty && bannerMsg != "" {
+				out.Write([]byte(bannerMsg))
+			}
+			if returning {
+				out.Write([]byte(renderf(returningMsg, briefWindow)))
+			} else {
+				out.Write([]byte(render(welcomeMsg)))
+			}
+		}
+
+		// Streamed rows are sent as each key is checked, in the order the
+		// keys were presented, so the table is cut short after the first
+		// maxRows keys rather than the most severe
+		var streamed *reportWriter
+		var checked func(keyResult)
+		if stream {
+			streamed = &reportWriter{w: channel, transcript: &transcript}
+			intro(streamed)
+
+			shown := keys
+			if maxRows > 0 && len(shown) > maxRows {
+				shown = shown[:maxRows]
+			}
+			t := newStreamedTable(shown)
+			streamed.Write([]byte(t.header()))
+			streamed.flush()
+
+			checked = func(r keyResult) {
+				if r.index <= len(shown) {
+					r.issue = scoped(r.issue)
+					streamed.Write([]byte(t.row(r)))
+					streamed.flush()
+				}
+			}
+		}
+
+		a := analyze(logger, keys, sniffer.clientKexInit(), checked)
+		recordStats(a)
+		leaveOutOfScope(a)
+
+		rows := a.results
 		var table bytes.Buffer
-		tabWriter := new(tabwriter.Writer)
-		tabWriter.Init(&table, 5, 2, 2, ' ', 0)
-		// Note that using tabwriter, columns are tab-terminated,
-		// not tab-delimited
-		fmt.Fprint(tabWriter, "Bits\tType\tFingerprint\tIssues\n")
+		if stream {
+			if maxRows > 0 && len(rows) > maxRows {
+				rows = rows[:maxRows]
+			}
+		} else {
+			// Clients presenting a great many keys get a shorter table,
+			// showing the keys with the most severe issues
+			if keyOrder == "severity" {
+				rows = severityOrder(rows)
+			}
+			if maxRows > 0 && len(rows) > maxRows {
+				rows = mostSevere(rows, maxRows)
+			}
 
-		var issues string
-		var blacklisted, weak, dsa bool
-		for _, k := range keys {
-			issues = "No known issues"
-			length, err := k.BitLen()
+			if err := writeTable(&table, rows); err != nil {
+				logger.Errorln("Error when flushing tab writer:", err)
+			}
+		}
+
+		if truncated := len(a.results) - len(rows); truncated > 0 {
+			fmt.Fprintf(&table, "... and %d more keys (truncated)\n", truncated)
+		}
+
+		stopKeepalive()
+
+		// The report is buffered and sent in one write, so that it isn't
+		// shown in chunks over slow links. Writing stops as soon as a write
+		// fails, as the client has most likely gone away.
+		out := &reportWriter{w: channel, transcript: &transcript}
+		if streamed != nil {
+			out.err = streamed.err
+		}
 
-			if err != nil {
-				log.Errorf("Failed to determine key length for %s key: %s", k.key.Type(), err)
+		found := map[string]bool{
+			"wellknown":     a.wellKnown,
+			"container":     a.containerImage,
+			"blacklisted":   a.blacklisted,
+			"revoked":       a.revoked,
+			"collision":     a.collision,
+			"typemismatch":  a.typeMismatch,
+			"trivial":       a.trivialModulus,
+			"factor":        a.knownFactor,
+			"entropy":       a.lowEntropy,
+			"sharedmodulus": a.sharedModulus,
+			"modulus":       a.weakModulus,
+			"dsa":           a.dsa,
+			"weak":          a.weak,
+			"curve":         a.weakCurve,
+			"mismatch":      a.mismatch,
+			"encoding":      a.nonCanonical,
+			"unparseable":   a.unparseable,
+			"agent":         agentFwd,
+			"x11":           x11,
+		}
+		for name := range found {
+			found[name] = found[name] && inScope(name)
+		}
+		verdict, exitStatus := worstSeverity(found, requireModern && !a.modern).status()
+		recordFindings(found, a.results)
+
+		issues := []string{}
+		for name, ok := range found {
+			if ok {
+				issues = append(issues, name)
 			}
+		}
+		sort.Strings(issues)
+		record := auditRecord{
+			Time:            clk.Now().UTC(),
+			RemoteAddr:      conn.RemoteAddr().String(),
+			ForwardedFor:    forwardedFor,
+			Conn:            nConn.id,
+			Ref:             nConn.ref,
+			User:            user,
+			Keys:            auditKeys(a.results),
+			Issues:          issues,
+			AgentForwarding: agentFwd,
+			X11Forwarding:   x11,
+			Verdict:         verdict,
+		}
+		audit(logger, record)
+		recordRecent(record)
 
-			if k.key.Type() == ssh.KeyAlgoDSA {
-				issues = "DSA KEY"
-				dsa = true
+		// Running "report --json" gives the report as JSON, for use in CI
+		if command == jsonCommand {
+			if err := writeJSON(out, a, agentFwd, x11, verdict); err != nil {
+				logger.Errorln("Failed to encode JSON:", err)
 			}
 
-			if length < 2048 && k.key.Type() == ssh.KeyAlgoRSA {
-				issues = "WEAK KEY LENGTH"
-				weak = true
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, 0)
+			channel.Close()
+			continue
+		}
+
+		// Connecting with a fingerprint as the user name checks whether
+		// the client presented that key
+		if expected := user; isFingerprint(expected) {
+			result, exitStatus := "NO MATCH", 1
+			for _, k := range keys {
+				if k.MatchesFingerprint(expected) {
+					result, exitStatus = fmt.Sprintf("MATCH: %s %s", k.key.Type(), k.FingerprintSHA256()), 0
+					break
+				}
 			}
 
-			if k.blacklisted {
-				// being blacklisted takes priority of any key length weaknesses
-				issues = "BLACKLISTED"
-				blacklisted = true
+			out.Write([]byte(result + "\n"))
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, exitStatus)
+			channel.Close()
+			continue
+		}
+
+		// Connecting as the "csv" user gives one row per key, for use in
+		// spreadsheets
+		if user == "csv" {
+			w := csv.NewWriter(out)
+			w.UseCRLF = true
+			header := []string{"Type", "Bits", "Fingerprint", "SHA256 fingerprint", "Accepted by " + openssh9.name, "Issues"}
+			if fips != nil {
+				header = append(header, fips.name)
 			}
+			w.Write(header)
+			for _, r := range a.results {
+				record := []string{
+					r.key.key.Type(),
+					r.bits(),
+					r.key.Fingerprint(),
+					r.key.FingerprintSHA256(),
+					r.accepted,
+					r.issue,
+				}
+				if fips != nil {
+					record = append(record, r.fips)
+				}
+				w.Write(record)
+			}
+			w.Flush()
 
-			fmt.Fprintf(tabWriter, "%d\t%s\t%s\t%s\t\n", length, k.key.Type(), k.Fingerprint(), issues)
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, 0)
+			channel.Close()
+			continue
 		}
 
-		err = tabWriter.Flush()
-		if err != nil {
-			log.Errorln("Error when flushing tab writer:", err)
+		// Connecting as the "sarif" user describes the issues found in
+		// SARIF, for use with security scanning tools
+		if user == "sarif" {
+			if err := writeSARIF(out, a, agentFwd, x11); err != nil {
+				logger.Errorln("Failed to encode SARIF:", err)
+			}
+
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, 0)
+			channel.Close()
+			continue
 		}
-		channel.Write([]byte(
+
+		// Connecting as the "html" user gives the report as a web page, for
+		// users who'd rather not read it in a terminal
+		if user == "html" {
+			if err := writeHTML(out, a, found, verdict); err != nil {
+				logger.Errorln("Failed to render HTML:", err)
+			}
+
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, 0)
+			channel.Close()
+			continue
+		}
+
+		if status {
+			out.Write([]byte(verdict + "\n"))
+			out.flush()
+			out.logError(logger)
+			sendExitStatus(channel, exitStatus)
+			channel.Close()
+			continue
+		}
+
+		if !stream {
+			intro(out)
+		}
+		out.Write([]byte(
 			strings.Replace(table.String(), "\n", "\n\r", -1) +
 				"\n\r"))
 
-		if blacklisted {
-			channel.Write([]byte(blacklistMsg))
+		host := &publicKey{key: hostKey.PublicKey()}
+		out.Write([]byte(renderf(hostKeyMsg, host.key.Type(), host.FingerprintSHA256())))
+
+		// Connecting as the "verbose" user also shows details of the
+		// SSH transport
+		if user == "verbose" {
+			out.Write([]byte(transportDetails(conn, config, sniffer.clientKexInit())))
+			out.Write([]byte(rejectionDetails(keys)))
+		}
+
+		// Connecting as the "explain" user, or with TEACHING_MODE set,
+		// explains what was checked for each key and why
+		if user == "explain" || teachingMode && !machine {
+			for _, r := range rows {
+				out.Write([]byte(explain(r, width)))
+			}
+		}
+
+		if a.wellKnown && !hidden("wellknown") {
+			out.Write([]byte(labelled("wellknown", translate(wellKnownMsg), wrapWidth, strings.Join(a.wellKnownSources, "\n\r          "))))
+		}
+
+		if a.containerImage && !hidden("container") {
+			out.Write([]byte(labelled("container", translate(containerImageMsg), wrapWidth, strings.Join(a.containerImages, "\n\r          "))))
+		}
+
+		if a.blacklisted && !hidden("blacklisted") {
+			out.Write([]byte(labelled("blacklisted", translate(blacklistMsg), wrapWidth, strings.Join(a.blacklistSources, "\n\r          "))))
+		}
+
+		if a.revoked && !hidden("revoked") {
+			out.Write([]byte(labelled("revoked", translate(revokedMsg), wrapWidth, strings.Join(a.revocations, "\n\r          "))))
+		}
+
+		if a.collision && !hidden("collision") {
+			out.Write([]byte(labelled("collision", translate(collisionMsg), wrapWidth)))
+		}
+
+		if a.typeMismatch && !hidden("typemismatch") {
+			out.Write([]byte(labelled("typemismatch", translate(typeMismatchMsg), wrapWidth, strings.Join(a.typeMismatches, "\n\r          "))))
+		}
+
+		if a.trivialModulus && !hidden("trivial") {
+			out.Write([]byte(labelled("trivial", translate(trivialModulusMsg), wrapWidth, strings.Join(a.trivialModuli, "\n\r          "))))
+		}
+
+		if a.knownFactor && !hidden("factor") {
+			out.Write([]byte(labelled("factor", translate(knownFactorMsg), wrapWidth, strings.Join(a.factoredModuli, "\n\r          "))))
+		}
+
+		if a.lowEntropy && !hidden("entropy") {
+			out.Write([]byte(labelled("entropy", translate(lowEntropyMsg), wrapWidth, strings.Join(a.lowEntropyKeys, "\n\r          "))))
+		}
+
+		if a.sharedModulus && !hidden("sharedmodulus") {
+			out.Write([]byte(labelled("sharedmodulus", translate(sharedModulusMsg), wrapWidth, strings.Join(a.sharedModuli, "\n\r          "))))
+		}
+
+		if a.weakModulus && !hidden("modulus") {
+			out.Write([]byte(labelled("modulus", translate(weakModulusMsg), wrapWidth, strings.Join(a.weakModuli, "\n\r          "))))
+		}
+
+		if a.dsa && !hidden("dsa") {
+			out.Write([]byte(labelled("dsa", translate(dsaMsg), wrapWidth)))
+		}
+
+		if a.weakSHA1 && !hidden("weak") {
+			out.Write([]byte(labelled("weak", translate(weakSHA1Msg), wrapWidth)))
+		} else if a.weak && !hidden("weak") {
+			out.Write([]byte(labelled("weak", translate(weakMsg), wrapWidth)))
+		}
+
+		if a.weakCurve && !hidden("curve") {
+			out.Write([]byte(labelled("curve", translate(weakCurveMsg), wrapWidth, strings.Join(a.weakCurves, "\n\r          "))))
+		}
+
+		if a.minimumCurve {
+			out.Write([]byte(render(minimumCurveMsg)))
+		}
+
+		if a.mismatch && !hidden("mismatch") {
+			out.Write([]byte(labelled("mismatch", translate(mismatchMsg), wrapWidth)))
+		}
+
+		if a.nonCanonical && !hidden("encoding") {
+			out.Write([]byte(labelled("encoding", translate(nonCanonicalMsg), wrapWidth, strings.Join(a.nonCanonicalKeys, "\n\r          "))))
+		}
+
+		if a.unparseable && !hidden("unparseable") {
+			out.Write([]byte(labelled("unparseable", translate(unparseableMsg), wrapWidth, strings.Join(a.unparseableErrs, "\n\r          "))))
+		}
+
+		if a.exempt {
+			out.Write([]byte(renderf(exemptMsg, strings.Join(a.exemptions, "\n\r          "))))
+		}
+
+		if compareHostKey && a.weakerThanHost {
+			out.Write([]byte(renderf(weakerThanHostMsg, a.hostBits)))
 		}
 
-		if dsa {
-			channel.Write([]byte(dsaMsg))
+		if a.strongRSA {
+			out.Write([]byte(render(rsaSignatureMsg)))
 		}
 
-		if weak {
-			channel.Write([]byte(weakMsg))
+		// The policy verdict covers FIPS compliance, if configured
+		if clauses := localPolicy(); len(clauses) > 0 {
+			details, pass := policyVerdict(clauses, a)
+			if pass {
+				out.Write([]byte(renderf(policyPassMsg, strings.Join(details, "\n\r          "))))
+			} else {
+				out.Write([]byte(renderf(policyFailMsg, strings.Join(details, "\n\r          "))))
+			}
+		} else if fips != nil {
+			if a.fipsFailures > 0 {
+				out.Write([]byte(renderf(fipsNonCompliantMsg, fips.name, a.fipsFailures, len(a.results))))
+			} else {
+				out.Write([]byte(renderf(fipsCompliantMsg, fips.name)))
+			}
 		}
 
-		reqLock.Lock()
-		if agentFwd {
-			channel.Write([]byte(agentMsg))
+		if details := sunsetDetails(a.results); len(details) > 0 {
+			out.Write([]byte(renderf(sunsetMsg, strings.Join(details, "\n\r          "))))
 		}
-		if x11 {
-			channel.Write([]byte(x11Msg))
+
+		if len(a.notEvaluated) > 0 {
+			out.Write([]byte(renderf(notEvaluatedMsg, strings.Join(a.notEvaluated, "\n\r          "))))
+		}
+
+		if requireModern && !a.modern {
+			out.Write([]byte(render(modernMsg)))
+		}
+
+		// Exempt keys are known about, so are acceptable
+		rejected := strict && !a.strong && !a.exempt
+		if rejected {
+			out.Write([]byte(render(strictMsg)))
+		}
+
+		if offered > maxKeys {
+			out.Write([]byte(renderf(tooManyKeysMsg, offered)))
+		}
+
+		if dropped > 0 {
+			out.Write([]byte(renderf(uncheckedKeysMsg, dropped, maxAuthTries)))
+		}
+
+		if kexInit := sniffer.clientKexInit(); checkCompression && kexInit != nil {
+			switch c := preferredCompression(kexInit); c {
+			case "none":
+			case "zlib":
+				out.Write([]byte(render(preAuthCompressionMsg)))
+			default:
+				out.Write([]byte(renderf(compressionMsg, c)))
+			}
+		}
+
+		if checkDeprecations {
+			if found := deprecated(keys, sniffer.clientKexInit()); len(found) > 0 {
+				out.Write([]byte(renderf(deprecationMsg, strings.Join(found, "\n\r          "))))
+			}
+		}
+
+		if details, unrestricted := certificateDetails(keys); len(details) > 0 {
+			out.Write([]byte(renderf(certificatesMsg, strings.Join(details, "\n\r          "))))
+			if len(unrestricted) > 0 {
+				out.Write([]byte(renderf(unrestrictedCertMsg, strings.Join(unrestricted, "\n\r          "))))
+			}
+			if sha1Signed := sha1Certificates(keys); len(sha1Signed) > 0 {
+				out.Write([]byte(renderf(sha1CertMsg, strings.Join(sha1Signed, "\n\r          "))))
+			}
+			if suspicious := suspiciousKeyIDs(keys); len(suspicious) > 0 {
+				out.Write([]byte(renderf(suspiciousKeyIDMsg, strings.Join(suspicious, "\n\r          "))))
+			}
+		}
+
+		// Only advise removing legacy keys if there's a stronger key to
+		// fall back on
+		if a.strong && len(a.legacy) > 0 {
+			out.Write([]byte(renderf(legacyMsg, strings.Join(a.legacy, "\n\r          "))))
+		}
+
+		if agentFwd && !hidden("agent") {
+			out.Write([]byte(labelled("agent", translate(agentMsg), wrapWidth)))
+		}
+		if detectChains {
+			if seenElsewhere(keys, clientHost) && agentFwd {
+				out.Write([]byte(render(chainMsg)))
+			}
+		}
+		if x11 && !hidden("x11") {
+			out.Write([]byte(labelled("x11", translate(x11Msg), wrapWidth)))
+		}
+
+		if briefWindow > 0 {
+			rememberVisit(visitorID(clientHost), issues)
+		}
+
+		if compareSessions {
+			since := "from this address"
+			if token != "" {
+				since = "with this token"
+			}
+
+			added, removed, ok := compareWithPrevious(historyID(token, clientHost), a.results)
+			changes := describeChanges(added, removed, token != "")
+
+			switch {
+			case !ok:
+			case len(changes) == 0:
+				out.Write([]byte(renderf(unchangedKeysMsg, since)))
+			default:
+				out.Write([]byte(renderf(changedKeysMsg, since, strings.Join(changes, "\n\r          "))))
+			}
+		}
+
+		// Returning visitors have already read the notes that apply to
+		// everyone
+		if pinningNote && !pty && !returning {
+			out.Write([]byte(render(pinningMsg)))
+		}
+
+		if postQuantumNote && len(a.results) > 0 && !returning {
+			out.Write([]byte(render(postQuantumMsg)))
+		}
+
+		if praise && a.exemplary && !agentFwd && !x11 && len(deprecated(keys, sniffer.clientKexInit())) == 0 && len(sha1Certificates(keys)) == 0 {
+			out.Write([]byte(render(praiseMsg)))
+		}
+
+		var actions []string
+		for _, r := range recommendations {
+			if n := a.issueCounts[r.issue]; n > 0 {
+				actions = append(actions, fmt.Sprintf("  %d. "+r.action, len(actions)+1, n))
+			}
+		}
+		if len(actions) > 0 {
+			out.Write([]byte(renderf(actionsMsg, strings.Join(actions, "\n\r"))))
+
+			// Users of mobile SSH clients may find it easier to follow
+			// the instructions on another device. Terminals too narrow
+			// for the code would make a mess of it.
+			if pty && remediationQR != nil && (columns == 0 || int(columns) >= remediationQR.width()) {
+				out.Write([]byte(renderf(qrCodeMsg, strings.Replace(remediationQR.render(), "\n", "\n\r", -1), remediationURL)))
+			}
+		}
+
+		out.Write([]byte(renderf(referenceMsg, nConn.ref)))
+		out.Write([]byte(render(footerMsg)))
+		if goodbyeMsg != "" {
+			out.Write([]byte(goodbyeMsg))
+		}
+
+		if reportSigner != nil {
+			signer := &publicKey{key: reportSigner.PublicKey()}
+			out.Write([]byte(renderf(signedMsg, signer.key.Type(), signer.FingerprintSHA256(), reportNamespace)))
+			if sig, err := signReport(reportSigner, transcript.Bytes()); err != nil {
+				logger.Errorln("Failed to sign report:", err)
+			} else {
+				out.Write([]byte(strings.Replace(sig, "\n", "\n\r", -1)))
+			}
+		}
+
+		out.flush()
+		out.logError(logger)
+
+		reason := disconnectReason
+		if rejected {
+			logger.Warnln("Rejected", conn.RemoteAddr(), "as no acceptable keys were presented")
+			sendExitStatus(channel, 1)
+			reason = "No acceptable keys"
+		}
+
+		if interactive && pty && !rejected && out.err == nil {
+			menu(logger, channel, input, keys, func() string {
+				return transportDetails(conn, config, sniffer.clientKexInit())
+			})
 		}
 
 		// Explicitly close the channel to end the session
 		channel.Close()
+
+		// Clients may discard any output they haven't yet shown if we
+		// disconnect before they've closed their side of the channel
+		select {
+		case <-reqsDone:
+			disconnect(conn, reason)
+		case <-clk.After(time.Second):
+		}
+	}
+
+}
+
+// transportDetails describes the SSH transport negotiated with the client
+func transportDetails(conn ssh.ConnMetadata, config *ssh.ServerConfig, clientKexInit *kexInitMsg) string {
+	var b bytes.Buffer
+	fmt.Fprint(&b, "Transport details:\n")
+	fmt.Fprintf(&b, "  %-16s%s\n", "Client version:", conn.ClientVersion())
+
+	if clientKexInit == nil {
+		fmt.Fprint(&b, "  Failed to determine the negotiated algorithms\n\n")
+		return strings.Replace(b.String(), "\n", "\n\r", -1)
+	}
+
+	t := negotiated(config, clientKexInit)
+	both := func(clientServer, serverClient string) string {
+		if clientServer == serverClient {
+			return clientServer
+		}
+		return clientServer + " (client to server), " + serverClient + " (server to client)"
+	}
+	mac := func(cipher, mac string) string {
+		if isAEAD(cipher) {
+			return "<implicit>"
+		}
+		return mac
+	}
+
+	fmt.Fprintf(&b, "  %-16s%s\n", "Key exchange:", t.kex)
+	fmt.Fprintf(&b, "  %-16s%s\n", "Host key:", t.hostKey)
+	fmt.Fprintf(&b, "  %-16s%s\n", "Cipher:", both(t.cipherClientServer, t.cipherServerClient))
+	fmt.Fprintf(&b, "  %-16s%s\n", "MAC:", both(
+		mac(t.cipherClientServer, t.macClientServer),
+		mac(t.cipherServerClient, t.macServerClient)))
+	fmt.Fprintf(&b, "  %-16s%s\n\n", "Compression:", t.compression)
+
+	return strings.Replace(b.String(), "\n", "\n\r", -1)
+}
+
+// rejectionDetails lists the servers likely to reject each key
+func rejectionDetails(keys []*publicKey) string {
+	var b bytes.Buffer
+	fmt.Fprint(&b, "Servers likely to reject your keys:\n")
+
+	var rejected bool
+	for _, k := range keys {
+		if rejections := rejectedBy(k); len(rejections) > 0 {
+			rejected = true
+			fmt.Fprintf(&b, "  %s %s\n", k.key.Type(), k.Fingerprint())
+			for _, r := range rejections {
+				fmt.Fprintf(&b, "    - %s\n", r)
+			}
+		}
+	}
+
+	if !rejected {
+		fmt.Fprint(&b, "  None of the servers known to this server\n")
+	}
+	fmt.Fprint(&b, "\n")
+
+	return strings.Replace(b.String(), "\n", "\n\r", -1)
+}
+
+// disconnectByApplication is SSH_DISCONNECT_BY_APPLICATION, see RFC 4253
+const disconnectByApplication = 11
+
+// disconnect tells the client why the connection is being closed, where the
+// underlying connection supports it
+func disconnect(conn *ssh.ServerConn, reason string) {
+	if d, ok := conn.Conn.(interface {
+		Disconnect(reason uint32, message string) error
+	}); ok {
+		d.Disconnect(disconnectByApplication, reason)
+	}
+}
+
+// reportWriter buffers the report so it can be written to the client in one
+// go. Once a write fails, further writes are discarded.
+type reportWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	err error
+
+	// transcript records everything written, if set, so that it can be
+	// signed
+	transcript *bytes.Buffer
+}
+
+func (r *reportWriter) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if r.transcript != nil {
+		r.transcript.Write(p)
+	}
+	return r.buf.Write(p)
+}
+
+// flush writes everything buffered so far to the client
+func (r *reportWriter) flush() error {
+	if r.err == nil && r.buf.Len() > 0 {
+		_, r.err = r.w.Write(r.buf.Bytes())
+	}
+	r.buf.Reset()
+
+	return r.err
+}
+
+// logError logs why the report couldn't be written in full, if it couldn't
+func (r *reportWriter) logError(logger *log.Entry) {
+	switch r.err {
+	case nil:
+	case io.EOF:
+		logger.Infoln("Client disconnected during report")
+	default:
+		logger.Warnln("Failed to write report:", r.err)
+	}
+}
+
+// sendExitStatus tells the client the exit status of the session, as if a
+// command had been run
+func sendExitStatus(channel ssh.Channel, status int) {
+	msg := struct{ Status uint32 }{uint32(status)}
+	channel.SendRequest("exit-status", false, ssh.Marshal(&msg))
+}
+
+// malformedKeyError reports whether the handshake error was caused by the
+// client offering a public key that was truncated or had trailing data
+func malformedKeyError(err error) bool {
+	switch err.Error() {
+	case "ssh: trailing junk in public key", "ssh: short read":
+		return true
+	}
+
+	return false
+}
+
+// invalidCurvePoint reports whether the handshake, or parsing a key, failed
+// because an ECDSA key's point isn't on the curve its type names, or is the
+// point at infinity. The ssh package rejects such keys as elliptic.Unmarshal
+// does, so they can't be reported to the user.
+func invalidCurvePoint(err error) bool {
+	return err.Error() == "ssh: invalid curve point"
+}
+
+// keepalive periodically sends traffic to the client while its keys are
+// being checked, so that proxies don't drop the connection for being idle.
+// Interactive sessions are shown a dot; other sessions are sent a keepalive
+// request so that their output isn't affected. Call the returned function
+// to stop sending keepalives.
+func keepalive(conn ssh.Conn, channel ssh.Channel, pty bool) (stop func()) {
+	if keepaliveInterval == 0 {
+		return func() {}
 	}
 
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := clk.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+				if pty {
+					channel.Write([]byte("."))
+				} else {
+					conn.SendRequest("keepalive@openssh.com", false, nil)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
 }
 
 func publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	sessions.mu.Lock()
 	sessionID := string(conn.SessionID())
-	sessions.keys[sessionID] = append(sessions.keys[sessionID], &publicKey{key: key})
+	if _, ok := sessions.added[sessionID]; !ok {
+		sessions.added[sessionID] = clk.Now()
+	}
+	if maxAuthTries > 0 && len(sessions.keys[sessionID]) >= maxAuthTries {
+		sessions.dropped[sessionID]++
+	} else {
+		sessions.keys[sessionID] = append(sessions.keys[sessionID], &publicKey{key: key})
+	}
 	sessions.mu.Unlock()
 
 	// Never succeed a key, or we might not see the next. See KeyboardInteractiveCallback.
@@ -184,7 +1257,27 @@ func keyboardInteractiveCallback(ssh.ConnMetadata, ssh.KeyboardInteractiveChalle
 	return nil, nil
 }
 
+// testPublicKeyCallback accepts any key offered, so that tests can
+// authenticate with nothing more than a key pair. Clients such as OpenSSH
+// stop offering keys once one is accepted, so their remaining keys go
+// unchecked, which is why it must never be used in production.
+func testPublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	publicKeyCallback(conn, key)
+	return nil, nil
+}
+
 var (
+	actionsMsg = strings.Replace(`Recommended actions:
+%s
+
+`, "\n", "\n\r", -1)
+
+	qrCodeMsg = strings.Replace(`To follow these instructions on another device, scan this code:
+%s
+%s
+
+`, "\n", "\n\r", -1)
+
 	agentMsg = strings.Replace(`CRITICAL: SSH agent forwarding is enabled; it is dangerous to enable agent forwarding
 	  for servers you do not trust as it allows them to log in to other servers as you.
 
@@ -192,7 +1285,99 @@ var (
 
 	blacklistMsg = strings.Replace(`CRITICAL: You are using blacklisted key(s) that are known to be insecure.
           You should replace them immediately.
-          See: https://www.debian.org/security/2008/dsa-1576
+          Matched:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	agentAuditMsg = strings.Replace(`CRITICAL: As requested, this server has listed all of the keys held by your
+          forwarded SSH agent, which are included below. Any server you
+          forward your agent to can do the same, and can use those keys to
+          log in to other servers as you.
+
+`, "\n", "\n\r", -1)
+
+	agentSourcesMsg = strings.Replace(`NOTE:     Comparing the keys your SSH client offered when logging in with
+          those held by your forwarded SSH agent:
+          %s
+          Keys not held by your agent were most likely read from files, e.g.
+          those named by IdentityFile. Keys not offered weren't tried, e.g.
+          because IdentitiesOnly is set.
+
+`, "\n", "\n\r", -1)
+
+	agentAuditFailedMsg = strings.Replace(`ERROR:    Failed to list the keys held by your forwarded SSH agent. Only the
+          keys presented by your SSH client are shown below.
+
+`, "\n", "\n\r", -1)
+
+	agentAuditDeclinedMsg = strings.Replace(`NOTICE:   The keys held by your forwarded SSH agent weren't listed, as you
+          didn't confirm that they should be. Only the keys presented by your
+          SSH client are shown below.
+
+`, "\n", "\n\r", -1)
+
+	agentConfirmPrompt = strings.Replace(`This server can list all of the keys held by your forwarded SSH agent, as
+any server you forward your agent to can. Type "yes" to continue: `, "\n", "\n\r", -1)
+
+	agentAuditNoFwdMsg = strings.Replace(`NOTICE:   To check all of the keys held by your SSH agent, connect with agent
+          forwarding enabled, e.g.: ssh -A -s <host> agent
+
+`, "\n", "\n\r", -1)
+
+	changedKeysMsg = strings.Replace(`NOTE:     Since you last connected %s, your SSH client has:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	certificatesMsg = strings.Replace(`NOTE:     Your SSH client presented the following certificate(s). Check that
+          their restrictions are as intended:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	chainMsg = strings.Replace(`NOTICE:   The same set of keys was recently presented to this server from
+          another address, which suggests that your SSH agent is being
+          forwarded through one or more intermediate hosts. Any of those
+          hosts can use your agent to log in to other servers as you.
+          This is a heuristic and may be wrong, e.g. if you use the same
+          keys on more than one machine.
+
+`, "\n", "\n\r", -1)
+
+	collisionMsg = strings.Replace(`CRITICAL: Your SSH client presented keys of different types with the same
+          fingerprint. This should never happen, and suggests a bug in your
+          SSH client or that your keys have been tampered with.
+
+`, "\n", "\n\r", -1)
+
+	typeMismatchMsg = strings.Replace(`CRITICAL: Your SSH agent listed key(s) as a different type than they are:
+          %s
+          This should never happen, and suggests a bug in your SSH agent
+          or that your keys have been tampered with.
+
+`, "\n", "\n\r", -1)
+
+	compressionMsg = strings.Replace(`NOTICE:   Your SSH client prefers to use compression (%s), e.g.
+          because you connected using ssh -C. Compression rarely helps on fast
+          networks, and the length of compressed data can reveal something
+          of its contents even when encrypted.
+
+`, "\n", "\n\r", -1)
+
+	containerImageMsg = strings.Replace(`CRITICAL: You are using key(s) that are shipped in public container images,
+          so anyone who pulls the image can extract the private key(s) and
+          log in to servers that accept them. Generate keys when containers are
+          deployed rather than baking them into images.
+          Matched:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	deprecationMsg = strings.Replace(`NOTICE:   The following key(s) are accepted by many servers, but are used in
+          ways that OpenSSH has deprecated, so will generate deprecation
+          warnings or stop working as servers are upgraded:
+          %s
 
 `, "\n", "\n\r", -1)
 
@@ -200,11 +1385,287 @@ var (
 	  default in OpenSSH version 7.0 and above.
           Consider replacing them with a new RSA or ECDSA key.
 
+`, "\n", "\n\r", -1)
+
+	weakModulusMsg = strings.Replace(`CRITICAL: This server's experimental checks for flawed RSA key generators
+          have factored the modulus of your RSA key(s), so anyone can derive
+          their private keys. Replace them immediately, using a different
+          key generator:
+          %s
+
 `, "\n", "\n\r", -1)
 
 	weakMsg = strings.Replace(`WARNING:  You are using RSA key(s) with a length of less than 2048 bits.
           Consider replacing them with a new key of 2048 bits or more.
 
+`, "\n", "\n\r", -1)
+
+	weakCurveMsg = strings.Replace(`WARNING:  You are using ECDSA key(s) on curves other than NIST P-256, P-384 or
+          P-521, which are too weak or aren't widely supported:
+          %s
+          Consider replacing them with a new key using: ssh-keygen -t ecdsa -b 384
+
+`, "\n", "\n\r", -1)
+
+	minimumCurveMsg = strings.Replace(`NOTE:     Your ECDSA key(s) use the NIST P-256 curve, which is acceptable but
+          is the minimum recommended. P-384 and P-521 offer a greater
+          margin, e.g. using: ssh-keygen -t ecdsa -b 384
+
+`, "\n", "\n\r", -1)
+
+	weakSHA1Msg = strings.Replace(`WARNING:  You are using RSA key(s) with a length of less than 2048 bits,
+          and your SSH client can only sign with them using ssh-rsa, which
+          relies on SHA-1. Both are being phased out: servers increasingly
+          reject short RSA keys, and OpenSSH 8.8 and later reject ssh-rsa
+          signatures by default. A longer RSA key alone won't fix this.
+          Generate a new key, ideally with ssh-keygen -t ecdsa -b 384, and
+          upgrade your SSH client to one that supports rsa-sha2 signatures
+          (OpenSSH 7.2 or later).
+
+`, "\n", "\n\r", -1)
+
+	exemptMsg = strings.Replace(`NOTICE:   The following key(s) have known issues, but are exempt from
+          warnings, e.g. because they are due to be replaced:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	referenceMsg = strings.Replace(`Reference: %s (please quote this if you report a problem)
+
+`, "\n", "\n\r", -1)
+
+	fipsCompliantMsg = strings.Replace(`NOTE:     %s: COMPLIANT. All of your keys comply with FIPS 140 key size
+          guidance.
+
+`, "\n", "\n\r", -1)
+
+	fipsNonCompliantMsg = strings.Replace(`WARNING:  %s: NON-COMPLIANT. %d of your %d key(s) don't comply with FIPS
+          140 key size guidance; see the table above for the reasons.
+
+`, "\n", "\n\r", -1)
+
+	footerMsg = strings.Replace(`Questions? See https://github.com/mattbostock/sshkeycheck/issues
+
+`, "\n", "\n\r", -1)
+
+	hostKeyMsg = strings.Replace(`This server's host key is %s %s
+Check that this matches the fingerprint your SSH client showed when you first
+connected, or the one shown by "ssh-keygen -lF <hostname>".
+
+`, "\n", "\n\r", -1)
+
+	knownFactorMsg = strings.Replace(`CRITICAL: The modulus of the following RSA key(s) is divisible by a known
+          factor, so anyone can work out the private key(s). They were
+          generated by flawed software, which may have generated other keys
+          you use too; replace them immediately using a different tool:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	lowEntropyMsg = strings.Replace(`CRITICAL: The following key(s) appear to have been generated with too little
+          entropy, e.g. by a device just after it first booted, so others
+          generated the same way may share or reveal their private key(s).
+          Replace them using a device that has been running long enough to
+          gather entropy:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	legacyMsg = strings.Replace(`NOTICE:   Your SSH client also presents key(s) with no known issues.
+          Consider removing the following key(s) from your SSH agent and
+          configuration, so that they can't be used to log in to servers
+          that still accept them:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	mismatchMsg = strings.Replace(`WARNING:  Your key(s) marked SIZE MISMATCH are not the length their type
+          suggests, e.g. a 2047 bit RSA key where 2048 bits were likely
+          intended. This can indicate a bug in the software used to generate
+          them, or that they have been corrupted or tampered with.
+          Consider generating a new key using: ssh-keygen -t ecdsa -b 384
+
+`, "\n", "\n\r", -1)
+
+	nonCanonicalMsg = strings.Replace(`WARNING:  Your SSH agent holds key(s) that aren't encoded the way SSH
+          software usually encodes them, e.g. with a number padded with a
+          zero byte it doesn't need:
+          %s
+          Some servers reject such keys. Export the public key again using
+          ssh-keygen -y -f <private key file>, and add the private key to
+          your agent again.
+
+`, "\n", "\n\r", -1)
+
+	modernMsg = strings.Replace(`FAIL:     This server requires at least one modern (ECDSA) key, but none
+          of the keys presented by your SSH client are modern.
+          Consider generating a new key using: ssh-keygen -t ecdsa -b 384
+
+`, "\n", "\n\r", -1)
+
+	notEvaluatedMsg = strings.Replace(`NOTICE:   The following checks took too long, so weren't evaluated and any
+          issues they would have found aren't shown:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	pinningMsg = strings.Replace(`NOTE:     You connected without a terminal, so may be running a script.
+          Scripts often disable host key checking, e.g. with
+          StrictHostKeyChecking=no, which leaves them open to
+          man-in-the-middle attacks. Pin the host keys of the servers your
+          scripts connect to in a known_hosts file instead.
+
+`, "\n", "\n\r", -1)
+
+	postQuantumMsg = strings.Replace(`NOTE:     Like all SSH keys in use today (RSA, ECDSA and Ed25519), your keys
+          rely on problems that a large enough quantum computer could solve,
+          though none yet exists. There's nothing to do for now, as SSH has
+          no post-quantum key types yet. Separately, OpenSSH 9.0 and later
+          use post-quantum key exchange by default (sntrup761x25519-sha512,
+          or mlkem768x25519-sha256 from 10.0), so that sessions recorded
+          today can't be decrypted later.
+
+`, "\n", "\n\r", -1)
+
+	policyPassMsg = strings.Replace(`NOTE:     POLICY: PASS. All of your keys comply with this server's policy:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	policyFailMsg = strings.Replace(`FAIL:     POLICY: FAIL. Your SSH client doesn't comply with this server's
+          policy. Each key is listed with the settings it breaks, if any:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	signedMsg = strings.Replace(`This report is signed by %s %s in namespace %s.
+To verify it, see https://github.com/mattbostock/sshkeycheck#verifying-reports
+`, "\n", "\n\r", -1)
+
+	praiseMsg = strings.Replace(`NOTE:     Your SSH keys follow current best practices. Well done!
+
+`, "\n", "\n\r", -1)
+
+	preAuthCompressionMsg = strings.Replace(`WARNING:  Your SSH client prefers zlib compression, which starts before you
+          have logged in, over compression that starts afterwards. Servers
+          that accept it expose their compression code to anyone who
+          connects, which is why OpenSSH 7.4 and above no longer do.
+          Consider disabling compression unless you need it.
+
+`, "\n", "\n\r", -1)
+
+	progressMsg = "Checking your keys..."
+
+	revokedMsg = strings.Replace(`CRITICAL: You are using key(s) or certificate(s) that have been revoked by
+          this server's operator, and will no longer be accepted by their
+          servers:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	rsaSignatureMsg = strings.Replace(`NOTE:     "ssh-rsa" names both the RSA key type used by your key(s), which
+          is fine, and the SHA-1 signature algorithm, which OpenSSH 8.8
+          disabled by default. RSA keys can still be used with rsa-sha2-256
+          or rsa-sha2-512 signatures, supported since OpenSSH 7.2. If a
+          server rejects your RSA key, upgrade the client or server rather
+          than adding ssh-rsa to PubkeyAcceptedAlgorithms.
+
+`, "\n", "\n\r", -1)
+
+	sharedModulusMsg = strings.Replace(`CRITICAL: Your SSH client presented RSA keys that share the same modulus with
+          different exponents. Anyone holding the private key for one of them
+          can derive the private key for the other, so they should be
+          treated as compromised and replaced:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	strictMsg = strings.Replace(`FAIL:     This server only admits clients that present at least one key with
+          no known issues, so your connection will be closed. Fix the issues
+          above and try again.
+
+`, "\n", "\n\r", -1)
+
+	unrestrictedCertMsg = strings.Replace(`WARNING:  The following certificate(s) have no principals or critical options,
+          so may be accepted by servers trusting their CA for any user, from
+          any address, to run any command. Consider asking your CA to
+          restrict them:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	sha1CertMsg = strings.Replace(`WARNING:  The following certificate(s) were signed by their CA using ssh-rsa,
+          which signs a SHA-1 hash of the certificate. OpenSSH 8.2 and later
+          reject such certificates unless ssh-rsa is explicitly re-enabled.
+          Ask your CA to sign with rsa-sha2-512 or rsa-sha2-256 instead, e.g.
+          with ssh-keygen -t rsa-sha2-512, or to move to an ECDSA CA key:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	suspiciousKeyIDMsg = strings.Replace(`NOTE:     The following certificate(s) have a key ID that is empty or looks like
+          a default or placeholder, which suggests they were issued for
+          testing, or were never personalised. Key IDs are logged by servers
+          to identify who logged in, so ask your CA to issue certificates
+          with a meaningful key ID:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	unchangedKeysMsg = strings.Replace(`NOTE:     Your SSH client presented the same keys as when you last connected
+          %s.
+
+`, "\n", "\n\r", -1)
+
+	uncheckedKeysMsg = strings.Replace(`NOTICE:   %d of the keys presented by your SSH client weren't checked, as
+          this server only checks the first %d keys presented.
+
+`, "\n", "\n\r", -1)
+
+	sunsetMsg = strings.Replace(`NOTICE:   Under this server operator's key sunset schedule:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	tooManyKeysMsg = strings.Replace(`NOTICE:   Your SSH client presented %d keys. Trying many keys slows down
+          logging in, and servers may disconnect you before the right key
+          is tried, as OpenSSH allows 6 attempts by default (MaxAuthTries).
+          Consider removing unused keys from your SSH agent, and setting
+          IdentitiesOnly and IdentityFile for each host in ~/.ssh/config.
+
+`, "\n", "\n\r", -1)
+
+	trivialModulusMsg = strings.Replace(`CRITICAL: The modulus of the following RSA key(s) is trivial to factor, so
+          anyone can work out the private key(s). The software that generated
+          them is badly broken; you should replace them immediately using
+          a different tool:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	unparseableMsg = strings.Replace(`WARNING:  Your SSH client presented key(s) that couldn't be parsed, so they
+          may be corrupt, or of a kind this server doesn't understand:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	weakerThanHostMsg = strings.Replace(`NOTE:     Your RSA key(s) meet the minimum recommended length, but are weaker
+          than this server's own host key, which is comparable to a %d bit
+          RSA key. Consider using a longer RSA key, or an ECDSA key.
+
+`, "\n", "\n\r", -1)
+
+	wellKnownMsg = strings.Replace(`CRITICAL: You are using well-known key(s), whose private keys have been
+          published, so anyone can log in to servers that accept them.
+          You should replace them immediately.
+          Matched:
+          %s
+
+`, "\n", "\n\r", -1)
+
+	returningMsg = strings.Replace(`Welcome back. Advice you were shown in the last %s is left out below;
+only issues new to you are explained.
+
 `, "\n", "\n\r", -1)
 
 	welcomeMsg = strings.Replace(`This server checks your SSH public keys for known or potential
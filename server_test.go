@@ -0,0 +1,773 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	var err error
+	if hostKey, err = demoHostKey(); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// startTestServer serves connections on a free port until the test ends,
+// returning its address
+func startTestServer(t testing.TB) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serveTestListener(t, listener)
+}
+
+// serveTestListener serves connections accepted by the listener until the
+// test ends, returning its address
+func serveTestListener(t testing.TB, listener net.Listener) string {
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: keyboardInteractiveCallback,
+		PublicKeyCallback:           publicKeyCallback,
+	}
+	config.AddHostKey(hostKey)
+
+	// Sessions are waited for, so that none outlives the test that
+	// started it and sees another test's settings
+	var sessions sync.WaitGroup
+	t.Cleanup(func() {
+		listener.Close()
+		sessions.Wait()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			sessions.Add(1)
+			go func() {
+				defer sessions.Done()
+				serve(config, trace(conn))
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// testClient connects to the server at addr as the given user, offering
+// the given keys
+func testClient(t testing.TB, addr, user string, signers ...ssh.Signer) *ssh.Client {
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signers...),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return nil, nil
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// testReport returns the report given to the user for the given keys
+func testReport(t testing.TB, user string, signers ...ssh.Signer) string {
+	session, err := testClient(t, startTestServer(t), user, signers...).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	session.Wait()
+
+	return out.String()
+}
+
+// testSigner returns a freshly generated ECDSA key
+func testSigner(t testing.TB) ssh.Signer {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// testWeakSigner returns a freshly generated 1024 bit RSA key
+func testWeakSigner(t testing.TB) ssh.Signer {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// Forwarding requests sent after the shell request are too late to change
+// the report, and mustn't race with it being written
+func TestLateForwardingRequests(t *testing.T) {
+	addr := startTestServer(t)
+	signer := testSigner(t)
+	x11Req := ssh.Marshal(struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{false, "MIT-MAGIC-COOKIE-1", "00", 0})
+
+	for i := 0; i < 10; i++ {
+		// The requests are sent straight after the shell request, without
+		// waiting for replies, so that they arrive as the report is
+		// being written
+		channel, reqs, err := testClient(t, addr, "late", signer).OpenChannel("session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go ssh.DiscardRequests(reqs)
+
+		channel.SendRequest("shell", false, nil)
+		for j := 0; j < 50; j++ {
+			channel.SendRequest("auth-agent-req@openssh.com", false, nil)
+			channel.SendRequest("x11-req", false, x11Req)
+		}
+		out, _ := ioutil.ReadAll(channel)
+
+		report := string(out)
+		if !strings.Contains(report, strings.TrimSpace(welcomeMsg[:40])) {
+			t.Fatalf("report not sent:\n%s", report)
+		}
+		if strings.Contains(report, "agent forwarding is enabled") || strings.Contains(report, "X11 forwarding") {
+			t.Errorf("report changed by forwarding requests sent after the shell request:\n%s", report)
+		}
+	}
+}
+
+// The whole report, from the handshake to the end of the session
+func BenchmarkReport(b *testing.B) {
+	addr := startTestServer(b)
+	signer := testSigner(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		session, err := testClient(b, addr, "bench", signer).NewSession()
+		if err != nil {
+			b.Fatal(err)
+		}
+		session.Stdout = ioutil.Discard
+		if err := session.Shell(); err != nil {
+			b.Fatal(err)
+		}
+		session.Wait()
+		session.Close()
+	}
+}
+
+// readReport reads everything the server sends on the channel, closing
+// the returned channel once it's done
+func readReport(channel ssh.Channel) <-chan string {
+	report := make(chan string, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(channel)
+		report <- string(out)
+	}()
+
+	return report
+}
+
+// openSession opens a session channel as the given user, without asking
+// for a shell
+func openSession(t testing.TB, user string) ssh.Channel {
+	channel, reqs, err := testClient(t, startTestServer(t), user, testSigner(t)).OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(reqs)
+
+	return channel
+}
+
+// Clients that never ask for a shell are sent their report after 30
+// seconds
+func TestShellRequestTimeout(t *testing.T) {
+	c := useFakeClock(t)
+	channel := openSession(t, "stalled")
+	report := readReport(channel)
+
+	c.waitFor(t, 30*time.Second)
+	c.Advance(29 * time.Second)
+	select {
+	case r := <-report:
+		t.Fatalf("report sent before the timeout:\n%s", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	select {
+	case r := <-report:
+		if !strings.Contains(r, "Fingerprint") {
+			t.Errorf("expected a report, got:\n%s", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("report not sent after the timeout")
+	}
+}
+
+// Whatever order a client sends its requests in, the report is sent once
+// it asks for a shell or command, formatted for a terminal only if it asked
+// for one first
+func TestSessionRequestOrder(t *testing.T) {
+	defer func(msg string) { bannerMsg = msg }(bannerMsg)
+	bannerMsg = "Terminal banner\n\r\n\r"
+
+	ptyReq := ssh.Marshal(struct {
+		Term          string
+		Columns, Rows uint32
+		Width, Height uint32
+		Modes         string
+	}{"xterm", 80, 24, 0, 0, ""})
+	execReq := ssh.Marshal(struct{ Command string }{""})
+
+	type request struct {
+		name    string
+		payload []byte
+	}
+	pty, shell, exec := request{"pty-req", ptyReq}, request{"shell", nil}, request{"exec", execReq}
+
+	for _, test := range []struct {
+		name     string
+		requests []request
+		pty      bool
+	}{
+		{"shell only", []request{shell}, false},
+		{"pty then shell", []request{pty, shell}, true},
+		{"shell then pty", []request{shell, pty}, false},
+		{"pty twice then shell", []request{pty, pty, shell}, true},
+		{"exec only", []request{exec}, false},
+		{"pty then exec", []request{pty, exec}, true},
+	} {
+		channel := openSession(t, "order")
+		report := readReport(channel)
+		for _, req := range test.requests {
+			if _, err := channel.SendRequest(req.name, false, req.payload); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		select {
+		case r := <-report:
+			if !strings.Contains(r, "Fingerprint") {
+				t.Errorf("%s: expected a report, got:\n%s", test.name, r)
+			}
+			if got := strings.Contains(r, "Terminal banner"); got != test.pty {
+				t.Errorf("%s: got formatted for a terminal %t, expected %t:\n%s", test.name, got, test.pty, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: report not sent", test.name)
+		}
+	}
+}
+
+// Clients presenting more keys than an OpenSSH server would accept have
+// them all checked, unless MAX_AUTH_TRIES is set
+func TestMaxAuthTries(t *testing.T) {
+	defer func(n int) { maxAuthTries = n }(maxAuthTries)
+
+	var signers []ssh.Signer
+	for i := 0; i < 10; i++ {
+		signers = append(signers, testSigner(t))
+	}
+
+	for _, test := range []struct {
+		maxAuthTries, checked int
+		notice                string
+	}{
+		{0, 10, ""},
+		{4, 4, "6 of the keys presented by your SSH client weren't checked"},
+		{10, 10, ""},
+	} {
+		maxAuthTries = test.maxAuthTries
+		report := testReport(t, "many", signers...)
+
+		for i, s := range signers {
+			fingerprint := (&publicKey{key: s.PublicKey()}).Fingerprint()
+			if shown := strings.Contains(report, fingerprint); shown != (i < test.checked) {
+				t.Errorf("MAX_AUTH_TRIES=%d: key %d shown %t:\n%s", test.maxAuthTries, i+1, shown, report)
+			}
+		}
+		if test.notice != "" && !strings.Contains(report, test.notice) {
+			t.Errorf("MAX_AUTH_TRIES=%d: expected %q in report:\n%s", test.maxAuthTries, test.notice, report)
+		} else if test.notice == "" && strings.Contains(report, "weren't checked") {
+			t.Errorf("MAX_AUTH_TRIES=%d: unexpected notice of unchecked keys:\n%s", test.maxAuthTries, report)
+		}
+	}
+}
+
+// The table lists keys in the order presented, unless KEY_ORDER asks for
+// those with the most severe issues first
+func TestReportKeyOrder(t *testing.T) {
+	defer func(order string) { keyOrder = order }(keyOrder)
+
+	sound, weak := testSigner(t), testWeakSigner(t)
+	soundFingerprint := (&publicKey{key: sound.PublicKey()}).Fingerprint()
+	weakFingerprint := (&publicKey{key: weak.PublicKey()}).Fingerprint()
+
+	for _, test := range []struct {
+		order     string
+		weakFirst bool
+	}{
+		{"presented", false},
+		{"severity", true},
+	} {
+		keyOrder = test.order
+		report := testReport(t, "order", sound, weak)
+
+		s, w := strings.Index(report, soundFingerprint), strings.Index(report, weakFingerprint)
+		if s < 0 || w < 0 {
+			t.Fatalf("KEY_ORDER=%s: keys missing from report:\n%s", test.order, report)
+		}
+		if (w < s) != test.weakFirst {
+			t.Errorf("KEY_ORDER=%s: got weak key listed first %t:\n%s", test.order, w < s, report)
+		}
+	}
+}
+
+// Clients presenting more than maxRows keys are shown those with the most
+// severe issues, and told how many were left out
+func TestReportTruncated(t *testing.T) {
+	defer func(n int) { maxRows = n }(maxRows)
+	maxRows = 2
+
+	weakSigner := testWeakSigner(t)
+	signers := []ssh.Signer{testSigner(t), testSigner(t), testSigner(t), weakSigner}
+
+	report := testReport(t, "many", signers...)
+	for _, expected := range []string{
+		(&publicKey{key: weakSigner.PublicKey()}).Fingerprint(),
+		(&publicKey{key: signers[0].PublicKey()}).Fingerprint(),
+		"... and 2 more keys (truncated)",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %q in report:\n%s", expected, report)
+		}
+	}
+	for _, s := range signers[1:3] {
+		if fingerprint := (&publicKey{key: s.PublicKey()}).Fingerprint(); strings.Contains(report, fingerprint) {
+			t.Errorf("expected %s to be left out of the table:\n%s", fingerprint, report)
+		}
+	}
+}
+
+// Clients that authenticate but never open a channel are disconnected
+// once channelTimeout has passed, and counted as having opened no channel
+func TestNoChannelTimeout(t *testing.T) {
+	c := useFakeClock(t)
+	logs := captureLogs(t)
+	before := atomic.LoadUint64(&reaped.channel)
+
+	client := testClient(t, startTestServer(t), "probe", testSigner(t))
+	closed := make(chan error, 1)
+	go func() { closed <- client.Wait() }()
+
+	c.waitFor(t, channelTimeout)
+	c.Advance(channelTimeout - time.Second)
+	select {
+	case <-closed:
+		t.Fatal("connection closed before the timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the timeout")
+	}
+
+	if !logs.contains("as no channel was opened") {
+		t.Error("expected the connection to be logged as opening no channel")
+	}
+	if n := atomic.LoadUint64(&reaped.channel) - before; n != 1 {
+		t.Errorf("got %d connections counted as opening no channel, expected 1", n)
+	}
+}
+
+// Clients that ask for a terminal are expected to ask for a shell
+// straight away, and are sent their report a second later if they don't
+func TestPtyRequestTimeout(t *testing.T) {
+	c := useFakeClock(t)
+	channel := openSession(t, "stalled")
+	report := readReport(channel)
+
+	ok, err := channel.SendRequest("pty-req", true, ssh.Marshal(struct {
+		Term          string
+		Columns, Rows uint32
+		Width, Height uint32
+		Modes         string
+	}{"xterm", 80, 24, 0, 0, ""}))
+	if !ok || err != nil {
+		t.Fatal("pty-req refused:", err)
+	}
+
+	c.waitFor(t, time.Second)
+	select {
+	case r := <-report:
+		t.Fatalf("report sent before the timeout:\n%s", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	select {
+	case r := <-report:
+		if !strings.Contains(r, "Fingerprint") {
+			t.Errorf("expected a report, got:\n%s", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("report not sent after the timeout")
+	}
+}
+
+// logCapture records the entries logged until the test ends
+type logCapture struct {
+	mu      sync.Mutex
+	entries []string
+	levels  []log.Level
+}
+
+func (c *logCapture) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel, log.InfoLevel, log.DebugLevel}
+}
+
+func (c *logCapture) Fire(entry *log.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, entry.Message)
+	c.levels = append(c.levels, entry.Level)
+	return nil
+}
+
+// contains reports whether an entry containing s was logged
+func (c *logCapture) contains(s string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if strings.Contains(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// level returns the level of the first entry containing s, if one was logged
+func (c *logCapture) level(s string) (log.Level, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, e := range c.entries {
+		if strings.Contains(e, s) {
+			return c.levels[i], true
+		}
+	}
+	return 0, false
+}
+
+// captureLogs records the entries logged by the standard logger until the
+// test ends
+func captureLogs(t testing.TB) *logCapture {
+	hooks := make(map[log.Level][]log.Hook)
+	for level, h := range log.StandardLogger().Hooks {
+		hooks[level] = h
+	}
+	t.Cleanup(func() {
+		for level := range log.StandardLogger().Hooks {
+			log.StandardLogger().Hooks[level] = hooks[level]
+		}
+	})
+
+	c := &logCapture{}
+	log.AddHook(c)
+	return c
+}
+
+// malformedKey is a public key whose encoding has been tampered with
+type malformedKey struct {
+	ssh.PublicKey
+	blob []byte
+}
+
+func (k malformedKey) Marshal() []byte { return k.blob }
+
+// malformedSigner offers a malformed key
+type malformedSigner struct {
+	ssh.Signer
+	blob []byte
+}
+
+func (s malformedSigner) PublicKey() ssh.PublicKey { return malformedKey{s.Signer.PublicKey(), s.blob} }
+
+func TestMalformedKeyError(t *testing.T) {
+	blob := testSigner(t).PublicKey().Marshal()
+	for _, test := range []struct {
+		name      string
+		blob      []byte
+		malformed bool
+	}{
+		{"trailing data", append(append([]byte{}, blob...), "garbage"...), true},
+		{"truncated", blob[:len(blob)-5], true},
+		{"truncated type", blob[:2], true},
+	} {
+		_, err := ssh.ParsePublicKey(test.blob)
+		if err == nil || malformedKeyError(err) != test.malformed {
+			t.Errorf("%s: got error %v, expected malformed %t", test.name, err, test.malformed)
+		}
+	}
+	if malformedKeyError(errors.New("ssh: no common algorithms")) {
+		t.Error("other handshake errors taken to be malformed keys")
+	}
+}
+
+// Clients offering malformed keys fail the handshake, which is logged
+func TestMalformedKeyHandshake(t *testing.T) {
+	addr := startTestServer(t)
+	signer := testSigner(t)
+	blob := signer.PublicKey().Marshal()
+
+	for _, test := range []struct {
+		name string
+		blob []byte
+	}{
+		{"trailing data", append(append([]byte{}, blob...), "garbage"...)},
+		{"truncated", blob[:len(blob)-5]},
+	} {
+		logs := captureLogs(t)
+		if _, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User: "malformed",
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(malformedSigner{signer, test.blob})},
+		}); err == nil {
+			t.Errorf("%s: handshake succeeded", test.name)
+		}
+
+		// The server logs the failure once it sees the connection close
+		for deadline := time.Now().Add(5 * time.Second); !logs.contains("MALFORMED KEY DATA") && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !logs.contains("MALFORMED KEY DATA") {
+			t.Errorf("%s: malformed key not logged", test.name)
+		}
+	}
+}
+
+// ecdsaPoint returns a P-256 key blob whose point is encoded as given, which
+// needn't be a valid point
+func ecdsaPoint(point []byte) []byte {
+	return ssh.Marshal(struct {
+		Name, Curve string
+		KeyBytes    []byte
+	}{ssh.KeyAlgoECDSA256, "nistp256", point})
+}
+
+// Clients offering ECDSA keys whose point isn't on the curve, or is the point
+// at infinity, fail the handshake, which is logged as an error
+func TestInvalidCurvePointHandshake(t *testing.T) {
+	addr := startTestServer(t)
+	signer := testSigner(t)
+
+	for _, test := range []struct {
+		name string
+		blob []byte
+	}{
+		{"point not on the curve", ecdsaPoint(append([]byte{4}, make([]byte, 64)...))},
+		{"point at infinity", ecdsaPoint([]byte{0})},
+	} {
+		if _, err := ssh.ParsePublicKey(test.blob); err == nil || !invalidCurvePoint(err) || malformedKeyError(err) {
+			t.Errorf("%s: got error %v, expected an invalid curve point", test.name, err)
+		}
+
+		logs := captureLogs(t)
+		if _, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User: "invalid",
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(malformedSigner{signer, test.blob})},
+		}); err == nil {
+			t.Errorf("%s: handshake succeeded", test.name)
+		}
+
+		for deadline := time.Now().Add(5 * time.Second); !logs.contains("INVALID CURVE POINT") && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if level, ok := logs.level("INVALID CURVE POINT"); !ok || level != log.ErrorLevel {
+			t.Errorf("%s: got %t at level %s, expected the invalid point to be logged as an error", test.name, ok, level)
+		}
+	}
+}
+
+// Keys whose issues are left out by REPORT_ONLY are shown as out of scope,
+// and don't affect the exit status
+func TestReportOnly(t *testing.T) {
+	defer func(scope map[string]bool) { reportOnly = scope }(reportOnly)
+	signer := testWeakSigner(t)
+
+	for _, test := range []struct {
+		scope  map[string]bool
+		issue  string
+		status string
+	}{
+		{nil, issueWeak, "WARN"},
+		{map[string]bool{"weak": true}, issueWeak, "WARN"},
+		{map[string]bool{"blacklisted": true, "revoked": true}, issueOutOfScope, "OK"},
+	} {
+		reportOnly = test.scope
+		if report := testReport(t, "table", signer); !strings.Contains(report, test.issue) {
+			t.Errorf("%v: expected %q in report:\n%s", test.scope, test.issue, report)
+		}
+		if status := testReport(t, "status", signer); !strings.HasPrefix(status, test.status) {
+			t.Errorf("%v: got status %q, expected %s", test.scope, status, test.status)
+		}
+	}
+}
+
+// Connections that never complete the handshake are closed once
+// HANDSHAKE_TIMEOUT passes, freeing their worker, and counted as reaped,
+// while those that do are given as long as they need
+func TestHandshakeTimeout(t *testing.T) {
+	defer func(d time.Duration) { handshakeTimeout = d }(handshakeTimeout)
+	handshakeTimeout = 200 * time.Millisecond
+	before := atomic.LoadUint64(&reaped.handshake)
+
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: keyboardInteractiveCallback,
+		PublicKeyCallback:           publicKeyCallback,
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	idle, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handle(config, trace(conn))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection still being served after the handshake timeout")
+	}
+	if n := atomic.LoadUint64(&reaped.handshake) - before; n != 1 {
+		t.Errorf("got %d connections counted as timing out during the handshake, expected 1", n)
+	}
+
+	// A client that authenticates is no longer bound by the timeout
+	client := testClient(t, startTestServer(t), "patient", testSigner(t))
+	time.Sleep(2 * handshakeTimeout)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	session.Wait()
+	if !strings.Contains(out.String(), "Reference:") {
+		t.Errorf("got report:\n%s", out.String())
+	}
+}
+
+// Connections are closed once SESSION_TIMEOUT passes, however active they
+// are, and counted as reaped
+func TestSessionTimeout(t *testing.T) {
+	defer func(d time.Duration) { sessionTimeout = d }(sessionTimeout)
+	sessionTimeout = 10 * time.Second
+	c := useFakeClock(t)
+	before := atomic.LoadUint64(&reaped.session)
+
+	client := testClient(t, startTestServer(t), "lingering", testSigner(t))
+	closed := make(chan error, 1)
+	go func() { closed <- client.Wait() }()
+
+	c.waitFor(t, sessionTimeout)
+	c.Advance(sessionTimeout)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the session timeout")
+	}
+
+	if n := atomic.LoadUint64(&reaped.session) - before; n != 1 {
+		t.Errorf("got %d connections counted as timing out, expected 1", n)
+	}
+}
+
+// Writes to clients that stop reading fail once WRITE_TIMEOUT passes, and
+// the connection is counted as reaped once, however many writes fail
+func TestWriteTimeout(t *testing.T) {
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 100 * time.Millisecond
+	before := atomic.LoadUint64(&reaped.write)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &writeTimeoutConn{Conn: server}
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write([]byte("unread")); !timedOut(err) {
+			t.Errorf("got %v, expected the write to time out", err)
+		}
+	}
+
+	if n := atomic.LoadUint64(&reaped.write) - before; n != 1 {
+		t.Errorf("got %d connections counted as timing out, expected 1", n)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// severity is how serious an issue is considered to be, which determines how
+// it is labelled in the report and the result given to the "status" user
+type severity int
+
+const (
+	severityNotice severity = iota
+	severityWarning
+	severityCritical
+)
+
+var severityNames = map[string]severity{
+	"notice":   severityNotice,
+	"warning":  severityWarning,
+	"critical": severityCritical,
+}
+
+// severities maps each issue that may be found to its severity. The
+// defaults can be overridden using the SEVERITY environment variable.
+var severities = map[string]severity{
+	"wellknown":     severityCritical,
+	"container":     severityCritical,
+	"blacklisted":   severityCritical,
+	"revoked":       severityCritical,
+	"collision":     severityCritical,
+	"typemismatch":  severityCritical,
+	"trivial":       severityCritical,
+	"factor":        severityCritical,
+	"entropy":       severityCritical,
+	"sharedmodulus": severityCritical,
+	"modulus":       severityCritical,
+	"dsa":           severityWarning,
+	"weak":          severityWarning,
+	"curve":         severityWarning,
+	"mismatch":      severityWarning,
+	"encoding":      severityWarning,
+	"unparseable":   severityWarning,
+	"agent":         severityCritical,
+	"x11":           severityCritical,
+}
+
+// issueSeverities maps each issue shown in the table to its name in
+// severities
+var issueSeverities = map[string]string{
+	issueWellKnown:         "wellknown",
+	issueContainerImage:    "container",
+	issueBlacklisted:       "blacklisted",
+	issueBlacklistedDebian: "blacklisted",
+	issueBlacklistedLocal:  "blacklisted",
+	issueRevoked:           "revoked",
+	issueRevokedSerial:     "revoked",
+	issueCollision:         "collision",
+	issueTypeMismatch:      "typemismatch",
+	issueTrivialModulus:    "trivial",
+	issueKnownFactor:       "factor",
+	issueLowEntropy:        "entropy",
+	issueSharedModulus:     "sharedmodulus",
+	issueWeakModulus:       "modulus",
+	issueDSA:               "dsa",
+	issueWeak:              "weak",
+	issueWeakSHA1:          "weak",
+	issueWeakCurve:         "curve",
+	issueMismatch:          "mismatch",
+	issueNonCanonical:      "encoding",
+	issueUnparseable:       "unparseable",
+}
+
+// parseSeverities overrides the severity of the issues listed in s, which
+// takes the form "dsa=critical,weak=notice"
+func parseSeverities(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected issue=severity: %q", pair)
+		}
+
+		if _, ok := severities[parts[0]]; !ok {
+			return fmt.Errorf("unknown issue: %q", parts[0])
+		}
+
+		sev, ok := severityNames[parts[1]]
+		if !ok {
+			return fmt.Errorf("unknown severity for %s, expected notice, warning or critical: %q", parts[0], parts[1])
+		}
+
+		severities[parts[0]] = sev
+	}
+
+	return nil
+}
+
+// label returns the label used for the severity in the report, padded to
+// the width of the longest label
+func (s severity) label() string {
+	switch s {
+	case severityCritical:
+		return "CRITICAL: "
+	case severityWarning:
+		return "WARNING:  "
+	}
+
+	return "NOTICE:   "
+}
+
+// worstSeverity returns the severity of the most serious issue found, or
+// critical if the client failed REQUIRE_MODERN_KEY
+func worstSeverity(found map[string]bool, notModern bool) severity {
+	worst := severityNotice
+	for issue, ok := range found {
+		if ok && severities[issue] > worst {
+			worst = severities[issue]
+		}
+	}
+	if notModern {
+		worst = severityCritical
+	}
+
+	return worst
+}
+
+// status returns the summary and exit status given to the "status" user
+func (s severity) status() (string, int) {
+	switch s {
+	case severityCritical:
+		return "CRITICAL", 2
+	case severityWarning:
+		return "WARN", 1
+	}
+
+	return "OK", 0
+}
+
+// labelled replaces the label at the start of msg with the one for the
+// issue's configured severity, formats it with args, if any, wrapping it to
+// width as formatMessage does, and links to the issue's documentation
+func labelled(issue, msg string, width int, args ...interface{}) string {
+	label := severities[issue].label()
+	msg = label + msg[len(label):]
+
+	return documented(issue, formatMessage(msg, width, args...))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// stopping is closed once the server starts shutting down, so that accept
+// loops know to stop when their listener is closed
+var stopping = make(chan struct{})
+
+// serveUntilSignalled accepts connections on each of the given listeners
+// until the server receives SIGINT or SIGTERM, then waits for sessions in
+// progress to finish and logs a summary of the connections served. A
+// second signal stops the server immediately.
+func serveUntilSignalled(listeners ...net.Listener) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+
+	var acceptors sync.WaitGroup
+	for _, l := range listeners {
+		acceptors.Add(1)
+		go func(l net.Listener) {
+			accept(l)
+			acceptors.Done()
+		}(l)
+	}
+
+	log.Infof("Received %s, waiting for sessions in progress to end", <-c)
+	close(stopping)
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	go func() {
+		log.Warnf("Received %s, stopping immediately", <-c)
+		logStats()
+		os.Exit(1)
+	}()
+
+	// Nothing more can be queued once every accept loop has returned
+	acceptors.Wait()
+	close(queue)
+	workersDone.Wait()
+
+	logStats()
+}
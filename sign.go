@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// reportSigner signs each report, if set, so that users can verify that it
+// came from this server and wasn't changed since
+var reportSigner ssh.Signer
+
+// reportNamespace is the namespace reports are signed in, which stops the
+// signatures being mistaken for signatures of anything else using the key
+const reportNamespace = "sshkeycheck-report"
+
+// setupReportSigner sets reportSigner to the key given in REPORT_SIGNING_KEY,
+// or the host key if SIGN_REPORTS is set. Only ECDSA keys can be used, as
+// ssh-keygen only verifies RSA signatures made using SHA-2, which the ssh
+// package can't make.
+func setupReportSigner() error {
+	if pem := os.Getenv("REPORT_SIGNING_KEY"); pem != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(pem))
+		if err != nil {
+			return err
+		}
+		reportSigner = signer
+	} else if envBool("SIGN_REPORTS", false) {
+		reportSigner = hostKey
+	}
+
+	if reportSigner != nil && !strings.HasPrefix(reportSigner.PublicKey().Type(), "ecdsa-") {
+		return fmt.Errorf("can't sign reports using a %s key, only ECDSA", reportSigner.PublicKey().Type())
+	}
+
+	return nil
+}
+
+// signReport returns an armored signature of the report, in the format made
+// by "ssh-keygen -Y sign" (PROTOCOL.sshsig in OpenSSH), and verified by
+// "ssh-keygen -Y verify". Carriage returns are left out of what's signed,
+// as saving the report or copying it from the terminal may lose them, and
+// they don't change its meaning.
+func signReport(signer ssh.Signer, report []byte) (string, error) {
+	hash := sha512.Sum512(bytes.Replace(report, []byte("\r"), nil, -1))
+
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace, Reserved, HashAlgorithm string
+		Hash                               []byte
+	}{reportNamespace, "", "sha512", hash[:]})...)
+	sig, err := signer.Sign(rand.Reader, signed)
+	if err != nil {
+		return "", err
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version                            uint32
+		PublicKey                          []byte
+		Namespace, Reserved, HashAlgorithm string
+		Signature                          []byte
+	}{1, signer.PublicKey().Marshal(), reportNamespace, "", "sha512", ssh.Marshal(sig)})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	lines := []string{"-----BEGIN SSH SIGNATURE-----"}
+	for len(encoded) > 70 {
+		lines = append(lines, encoded[:70])
+		encoded = encoded[70:]
+	}
+	lines = append(lines, encoded, "-----END SSH SIGNATURE-----")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
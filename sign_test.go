@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// verifyReport checks the signature at the end of the report using
+// ssh-keygen, as users are told to in the README, returning its output
+func verifyReport(t *testing.T, signer ssh.Signer, report string) (string, error) {
+	t.Helper()
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+
+	report = strings.Replace(report, "\r", "", -1)
+	i := strings.Index(report, "-----BEGIN SSH SIGNATURE-----\n")
+	if i < 0 {
+		t.Fatalf("report not signed:\n%s", report)
+	}
+
+	dir, err := ioutil.TempDir("", "signed-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"allowed_signers": "keycheck " + string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		"report.sig":      report[i:],
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", filepath.Join(dir, "allowed_signers"),
+		"-I", "keycheck", "-n", reportNamespace, "-s", filepath.Join(dir, "report.sig"))
+	cmd.Stdin = strings.NewReader(report[:i])
+	out, err := cmd.CombinedOutput()
+
+	return string(out), err
+}
+
+func TestSignReport(t *testing.T) {
+	signer := testSigner(t)
+	report := "Fingerprint  Type\n\rsome:key     ssh-rsa\n\r"
+
+	sig, err := signReport(signer, []byte(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := verifyReport(t, signer, report+sig); err != nil {
+		t.Errorf("signature not verified: %s\n%s", err, out)
+	}
+
+	// Carriage returns aren't signed, but everything else is
+	if out, err := verifyReport(t, signer, strings.Replace(report, "ssh-rsa", "ssh-dss", 1)+sig); err == nil {
+		t.Errorf("signature of a changed report verified:\n%s", out)
+	}
+}
+
+// The whole report is signed, including the prompt to list the keys in the
+// forwarded agent, which is written before the report itself
+func TestSignedReportWithAgentPrompt(t *testing.T) {
+	defer func(signer ssh.Signer) { reportSigner = signer }(reportSigner)
+	reportSigner = testSigner(t)
+
+	channel := openSession(t, "signed")
+	report := readReport(channel)
+	channel.SendRequest("auth-agent-req@openssh.com", false, nil)
+	if ok, err := channel.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{"agent"})); !ok || err != nil {
+		t.Fatal("subsystem refused:", err)
+	}
+	channel.Write([]byte("no\n"))
+
+	r := <-report
+	if !bytes.Contains([]byte(r), []byte(strings.TrimSpace(agentConfirmPrompt))) {
+		t.Fatalf("agent prompt not shown:\n%s", r)
+	}
+	if out, err := verifyReport(t, reportSigner, r); err != nil {
+		t.Errorf("signature not verified: %s\n%s\n%s", err, out, r)
+	}
+}
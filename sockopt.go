@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// tunedListener applies the configured socket options to each TCP
+// connection it accepts. Other kinds of connection are passed on as they
+// are.
+type tunedListener struct {
+	net.Listener
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tune(tcpConn); err != nil {
+			log.Warnln("Failed to set socket options for", conn.RemoteAddr(), err)
+		}
+	}
+
+	return conn, nil
+}
+
+// tuneListener sets the listener's backlog, if configured, and logs the
+// settings that will apply to the connections it accepts
+func tuneListener(l net.Listener) {
+	backlog := "system default"
+	if listenBacklog > 0 {
+		if err := setBacklog(l, listenBacklog); err != nil {
+			log.Fatalln("Failed to set LISTEN_BACKLOG for", l.Addr(), err)
+		}
+
+		backlog = strconv.Itoa(listenBacklog)
+		if max, ok := maxBacklog(); ok && max < listenBacklog {
+			backlog = fmt.Sprintf("%d (LISTEN_BACKLOG of %d capped by net.core.somaxconn)", max, listenBacklog)
+		}
+	} else if max, ok := maxBacklog(); ok {
+		backlog = strconv.Itoa(max)
+	}
+
+	sizeOrDefault := func(n int) string {
+		if n == 0 {
+			return "system default"
+		}
+		return strconv.Itoa(n)
+	}
+	keepalive := "unchanged"
+	if tcpKeepalive > 0 {
+		keepalive = tcpKeepalive.String()
+	}
+
+	log.WithFields(log.Fields{
+		"backlog":      backlog,
+		"keepalive":    keepalive,
+		"read_buffer":  sizeOrDefault(tcpReadBuffer),
+		"write_buffer": sizeOrDefault(tcpWriteBuffer),
+	}).Infoln("Socket settings for", l.Addr())
+}
+
+// tune enables TCP keepalives, so that clients that vanish without closing
+// their connection are noticed, and sets the socket's buffer sizes
+func tune(conn *net.TCPConn) error {
+	if tcpKeepalive > 0 {
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := conn.SetKeepAlivePeriod(tcpKeepalive); err != nil {
+			return err
+		}
+	}
+
+	if tcpReadBuffer > 0 {
+		if err := conn.SetReadBuffer(tcpReadBuffer); err != nil {
+			return err
+		}
+	}
+
+	if tcpWriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(tcpWriteBuffer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// totals counts the keys checked since the server started, and the issues
+// found with them
+var totals = struct {
+	sync.Mutex
+	keys   uint64
+	issues map[string]uint64
+}{issues: make(map[string]uint64)}
+
+// reaped counts the connections closed for taking too long, by the timeout
+// that closed them, so that operators can tune the timeouts to their
+// clients
+var reaped struct {
+	// handshake counts connections that didn't complete their TLS or SSH
+	// handshake within handshakeTimeout
+	handshake uint64
+
+	// channel counts connections that opened no channel within
+	// channelTimeout
+	channel uint64
+
+	// interactive counts interactive sessions left idle at the menu for
+	// menuTimeout
+	interactive uint64
+
+	// session counts connections still open after sessionTimeout
+	session uint64
+
+	// write counts connections that didn't accept what was written to them
+	// within writeTimeout
+	write uint64
+}
+
+// reapedCounts returns the counts of reaped connections, by the name of
+// the timeout that closed them
+func reapedCounts() []struct {
+	timeout string
+	count   uint64
+} {
+	return []struct {
+		timeout string
+		count   uint64
+	}{
+		{"handshake", atomic.LoadUint64(&reaped.handshake)},
+		{"channel", atomic.LoadUint64(&reaped.channel)},
+		{"interactive", atomic.LoadUint64(&reaped.interactive)},
+		{"session", atomic.LoadUint64(&reaped.session)},
+		{"write", atomic.LoadUint64(&reaped.write)},
+	}
+}
+
+// recordStats adds the outcome of checking a client's keys to the totals
+func recordStats(a *analysis) {
+	totals.Lock()
+	defer totals.Unlock()
+
+	totals.keys += uint64(len(a.results))
+	for issue, n := range a.issueCounts {
+		totals.issues[issue] += uint64(n)
+	}
+}
+
+// logStats logs the totals, using the names from severities for each issue.
+// Issues sharing a name are counted together.
+func logStats() {
+	totals.Lock()
+	defer totals.Unlock()
+
+	fields := log.Fields{
+		"connections": atomic.LoadUint64(&connCount),
+		"keys":        totals.keys,
+	}
+	names := make(map[string]uint64)
+	for issue, name := range issueSeverities {
+		names[name] += totals.issues[issue]
+	}
+	for name, n := range names {
+		fields[name] = n
+	}
+	fields["exempt"] = totals.issues[issueExempt]
+	fields["busy_workers"] = atomic.LoadInt32(&busyWorkers)
+	fields["queue_depth"] = len(queue)
+	for _, r := range reapedCounts() {
+		fields["reaped_"+r.timeout] = r.count
+	}
+
+	log.WithFields(fields).Infoln("Summary of connections since the server started")
+}
+
+// logStatsEvery logs the totals at each interval, until the server stops
+func logStatsEvery(interval time.Duration) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			logStats()
+		case <-stopping:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sunsetRule is a date from which keys of an algorithm, optionally only
+// those shorter than a given length, no longer comply with an
+// organisation's policy
+type sunsetRule struct {
+	algorithm string
+	below     int
+	date      time.Time
+}
+
+// sunsetSchedule lists the rules given in SUNSET_SCHEDULE, if any
+var sunsetSchedule []sunsetRule
+
+// keyAlgorithms maps the algorithm names used in sunset schedules and
+// ALLOWED_KEY_TYPES to the key types they apply to
+var keyAlgorithms = map[string]func(keyType string) bool{
+	"rsa":     func(t string) bool { return t == ssh.KeyAlgoRSA },
+	"dsa":     func(t string) bool { return t == ssh.KeyAlgoDSA },
+	"ecdsa":   func(t string) bool { return strings.HasPrefix(t, "ecdsa-sha2-") },
+	"ed25519": func(t string) bool { return t == "ssh-ed25519" },
+}
+
+// parseSunsetSchedule parses a schedule of the form
+// "rsa<3072=2025-12-31,rsa=2027-12-31", in which each rule gives an
+// algorithm, optionally followed by the length below which the rule
+// applies, and the date from which keys it applies to are non-compliant
+func parseSunsetSchedule(s string) ([]sunsetRule, error) {
+	var rules []sunsetRule
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected algorithm=date: %q", pair)
+		}
+
+		var r sunsetRule
+		r.algorithm = parts[0]
+		if i := strings.Index(parts[0], "<"); i >= 0 {
+			below, err := strconv.Atoi(parts[0][i+1:])
+			if err != nil || below <= 0 {
+				return nil, fmt.Errorf("invalid length for %s: %q", parts[0][:i], parts[0][i+1:])
+			}
+			r.algorithm, r.below = parts[0][:i], below
+		}
+
+		if _, ok := keyAlgorithms[r.algorithm]; !ok {
+			return nil, fmt.Errorf("unknown algorithm, expected rsa, dsa, ecdsa or ed25519: %q", r.algorithm)
+		}
+
+		date, err := time.Parse("2006-01-02", parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid date for %s, expected YYYY-MM-DD: %q", parts[0], parts[1])
+		}
+		r.date = date
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// sunset returns the earliest date from which the key no longer complies
+// with the schedule, if any rule applies to it
+func sunset(r keyResult) (time.Time, bool) {
+	var earliest time.Time
+	var found bool
+	for _, rule := range sunsetSchedule {
+		if !rule.appliesTo(r) {
+			continue
+		}
+
+		if !found || rule.date.Before(earliest) {
+			earliest, found = rule.date, true
+		}
+	}
+
+	return earliest, found
+}
+
+// appliesTo reports whether the rule applies to the key. A certificate is
+// judged by the key it certifies.
+func (rule sunsetRule) appliesTo(r keyResult) bool {
+	if !keyAlgorithms[rule.algorithm](certifiedType(r.key)) {
+		return false
+	}
+
+	return rule.below == 0 || r.key.parseErr == nil && r.length < rule.below
+}
+
+// String returns the rule as given in SUNSET_SCHEDULE
+func (rule sunsetRule) String() string {
+	if rule.below > 0 {
+		return fmt.Sprintf("%s<%d=%s", rule.algorithm, rule.below, rule.date.Format("2006-01-02"))
+	}
+	return rule.algorithm + "=" + rule.date.Format("2006-01-02")
+}
+
+// certifiedType returns the key's type or, for a certificate, the type of
+// the key it certifies
+func certifiedType(k *publicKey) string {
+	if cert, ok := k.key.(*ssh.Certificate); ok {
+		return cert.Key.Type()
+	}
+	return k.key.Type()
+}
+
+// sunsetDetails describes when each key stops complying with the schedule,
+// for the keys that any rule applies to
+func sunsetDetails(results []keyResult) []string {
+	var details []string
+	for _, r := range results {
+		date, ok := sunset(r)
+		if !ok {
+			continue
+		}
+
+		key := fmt.Sprintf("%s (%s %s)", r.key.Fingerprint(), r.key.key.Type(), r.bits())
+		days := int(math.Ceil(date.Sub(clk.Now()).Hours() / 24))
+		switch {
+		case days > 0:
+			details = append(details, fmt.Sprintf("%s stops complying on %s, in %d day(s)", key, date.Format("2006-01-02"), days))
+		case days == 0:
+			details = append(details, fmt.Sprintf("%s stops complying today", key))
+		default:
+			details = append(details, fmt.Sprintf("%s stopped complying on %s, %d day(s) ago", key, date.Format("2006-01-02"), -days))
+		}
+	}
+
+	return details
+}
@@ -0,0 +1,66 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	logrus_syslog "github.com/Sirupsen/logrus/hooks/syslog"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"mail":   syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON,
+	"auth":   syslog.LOG_AUTH,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// setupSyslog sends logs to syslog if configured to do so, falling back to
+// logging only to stderr if syslog is unavailable
+func setupSyslog() {
+	if !envBool("SYSLOG", false) {
+		return
+	}
+
+	facility := os.Getenv("SYSLOG_FACILITY")
+	if facility == "" {
+		facility = "daemon"
+	}
+
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		log.Fatalf("Unknown syslog facility %q", facility)
+	}
+
+	tag := os.Getenv("SYSLOG_TAG")
+	if tag == "" {
+		tag = "sshkeycheck"
+	}
+
+	network, addr := os.Getenv("SYSLOG_NETWORK"), os.Getenv("SYSLOG_ADDR")
+	hook, err := logrus_syslog.NewSyslogHook(network, addr, priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		log.Warnln("Failed to connect to syslog, logging to stderr only:", err)
+		return
+	}
+
+	log.AddHook(hook)
+
+	if envBool("SYSLOG_ONLY", false) {
+		log.SetOutput(ioutil.Discard)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import log "github.com/Sirupsen/logrus"
+
+// setupSyslog warns that syslog isn't supported on this platform
+func setupSyslog() {
+	if envBool("SYSLOG", false) {
+		log.Warnln("Syslog is not supported on this platform, logging to stderr only")
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Entries are sent to the configured syslog server, at the facility and
+// with the tag given, and at the priority equivalent to their level
+func TestSetupSyslog(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	hooks := make(map[log.Level][]log.Hook)
+	for level, h := range log.StandardLogger().Hooks {
+		hooks[level] = h
+	}
+	defer func() {
+		for level := range log.StandardLogger().Hooks {
+			log.StandardLogger().Hooks[level] = hooks[level]
+		}
+	}()
+
+	t.Setenv("SYSLOG", "true")
+	t.Setenv("SYSLOG_NETWORK", "udp")
+	t.Setenv("SYSLOG_ADDR", server.LocalAddr().String())
+	t.Setenv("SYSLOG_FACILITY", "local3")
+	t.Setenv("SYSLOG_TAG", "keycheck-test")
+	setupSyslog()
+
+	for _, test := range []struct {
+		log      func(...interface{})
+		priority string
+	}{
+		// local3 is facility 19, so priorities start at 19*8
+		{log.Error, "<155>"},
+		{log.Warn, "<156>"},
+		{log.Info, "<158>"},
+	} {
+		test.log("Syslog test entry")
+
+		buf := make([]byte, 1024)
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := string(buf[:n])
+		if !strings.HasPrefix(entry, test.priority) || !strings.Contains(entry, "keycheck-test") || !strings.Contains(entry, "Syslog test entry") {
+			t.Errorf("got %q, expected priority %s", entry, test.priority)
+		}
+	}
+}
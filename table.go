@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// tableColumn is one of the columns in the table of keys before the last,
+// which gives each key's issues
+type tableColumn struct {
+	header string
+	value  func(r keyResult) string
+}
+
+// tableColumns returns the columns shown before each key's issues, as
+// configured
+func tableColumns() []tableColumn {
+	var columns []tableColumn
+	if numberKeys {
+		columns = append(columns, tableColumn{"#", func(r keyResult) string { return fmt.Sprint(r.index) }})
+	}
+	columns = append(columns, tableColumn{"Bits", keyResult.bits})
+	if showStrength {
+		columns = append(columns, tableColumn{"Strength", keyResult.strength})
+	}
+	columns = append(columns,
+		tableColumn{"Type", func(r keyResult) string { return r.key.key.Type() }},
+		tableColumn{"Fingerprint", func(r keyResult) string { return r.key.Fingerprint() }},
+	)
+	if showBabble {
+		columns = append(columns, tableColumn{"Bubblebabble", func(r keyResult) string { return r.key.FingerprintBabble() }})
+	}
+	columns = append(columns, tableColumn{"Accepted by " + openssh9.name, func(r keyResult) string { return r.accepted }})
+	if fips != nil {
+		columns = append(columns, tableColumn{fips.name, func(r keyResult) string { return r.fips }})
+	}
+
+	return columns
+}
+
+// The minimum width of each column, and the padding after its widest
+// cell, as for the tabwriter used when rows aren't streamed
+const (
+	columnMinWidth = 5
+	columnPadding  = 2
+)
+
+// writeTable writes the table of keys in one go, so that each column is as
+// wide as its widest cell
+func writeTable(w io.Writer, rows []keyResult) error {
+	tabWriter := new(tabwriter.Writer)
+	tabWriter.Init(w, columnMinWidth, 2, columnPadding, ' ', 0)
+	// Note that using tabwriter, columns are tab-terminated,
+	// not tab-delimited
+	columns := tableColumns()
+	for _, c := range columns {
+		fmt.Fprint(tabWriter, c.header+"\t")
+	}
+	fmt.Fprint(tabWriter, "Issues\n")
+
+	for _, r := range rows {
+		for _, c := range columns {
+			fmt.Fprintf(tabWriter, "%s\t", c.value(r))
+		}
+		fmt.Fprintf(tabWriter, "%s\t\n", r.issue)
+	}
+
+	return tabWriter.Flush()
+}
+
+// streamedTable writes the table of keys a row at a time, as each key is
+// checked, so that users presenting many keys see progress. As later rows
+// can't widen the columns once earlier rows have been sent, their widths
+// are worked out beforehand from everything but the issues, which are
+// cheap to find and are shown last.
+type streamedTable struct {
+	columns []tableColumn
+	widths  []int
+}
+
+// newStreamedTable sizes the table's columns to fit the given keys
+func newStreamedTable(keys []*publicKey) *streamedTable {
+	t := &streamedTable{columns: tableColumns()}
+	for _, c := range t.columns {
+		t.widths = append(t.widths, len(c.header))
+	}
+
+	for i, k := range keys {
+		length, _ := k.BitLen()
+		r := keyResult{index: i + 1, key: k, length: length, accepted: acceptance(k)}
+		r.fips, _ = fipsCompliance(k)
+		for j, c := range t.columns {
+			if v := c.value(r); len(v) > t.widths[j] {
+				t.widths[j] = len(v)
+			}
+		}
+	}
+
+	for i, w := range t.widths {
+		if w += columnPadding; w < columnMinWidth {
+			w = columnMinWidth
+		}
+		t.widths[i] = w
+	}
+
+	return t
+}
+
+// header returns the table's header line
+func (t *streamedTable) header() string {
+	cells := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cells[i] = c.header
+	}
+
+	return t.line(cells, "Issues")
+}
+
+// row returns the key's line in the table
+func (t *streamedTable) row(r keyResult) string {
+	cells := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cells[i] = c.value(r)
+	}
+
+	return t.line(cells, r.issue)
+}
+
+func (t *streamedTable) line(cells []string, last string) string {
+	var b bytes.Buffer
+	for i, cell := range cells {
+		b.WriteString(cell)
+		b.WriteString(strings.Repeat(" ", t.widths[i]-len(cell)))
+	}
+	b.WriteString(last)
+	b.WriteString("\n\r")
+
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The bubblebabble column is only shown if asked for
+func TestWriteTableBubblebabble(t *testing.T) {
+	defer func(show bool) { showBabble = show }(showBabble)
+
+	a := analyzeKeys(generateKey(t, "ecdsa-256"))
+	babble := a.results[0].key.FingerprintBabble()
+	for _, show := range []bool{false, true} {
+		showBabble = show
+
+		var out bytes.Buffer
+		if err := writeTable(&out, a.results); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(out.String(), babble) && strings.Contains(out.String(), "Bubblebabble"); got != show {
+			t.Errorf("BUBBLEBABBLE %t: column shown %t:\n%s", show, got, out.String())
+		}
+	}
+}
+
+// The security strength column is only shown if asked for
+func TestWriteTableStrength(t *testing.T) {
+	defer func(show bool) { showStrength = show }(showStrength)
+
+	a := analyzeKeys(generateKey(t, "rsa-2048"), generateKey(t, "ecdsa-384"))
+	for _, show := range []bool{false, true} {
+		showStrength = show
+
+		var out bytes.Buffer
+		if err := writeTable(&out, a.results); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(out.String(), "\n")
+		got := strings.Contains(lines[0], "Strength") && strings.HasPrefix(lines[1], "2048  112 ") && strings.HasPrefix(lines[2], "384   192 ")
+		if got != show {
+			t.Errorf("SECURITY_STRENGTH %t: column shown %t:\n%s", show, got, out.String())
+		}
+	}
+}
+
+func BenchmarkWriteTable(b *testing.B) {
+	a := analyzeKeys(generateKey(b, "rsa-2048"), generateKey(b, "dsa-1024"), generateKey(b, "ecdsa-256"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writeTable(ioutil.Discard, a.results); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// checkTimeout is how long each of the more expensive checks may take, for
+// each key or set of keys it applies to, before it is left out of the
+// report rather than delaying it further
+var checkTimeout = 5 * time.Second
+
+// withinTimeout runs check in the background and reports whether it
+// finished within checkTimeout. If it didn't, stop is closed, which checks
+// with long loops watch for so that they give up rather than running on
+// unseen. They may still be running for a while, so none of their results
+// may be used.
+func withinTimeout(logger *log.Entry, name string, check func(stop <-chan struct{})) bool {
+	done, stop := make(chan struct{}), make(chan struct{})
+	go func() {
+		check(stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-clk.After(checkTimeout):
+		close(stop)
+		logger.Warnf("Check of %s timed out after %s, so wasn't evaluated", name, checkTimeout)
+		return false
+	}
+}
+
+// stopped reports whether stop has been closed
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
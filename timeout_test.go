@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithinTimeout(t *testing.T) {
+	c := useFakeClock(t)
+
+	if !withinTimeout(testLogger, "quick", func(<-chan struct{}) {}) {
+		t.Error("check that finished straight away timed out")
+	}
+	// Fire the quick check's timer, so that it isn't mistaken for the next
+	c.Advance(checkTimeout)
+
+	// A check that runs until it's stopped must be told to stop once it
+	// has timed out, rather than being left running
+	gaveUp := make(chan bool, 1)
+	result := make(chan bool, 1)
+	go func() {
+		result <- withinTimeout(testLogger, "slow", func(stop <-chan struct{}) {
+			for !stopped(stop) {
+				time.Sleep(time.Millisecond)
+			}
+			gaveUp <- true
+		})
+	}()
+
+	c.waitFor(t, checkTimeout)
+	c.Advance(checkTimeout)
+	select {
+	case ok := <-result:
+		if ok {
+			t.Error("check that never finished was evaluated")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after checkTimeout")
+	}
+	select {
+	case <-gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Error("check still running after timing out")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// connCount is used to give each connection a unique ID
+var connCount uint64
+
+// tracedConn is a connection tagged with an ID, which is included in each
+// log entry about the connection so that entries about concurrent
+// connections can be told apart
+type tracedConn struct {
+	net.Conn
+	id string
+
+	// ref is a short code shown to the user so that they can quote it when
+	// reporting a problem. It's derived from the ID, but doesn't reveal how
+	// many connections the server has handled.
+	ref string
+}
+
+func trace(conn net.Conn) *tracedConn {
+	id := strconv.FormatUint(atomic.AddUint64(&connCount, 1), 10)
+
+	mac := hmac.New(sha256.New, logKey)
+	mac.Write([]byte(id))
+	ref := hex.EncodeToString(mac.Sum(nil)[:3])
+
+	return &tracedConn{Conn: conn, id: id, ref: ref}
+}
+
+// logger returns a log entry tagged with the connection's ID and reference
+func (c *tracedConn) logger() *log.Entry {
+	fields := log.Fields{"conn": c.id, "ref": c.ref}
+
+	// Behind a load balancer, entries are also tagged with the address of
+	// the client it forwards for
+	if p, ok := c.Conn.(*proxiedConn); ok {
+		fields["client"] = p.remote.String()
+	}
+
+	return log.WithFields(fields)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// msgKexInit is SSH_MSG_KEXINIT, see RFC 4253
+const msgKexInit = 20
+
+// kexInitMsg mirrors the ssh package's unexported message of the same name
+type kexInitMsg struct {
+	Cookie                  [16]byte `sshtype:"20"`
+	KexAlgos                []string
+	ServerHostKeyAlgos      []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+	LanguagesClientServer   []string
+	LanguagesServerClient   []string
+	FirstKexFollows         bool
+	Reserved                uint32
+}
+
+// kexSniffer wraps a connection to record the client's key exchange
+// proposal, which the ssh package doesn't expose. The proposal is always
+// the first packet sent by the client and is sent in the clear.
+type kexSniffer struct {
+	net.Conn
+
+	mu      sync.Mutex
+	buf     []byte
+	done    bool
+	kexInit *kexInitMsg
+}
+
+func (s *kexSniffer) Read(b []byte) (int, error) {
+	n, err := s.Conn.Read(b)
+
+	s.mu.Lock()
+	if !s.done {
+		s.buf = append(s.buf, b[:n]...)
+		s.parse()
+	}
+	s.mu.Unlock()
+
+	return n, err
+}
+
+// parse looks for the client's KEXINIT packet in the data read so far
+func (s *kexSniffer) parse() {
+	// Skip the version exchange, which ends with the first line
+	// beginning with "SSH-"
+	start := 0
+	for {
+		end := bytes.IndexByte(s.buf[start:], '\n')
+		if end == -1 {
+			return
+		}
+		line := s.buf[start : start+end]
+		start += end + 1
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+
+	packet := s.buf[start:]
+	if len(packet) < 5 {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(packet)
+	padding := uint32(packet[4])
+	if length > 256*1024 || padding+1 > length {
+		// Not a plausible packet, so give up
+		s.done = true
+		return
+	}
+	if uint32(len(packet)) < 4+length {
+		return
+	}
+
+	s.done = true
+	s.buf = nil
+
+	payload := packet[5 : 4+length-padding]
+	if len(payload) == 0 || payload[0] != msgKexInit {
+		return
+	}
+
+	msg := new(kexInitMsg)
+	if ssh.Unmarshal(payload, msg) == nil {
+		s.kexInit = msg
+	}
+}
+
+// clientKexInit returns the client's key exchange proposal, or nil if it
+// wasn't seen
+func (s *kexSniffer) clientKexInit() *kexInitMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kexInit
+}
+
+// transportInfo describes the algorithms negotiated for a connection
+type transportInfo struct {
+	kex, hostKey       string
+	cipherClientServer string
+	cipherServerClient string
+	macClientServer    string
+	macServerClient    string
+	compression        string
+}
+
+// negotiated works out which algorithms were agreed with the client, in the
+// same way as the ssh package: the first algorithm proposed by the client
+// that is also supported by the server is used
+func negotiated(config *ssh.ServerConfig, client *kexInitMsg) transportInfo {
+	c := config.Config
+	c.SetDefaults()
+
+	return transportInfo{
+		kex:                firstCommon(client.KexAlgos, c.KeyExchanges),
+		hostKey:            firstCommon(client.ServerHostKeyAlgos, []string{hostKey.PublicKey().Type()}),
+		cipherClientServer: firstCommon(client.CiphersClientServer, c.Ciphers),
+		cipherServerClient: firstCommon(client.CiphersServerClient, c.Ciphers),
+		macClientServer:    firstCommon(client.MACsClientServer, c.MACs),
+		macServerClient:    firstCommon(client.MACsServerClient, c.MACs),
+		// The ssh package doesn't support compression
+		compression: firstCommon(client.CompressionClientServer, []string{"none"}),
+	}
+}
+
+// preferredCompression returns the compression algorithm the client would
+// most like to use, in either direction. OpenSSH always offers compression,
+// but only prefers it when asked to, e.g. using ssh -C.
+func preferredCompression(client *kexInitMsg) string {
+	for _, algos := range [][]string{client.CompressionClientServer, client.CompressionServerClient} {
+		if len(algos) > 0 && algos[0] != "none" {
+			return algos[0]
+		}
+	}
+
+	return "none"
+}
+
+func firstCommon(client, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+
+	return "<none>"
+}
+
+// isAEAD reports whether the cipher provides its own integrity protection,
+// in which case the negotiated MAC isn't used
+func isAEAD(cipher string) bool {
+	return strings.Contains(cipher, "gcm") || strings.Contains(cipher, "poly1305")
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// websocketGUID is appended to the client's key to accept a WebSocket
+// connection, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The WebSocket opcodes used, see RFC 6455
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// The WebSocket close status codes sent, see RFC 6455
+const (
+	wsNormalClosure = 1000
+	wsProtocolError = 1002
+)
+
+// websocketSubprotocol is the subprotocol given by browser-based clients,
+// such as those using websockify, that send the connection's bytes in
+// binary messages
+const websocketSubprotocol = "binary"
+
+// errWebsocketClosed is returned by a websocketListener once it's closed
+var errWebsocketClosed = errors.New("WebSocket listener closed")
+
+// websocketListener accepts SSH connections made over WebSockets, for
+// browser-based SSH clients, which can't make TCP connections themselves.
+// It serves HTTP on the listener it wraps, returning each connection
+// upgraded to a WebSocket from Accept, so that it's served the same way as
+// any other, and any path can be used.
+type websocketListener struct {
+	net.Listener
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebsocketListener(l net.Listener) *websocketListener {
+	w := &websocketListener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(w.upgrade),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(l); err != nil {
+			select {
+			case <-w.done:
+			default:
+				log.Errorln("Stopped accepting SSH over WebSockets:", err)
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *websocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-w.conns:
+		return conn, nil
+	case <-w.done:
+		return nil, errWebsocketClosed
+	}
+}
+
+func (w *websocketListener) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return w.Listener.Close()
+}
+
+// upgrade completes the WebSocket handshake, see RFC 6455, and passes the
+// connection to Accept
+func (w *websocketListener) upgrade(rw http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		rw.Header().Set("Upgrade", "websocket")
+		http.Error(rw, "Connect using a WebSocket to check your SSH keys", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "Unsupported WebSocket version", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "WebSockets not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Warnln("Failed to take over WebSocket connection from", r.RemoteAddr, err)
+		return
+	}
+	// The server's deadline for reading the request no longer applies
+	conn.SetDeadline(time.Time{})
+
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n")
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", websocketSubprotocol) {
+		buf.WriteString("Sec-WebSocket-Protocol: " + websocketSubprotocol + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	if err := buf.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &websocketConn{Conn: conn, r: buf.Reader}
+	select {
+	case w.conns <- ws:
+	case <-w.done:
+		conn.Close()
+	}
+}
+
+// headerContains reports whether any of the comma-separated values of the
+// named header is the given token, ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, values := range h[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(values, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept header accepting the
+// given Sec-WebSocket-Key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// websocketConn carries a byte stream in the messages of a WebSocket, as
+// the server's end of it. Data frames are read as the stream, whatever
+// their type and however they're fragmented, and are written as binary
+// frames; pings are answered, and a close frame ends the stream once it's
+// acknowledged.
+type websocketConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// remaining is the length of the data frame being read that is yet to
+	// be read, masked with mask from maskPos
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+
+	// closed is set once a close frame has been read
+	closed bool
+
+	// writeMu serialises frames, as pongs are written while reading
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func (c *websocketConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	for i := range b[:n] {
+		b[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+	c.remaining -= uint64(n)
+
+	return n, err
+}
+
+// readFrame reads the header of the next frame, and the whole of control
+// frames, which are handled as they're read, leaving the payload of data
+// frames to be read
+func (c *websocketConn) readFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7f)
+
+	// Clients must mask every frame, and no extensions are agreed
+	if !masked || header[0]&0x70 != 0 {
+		return c.fail("WebSocket frame not masked, or using an extension")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+	default:
+		return c.fail("unknown WebSocket opcode")
+	}
+
+	if !fin || length > 125 {
+		return c.fail("WebSocket control frame fragmented or too long")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= c.mask[i%4]
+	}
+
+	switch opcode {
+	case wsPing:
+		return c.writeFrame(wsPong, payload)
+	case wsClose:
+		// The close is acknowledged with the status the client gave
+		c.closed = true
+		status := make([]byte, 2)
+		binary.BigEndian.PutUint16(status, wsNormalClosure)
+		if len(payload) >= 2 {
+			status = payload[:2]
+		}
+		c.sendClose(status)
+	}
+
+	return nil
+}
+
+// fail closes the connection, telling the client it broke the protocol
+func (c *websocketConn) fail(reason string) error {
+	status := make([]byte, 2)
+	binary.BigEndian.PutUint16(status, wsProtocolError)
+	c.sendClose(status)
+	c.Conn.Close()
+
+	return errors.New(reason)
+}
+
+func (c *websocketConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// writeFrame writes a single unmasked frame, as servers must
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return errors.New("WebSocket closed")
+	}
+	if opcode == wsClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// sendClose sends a close frame with the given status, unless one has
+// already been sent, giving up quickly if the client isn't reading
+func (c *websocketConn) sendClose(status []byte) {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsClose, status)
+}
+
+// Close ends the WebSocket cleanly, by sending a close frame, before
+// closing the connection
+func (c *websocketConn) Close() error {
+	status := make([]byte, 2)
+	binary.BigEndian.PutUint16(status, wsNormalClosure)
+	c.sendClose(status)
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clientFrame returns a frame as a client sends it, masked
+func clientFrame(opcode byte, fin bool, payload []byte) []byte {
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+// readServerFrame reads a frame sent by the server, which mustn't be masked
+func readServerFrame(t testing.TB, r io.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		t.Errorf("got frame header %x, expected a final unmasked frame", header)
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+// testWebsocketClient is the client's end of a WebSocket, carrying a byte
+// stream in binary messages, as browser-based SSH clients do
+type testWebsocketClient struct {
+	t testing.TB
+	net.Conn
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (c *testWebsocketClient) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		opcode, payload, err := readServerFrame(c.t, c.r)
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case wsBinary:
+			c.buf = payload
+		case wsClose:
+			return 0, io.EOF
+		default:
+			c.t.Errorf("got unexpected opcode %d", opcode)
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *testWebsocketClient) Write(b []byte) (int, error) {
+	if _, err := c.Conn.Write(clientFrame(wsBinary, true, b)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// dialWebsocket opens a WebSocket to the server at addr
+func dialWebsocket(t testing.TB, addr string) *testWebsocketClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The key is the example given in RFC 6455
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Protocol", "binary")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		resp.Header.Get("Sec-WebSocket-Protocol") != "binary" {
+		t.Fatalf("got response %s, %v", resp.Status, resp.Header)
+	}
+
+	return &testWebsocketClient{t: t, Conn: conn, r: r}
+}
+
+// startTestWebsocketServer serves SSH over WebSockets on a free port until
+// the test ends, returning its address
+func startTestWebsocketServer(t testing.TB) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serveTestListener(t, newWebsocketListener(listener))
+}
+
+// Browser-based clients get the same report over a WebSocket as over SSH
+func TestWebsocketReport(t *testing.T) {
+	addr := startTestWebsocketServer(t)
+	ws := dialWebsocket(t, addr)
+
+	conn, chans, reqs, err := ssh.NewClientConn(ws, addr, &ssh.ClientConfig{
+		User: "websocket",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(testWeakSigner(t)),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return nil, nil
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	session.Wait()
+
+	if !strings.Contains(out.String(), "1024") || !strings.Contains(out.String(), "WEAK KEY LENGTH") {
+		t.Errorf("got report:\n%s", out.String())
+	}
+}
+
+// Closing the connection closes the WebSocket cleanly first, after which
+// nothing more can be written
+func TestWebsocketConnClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := &websocketConn{Conn: server, r: bufio.NewReader(server)}
+
+	frames := make(chan []byte)
+	go func() {
+		opcode, payload, _ := readServerFrame(t, client)
+		frames <- append([]byte{opcode}, payload...)
+	}()
+
+	if err := ws.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if frame := <-frames; !bytes.Equal(frame, []byte{wsClose, 0x03, 0xe8}) {
+		t.Errorf("got frame %x, expected a close with status %d", frame, wsNormalClosure)
+	}
+	if _, err := ws.Write([]byte("more")); err == nil {
+		t.Error("expected writing after closing to fail")
+	}
+}
+
+// Requests that aren't for a WebSocket are turned away
+func TestWebsocketUpgradeRequired(t *testing.T) {
+	addr := startTestWebsocketServer(t)
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("got status %d, expected %d", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+}
+
+// Data frames are read as a stream whatever their type and fragmentation,
+// pings are answered, and clients breaking the protocol are disconnected
+func TestWebsocketConnFrames(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		frames   [][]byte
+		expected string
+		replies  []byte
+		status   uint16
+	}{
+		{
+			"fragmented",
+			[][]byte{clientFrame(wsBinary, false, []byte("hello, ")), clientFrame(wsContinuation, true, []byte("world"))},
+			"hello, world", nil, 0,
+		},
+		{
+			"text and binary",
+			[][]byte{clientFrame(wsText, true, []byte("SSH-2.0-")), clientFrame(wsBinary, true, []byte("browser"))},
+			"SSH-2.0-browser", nil, 0,
+		},
+		{
+			"long frame",
+			[][]byte{clientFrame(wsBinary, true, bytes.Repeat([]byte("x"), 300))},
+			strings.Repeat("x", 300), nil, 0,
+		},
+		{
+			"ping",
+			[][]byte{clientFrame(wsBinary, false, []byte("before ")), clientFrame(wsPing, true, []byte("ping")), clientFrame(wsContinuation, true, []byte("after"))},
+			"before after", []byte{wsPong}, 0,
+		},
+		{
+			"closed by client",
+			[][]byte{clientFrame(wsBinary, true, []byte("bye")), clientFrame(wsClose, true, []byte{0x03, 0xe9})},
+			"bye", []byte{wsClose}, 1001,
+		},
+		{
+			"unmasked",
+			[][]byte{{0x82, 0x03, 'b', 'a', 'd'}},
+			"", []byte{wsClose}, wsProtocolError,
+		},
+		{
+			"fragmented ping",
+			[][]byte{clientFrame(wsPing, false, []byte("ping"))},
+			"", []byte{wsClose}, wsProtocolError,
+		},
+	} {
+		server, client := net.Pipe()
+		ws := &websocketConn{Conn: server, r: bufio.NewReader(server)}
+
+		go func() {
+			for _, frame := range test.frames {
+				if _, err := client.Write(frame); err != nil {
+					break
+				}
+			}
+		}()
+
+		replies := make(chan []byte)
+		var status uint16
+		go func() {
+			var opcodes []byte
+			for {
+				opcode, payload, err := readServerFrame(t, client)
+				if err != nil {
+					break
+				}
+				opcodes = append(opcodes, opcode)
+				if opcode == wsClose {
+					status = binary.BigEndian.Uint16(payload)
+					break
+				}
+			}
+			replies <- opcodes
+		}()
+
+		var got []byte
+		buf := make([]byte, 7)
+		for len(got) < len(test.expected) {
+			n, err := ws.Read(buf)
+			got = append(got, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		if string(got) != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+		}
+
+		// Reading on past the data reads the close that follows
+		if test.status != 0 {
+			if _, err := ws.Read(buf); err == nil {
+				t.Errorf("%s: expected the stream to end", test.name)
+			}
+		}
+		server.Close()
+		if opcodes := <-replies; !bytes.Equal(opcodes, test.replies) || status != test.status {
+			t.Errorf("%s: got replies %v, status %d, expected %v, %d", test.name, opcodes, status, test.replies, test.status)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// wellKnownKeys maps the SHA-256 fingerprints of keys whose private keys
+// have been published, so that anyone can use them, to where they were
+// published. Further keys can be listed in the file named by
+// WELL_KNOWN_KEYS_FILE.
+var wellKnownKeys = map[string]string{
+	// https://github.com/hashicorp/vagrant/tree/main/keys
+	"SHA256:1M4RzhMyWuFS/86uPY/ce2prh/dVTHW7iD2RhpquOZA": "Vagrant's insecure key",
+}
+
+// containerImageKeys maps the SHA-256 fingerprints of keys shipped in
+// public container images, whose private keys anyone can extract by
+// pulling the image, to the image they were found in. None are built in,
+// as only keys confirmed to be in a published image should be listed;
+// they're loaded from the file named by CONTAINER_IMAGE_KEYS_FILE.
+var containerImageKeys = make(map[string]string)
+
+// loadWellKnownKeys adds the keys listed in the named file to
+// wellKnownKeys. Each line gives a SHA-256 fingerprint followed by where the
+// key's private key was published.
+func loadWellKnownKeys(path string) {
+	loadPublishedKeys(path, "well-known keys", wellKnownKeys)
+}
+
+// loadContainerImageKeys adds the keys listed in the named file to
+// containerImageKeys, in the same format as for loadWellKnownKeys, giving
+// the image that each key was found in
+func loadContainerImageKeys(path string) {
+	loadPublishedKeys(path, "container image keys", containerImageKeys)
+}
+
+// loadPublishedKeys adds the fingerprints and descriptions listed in the
+// named file to keys
+func loadPublishedKeys(path, kind string, keys map[string]string) {
+	listed, err := readPublishedKeys(path)
+	if err != nil {
+		log.Fatalf("Failed to load %s file: %s", kind, err)
+	}
+
+	for fingerprint, description := range listed {
+		keys[fingerprint] = description
+	}
+}
+
+// readPublishedKeys reads the fingerprints and descriptions listed in the
+// named file
+func readPublishedKeys(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(entry, " ", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "SHA256:") {
+			return nil, fmt.Errorf("invalid entry in %s on line %d, expected a SHA256 fingerprint and description: %q", path, line, entry)
+		}
+
+		keys[strings.TrimRight(fields[0], "=")] = strings.TrimSpace(fields[1])
+	}
+
+	return keys, scanner.Err()
+}
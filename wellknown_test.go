@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// vagrantKey is Vagrant's insecure public key, which is built into
+// wellKnownKeys
+const vagrantKey = "ssh-rsa AAAAB3NzaC1yc2EAAAABIwAAAQEA6NF8iallvQVp22WDkTkyrtvp9eWW6A8YVr+kz4TjGYe7gHzIw+niNltGEFHzD8+v1I2YJ6oXevct1YeS0o9HZyN1Q9qgCgzUFtdOKLv6IedplqoPkcmF0aYet2PkEDo3MlTBckFXPITAMzF8dJSIFo9D8HfdOV0IAdx4O7PtixWKn5y2hMNG0zQPyUecp4pzC6kivAIhyfHilFR61RGL+GPXQ2MWZWFYbAGjyiYJnAmCP3NOTd0jMZEnDkbUvxhMmBYSdETk1rRgm+R4LOzFUGaHqHDLKLX+FIPKcF96hrucXzcWyLbIbEgE98OHlnVYCzRdK8jlqm8tehUc9c9WhQ== vagrant insecure public key"
+
+func TestWellKnownKeys(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(vagrantKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := analyzeKeys(key, generateKey(t, "ecdsa-256"))
+	if !a.wellKnown || a.results[0].issue != issueWellKnown {
+		t.Fatalf("Vagrant's insecure key not found: %+v", a.results[0])
+	}
+	if a.results[1].issue != issueNone {
+		t.Errorf("got %q for a freshly generated key", a.results[1].issue)
+	}
+	if want := []string{a.results[0].key.Fingerprint() + " (Vagrant's insecure key)"}; len(a.wellKnownSources) != 1 || a.wellKnownSources[0] != want[0] {
+		t.Errorf("got sources %q, expected %q", a.wellKnownSources, want)
+	}
+}
+
+// Keys listed in WELL_KNOWN_KEYS_FILE are flagged alongside the built-in
+// ones, with where each was published
+func TestLoadWellKnownKeys(t *testing.T) {
+	defer func(keys map[string]string) { wellKnownKeys = keys }(wellKnownKeys)
+	wellKnownKeys = map[string]string{"SHA256:1M4RzhMyWuFS/86uPY/ce2prh/dVTHW7iD2RhpquOZA": "Vagrant's insecure key"}
+
+	vagrant, _, _, _, err := ssh.ParseAuthorizedKey([]byte(vagrantKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tutorial, appliance := &publicKey{key: generateKey(t, "rsa-2048")}, &publicKey{key: generateKey(t, "ecdsa-384")}
+	path := filepath.Join(t.TempDir(), "well_known_keys")
+	list := "# Keys published with their private keys\n" +
+		tutorial.FingerprintSHA256() + "= Example key from a tutorial\n" +
+		appliance.FingerprintSHA256() + " Default key of an appliance\n"
+	if err := ioutil.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loadWellKnownKeys(path)
+
+	for _, test := range []struct {
+		name   string
+		key    ssh.PublicKey
+		source string
+	}{
+		{"built-in key", vagrant, "Vagrant's insecure key"},
+		{"listed key with padding", tutorial.key, "Example key from a tutorial"},
+		{"listed key", appliance.key, "Default key of an appliance"},
+		{"unlisted key", generateKey(t, "ecdsa-256"), ""},
+	} {
+		a := analyzeKeys(test.key)
+		if test.source == "" {
+			if a.wellKnown || a.results[0].issue != issueNone {
+				t.Errorf("%s: got %q, expected no issue", test.name, a.results[0].issue)
+			}
+			continue
+		}
+		if !a.wellKnown || a.results[0].issue != issueWellKnown {
+			t.Errorf("%s: got %q, expected %q", test.name, a.results[0].issue, issueWellKnown)
+		}
+		if expected := a.results[0].key.Fingerprint() + " (" + test.source + ")"; len(a.wellKnownSources) != 1 || a.wellKnownSources[0] != expected {
+			t.Errorf("%s: got sources %q, expected %q", test.name, a.wellKnownSources, expected)
+		}
+	}
+}
+
+func TestContainerImageKeys(t *testing.T) {
+	defer func(keys map[string]string) { containerImageKeys = keys }(containerImageKeys)
+	containerImageKeys = make(map[string]string)
+
+	shipped := &publicKey{key: generateKey(t, "ecdsa-384")}
+	path := filepath.Join(t.TempDir(), "container_image_keys")
+	list := "# Found in /etc/ssh\n" + shipped.FingerprintSHA256() + " example/sshd:1.0\n"
+	if err := ioutil.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loadContainerImageKeys(path)
+
+	a := analyzeKeys(shipped.key, generateKey(t, "ecdsa-256"))
+	switch {
+	case !a.containerImage || a.results[0].issue != issueContainerImage:
+		t.Fatalf("key from CONTAINER_IMAGE_KEYS_FILE not found: %+v", a.results[0])
+	case a.results[1].issue != issueNone:
+		t.Errorf("got %q for a key that isn't listed", a.results[1].issue)
+	case len(a.containerImages) != 1 || a.containerImages[0] != shipped.Fingerprint()+" (example/sshd:1.0)":
+		t.Errorf("got images %q", a.containerImages)
+	}
+}
+
+func TestReadPublishedKeys(t *testing.T) {
+	for _, test := range []struct {
+		list string
+		ok   bool
+	}{
+		{"SHA256:1M4RzhMyWuFS/86uPY/ce2prh/dVTHW7iD2RhpquOZA= example\n", true},
+		{"\n# comment\n", true},
+		{"SHA256:1M4RzhMyWuFS/86uPY/ce2prh/dVTHW7iD2RhpquOZA\n", false},
+		{"1c:77:ad:42:be:a3:0b:90:07:79:05:74:72:39:fd:1d example\n", false},
+	} {
+		path := filepath.Join(t.TempDir(), "keys")
+		if err := ioutil.WriteFile(path, []byte(test.list), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := readPublishedKeys(path)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.list, err)
+		}
+		if test.ok && len(keys) > 0 && keys["SHA256:1M4RzhMyWuFS/86uPY/ce2prh/dVTHW7iD2RhpquOZA"] != "example" {
+			t.Errorf("%q: got %v, expected padding to be trimmed", test.list, keys)
+		}
+	}
+}
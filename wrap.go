@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// minWrapWidth is the narrowest width messages are wrapped to, so that
+// there's room for some text beside the severity label
+const minWrapWidth = 40
+
+var (
+	// severityLabelPattern matches the label at the start of a labelled
+	// message, e.g. "WARNING:  "
+	severityLabelPattern = regexp.MustCompile(`^[A-Z]+: +`)
+
+	// placeholderPattern matches a line ending in a formatting verb
+	placeholderPattern = regexp.MustCompile(`%[a-z]$`)
+
+	// verbPattern matches the formatting verbs in a line, and escaped
+	// percent signs, which don't take an argument
+	verbPattern = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+)
+
+// formatMessage formats msg with args, if any, and re-wraps the result to
+// the given width, or leaves its line breaks as they are if width is zero
+func formatMessage(msg string, width int, args ...interface{}) string {
+	if width == 0 {
+		if len(args) > 0 {
+			return fmt.Sprintf(msg, args...)
+		}
+		return msg
+	}
+
+	return wrapMessage(msg, width, args...)
+}
+
+// wrapMessage formats msg, a message with CRLF line endings such as those
+// shown in the report, with args, if any, and re-wraps its prose to the
+// given width. Wrapping follows formatting, so that the substituted text is
+// counted towards the width. Paragraphs stay separated by blank lines, and
+// continuation lines of labelled messages are indented to line up after the
+// label. Lines that consist only of a placeholder, which lists are
+// substituted for, are kept as they are once formatted, and lines ending in
+// a colon or a placeholder introduce what follows on the next line, so
+// aren't joined to it.
+func wrapMessage(msg string, width int, args ...interface{}) string {
+	if width < minWrapWidth {
+		width = minWrapWidth
+	}
+
+	var indent string
+	if label := severityLabelPattern.FindString(msg); len(label) == len(severityNotice.label()) {
+		indent = strings.Repeat(" ", len(label))
+	}
+
+	var out, words []string
+	var first, rest string
+	flush := func() {
+		if len(words) == 0 {
+			return
+		}
+
+		line := first + words[0]
+		for _, word := range words[1:] {
+			if utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+				out = append(out, line)
+				line = rest + word
+				continue
+			}
+			line += " " + word
+		}
+		out = append(out, line)
+		words = nil
+	}
+
+	formatted := len(args) > 0
+	for i, format := range strings.Split(msg, "\n\r") {
+		// Each line is formatted with the arguments for its own verbs, so
+		// that what's substituted for a placeholder line stays apart from
+		// the prose around it
+		line := format
+		if formatted {
+			n := 0
+			for _, verb := range verbPattern.FindAllString(format, -1) {
+				if verb != "%%" {
+					n++
+				}
+			}
+			if n > len(args) {
+				n = len(args)
+			}
+			line = fmt.Sprintf(format, args[:n]...)
+			args = args[n:]
+		}
+
+		spec := strings.TrimSpace(format)
+		if spec == "" || strings.HasPrefix(spec, "%") && len(strings.Fields(spec)) == 1 {
+			flush()
+			out = append(out, line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if len(words) == 0 {
+			switch leading := line[:len(line)-len(strings.TrimLeft(line, " \t"))]; {
+			case i == 0 && indent != "":
+				first = line[:len(indent)]
+				trimmed = strings.TrimSpace(line[len(indent):])
+				rest = indent
+			case leading != "" && indent != "":
+				first, rest = indent, indent
+			default:
+				first, rest = leading, leading
+			}
+		}
+
+		words = append(words, strings.Fields(trimmed)...)
+		if strings.HasSuffix(spec, ":") || placeholderPattern.MatchString(spec) {
+			flush()
+		}
+	}
+	flush()
+
+	return strings.Join(out, "\n\r")
+}
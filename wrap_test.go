@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWrapMessage(t *testing.T) {
+	list := strings.Join([]string{"ssh-rsa SHA256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa (Debian, CVE-2008-0166)", "ssh-rsa SHA256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}, "\n\r          ")
+
+	for _, test := range []struct {
+		msg      string
+		width    int
+		args     []interface{}
+		expected string
+	}{
+		{
+			msg:      "WARNING:  Some words that need wrapping to fit within forty\n\r          columns.\n\r\n\r",
+			width:    40,
+			expected: "WARNING:  Some words that need wrapping\n\r          to fit within forty columns.\n\r\n\r",
+		},
+		{
+			msg:      "WARNING:  Some words that need wrapping to fit within forty\n\r          columns.\n\r\n\r",
+			width:    80,
+			expected: "WARNING:  Some words that need wrapping to fit within forty columns.\n\r\n\r",
+		},
+		// The width is never narrower than minWrapWidth
+		{
+			msg:      "NOTE:     one two three four five six seven eight nine\n\r",
+			width:    10,
+			expected: "NOTE:     one two three four five six\n\r          seven eight nine\n\r",
+		},
+		// Substituted text counts towards the width
+		{
+			msg:      "Welcome back. Advice you were shown in the last %s is left out below;\n\ronly issues new to you are explained.\n\r",
+			width:    60,
+			args:     []interface{}{"a very long while indeed"},
+			expected: "Welcome back. Advice you were shown in the last a very long\n\rwhile indeed is left out below; only issues new to you are\n\rexplained.\n\r",
+		},
+		{
+			msg:      "Welcome back. Advice you were shown in the last %s is left out below;\n\ronly issues new to you are explained.\n\r",
+			width:    80,
+			args:     []interface{}{"24h0m0s"},
+			expected: "Welcome back. Advice you were shown in the last 24h0m0s is left out below; only\n\rissues new to you are explained.\n\r",
+		},
+		// Lists substituted for placeholder lines are kept as they are,
+		// however wide, and aren't joined to the prose around them
+		{
+			msg:      "CRITICAL: You are using well-known key(s), whose private keys have been\n\r          published.\n\r          Matched:\n\r          %s\n\r          Replace them.\n\r\n\r",
+			width:    40,
+			args:     []interface{}{list},
+			expected: "CRITICAL: You are using well-known\n\r          key(s), whose private keys\n\r          have been published. Matched:\n\r          " + list + "\n\r          Replace them.\n\r\n\r",
+		},
+		{
+			msg:      "CRITICAL: You are using well-known key(s), whose private keys have been\n\r          published.\n\r          Matched:\n\r          %s\n\r          Replace them.\n\r\n\r",
+			width:    80,
+			args:     []interface{}{list},
+			expected: "CRITICAL: You are using well-known key(s), whose private keys have been\n\r          published. Matched:\n\r          " + list + "\n\r          Replace them.\n\r\n\r",
+		},
+		// Lines ending in a placeholder aren't joined to the next, and
+		// arguments are matched to each line's own verbs
+		{
+			msg:      "This server's host key is %s %s\n\rCheck that this matches, 100%% sure.\n\r",
+			width:    40,
+			args:     []interface{}{"ecdsa-sha2-nistp256", "SHA256:9P9kjoChlPZ4jOIg9OZtDQVk1W3kg4DBHIjC4ysPph8"},
+			expected: "This server's host key is\n\recdsa-sha2-nistp256\n\rSHA256:9P9kjoChlPZ4jOIg9OZtDQVk1W3kg4DBHIjC4ysPph8\n\rCheck that this matches, 100% sure.\n\r",
+		},
+		// Without arguments, messages aren't formatted
+		{
+			msg:      "Recommended actions:\n\r%s\n\r100%%\n\r",
+			width:    60,
+			expected: "Recommended actions:\n\r%s\n\r100%%\n\r",
+		},
+	} {
+		got := wrapMessage(test.msg, test.width, test.args...)
+		if got != test.expected {
+			t.Errorf("%q at width %d:\ngot      %q\nexpected %q", test.msg, test.width, got, test.expected)
+		}
+	}
+}
+
+// The report's messages fit the width they're wrapped to, other than the
+// lists substituted into them
+func TestWrappedMessagesFit(t *testing.T) {
+	for _, width := range []int{40, 60, 80, 120} {
+		for _, msg := range []string{welcomeMsg, returningMsg, weakMsg, dsaMsg, agentMsg, x11Msg, hostKeyMsg} {
+			var args []interface{}
+			for range verbPattern.FindAllString(msg, -1) {
+				args = append(args, "24h0m0s")
+			}
+			for _, line := range strings.Split(wrapMessage(msg, width, args...), "\n\r") {
+				// URLs and other unbreakable words can't be made to fit
+				if n := utf8.RuneCountInString(line); n > width && len(strings.Fields(line)) > 1 {
+					t.Errorf("line of %d characters at width %d: %q", n, width, line)
+				}
+			}
+		}
+	}
+}
+
+func TestLabelled(t *testing.T) {
+	for _, test := range []struct {
+		width    int
+		expected string
+	}{
+		{0, "CRITICAL: You are using well-known key(s) matching\n\r          ssh-rsa SHA256:aaaa (a published key)\n\r\n\r"},
+		{40, "CRITICAL: You are using well-known\n\r          key(s) matching\n\r          ssh-rsa SHA256:aaaa (a published key)\n\r\n\r"},
+		{80, "CRITICAL: You are using well-known key(s) matching\n\r          ssh-rsa SHA256:aaaa (a published key)\n\r\n\r"},
+	} {
+		msg := "CRITICAL: You are using well-known key(s) matching\n\r          %s\n\r\n\r"
+		got := labelled("wellknown", msg, test.width, "ssh-rsa SHA256:aaaa (a published key)")
+		if got != test.expected {
+			t.Errorf("width %d:\ngot      %q\nexpected %q", test.width, got, test.expected)
+		}
+	}
+}